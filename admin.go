@@ -0,0 +1,421 @@
+package kelips
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	adminEndpointNode   = "/node"
+	adminEndpointGroups = "/groups"
+	adminEndpointTuples = "/tuples"
+	adminEndpointGossip = "/gossip"
+	adminEndpointPeers  = "/peers"
+	adminEndpointExpire = "/expire"
+	adminEndpointKeys   = "/keys"
+	adminEndpointResize = "/resize"
+)
+
+const (
+	defaultAdminPageSize = 100
+	maxAdminPageSize     = 1000
+)
+
+// AdminNode is the admin view of the local node
+type AdminNode struct {
+	Host string `json:"host"`
+	// Home group id
+	Group int64 `json:"group"`
+	// Total number of groups
+	K int64 `json:"k"`
+	// Number of groups being resized to.  Zero if not resizing
+	Resizing int64 `json:"resizing,omitempty"`
+	// Tuples stored locally
+	Tuples int         `json:"tuples"`
+	Expiry AdminExpiry `json:"expiry"`
+}
+
+// AdminExpiry is the tuple expiration settings of the home group
+type AdminExpiry struct {
+	TupleTTL  time.Duration `json:"tuple_ttl"`
+	ExpireMin time.Duration `json:"expire_min"`
+	ExpireMax time.Duration `json:"expire_max"`
+}
+
+// AdminGroup is the admin view of an affinity group
+type AdminGroup struct {
+	ID       int64          `json:"id"`
+	Local    bool           `json:"local"`
+	Contacts []AdminContact `json:"contacts"`
+}
+
+// AdminContact is a contact held for a group
+type AdminContact struct {
+	Host string `json:"host"`
+	Zone string `json:"zone,omitempty"`
+	Rack string `json:"rack,omitempty"`
+	// Round trip time if measured by the contact store
+	RTT time.Duration `json:"rtt,omitempty"`
+	// Last time the contact was reached or reported alive if tracked
+	LastSeen *time.Time `json:"last_seen,omitempty"`
+}
+
+// AdminTuple is the admin view of a stored tuple
+type AdminTuple struct {
+	Key        string            `json:"key"`
+	Hosts      []string          `json:"hosts"`
+	Meta       map[string]string `json:"meta,omitempty"`
+	Heartbeats int64             `json:"heartbeats"`
+	LastSeen   time.Time         `json:"last_seen"`
+	// Lease duration and deadline of leased tuples
+	TTL     time.Duration `json:"ttl,omitempty"`
+	Expires *time.Time    `json:"expires,omitempty"`
+}
+
+// AdminTuplePage is a page of tuples ordered by key
+type AdminTuplePage struct {
+	Total  int          `json:"total"`
+	Offset int          `json:"offset"`
+	Limit  int          `json:"limit"`
+	Tuples []AdminTuple `json:"tuples"`
+}
+
+// AdminPlacement is the group a key hashes to along with the contacts held
+// for it
+type AdminPlacement struct {
+	Key   string     `json:"key"`
+	Group AdminGroup `json:"group"`
+}
+
+// AdminGossip is the gossip pool membership of the node
+type AdminGossip struct {
+	// Inter group pool i.e. all nodes
+	Inter []string `json:"inter"`
+	// Home group pool
+	Home []string `json:"home"`
+}
+
+// AdminHandler serves a JSON API to inspect and manage the state of a node.
+// It can be mounted next to the HTTPTransport with http.StripPrefix e.g.
+// under /admin.  The routes are:
+//
+//	GET    /node                  home group, k and expiry settings
+//	GET    /groups                all groups with their contacts
+//	GET    /groups/<id>           a single group
+//	GET    /tuples?offset=&limit= locally stored tuples ordered by key
+//	GET    /gossip                gossip pool membership
+//	GET    /keys/<key>            group the key hashes to
+//	DELETE /peers/<host>          evict the peer from its group
+//	POST   /expire/<host>         expire all tuples homed on the host
+//	POST   /resize/<k>            resize the cluster to k groups
+type AdminHandler struct {
+	kelips *Kelips
+	// optional
+	gossip *Gossip
+}
+
+// NewAdminHandler returns an admin handler for the node.  gossip may be nil
+// if the node does not use gossip
+func NewAdminHandler(klp *Kelips, gossip *Gossip) *AdminHandler {
+	return &AdminHandler{kelips: klp, gossip: gossip}
+}
+
+func (admin *AdminHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := r.URL.Path
+
+	switch {
+	case path == adminEndpointNode:
+		if !allowMethod(w, r, http.MethodGet) {
+			return
+		}
+		writeJSON(w, http.StatusOK, admin.node())
+
+	case path == adminEndpointGroups:
+		if !allowMethod(w, r, http.MethodGet) {
+			return
+		}
+		current, _ := admin.kelips.rings()
+		groups := make([]AdminGroup, 0, len(current.groups))
+		for _, group := range current.groups {
+			groups = append(groups, adminGroup(group))
+		}
+		writeJSON(w, http.StatusOK, groups)
+
+	case strings.HasPrefix(path, adminEndpointGroups+"/"):
+		if !allowMethod(w, r, http.MethodGet) {
+			return
+		}
+		admin.handleGroup(w, strings.TrimPrefix(path, adminEndpointGroups+"/"))
+
+	case path == adminEndpointTuples:
+		if !allowMethod(w, r, http.MethodGet) {
+			return
+		}
+		admin.handleTuples(w, r)
+
+	case path == adminEndpointGossip:
+		if !allowMethod(w, r, http.MethodGet) {
+			return
+		}
+		admin.handleGossip(w)
+
+	case strings.HasPrefix(path, adminEndpointKeys+"/"):
+		if !allowMethod(w, r, http.MethodGet) {
+			return
+		}
+		admin.handleKey(w, strings.TrimPrefix(path, adminEndpointKeys+"/"))
+
+	case strings.HasPrefix(path, adminEndpointPeers+"/"):
+		if !allowMethod(w, r, http.MethodDelete) {
+			return
+		}
+		admin.handleEvict(w, r, strings.TrimPrefix(path, adminEndpointPeers+"/"))
+
+	case strings.HasPrefix(path, adminEndpointExpire+"/"):
+		if !allowMethod(w, r, http.MethodPost) {
+			return
+		}
+		admin.handleExpire(w, strings.TrimPrefix(path, adminEndpointExpire+"/"))
+
+	case strings.HasPrefix(path, adminEndpointResize+"/"):
+		if !allowMethod(w, r, http.MethodPost) {
+			return
+		}
+		admin.handleResize(w, strings.TrimPrefix(path, adminEndpointResize+"/"))
+
+	default:
+		writeError(w, http.StatusNotFound, "not found")
+
+	}
+}
+
+func (admin *AdminHandler) node() AdminNode {
+	current, next := admin.kelips.rings()
+	home := current.groups[current.id].(*affinityGroup)
+	node := AdminNode{
+		Host:  home.Host,
+		Group: current.id,
+		K:     current.k,
+		Expiry: AdminExpiry{
+			TupleTTL:  home.tupleTTL,
+			ExpireMin: home.tupleExpMin,
+			ExpireMax: home.tupleExpMax,
+		},
+	}
+
+	if next != nil {
+		node.Resizing = next.k
+	}
+
+	if counter, ok := home.tuples.(tupleCounter); ok {
+		node.Tuples = counter.Count()
+	} else {
+		node.Tuples = len(home.tuples.List())
+	}
+
+	return node
+}
+
+func (admin *AdminHandler) handleGroup(w http.ResponseWriter, id string) {
+	current, _ := admin.kelips.rings()
+	gid, err := strconv.ParseInt(id, 10, 64)
+	if err != nil || gid < 0 || gid >= current.k {
+		writeError(w, http.StatusNotFound, "group not found: "+id)
+		return
+	}
+	writeJSON(w, http.StatusOK, adminGroup(current.groups[gid]))
+}
+
+func (admin *AdminHandler) handleKey(w http.ResponseWriter, key string) {
+	if key == "" {
+		writeError(w, http.StatusNotFound, "no key")
+		return
+	}
+
+	current, _ := admin.kelips.rings()
+	_, group := current.lookup([]byte(key))
+	writeJSON(w, http.StatusOK, AdminPlacement{
+		Key:   key,
+		Group: adminGroup(group),
+	})
+}
+
+func (admin *AdminHandler) handleTuples(w http.ResponseWriter, r *http.Request) {
+	offset, err := queryInt(r, "offset", 0)
+	if err != nil || offset < 0 {
+		writeError(w, http.StatusBadRequest, "invalid offset")
+		return
+	}
+	limit, err := queryInt(r, "limit", defaultAdminPageSize)
+	if err != nil || limit < 1 {
+		writeError(w, http.StatusBadRequest, "invalid limit")
+		return
+	}
+	if limit > maxAdminPageSize {
+		limit = maxAdminPageSize
+	}
+
+	tuples := admin.kelips.localGroup().tuples.List()
+	sort.Slice(tuples, func(i, j int) bool {
+		return bytes.Compare(tuples[i].Key, tuples[j].Key) < 0
+	})
+
+	page := AdminTuplePage{
+		Total:  len(tuples),
+		Offset: offset,
+		Limit:  limit,
+		Tuples: make([]AdminTuple, 0, limit),
+	}
+
+	if offset < len(tuples) {
+		end := offset + limit
+		if end > len(tuples) {
+			end = len(tuples)
+		}
+		for _, t := range tuples[offset:end] {
+			at := AdminTuple{
+				Key:        string(t.Key),
+				Hosts:      t.Hosts,
+				Meta:       t.Meta,
+				Heartbeats: t.heartbeats,
+				LastSeen:   time.Unix(0, t.lastseen),
+				TTL:        t.TTL,
+			}
+			if expires := t.Expires(); !expires.IsZero() {
+				at.Expires = &expires
+			}
+			page.Tuples = append(page.Tuples, at)
+		}
+	}
+
+	writeJSON(w, http.StatusOK, page)
+}
+
+func (admin *AdminHandler) handleGossip(w http.ResponseWriter) {
+	if admin.gossip == nil {
+		writeError(w, http.StatusNotFound, "gossip not enabled")
+		return
+	}
+
+	gs := AdminGossip{Inter: []string{}, Home: []string{}}
+	for _, p := range admin.gossip.inter.Peers().List() {
+		gs.Inter = append(gs.Inter, p.Address())
+	}
+	if pool := admin.gossip.gtuples.homePool(); pool != nil {
+		for _, p := range pool.Peers().List() {
+			gs.Home = append(gs.Home, p.Address())
+		}
+	}
+	sort.Strings(gs.Inter)
+	sort.Strings(gs.Home)
+
+	writeJSON(w, http.StatusOK, gs)
+}
+
+// handleEvict removes the peer from the contacts of its group.  It is added
+// back if it is still seen by gossip
+func (admin *AdminHandler) handleEvict(w http.ResponseWriter, r *http.Request, host string) {
+	if host == "" {
+		writeError(w, http.StatusNotFound, "no host")
+		return
+	}
+
+	gid, err := admin.kelips.RemovePeerContext(r.Context(), &Peer{Host: host})
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]int64{"group": gid})
+}
+
+// handleExpire removes the host from all local tuples as if it had left
+func (admin *AdminHandler) handleExpire(w http.ResponseWriter, host string) {
+	if host == "" {
+		writeError(w, http.StatusNotFound, "no host")
+		return
+	}
+
+	home := admin.kelips.localGroup()
+	c := home.tuples.ExpireHost(host)
+	home.updateTupleCount()
+
+	writeJSON(w, http.StatusOK, map[string]int{"expired": c})
+}
+
+func (admin *AdminHandler) handleResize(w http.ResponseWriter, k string) {
+	n, err := strconv.ParseInt(k, 10, 64)
+	if err == nil {
+		err = admin.kelips.Resize(n)
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]int64{"resizing": n})
+}
+
+func adminGroup(group AffinityGroup) AdminGroup {
+	gc := group.Contact()
+	ag := AdminGroup{ID: gc.ID, Local: group.IsLocal(), Contacts: []AdminContact{}}
+
+	contacts := groupContacts(group)
+	if contacts == nil {
+		return ag
+	}
+
+	for _, c := range contacts.List() {
+		ac := AdminContact{Host: c.Address()}
+		ac.Zone, ac.Rack = contactLabels(c)
+		if p, ok := c.(*Peer); ok {
+			ac.RTT = p.RTT()
+			if seen := p.LastSeen(); !seen.IsZero() {
+				ac.LastSeen = &seen
+			}
+		}
+		ag.Contacts = append(ag.Contacts, ac)
+	}
+	sort.Slice(ag.Contacts, func(i, j int) bool {
+		return ag.Contacts[i].Host < ag.Contacts[j].Host
+	})
+
+	return ag
+}
+
+func allowMethod(w http.ResponseWriter, r *http.Request, method string) bool {
+	if r.Method == method {
+		return true
+	}
+	writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	return false
+}
+
+func queryInt(r *http.Request, name string, def int) (int, error) {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		return def, nil
+	}
+	return strconv.Atoi(v)
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		code = http.StatusInternalServerError
+		b, _ = json.Marshal(map[string]string{"error": err.Error()})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	w.Write(b)
+}
+
+func writeError(w http.ResponseWriter, code int, msg string) {
+	writeJSON(w, code, map[string]string{"error": msg})
+}
@@ -0,0 +1,110 @@
+package kelips
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func adminRequest(t *testing.T, h http.Handler, method, path string, v interface{}) int {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(method, path, nil))
+	if v != nil && w.Code == http.StatusOK {
+		assert.Nil(t, json.Unmarshal(w.Body.Bytes(), v))
+	}
+	return w.Code
+}
+
+func Test_AdminHandler(t *testing.T) {
+	network := NewLoopbackNetwork(1)
+	knet := makeLoopbackNetwork(network, 9, 3)
+	klp := knet[0]
+	admin := NewAdminHandler(klp, nil)
+
+	var node AdminNode
+	assert.Equal(t, 200, adminRequest(t, admin, "GET", "/node", &node))
+	assert.Equal(t, hostOf(klp), node.Host)
+	assert.Equal(t, klp.id, node.Group)
+	assert.Equal(t, int64(3), node.K)
+	assert.True(t, node.Expiry.TupleTTL > 0)
+
+	var groups []AdminGroup
+	assert.Equal(t, 200, adminRequest(t, admin, "GET", "/groups", &groups))
+	assert.Equal(t, 3, len(groups))
+	var contacts int
+	for i, g := range groups {
+		assert.Equal(t, int64(i), g.ID)
+		assert.Equal(t, klp.id == g.ID, g.Local)
+		contacts += len(g.Contacts)
+	}
+	// All nodes including self
+	assert.Equal(t, 9, contacts)
+
+	var group AdminGroup
+	assert.Equal(t, 200, adminRequest(t, admin, "GET", fmt.Sprintf("/groups/%d", klp.id), &group))
+	assert.True(t, group.Local)
+	assert.Equal(t, 404, adminRequest(t, admin, "GET", "/groups/3", nil))
+	assert.Equal(t, 404, adminRequest(t, admin, "GET", "/groups/x", nil))
+
+	var placement AdminPlacement
+	assert.Equal(t, 200, adminRequest(t, admin, "GET", "/keys/some/key", &placement))
+	assert.Equal(t, "some/key", placement.Key)
+	assert.Equal(t, lookupGroup([]byte("some/key"), 3, klp.hasher()), placement.Group.ID)
+
+	// Tuples stored locally
+	home := klp.localGroup()
+	for i := 0; i < 5; i++ {
+		home.tuples.Insert(&Tuple{Key: []byte(fmt.Sprintf("key-%d", i)), Hosts: []string{"10.0.0.99:4000"}})
+	}
+	home.tuples.Insert(&Tuple{Key: []byte("key-x"), Hosts: []string{hostOf(klp)}})
+
+	var page AdminTuplePage
+	assert.Equal(t, 200, adminRequest(t, admin, "GET", "/tuples?offset=2&limit=3", &page))
+	assert.Equal(t, 6, page.Total)
+	assert.Equal(t, 3, len(page.Tuples))
+	assert.Equal(t, "key-2", page.Tuples[0].Key)
+	assert.Equal(t, "key-4", page.Tuples[2].Key)
+
+	assert.Equal(t, 200, adminRequest(t, admin, "GET", "/tuples?offset=10", &page))
+	assert.Equal(t, 0, len(page.Tuples))
+	assert.Equal(t, 400, adminRequest(t, admin, "GET", "/tuples?limit=0", nil))
+	assert.Equal(t, 400, adminRequest(t, admin, "GET", "/tuples?offset=-1", nil))
+
+	// Force expire a host
+	var expired map[string]int
+	assert.Equal(t, 405, adminRequest(t, admin, "GET", "/expire/10.0.0.99:4000", nil))
+	assert.Equal(t, 200, adminRequest(t, admin, "POST", "/expire/10.0.0.99:4000", &expired))
+	assert.Equal(t, 5, expired["expired"])
+	assert.Equal(t, 200, adminRequest(t, admin, "GET", "/node", &node))
+	assert.Equal(t, 1, node.Tuples)
+
+	// Evict a peer
+	peer := hostOf(knet[1])
+	var evicted map[string]int64
+	assert.Equal(t, 200, adminRequest(t, admin, "DELETE", "/peers/"+peer, &evicted))
+	assert.Equal(t, knet[1].id, evicted["group"])
+	assert.Equal(t, 200, adminRequest(t, admin, "GET", fmt.Sprintf("/groups/%d", knet[1].id), &group))
+	for _, c := range group.Contacts {
+		assert.NotEqual(t, peer, c.Host)
+	}
+	assert.Equal(t, 400, adminRequest(t, admin, "DELETE", "/peers/"+peer, nil))
+
+	// Resize without other nodes reporting
+	defer klp.routines.stop(context.Background())
+	assert.Equal(t, 405, adminRequest(t, admin, "GET", "/resize/4", nil))
+	assert.Equal(t, 400, adminRequest(t, admin, "POST", "/resize/0", nil))
+	assert.Equal(t, 400, adminRequest(t, admin, "POST", "/resize/x", nil))
+	assert.Equal(t, 200, adminRequest(t, admin, "POST", "/resize/4", nil))
+	assert.Equal(t, 200, adminRequest(t, admin, "GET", "/node", &node))
+	assert.Equal(t, int64(3), node.K)
+	assert.Equal(t, int64(4), node.Resizing)
+
+	// No gossip
+	assert.Equal(t, 404, adminRequest(t, admin, "GET", "/gossip", nil))
+	assert.Equal(t, 404, adminRequest(t, admin, "GET", "/unknown", nil))
+}
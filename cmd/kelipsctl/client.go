@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	kelips "github.com/euforia/go-kelips"
+)
+
+// client talks to the http server and admin API of a node as served by the
+// example binary
+type client struct {
+	base string
+	http *http.Client
+}
+
+func newClient(addr string, timeout time.Duration) *client {
+	base := addr
+	if !strings.Contains(base, "://") {
+		base = "http://" + base
+	}
+
+	return &client{
+		base: strings.TrimSuffix(base, "/"),
+		http: &http.Client{Timeout: timeout},
+	}
+}
+
+// lookupResult is the tuple for a key along with the lookup path if traced
+type lookupResult struct {
+	Key   string            `json:"key"`
+	Hosts []string          `json:"hosts"`
+	Meta  map[string]string `json:"meta,omitempty"`
+	Trace []kelips.TraceHop `json:"trace,omitempty"`
+	Error string            `json:"error,omitempty"`
+}
+
+// batchItem is the result for a single key of a batch request
+type batchItem struct {
+	Key   string            `json:"key"`
+	Hosts []string          `json:"hosts,omitempty"`
+	Meta  map[string]string `json:"meta,omitempty"`
+	Error string            `json:"error,omitempty"`
+}
+
+func (c *client) insert(key string, meta map[string]string) ([]string, error) {
+	q := url.Values{}
+	for k, v := range meta {
+		q.Set(k, v)
+	}
+
+	u := c.keyURL(key)
+	if len(q) > 0 {
+		u += "?" + q.Encode()
+	}
+
+	b, err := c.do(http.MethodPost, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	return splitHosts(b), nil
+}
+
+// lookup returns the tuple for the key.  If trace is set the lookup path is
+// returned even if the lookup failed
+func (c *client) lookup(key string, trace bool) (*lookupResult, error) {
+	u := c.keyURL(key)
+	if trace {
+		u += "?trace=1"
+	}
+
+	// Failed lookups return the error along with the trace
+	b, err := c.do(http.MethodGet, u, nil)
+	result := &lookupResult{}
+	if er := json.Unmarshal(b, result); er != nil {
+		if err == nil {
+			return nil, er
+		}
+		result = &lookupResult{}
+	}
+
+	result.Key = key
+	if err != nil {
+		result.Error = err.Error()
+		return result, err
+	}
+	return result, nil
+}
+
+func (c *client) delete(key string) error {
+	_, err := c.do(http.MethodDelete, c.keyURL(key), nil)
+	return err
+}
+
+// batch inserts or looks up all keys in a single request.  op is insert or
+// lookup
+func (c *client) batch(op string, keys []string) ([]batchItem, error) {
+	body, err := json.Marshal(keys)
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := c.do(http.MethodPost, c.base+"/batch/"+op, body)
+	if err != nil {
+		return nil, err
+	}
+
+	var items []batchItem
+	err = json.Unmarshal(b, &items)
+	return items, err
+}
+
+func (c *client) groups() ([]kelips.AdminGroup, error) {
+	var groups []kelips.AdminGroup
+	err := c.getJSON("/admin/groups", &groups)
+	return groups, err
+}
+
+func (c *client) placement(key string) (*kelips.AdminPlacement, error) {
+	var placement kelips.AdminPlacement
+	err := c.getJSON("/admin/keys/"+url.PathEscape(key), &placement)
+	return &placement, err
+}
+
+func (c *client) getJSON(path string, v interface{}) error {
+	b, err := c.do(http.MethodGet, c.base+path, nil)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+func (c *client) keyURL(key string) string {
+	return c.base + "/" + url.PathEscape(key)
+}
+
+// do makes the request returning the body.  The body of non-200 responses is
+// returned along with the error it contains
+func (c *client) do(method, u string, body []byte) ([]byte, error) {
+	req, err := http.NewRequest(method, u, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return b, responseError(resp.StatusCode, b)
+	}
+	return b, nil
+}
+
+// responseError returns the error message from a plain text or admin JSON
+// error body
+func responseError(code int, b []byte) error {
+	var e struct {
+		Error string `json:"error"`
+	}
+	if json.Unmarshal(b, &e) == nil && e.Error != "" {
+		return errors.New(e.Error)
+	}
+
+	msg := strings.TrimSpace(string(b))
+	if msg == "" {
+		msg = http.StatusText(code)
+	}
+	return fmt.Errorf("%d: %s", code, msg)
+}
+
+func splitHosts(b []byte) []string {
+	if len(b) == 0 {
+		return nil
+	}
+	return strings.Split(string(b), ",")
+}
@@ -0,0 +1,206 @@
+// kelipsctl is a command-line client to operate a kelips cluster through the
+// http server and admin API of a node as served by the example binary
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+const usage = `Usage: kelipsctl [flags] <command> [args]
+
+Commands:
+  insert <key> [name=value ...]   insert a key with optional metadata
+  lookup <key>                    lookup the home nodes of a key
+  delete <key>                    delete a key
+  load [-batch n] <file>          insert the keys in a file, one per line
+  members                         list contacts by affinity group
+  where <key>                     show the affinity group a key hashes to
+  trace <key>                     lookup a key showing the path taken
+
+Flags:
+`
+
+var (
+	addr    = flag.String("addr", "127.0.0.1:10000", "Node http address")
+	output  = flag.String("o", "table", "Output format: table or json")
+	timeout = flag.Duration("timeout", 10*time.Second, "Request timeout")
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() < 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if *output != "table" && *output != "json" {
+		fmt.Fprintf(os.Stderr, "Invalid output format: %s\n", *output)
+		os.Exit(2)
+	}
+
+	cli := newClient(*addr, *timeout)
+	out := &printer{w: os.Stdout, json: *output == "json"}
+
+	if err := run(cli, out, flag.Arg(0), flag.Args()[1:]); err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(1)
+	}
+}
+
+func run(cli *client, out *printer, cmd string, args []string) error {
+	switch cmd {
+	case "insert":
+		if len(args) < 1 {
+			return errUsage(cmd, "<key> [name=value ...]")
+		}
+		meta, err := parseMeta(args[1:])
+		if err != nil {
+			return err
+		}
+		hosts, err := cli.insert(args[0], meta)
+		if err != nil {
+			return err
+		}
+		return out.hosts(args[0], hosts)
+
+	case "lookup", "trace":
+		if len(args) != 1 {
+			return errUsage(cmd, "<key>")
+		}
+		res, err := cli.lookup(args[0], cmd == "trace")
+		// Print the path of failed traces
+		if err != nil && (res == nil || len(res.Trace) == 0) {
+			return err
+		}
+		if er := out.lookup(res); er != nil {
+			return er
+		}
+		return err
+
+	case "delete":
+		if len(args) != 1 {
+			return errUsage(cmd, "<key>")
+		}
+		return cli.delete(args[0])
+
+	case "load":
+		return runLoad(cli, out, args)
+
+	case "members":
+		groups, err := cli.groups()
+		if err != nil {
+			return err
+		}
+		return out.groups(groups)
+
+	case "where":
+		if len(args) != 1 {
+			return errUsage(cmd, "<key>")
+		}
+		placement, err := cli.placement(args[0])
+		if err != nil {
+			return err
+		}
+		return out.placement(placement)
+
+	}
+
+	return fmt.Errorf("unknown command: %s", cmd)
+}
+
+// runLoad inserts all keys from the file in batches
+func runLoad(cli *client, out *printer, args []string) error {
+	fs := flag.NewFlagSet("load", flag.ContinueOnError)
+	size := fs.Int("batch", 100, "Keys per request")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 || *size < 1 {
+		return errUsage("load", "[-batch n] <file>")
+	}
+
+	keys, err := readKeys(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	all := make([]batchItem, 0, len(keys))
+	var failed int
+	for i := 0; i < len(keys); i += *size {
+		end := i + *size
+		if end > len(keys) {
+			end = len(keys)
+		}
+
+		items, err := cli.batch("insert", keys[i:end])
+		if err != nil {
+			return fmt.Errorf("batch at line %d: %v", i+1, err)
+		}
+		for _, item := range items {
+			if item.Error != "" {
+				failed++
+			}
+		}
+		all = append(all, items...)
+	}
+
+	if err = out.batch(all); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "Loaded %d/%d keys\n", len(keys)-failed, len(keys))
+	if failed > 0 {
+		return fmt.Errorf("%d keys failed", failed)
+	}
+	return nil
+}
+
+// readKeys returns the non-empty lines of the file skipping # comments.  A
+// file of - reads stdin
+func readKeys(file string) ([]string, error) {
+	f := os.Stdin
+	if file != "-" {
+		var err error
+		if f, err = os.Open(file); err != nil {
+			return nil, err
+		}
+		defer f.Close()
+	}
+
+	keys := make([]string, 0)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		keys = append(keys, line)
+	}
+	return keys, scanner.Err()
+}
+
+func parseMeta(args []string) (map[string]string, error) {
+	meta := make(map[string]string, len(args))
+	for _, arg := range args {
+		kv := strings.SplitN(arg, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return nil, fmt.Errorf("invalid metadata: %s", arg)
+		}
+		meta[kv[0]] = kv[1]
+	}
+	return meta, nil
+}
+
+func errUsage(cmd, args string) error {
+	return fmt.Errorf("usage: kelipsctl %s %s", cmd, args)
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	kelips "github.com/euforia/go-kelips"
+)
+
+// printer writes results as JSON or an aligned table
+type printer struct {
+	w    io.Writer
+	json bool
+}
+
+func (p *printer) printJSON(v interface{}) error {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(p.w, string(b))
+	return err
+}
+
+// table writes the header and rows aligned by column
+func (p *printer) table(header []string, rows [][]string) error {
+	tw := tabwriter.NewWriter(p.w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, strings.Join(header, "\t"))
+	for _, row := range rows {
+		fmt.Fprintln(tw, strings.Join(row, "\t"))
+	}
+	return tw.Flush()
+}
+
+func (p *printer) hosts(key string, hosts []string) error {
+	if p.json {
+		return p.printJSON(map[string]interface{}{"key": key, "hosts": hosts})
+	}
+	return p.table([]string{"KEY", "HOSTS"}, [][]string{{key, strings.Join(hosts, ",")}})
+}
+
+func (p *printer) lookup(res *lookupResult) error {
+	if p.json {
+		return p.printJSON(res)
+	}
+
+	rows := [][]string{{res.Key, strings.Join(res.Hosts, ","), formatMeta(res.Meta)}}
+	if err := p.table([]string{"KEY", "HOSTS", "META"}, rows); err != nil {
+		return err
+	}
+
+	if len(res.Trace) == 0 {
+		return nil
+	}
+	fmt.Fprintln(p.w)
+	return p.trace(res.Trace)
+}
+
+func (p *printer) trace(hops []kelips.TraceHop) error {
+	rows := make([][]string, 0, len(hops))
+	for i, hop := range hops {
+		rows = append(rows, []string{
+			fmt.Sprintf("%d", i+1),
+			hop.Host,
+			fmt.Sprintf("%d", hop.Group),
+			string(hop.Decision),
+			hop.To,
+			hop.Latency.Round(time.Microsecond).String(),
+			hop.Error,
+		})
+	}
+	return p.table([]string{"HOP", "HOST", "GROUP", "DECISION", "TO", "LATENCY", "ERROR"}, rows)
+}
+
+func (p *printer) batch(items []batchItem) error {
+	if p.json {
+		return p.printJSON(items)
+	}
+
+	rows := make([][]string, 0, len(items))
+	for _, item := range items {
+		rows = append(rows, []string{item.Key, strings.Join(item.Hosts, ","), item.Error})
+	}
+	return p.table([]string{"KEY", "HOSTS", "ERROR"}, rows)
+}
+
+func (p *printer) groups(groups []kelips.AdminGroup) error {
+	if p.json {
+		return p.printJSON(groups)
+	}
+
+	rows := make([][]string, 0)
+	for _, g := range groups {
+		local := ""
+		if g.Local {
+			local = "*"
+		}
+		if len(g.Contacts) == 0 {
+			rows = append(rows, []string{fmt.Sprintf("%d", g.ID), local, "-", ""})
+			continue
+		}
+		for _, c := range g.Contacts {
+			rtt := ""
+			if c.RTT > 0 {
+				rtt = c.RTT.String()
+			}
+			rows = append(rows, []string{fmt.Sprintf("%d", g.ID), local, c.Host, rtt})
+		}
+	}
+	return p.table([]string{"GROUP", "HOME", "HOST", "RTT"}, rows)
+}
+
+func (p *printer) placement(pl *kelips.AdminPlacement) error {
+	if p.json {
+		return p.printJSON(pl)
+	}
+
+	hosts := make([]string, 0, len(pl.Group.Contacts))
+	for _, c := range pl.Group.Contacts {
+		hosts = append(hosts, c.Host)
+	}
+	rows := [][]string{{pl.Key, fmt.Sprintf("%d", pl.Group.ID), strings.Join(hosts, ",")}}
+	return p.table([]string{"KEY", "GROUP", "CONTACTS"}, rows)
+}
+
+func formatMeta(meta map[string]string) string {
+	pairs := make([]string, 0, len(meta))
+	for k, v := range meta {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
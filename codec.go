@@ -0,0 +1,443 @@
+package kelips
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// codecVersion is the version of the encoding used for tuple snapshots and
+// gossip messages.  All lengths and counts are uvarints and all byte strings
+// are length prefixed
+const codecVersion byte = 2
+
+// maxRecordSize is the max size of a single encoded tuple or message field
+const maxRecordSize = 1 << 20
+
+var (
+	errUnsupportedVersion = errors.New("unsupported codec version")
+)
+
+type byteReader interface {
+	io.Reader
+	io.ByteReader
+}
+
+// validateHost checks the host is a host:port pair.  The host may be an ip,
+// an ipv6 address with a zone or a dns name
+func validateHost(host string) error {
+	h, _, err := net.SplitHostPort(host)
+	if err != nil {
+		return errors.Wrapf(err, "invalid host %q", host)
+	}
+	if h == "" {
+		return fmt.Errorf("invalid host %q: missing host", host)
+	}
+	return nil
+}
+
+// writeTuples writes a snapshot of the tuples i.e. the codec version followed
+// by the size prefixed tuples.  Nothing is written if there are no tuples
+func writeTuples(w io.Writer, tuples []*Tuple) error {
+	if len(tuples) == 0 {
+		return nil
+	}
+
+	if _, err := w.Write([]byte{codecVersion}); err != nil {
+		return err
+	}
+	return writeTupleRecords(w, tuples)
+}
+
+// readTuples reads a snapshot written by writeTuples
+func readTuples(r io.Reader) ([]*Tuple, error) {
+	br := bufio.NewReader(r)
+
+	version, err := br.ReadByte()
+	if err != nil {
+		if err == io.EOF {
+			return []*Tuple{}, nil
+		}
+		return nil, err
+	}
+	if version != codecVersion {
+		return nil, errors.Wrapf(errUnsupportedVersion, "version %d", version)
+	}
+
+	return readTupleRecords(br)
+}
+
+// writeTupleRecords writes each tuple prefixed with its size.  All hosts are
+// validated before anything is written
+func writeTupleRecords(w io.Writer, tuples []*Tuple) error {
+	buf := make([]byte, 0)
+	for i, t := range tuples {
+		for _, h := range t.Hosts {
+			if err := validateHost(h); err != nil {
+				return errors.Wrapf(err, "tuple %d", i)
+			}
+		}
+
+		rec := encodeTuple(make([]byte, 0, len(t.Key)+40), t)
+		rec = appendTupleTTL(rec, t)
+		buf = appendBytes(buf, rec)
+	}
+
+	_, err := w.Write(buf)
+	return err
+}
+
+// readTupleRecords reads size prefixed tuples until EOF
+func readTupleRecords(r byteReader) ([]*Tuple, error) {
+	out := make([]*Tuple, 0)
+	for i := 0; ; i++ {
+		rec, err := readField(r)
+		if err != nil {
+			if err == io.EOF {
+				return out, nil
+			}
+			return out, errors.Wrapf(unexpectedEOF(err), "tuple %d", i)
+		}
+
+		rd := bytes.NewReader(rec)
+		tuple, err := decodeTuple(rd)
+		if err == nil {
+			err = readTupleTTL(rd, tuple)
+		}
+		if err != nil {
+			return out, errors.Wrapf(err, "tuple %d", i)
+		}
+
+		for _, h := range tuple.Hosts {
+			if err = validateHost(h); err != nil {
+				return out, errors.Wrapf(err, "tuple %d", i)
+			}
+		}
+
+		out = append(out, tuple)
+	}
+}
+
+// encodeTuple appends the key, hosts and metadata of the tuple to buf
+func encodeTuple(buf []byte, t *Tuple) []byte {
+	buf = appendBytes(buf, t.Key)
+	buf = appendUvarint(buf, uint64(len(t.Hosts)))
+	for _, h := range t.Hosts {
+		buf = appendBytes(buf, []byte(h))
+	}
+	return appendMeta(buf, t.Meta)
+}
+
+// decodeTuple decodes a tuple written by encodeTuple leaving any remaining
+// bytes in the reader
+func decodeTuple(r *bytes.Reader) (*Tuple, error) {
+	key, err := readBytes(r)
+	if err != nil {
+		return nil, errors.Wrap(err, "key")
+	}
+
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, errors.Wrap(unexpectedEOF(err), "host count")
+	}
+	if n > uint64(r.Len()) {
+		return nil, fmt.Errorf("invalid host count: %d", n)
+	}
+
+	tuple := &Tuple{Key: key, Hosts: make([]string, 0, n)}
+	for i := uint64(0); i < n; i++ {
+		host, err := readBytes(r)
+		if err != nil {
+			return nil, errors.Wrapf(err, "host %d", i)
+		}
+		tuple.Hosts = append(tuple.Hosts, string(host))
+	}
+
+	tuple.Meta, err = readMeta(r)
+	if err != nil {
+		return nil, errors.Wrap(err, "meta")
+	}
+
+	return tuple, nil
+}
+
+// appendTupleTTL appends the TTL of the tuple in nanoseconds.  It trails the
+// encoded tuple so peers that predate it ignore it
+func appendTupleTTL(buf []byte, t *Tuple) []byte {
+	return appendUvarint(buf, uint64(t.TTL))
+}
+
+// readTupleTTL sets the TTL of the tuple if there are bytes remaining.  Tuples
+// encoded without one use the store wide TTL
+func readTupleTTL(r *bytes.Reader, t *Tuple) error {
+	if r.Len() == 0 {
+		return nil
+	}
+
+	ttl, err := binary.ReadUvarint(r)
+	if err != nil {
+		return errors.Wrap(unexpectedEOF(err), "ttl")
+	}
+	if ttl > math.MaxInt64 {
+		return fmt.Errorf("invalid ttl: %d", ttl)
+	}
+	t.TTL = time.Duration(ttl)
+	return nil
+}
+
+// writeMessageHeader writes the codec version, message type and the host
+// sending the message
+func writeMessageHeader(w io.Writer, typ byte, host string) error {
+	if err := validateHost(host); err != nil {
+		return err
+	}
+
+	buf := appendBytes([]byte{codecVersion, typ}, []byte(host))
+	_, err := w.Write(buf)
+	return err
+}
+
+// readMessageHeader reads a header written by writeMessageHeader returning the
+// message type and sending host
+func readMessageHeader(r byteReader) (byte, string, error) {
+	version, err := r.ReadByte()
+	if err != nil {
+		return 0, "", unexpectedEOF(err)
+	}
+	if version != codecVersion {
+		return 0, "", errors.Wrapf(errUnsupportedVersion, "version %d", version)
+	}
+
+	typ, err := r.ReadByte()
+	if err != nil {
+		return 0, "", unexpectedEOF(err)
+	}
+
+	host, err := readField(r)
+	if err != nil {
+		return 0, "", errors.Wrap(unexpectedEOF(err), "host")
+	}
+
+	return typ, string(host), validateHost(string(host))
+}
+
+// writeKeys writes each key prefixed with its length
+func writeKeys(w io.Writer, keys [][]byte) error {
+	buf := make([]byte, 0)
+	for _, k := range keys {
+		buf = appendBytes(buf, k)
+	}
+	_, err := w.Write(buf)
+	return err
+}
+
+// readKeys reads length prefixed keys until EOF
+func readKeys(r byteReader) ([][]byte, error) {
+	out := make([][]byte, 0)
+	for i := 0; ; i++ {
+		key, err := readField(r)
+		if err != nil {
+			if err == io.EOF {
+				return out, nil
+			}
+			return out, errors.Wrapf(unexpectedEOF(err), "key %d", i)
+		}
+		out = append(out, key)
+	}
+}
+
+// writeRenew writes the key and new TTL of a renewed lease
+func writeRenew(w io.Writer, key []byte, ttl time.Duration) error {
+	buf := appendBytes(make([]byte, 0, len(key)+12), key)
+	_, err := w.Write(appendUvarint(buf, uint64(ttl)))
+	return err
+}
+
+// readRenew reads a lease renewal written by writeRenew
+func readRenew(r byteReader) ([]byte, time.Duration, error) {
+	key, err := readField(r)
+	if err != nil {
+		return nil, 0, errors.Wrap(unexpectedEOF(err), "key")
+	}
+	ttl, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, 0, errors.Wrap(unexpectedEOF(err), "ttl")
+	}
+	if ttl > math.MaxInt64 {
+		return nil, 0, fmt.Errorf("invalid ttl: %d", ttl)
+	}
+	return key, time.Duration(ttl), nil
+}
+
+// writeRelease writes the key and the host releasing it
+func writeRelease(w io.Writer, key []byte, host string) error {
+	if err := validateHost(host); err != nil {
+		return err
+	}
+	buf := appendBytes(make([]byte, 0, len(key)+len(host)+8), key)
+	_, err := w.Write(appendBytes(buf, []byte(host)))
+	return err
+}
+
+// readRelease reads a lease release written by writeRelease
+func readRelease(r byteReader) ([]byte, string, error) {
+	key, err := readField(r)
+	if err != nil {
+		return nil, "", errors.Wrap(unexpectedEOF(err), "key")
+	}
+	host, err := readField(r)
+	if err != nil {
+		return nil, "", errors.Wrap(unexpectedEOF(err), "host")
+	}
+	return key, string(host), validateHost(string(host))
+}
+
+// writeResize writes the epoch and number of affinity groups of a resize
+func writeResize(w io.Writer, epoch uint64, k int64) error {
+	buf := appendUvarint(make([]byte, 0, 20), epoch)
+	_, err := w.Write(appendVarint(buf, k))
+	return err
+}
+
+// writeResizeMsg writes the header and resize of the message
+func writeResizeMsg(w io.Writer, msg *resizeMsg) error {
+	buf := bytes.NewBuffer(nil)
+	if err := writeMessageHeader(buf, msg.Type, msg.Host); err != nil {
+		return err
+	}
+	if err := writeResize(buf, msg.Epoch, msg.K); err != nil {
+		return err
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// readResizeMsg reads a message written by writeResizeMsg
+func readResizeMsg(r byteReader) (*resizeMsg, error) {
+	typ, host, err := readMessageHeader(r)
+	if err != nil {
+		return nil, err
+	}
+	if typ < resizeMsgPropose || typ > resizeMsgComplete {
+		return nil, fmt.Errorf("unknown resize message type: %d", typ)
+	}
+
+	msg := &resizeMsg{Type: typ, Host: host}
+	msg.Epoch, msg.K, err = readResize(r)
+	return msg, err
+}
+
+// readResize reads a resize written by writeResize
+func readResize(r byteReader) (uint64, int64, error) {
+	epoch, err := binary.ReadUvarint(r)
+	if err != nil {
+		return 0, 0, errors.Wrap(unexpectedEOF(err), "epoch")
+	}
+	k, err := binary.ReadVarint(r)
+	if err != nil {
+		return 0, 0, errors.Wrap(unexpectedEOF(err), "k")
+	}
+	if k <= 0 {
+		return 0, 0, fmt.Errorf("invalid k: %d", k)
+	}
+	return epoch, k, nil
+}
+
+// appendMeta appends the number of entries followed by each length prefixed
+// key and value
+func appendMeta(buf []byte, meta map[string]string) []byte {
+	buf = appendUvarint(buf, uint64(len(meta)))
+	for k, v := range meta {
+		buf = appendBytes(buf, []byte(k))
+		buf = appendBytes(buf, []byte(v))
+	}
+	return buf
+}
+
+// readMeta reads metadata written by appendMeta.  It returns nil if there are
+// no entries
+func readMeta(r *bytes.Reader) (map[string]string, error) {
+	n, err := binary.ReadUvarint(r)
+	if err != nil || n == 0 {
+		return nil, unexpectedEOF(err)
+	}
+	if n > uint64(r.Len()) {
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	meta := make(map[string]string, n)
+	for i := uint64(0); i < n; i++ {
+		k, err := readBytes(r)
+		if err != nil {
+			return nil, err
+		}
+		v, err := readBytes(r)
+		if err != nil {
+			return nil, err
+		}
+		meta[string(k)] = string(v)
+	}
+	return meta, nil
+}
+
+func appendUvarint(buf []byte, v uint64) []byte {
+	tmp := make([]byte, binary.MaxVarintLen64)
+	n := binary.PutUvarint(tmp, v)
+	return append(buf, tmp[:n]...)
+}
+
+func appendVarint(buf []byte, v int64) []byte {
+	tmp := make([]byte, binary.MaxVarintLen64)
+	n := binary.PutVarint(tmp, v)
+	return append(buf, tmp[:n]...)
+}
+
+// appendBytes appends the length prefixed bytes
+func appendBytes(buf, b []byte) []byte {
+	buf = appendUvarint(buf, uint64(len(b)))
+	return append(buf, b...)
+}
+
+// readBytes reads length prefixed bytes from an in-memory reader
+func readBytes(r *bytes.Reader) ([]byte, error) {
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	if n > uint64(r.Len()) {
+		return nil, io.ErrUnexpectedEOF
+	}
+	b := make([]byte, n)
+	_, err = io.ReadFull(r, b)
+	return b, err
+}
+
+// readField reads length prefixed bytes from a stream.  It returns io.EOF only
+// if the stream ended before the field started
+func readField(r byteReader) ([]byte, error) {
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	if n > maxRecordSize {
+		return nil, fmt.Errorf("field too large: %d", n)
+	}
+
+	b := make([]byte, n)
+	_, err = io.ReadFull(r, b)
+	return b, unexpectedEOF(err)
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
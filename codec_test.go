@@ -0,0 +1,135 @@
+package kelips
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_codec_tuples(t *testing.T) {
+	in := []*Tuple{
+		&Tuple{Key: bytes.Repeat([]byte("k"), 4096), Hosts: []string{"127.0.0.1:8902"}},
+		&Tuple{Key: []byte("dns"), Hosts: []string{"node-1.kelips.example.com:8902", "localhost:9000"}},
+		&Tuple{Key: []byte("ipv6"), Hosts: []string{"[fe80::1%eth0]:8902", "[::1]:8902"}},
+		&Tuple{Key: []byte("meta"), Hosts: []string{"127.0.0.1:3741"}, Meta: map[string]string{"version": "3"}},
+		&Tuple{Key: []byte("nohosts")},
+		&Tuple{Key: []byte("leased"), Hosts: []string{"127.0.0.1:3741"}, TTL: 90 * time.Second},
+	}
+
+	buf := bytes.NewBuffer(nil)
+	assert.Nil(t, writeTuples(buf, in))
+	assert.Equal(t, codecVersion, buf.Bytes()[0])
+
+	out, err := readTuples(buf)
+	assert.Nil(t, err)
+	assert.Equal(t, len(in), len(out))
+	for i := range in {
+		assert.Equal(t, in[i].Key, out[i].Key)
+		assert.Equal(t, len(in[i].Hosts), len(out[i].Hosts))
+		for j := range in[i].Hosts {
+			assert.Equal(t, in[i].Hosts[j], out[i].Hosts[j])
+		}
+		assert.Equal(t, in[i].Meta, out[i].Meta)
+		assert.Equal(t, in[i].TTL, out[i].TTL)
+	}
+
+	// Tuples encoded without a ttl are accepted
+	legacy := appendBytes([]byte{codecVersion}, encodeTuple(nil, in[3]))
+	out, err = readTuples(bytes.NewReader(legacy))
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(out))
+	assert.Equal(t, in[3].Meta, out[0].Meta)
+	assert.EqualValues(t, 0, out[0].TTL)
+
+	// Empty snapshot
+	out, err = readTuples(bytes.NewBuffer(nil))
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(out))
+}
+
+func Test_codec_errors(t *testing.T) {
+	// Invalid hosts are reported rather than encoded
+	err := writeTuples(bytes.NewBuffer(nil), []*Tuple{&Tuple{Key: []byte("k"), Hosts: []string{"no-port"}}})
+	assert.NotNil(t, err)
+	assert.NotNil(t, writeMessageHeader(bytes.NewBuffer(nil), tupleMsgInsert, ""))
+
+	// Unknown versions are rejected
+	_, err = readTuples(bytes.NewBuffer([]byte{codecVersion + 1, 0}))
+	assert.NotNil(t, err)
+	_, _, err = readMessageHeader(bytes.NewReader([]byte{codecVersion + 1, tupleMsgInsert}))
+	assert.NotNil(t, err)
+
+	// Truncated input
+	buf := bytes.NewBuffer(nil)
+	assert.Nil(t, writeTuples(buf, testTuples[:2]))
+	b := buf.Bytes()
+	out, err := readTuples(bytes.NewReader(b[:len(b)-3]))
+	assert.NotNil(t, err)
+	assert.Equal(t, 1, len(out))
+
+	_, err = readKeys(bytes.NewReader([]byte{10, 'a'}))
+	assert.NotNil(t, err)
+}
+
+func Test_codec_message(t *testing.T) {
+	keys := [][]byte{[]byte("a"), bytes.Repeat([]byte("b"), 300), []byte("c/d")}
+
+	buf := bytes.NewBuffer(nil)
+	assert.Nil(t, writeMessageHeader(buf, tupleMsgDelete, "node-1.kelips.example.com:8902"))
+	assert.Nil(t, writeKeys(buf, keys))
+
+	r := bytes.NewReader(buf.Bytes())
+	typ, host, err := readMessageHeader(r)
+	assert.Nil(t, err)
+	assert.Equal(t, tupleMsgDelete, typ)
+	assert.Equal(t, "node-1.kelips.example.com:8902", host)
+
+	out, err := readKeys(r)
+	assert.Nil(t, err)
+	assert.Equal(t, keys, out)
+}
+
+func Test_codec_lease(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	assert.Nil(t, writeRenew(buf, []byte("a/b"), time.Minute))
+	key, ttl, err := readRenew(bytes.NewReader(buf.Bytes()))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("a/b"), key)
+	assert.Equal(t, time.Minute, ttl)
+
+	buf.Reset()
+	assert.NotNil(t, writeRelease(buf, []byte("a/b"), "no-port"))
+	assert.Nil(t, writeRelease(buf, []byte("a/b"), "127.0.0.1:8902"))
+	key, host, err := readRelease(bytes.NewReader(buf.Bytes()))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("a/b"), key)
+	assert.Equal(t, "127.0.0.1:8902", host)
+
+	_, _, err = readRelease(bytes.NewReader(buf.Bytes()[:4]))
+	assert.NotNil(t, err)
+}
+
+func Test_codec_resize(t *testing.T) {
+	in := &resizeMsg{Type: resizeMsgDone, Host: "127.0.0.1:8902", Epoch: 300, K: 12}
+
+	buf := bytes.NewBuffer(nil)
+	assert.Nil(t, writeResizeMsg(buf, in))
+	out, err := readResizeMsg(bytes.NewReader(buf.Bytes()))
+	assert.Nil(t, err)
+	assert.Equal(t, in, out)
+
+	_, err = readResizeMsg(bytes.NewReader(buf.Bytes()[:buf.Len()-1]))
+	assert.NotNil(t, err)
+
+	buf.Reset()
+	assert.Nil(t, writeResizeMsg(buf, &resizeMsg{Type: 9, Host: "127.0.0.1:8902", Epoch: 1, K: 1}))
+	_, err = readResizeMsg(bytes.NewReader(buf.Bytes()))
+	assert.NotNil(t, err)
+
+	buf.Reset()
+	assert.Nil(t, writeResizeMsg(buf, &resizeMsg{Type: resizeMsgPropose, Host: "127.0.0.1:8902", Epoch: 1}))
+	_, err = readResizeMsg(bytes.NewReader(buf.Bytes()))
+	assert.NotNil(t, err)
+}
@@ -2,22 +2,42 @@ package kelips
 
 import (
 	"crypto/sha256"
+	"errors"
+	"fmt"
 	"hash"
 	"time"
 
 	"github.com/hexablock/log"
 )
 
+// NoContactRetries disables trying alternate contacts on transport errors.
+// An unset ContactRetries uses the default
+const NoContactRetries = -1
+
 // Config holds the kelips config to init a new instance
 type Config struct {
 	K                 int64                 // Number of affinity groups
 	HashFunc          func() hash.Hash      // Hash function
+	Mapper            GroupMapper           // Maps keys and hosts to affinity groups
 	TupleTTL          time.Duration         // TTL from last seen before removing
 	TupleExpireMinInt time.Duration         // Interval min to check for expirations
 	TupleExpireMaxInt time.Duration         // Interval max to check for expirations
+	ReplicationFactor int                   // Number of home nodes per tuple
+	ContactRetries    int                   // Alternate contacts to try on transport errors.  NoContactRetries for none
+	SuspectTimeout    time.Duration         // Time a failed contact is tried last
+	ProbeInterval     time.Duration         // Interval to measure contact rtt
+	MaxContacts       int                   // Contacts kept per foreign group.  Zero is unlimited
+	ContactRefresh    time.Duration         // Interval to replace contacts with better ones
+	Placement         PlacementPolicy       // Selects the home nodes of new tuples
+	Capacity          int64                 // Max tuples homed on this node.  Zero is unlimited
+	LoadInterval      time.Duration         // Interval to advertise load over gossip
+	ResizeTimeout     time.Duration         // Time to await other nodes moving tuples on resize
+	Zone              string                // Availability zone of this node
+	Rack              string                // Rack of this node within the zone
 	Transport         Transport             // Network transport
-	Tuples            TupleStorage          // Tuple store
+	Tuples            TupleStorage          // Tuple store i.e. InmemTuples or FileTuples.  Closed on shutdown
 	Contacts          ContactStorageFactory // Contact store
+	Metrics           MetricsCollector      // Metrics sink i.e. PrometheusMetrics
 	Logger            *log.Logger
 }
 
@@ -25,32 +45,138 @@ type Config struct {
 func DefaultConfig() *Config {
 	return &Config{
 		HashFunc:          sha256.New,
+		Mapper:            ModuloMapper{},
 		TupleTTL:          45 * time.Second,
 		TupleExpireMinInt: 30 * time.Second,
 		TupleExpireMaxInt: 40 * time.Second,
+		ReplicationFactor: 1,
+		ContactRetries:    2,
+		SuspectTimeout:    30 * time.Second,
+		ProbeInterval:     10 * time.Second,
+		ContactRefresh:    30 * time.Second,
+		Placement:         RandomPlacement{},
+		LoadInterval:      10 * time.Second,
+		ResizeTimeout:     5 * time.Minute,
 		Logger:            log.NewDefaultLogger(),
 	}
 }
 
-// Validate validates the config and sets defaults
-func (conf *Config) Validate() {
+// Validate sets defaults for unset values and returns an error if the
+// config is inconsistent.  Defaults are those of DefaultConfig.  If only one
+// of the expiration intervals is set the other is set to the same value
+func (conf *Config) Validate() error {
+	conf.setDefaults()
+
+	if conf.Transport == nil {
+		return errors.New("transport required")
+	}
+	return conf.validateValues()
+}
+
+// validateValues checks all values other than the backends
+func (conf *Config) validateValues() error {
+	switch {
+	case conf.K <= 0:
+		return fmt.Errorf("invalid k %d: must be greater than 0", conf.K)
+	case conf.ReplicationFactor < 0:
+		return fmt.Errorf("invalid replication factor %d", conf.ReplicationFactor)
+	case conf.ContactRetries < NoContactRetries:
+		return fmt.Errorf("invalid contact retries %d", conf.ContactRetries)
+	case conf.TupleTTL < 0:
+		return fmt.Errorf("invalid tuple ttl %v", conf.TupleTTL)
+	case conf.SuspectTimeout < 0:
+		return fmt.Errorf("invalid suspect timeout %v", conf.SuspectTimeout)
+	case conf.ProbeInterval < 0:
+		return fmt.Errorf("invalid probe interval %v", conf.ProbeInterval)
+	case conf.MaxContacts < 0:
+		return fmt.Errorf("invalid max contacts %d", conf.MaxContacts)
+	case conf.ContactRefresh < 0:
+		return fmt.Errorf("invalid contact refresh %v", conf.ContactRefresh)
+	case conf.Capacity < 0:
+		return fmt.Errorf("invalid capacity %d", conf.Capacity)
+	case conf.LoadInterval < 0:
+		return fmt.Errorf("invalid load interval %v", conf.LoadInterval)
+	case conf.ResizeTimeout < 0:
+		return fmt.Errorf("invalid resize timeout %v", conf.ResizeTimeout)
+	case conf.TupleExpireMinInt < 0 || conf.TupleExpireMaxInt < 0:
+		return fmt.Errorf("invalid tuple expire interval min=%v max=%v",
+			conf.TupleExpireMinInt, conf.TupleExpireMaxInt)
+	case conf.TupleExpireMinInt > conf.TupleExpireMaxInt:
+		return fmt.Errorf("tuple expire min %v greater than max %v",
+			conf.TupleExpireMinInt, conf.TupleExpireMaxInt)
+	}
+
+	return nil
+}
+
+// setDefaults sets all unset values from DefaultConfig.  It is called by New
+// which cannot fail so invalid values are left as is
+func (conf *Config) setDefaults() {
+	def := DefaultConfig()
+
 	if conf.Tuples == nil {
 		conf.Tuples = NewInmemTuples()
 	}
 
+	if conf.Metrics == nil {
+		conf.Metrics = noopMetrics{}
+	}
+
+	if conf.Logger == nil {
+		conf.Logger = def.Logger
+	}
+
 	if conf.HashFunc == nil {
-		conf.HashFunc = sha256.New
+		conf.HashFunc = def.HashFunc
+	}
+
+	if conf.Mapper == nil {
+		conf.Mapper = def.Mapper
+	}
+
+	if conf.ReplicationFactor == 0 {
+		conf.ReplicationFactor = def.ReplicationFactor
+	}
+
+	if conf.ContactRetries == 0 {
+		conf.ContactRetries = def.ContactRetries
+	}
+
+	if conf.SuspectTimeout == 0 {
+		conf.SuspectTimeout = def.SuspectTimeout
+	}
+
+	if conf.ProbeInterval == 0 {
+		conf.ProbeInterval = def.ProbeInterval
+	}
+
+	if conf.ContactRefresh == 0 {
+		conf.ContactRefresh = def.ContactRefresh
+	}
+
+	if conf.Placement == nil {
+		conf.Placement = def.Placement
+	}
+
+	if conf.LoadInterval == 0 {
+		conf.LoadInterval = def.LoadInterval
+	}
+
+	if conf.ResizeTimeout == 0 {
+		conf.ResizeTimeout = def.ResizeTimeout
 	}
 
 	if conf.TupleTTL == 0 {
-		conf.TupleTTL = 30 * time.Second
+		conf.TupleTTL = def.TupleTTL
 	}
 
-	if conf.TupleExpireMaxInt == 0 {
-		conf.TupleExpireMaxInt = 30 * time.Second
-		conf.TupleExpireMinInt = 20 * time.Second
-	} else if conf.TupleExpireMinInt == 0 {
-		conf.TupleExpireMinInt = 20 * time.Second
-		conf.TupleExpireMaxInt = 30 * time.Second
+	switch {
+	case conf.TupleExpireMinInt == 0 && conf.TupleExpireMaxInt == 0:
+		conf.TupleExpireMinInt = def.TupleExpireMinInt
+		conf.TupleExpireMaxInt = def.TupleExpireMaxInt
+	case conf.TupleExpireMinInt == 0:
+		conf.TupleExpireMinInt = conf.TupleExpireMaxInt
+	case conf.TupleExpireMaxInt == 0:
+		conf.TupleExpireMaxInt = conf.TupleExpireMinInt
 	}
 }
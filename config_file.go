@@ -0,0 +1,559 @@
+package kelips
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/euforia/gossip"
+	"github.com/hexablock/log"
+	"gopkg.in/yaml.v3"
+)
+
+// EnvPrefix is the prefix of all environment variables read by LoadConfig
+const EnvPrefix = "KELIPS_"
+
+// Duration is a time.Duration read from a string such as 30s in config
+// files and the environment
+type Duration time.Duration
+
+// UnmarshalText satisfies the encoding.TextUnmarshaler interface
+func (d *Duration) UnmarshalText(b []byte) error {
+	v, err := time.ParseDuration(string(b))
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// MarshalText satisfies the encoding.TextMarshaler interface
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+// FileConfig is the serializable form of Config and the gossip config it is
+// paired with.  Backends are selected by name.  Each field can be set with
+// the environment variable named by its env tag prefixed with EnvPrefix
+type FileConfig struct {
+	K                 int64    `json:"k" yaml:"k" toml:"k" env:"K"`
+	Hash              string   `json:"hash" yaml:"hash" toml:"hash" env:"HASH"`
+	GroupMapper       string   `json:"group_mapper" yaml:"group_mapper" toml:"group_mapper" env:"GROUP_MAPPER"`
+	KeyMapper         string   `json:"key_mapper" yaml:"key_mapper" toml:"key_mapper" env:"KEY_MAPPER"`
+	TupleTTL          Duration `json:"tuple_ttl" yaml:"tuple_ttl" toml:"tuple_ttl" env:"TUPLE_TTL"`
+	TupleExpireMin    Duration `json:"tuple_expire_min" yaml:"tuple_expire_min" toml:"tuple_expire_min" env:"TUPLE_EXPIRE_MIN"`
+	TupleExpireMax    Duration `json:"tuple_expire_max" yaml:"tuple_expire_max" toml:"tuple_expire_max" env:"TUPLE_EXPIRE_MAX"`
+	ReplicationFactor int      `json:"replication_factor" yaml:"replication_factor" toml:"replication_factor" env:"REPLICATION_FACTOR"`
+	ContactRetries    int      `json:"contact_retries" yaml:"contact_retries" toml:"contact_retries" env:"CONTACT_RETRIES"`
+	SuspectTimeout    Duration `json:"suspect_timeout" yaml:"suspect_timeout" toml:"suspect_timeout" env:"SUSPECT_TIMEOUT"`
+	ProbeInterval     Duration `json:"probe_interval" yaml:"probe_interval" toml:"probe_interval" env:"PROBE_INTERVAL"`
+	MaxContacts       int      `json:"max_contacts" yaml:"max_contacts" toml:"max_contacts" env:"MAX_CONTACTS"`
+	ContactRefresh    Duration `json:"contact_refresh" yaml:"contact_refresh" toml:"contact_refresh" env:"CONTACT_REFRESH"`
+	Placement         string   `json:"placement" yaml:"placement" toml:"placement" env:"PLACEMENT"`
+	Capacity          int64    `json:"capacity" yaml:"capacity" toml:"capacity" env:"CAPACITY"`
+	LoadInterval      Duration `json:"load_interval" yaml:"load_interval" toml:"load_interval" env:"LOAD_INTERVAL"`
+	ResizeTimeout     Duration `json:"resize_timeout" yaml:"resize_timeout" toml:"resize_timeout" env:"RESIZE_TIMEOUT"`
+	Zone              string   `json:"zone" yaml:"zone" toml:"zone" env:"ZONE"`
+	Rack              string   `json:"rack" yaml:"rack" toml:"rack" env:"RACK"`
+	Debug             bool     `json:"debug" yaml:"debug" toml:"debug" env:"DEBUG"`
+
+	// Hosts pinned to groups.  In the environment as host=group pairs
+	// separated by commas
+	NodeGroups map[string]int64 `json:"node_groups" yaml:"node_groups" toml:"node_groups" env:"NODE_GROUPS"`
+
+	Transport TransportConfig `json:"transport" yaml:"transport" toml:"transport" env:"TRANSPORT"`
+	Tuples    TuplesConfig    `json:"tuples" yaml:"tuples" toml:"tuples" env:"TUPLES"`
+	Gossip    GossipConfig    `json:"gossip" yaml:"gossip" toml:"gossip" env:"GOSSIP"`
+}
+
+// TransportConfig selects and configures the transport backend
+type TransportConfig struct {
+	// Backend name i.e. http or grpc
+	Type string `json:"type" yaml:"type" toml:"type" env:"TYPE"`
+	// Use the gossip muxed listener magic number when dialing
+	Magic bool      `json:"magic" yaml:"magic" toml:"magic" env:"MAGIC"`
+	TLS   TLSConfig `json:"tls" yaml:"tls" toml:"tls" env:"TLS"`
+}
+
+// TLSConfig enables mutual TLS between peers when Cert is set.  It is only
+// supported by the http transport
+type TLSConfig struct {
+	Cert string `json:"cert" yaml:"cert" toml:"cert" env:"CERT"`
+	Key  string `json:"key" yaml:"key" toml:"key" env:"KEY"`
+	CA   string `json:"ca" yaml:"ca" toml:"ca" env:"CA"`
+	// Require peer certificates to match the peer host
+	VerifyHost bool `json:"verify_host" yaml:"verify_host" toml:"verify_host" env:"VERIFY_HOST"`
+}
+
+// TuplesConfig selects and configures the tuple storage backend
+type TuplesConfig struct {
+	// Backend name i.e. inmem or file
+	Type string `json:"type" yaml:"type" toml:"type" env:"TYPE"`
+	// Path of the file backend
+	Path string `json:"path" yaml:"path" toml:"path" env:"PATH"`
+}
+
+// GossipConfig holds the gossip addresses
+type GossipConfig struct {
+	// Address other nodes use to reach this node.  It is also the node name
+	AdvertiseAddr string `json:"advertise_addr" yaml:"advertise_addr" toml:"advertise_addr" env:"ADVERTISE_ADDR"`
+	// Address to listen on.  Defaults to the advertise address
+	BindAddr string `json:"bind_addr" yaml:"bind_addr" toml:"bind_addr" env:"BIND_ADDR"`
+}
+
+// TransportFactory returns a new transport for the config
+type TransportFactory func(conf *FileConfig) (Transport, error)
+
+// TupleStorageFactory returns a new tuple store for the config
+type TupleStorageFactory func(conf *FileConfig, logger *log.Logger) (TupleStorage, error)
+
+var (
+	backendsMu sync.RWMutex
+
+	transportBackends = map[string]TransportFactory{
+		"http": newHTTPTransportBackend,
+		"grpc": newGRPCTransportBackend,
+	}
+
+	tupleBackends = map[string]TupleStorageFactory{
+		"inmem": func(*FileConfig, *log.Logger) (TupleStorage, error) {
+			return NewInmemTuples(), nil
+		},
+		"file": newFileTuplesBackend,
+	}
+
+	hashFuncs = map[string]func() hash.Hash{
+		"sha1":   sha1.New,
+		"sha256": sha256.New,
+		"sha512": sha512.New,
+	}
+
+	groupMappers = map[string]GroupMapper{
+		"modulo": ModuloMapper{},
+		"jump":   JumpMapper{},
+	}
+
+	placementPolicies = map[string]PlacementPolicy{
+		"random":            RandomPlacement{},
+		"least-tuples":      LeastTuplesPlacement{},
+		"capacity-weighted": CapacityWeightedPlacement{},
+		"consistent-hash":   ConsistentHashPlacement{},
+	}
+)
+
+// RegisterTransport registers a named transport backend.  It replaces any
+// existing backend with the same name
+func RegisterTransport(name string, fn TransportFactory) {
+	backendsMu.Lock()
+	transportBackends[name] = fn
+	backendsMu.Unlock()
+}
+
+// RegisterTupleStorage registers a named tuple storage backend.  It replaces
+// any existing backend with the same name
+func RegisterTupleStorage(name string, fn TupleStorageFactory) {
+	backendsMu.Lock()
+	tupleBackends[name] = fn
+	backendsMu.Unlock()
+}
+
+// DefaultFileConfig returns the serializable form of DefaultConfig with the
+// http transport and in-memory tuples
+func DefaultFileConfig() *FileConfig {
+	def := DefaultConfig()
+	return &FileConfig{
+		K:                 3,
+		Hash:              "sha256",
+		GroupMapper:       "modulo",
+		TupleTTL:          Duration(def.TupleTTL),
+		TupleExpireMin:    Duration(def.TupleExpireMinInt),
+		TupleExpireMax:    Duration(def.TupleExpireMaxInt),
+		ReplicationFactor: def.ReplicationFactor,
+		ContactRetries:    def.ContactRetries,
+		SuspectTimeout:    Duration(def.SuspectTimeout),
+		ProbeInterval:     Duration(def.ProbeInterval),
+		ContactRefresh:    Duration(def.ContactRefresh),
+		Placement:         "random",
+		LoadInterval:      Duration(def.LoadInterval),
+		ResizeTimeout:     Duration(def.ResizeTimeout),
+		Transport:         TransportConfig{Type: "http", Magic: true},
+		Tuples:            TuplesConfig{Type: "inmem"},
+		Gossip:            GossipConfig{AdvertiseAddr: "127.0.0.1:10000"},
+	}
+}
+
+// LoadConfig returns the default config overridden by the file, if path is
+// not empty, followed by KELIPS_* environment variables.  The file format is
+// picked by the extension i.e. .yaml, .yml, .toml or .json.  Unknown fields
+// and invalid values are an error
+func LoadConfig(path string) (*FileConfig, error) {
+	conf := DefaultFileConfig()
+
+	if path != "" {
+		if err := conf.LoadFile(path); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := conf.LoadEnv(os.LookupEnv); err != nil {
+		return nil, err
+	}
+
+	if err := conf.Validate(); err != nil {
+		return nil, err
+	}
+	return conf, nil
+}
+
+// LoadFile decodes the file over the current values
+func (conf *FileConfig) LoadFile(path string) error {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".yaml", ".yml":
+		dec := yaml.NewDecoder(bytes.NewReader(b))
+		dec.KnownFields(true)
+		// An empty document leaves the values as is
+		if err = dec.Decode(conf); err == io.EOF {
+			err = nil
+		}
+
+	case ".toml":
+		var md toml.MetaData
+		if md, err = toml.Decode(string(b), conf); err == nil {
+			if undecoded := md.Undecoded(); len(undecoded) > 0 {
+				err = fmt.Errorf("unknown field %q", undecoded[0].String())
+			}
+		}
+
+	case ".json":
+		dec := json.NewDecoder(bytes.NewReader(b))
+		dec.DisallowUnknownFields()
+		err = dec.Decode(conf)
+
+	default:
+		return fmt.Errorf("unsupported config format %q: %s", ext, path)
+	}
+
+	if err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	return nil
+}
+
+// LoadEnv sets the values of all variables found by lookup e.g.
+// os.LookupEnv.  Names are EnvPrefix followed by the env tags of the
+// field path joined by an underscore e.g. KELIPS_TRANSPORT_TLS_CERT
+func (conf *FileConfig) LoadEnv(lookup func(string) (string, bool)) error {
+	return loadEnv(reflect.ValueOf(conf).Elem(), strings.TrimSuffix(EnvPrefix, "_"), lookup)
+}
+
+var (
+	durationType          = reflect.TypeOf(Duration(0))
+	errUnsupportedEnvType = errors.New("unsupported type")
+)
+
+func loadEnv(v reflect.Value, prefix string, lookup func(string) (string, bool)) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		tag := t.Field(i).Tag.Get("env")
+		if tag == "" {
+			continue
+		}
+		name := prefix + "_" + tag
+		field := v.Field(i)
+
+		if field.Kind() == reflect.Struct {
+			if err := loadEnv(field, name, lookup); err != nil {
+				return err
+			}
+			continue
+		}
+
+		val, ok := lookup(name)
+		if !ok {
+			continue
+		}
+		if err := setEnvValue(field, val); err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+func setEnvValue(field reflect.Value, val string) error {
+	if field.Type() == durationType {
+		d, err := time.ParseDuration(val)
+		if err == nil {
+			field.SetInt(int64(d))
+		}
+		return err
+	}
+
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(val)
+
+	case reflect.Bool:
+		b, err := strconv.ParseBool(val)
+		if err != nil {
+			return err
+		}
+		field.SetBool(b)
+
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(val, 10, 64)
+		if err != nil {
+			return err
+		}
+		field.SetInt(n)
+
+	case reflect.Map:
+		if field.Type().Key().Kind() != reflect.String || field.Type().Elem().Kind() != reflect.Int64 {
+			return errUnsupportedEnvType
+		}
+		m := reflect.MakeMap(field.Type())
+		for _, pair := range strings.Split(val, ",") {
+			i := strings.LastIndex(pair, "=")
+			if i < 0 {
+				return fmt.Errorf("invalid pair %q", pair)
+			}
+			n, err := strconv.ParseInt(pair[i+1:], 10, 64)
+			if err != nil {
+				return err
+			}
+			m.SetMapIndex(reflect.ValueOf(pair[:i]), reflect.ValueOf(n))
+		}
+		field.Set(m)
+
+	default:
+		return errUnsupportedEnvType
+	}
+
+	return nil
+}
+
+// Validate returns an error if a value is invalid, a backend is unknown or
+// the durations are inconsistent
+func (conf *FileConfig) Validate() error {
+	backendsMu.RLock()
+	_, okTrans := transportBackends[conf.Transport.Type]
+	_, okTuples := tupleBackends[conf.Tuples.Type]
+	backendsMu.RUnlock()
+
+	switch {
+	case conf.K <= 0:
+		return fmt.Errorf("invalid k %d: must be greater than 0", conf.K)
+	case !okTrans:
+		return fmt.Errorf("unknown transport %q: one of %s", conf.Transport.Type, backendNames(true))
+	case !okTuples:
+		return fmt.Errorf("unknown tuple storage %q: one of %s", conf.Tuples.Type, backendNames(false))
+	case hashFuncs[conf.Hash] == nil:
+		return fmt.Errorf("unknown hash %q", conf.Hash)
+	case groupMappers[conf.GroupMapper] == nil:
+		return fmt.Errorf("unknown group mapper %q", conf.GroupMapper)
+	case conf.KeyMapper != "" && groupMappers[conf.KeyMapper] == nil:
+		return fmt.Errorf("unknown key mapper %q", conf.KeyMapper)
+	case placementPolicies[conf.Placement] == nil:
+		return fmt.Errorf("unknown placement %q", conf.Placement)
+	case conf.Tuples.Type == "file" && conf.Tuples.Path == "":
+		return errors.New("file tuple storage requires a path")
+	case conf.Transport.TLS.Cert != "" && (conf.Transport.TLS.Key == "" || conf.Transport.TLS.CA == ""):
+		return errors.New("tls key and ca required with cert")
+	case conf.Transport.TLS.Cert != "" && conf.Transport.Type != "http":
+		return fmt.Errorf("tls not supported by transport %q", conf.Transport.Type)
+	}
+
+	if _, _, err := splitHostPort(conf.Gossip.AdvertiseAddr); err != nil {
+		return fmt.Errorf("invalid advertise address: %v", err)
+	}
+	for host, id := range conf.NodeGroups {
+		if id < 0 {
+			return fmt.Errorf("invalid group %d for node %s", id, host)
+		}
+	}
+	if conf.Gossip.BindAddr != "" {
+		if _, _, err := splitHostPort(conf.Gossip.BindAddr); err != nil {
+			return fmt.Errorf("invalid bind address: %v", err)
+		}
+	}
+
+	// Check the remaining values against the same rules as Config
+	kconf := conf.config()
+	kconf.setDefaults()
+	return kconf.validateValues()
+}
+
+// Config returns a validated kelips config with the backends created
+func (conf *FileConfig) Config() (*Config, error) {
+	if err := conf.Validate(); err != nil {
+		return nil, err
+	}
+
+	kconf := conf.config()
+	kconf.Logger.EnableDebug(conf.Debug)
+
+	backendsMu.RLock()
+	newTransport := transportBackends[conf.Transport.Type]
+	newTuples := tupleBackends[conf.Tuples.Type]
+	backendsMu.RUnlock()
+
+	var err error
+	if kconf.Transport, err = newTransport(conf); err != nil {
+		return nil, err
+	}
+	if kconf.Tuples, err = newTuples(conf, kconf.Logger); err != nil {
+		return nil, err
+	}
+
+	return kconf, kconf.Validate()
+}
+
+// config returns the kelips config without backends
+func (conf *FileConfig) config() *Config {
+	kconf := DefaultConfig()
+	kconf.K = conf.K
+	kconf.HashFunc = hashFuncs[conf.Hash]
+	kconf.Mapper = conf.mapper()
+	kconf.TupleTTL = time.Duration(conf.TupleTTL)
+	kconf.TupleExpireMinInt = time.Duration(conf.TupleExpireMin)
+	kconf.TupleExpireMaxInt = time.Duration(conf.TupleExpireMax)
+	kconf.ReplicationFactor = conf.ReplicationFactor
+	kconf.ContactRetries = conf.ContactRetries
+	kconf.SuspectTimeout = time.Duration(conf.SuspectTimeout)
+	kconf.ProbeInterval = time.Duration(conf.ProbeInterval)
+	kconf.MaxContacts = conf.MaxContacts
+	kconf.ContactRefresh = time.Duration(conf.ContactRefresh)
+	kconf.Placement = placementPolicies[conf.Placement]
+	kconf.Capacity = conf.Capacity
+	kconf.LoadInterval = time.Duration(conf.LoadInterval)
+	kconf.ResizeTimeout = time.Duration(conf.ResizeTimeout)
+	kconf.Zone = conf.Zone
+	kconf.Rack = conf.Rack
+	return kconf
+}
+
+// mapper returns the named group mapper with the node groups pinned and keys
+// mapped by the key mapper if set
+func (conf *FileConfig) mapper() GroupMapper {
+	m := groupMappers[conf.GroupMapper]
+	if len(conf.NodeGroups) > 0 {
+		m = TableMapper{Nodes: conf.NodeGroups, Fallback: m}
+	}
+	if conf.KeyMapper != "" {
+		m = SplitMapper{Keys: groupMappers[conf.KeyMapper], Nodes: m}
+	}
+	return m
+}
+
+// GossipConfig returns the gossip config for the addresses
+func (conf *FileConfig) GossipConfig() (*gossip.Config, error) {
+	ip, port, err := splitHostPort(conf.Gossip.AdvertiseAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	gconf := gossip.DefaultConfig()
+	gconf.Name = conf.Gossip.AdvertiseAddr
+	gconf.Debug = conf.Debug
+	gconf.AdvertiseAddr = ip
+	gconf.AdvertisePort = port
+	gconf.BindAddr = ip
+	gconf.BindPort = port
+
+	if conf.Gossip.BindAddr != "" {
+		if gconf.BindAddr, gconf.BindPort, err = splitHostPort(conf.Gossip.BindAddr); err != nil {
+			return nil, err
+		}
+	}
+
+	return gconf, nil
+}
+
+func splitHostPort(addr string) (string, int, error) {
+	host, p, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", 0, err
+	}
+	port, err := strconv.Atoi(p)
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid port: %s", p)
+	}
+	return host, port, nil
+}
+
+func backendNames(transports bool) string {
+	backendsMu.RLock()
+	names := make([]string, 0)
+	if transports {
+		for name := range transportBackends {
+			names = append(names, name)
+		}
+	} else {
+		for name := range tupleBackends {
+			names = append(names, name)
+		}
+	}
+	backendsMu.RUnlock()
+
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+func newHTTPTransportBackend(conf *FileConfig) (Transport, error) {
+	tconf := conf.Transport.TLS
+	if tconf.Cert == "" {
+		return NewHTTPTransport(conf.Transport.Magic), nil
+	}
+
+	cr, err := NewCertReloader(tconf.Cert, tconf.Key)
+	if err != nil {
+		return nil, err
+	}
+
+	pool, err := NewCertPool(tconf.CA)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewHTTPTransport(conf.Transport.Magic,
+		WithServerTLS(&tls.Config{
+			GetCertificate: cr.GetCertificate,
+			ClientAuth:     tls.RequireAndVerifyClientCert,
+			ClientCAs:      pool,
+		}),
+		WithClientTLS(&tls.Config{
+			GetClientCertificate: cr.GetClientCertificate,
+			RootCAs:              pool,
+		}, tconf.VerifyHost),
+	), nil
+}
+
+func newGRPCTransportBackend(conf *FileConfig) (Transport, error) {
+	return NewGRPCTransport(conf.Transport.Magic), nil
+}
+
+func newFileTuplesBackend(conf *FileConfig, logger *log.Logger) (TupleStorage, error) {
+	return NewFileTuples(conf.Tuples.Path, logger)
+}
@@ -0,0 +1,244 @@
+package kelips
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hexablock/log"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Config_Validate(t *testing.T) {
+	conf := &Config{K: 3, Transport: NewHTTPTransport(false)}
+	assert.Nil(t, conf.Validate())
+	// Defaults match DefaultConfig
+	def := DefaultConfig()
+	assert.Equal(t, def.TupleTTL, conf.TupleTTL)
+	assert.Equal(t, def.TupleExpireMinInt, conf.TupleExpireMinInt)
+	assert.Equal(t, def.TupleExpireMaxInt, conf.TupleExpireMaxInt)
+	assert.Equal(t, def.ContactRetries, conf.ContactRetries)
+
+	// Retries can be disabled
+	conf = &Config{K: 3, Transport: NewHTTPTransport(false), ContactRetries: NoContactRetries}
+	assert.Nil(t, conf.Validate())
+	assert.Equal(t, NoContactRetries, conf.ContactRetries)
+
+	// A single interval sets the other
+	conf = &Config{K: 3, Transport: NewHTTPTransport(false), TupleExpireMaxInt: time.Second}
+	assert.Nil(t, conf.Validate())
+	assert.Equal(t, time.Second, conf.TupleExpireMinInt)
+
+	invalid := []*Config{
+		{K: 0, Transport: NewHTTPTransport(false)},
+		{K: -1, Transport: NewHTTPTransport(false)},
+		{K: 3},
+		{K: 3, Transport: NewHTTPTransport(false), TupleExpireMinInt: 2 * time.Second, TupleExpireMaxInt: time.Second},
+		{K: 3, Transport: NewHTTPTransport(false), ContactRetries: -2},
+		{K: 3, Transport: NewHTTPTransport(false), ReplicationFactor: -1},
+		{K: 3, Transport: NewHTTPTransport(false), TupleTTL: -time.Second},
+		{K: 3, Transport: NewHTTPTransport(false), Capacity: -1},
+		{K: 3, Transport: NewHTTPTransport(false), MaxContacts: -1},
+	}
+	for i, c := range invalid {
+		assert.NotNil(t, c.Validate(), "config=%d", i)
+	}
+}
+
+func writeConfigFile(t *testing.T, dir, name, content string) string {
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func Test_FileConfig_LoadFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kelips-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"kelips.yaml": `
+k: 5
+tuple_ttl: 1m
+tuple_expire_min: 10s
+tuple_expire_max: 20s
+transport:
+  type: grpc
+tuples:
+  type: file
+  path: /tmp/tuples
+gossip:
+  advertise_addr: 10.0.0.1:4000
+`,
+		"kelips.toml": `
+k = 5
+tuple_ttl = "1m"
+tuple_expire_min = "10s"
+tuple_expire_max = "20s"
+
+[transport]
+type = "grpc"
+
+[tuples]
+type = "file"
+path = "/tmp/tuples"
+
+[gossip]
+advertise_addr = "10.0.0.1:4000"
+`,
+		"kelips.json": `{
+  "k": 5,
+  "tuple_ttl": "1m",
+  "tuple_expire_min": "10s",
+  "tuple_expire_max": "20s",
+  "transport": {"type": "grpc"},
+  "tuples": {"type": "file", "path": "/tmp/tuples"},
+  "gossip": {"advertise_addr": "10.0.0.1:4000"}
+}`,
+	}
+
+	for name, content := range files {
+		conf := DefaultFileConfig()
+		err := conf.LoadFile(writeConfigFile(t, dir, name, content))
+		assert.Nil(t, err, name)
+		assert.Equal(t, int64(5), conf.K, name)
+		assert.Equal(t, Duration(time.Minute), conf.TupleTTL, name)
+		assert.Equal(t, Duration(10*time.Second), conf.TupleExpireMin, name)
+		assert.Equal(t, Duration(20*time.Second), conf.TupleExpireMax, name)
+		assert.Equal(t, "grpc", conf.Transport.Type, name)
+		assert.Equal(t, "file", conf.Tuples.Type, name)
+		assert.Equal(t, "/tmp/tuples", conf.Tuples.Path, name)
+		assert.Equal(t, "10.0.0.1:4000", conf.Gossip.AdvertiseAddr, name)
+		// Unset values keep their defaults
+		assert.Equal(t, "sha256", conf.Hash, name)
+		assert.Nil(t, conf.Validate(), name)
+	}
+
+	conf := DefaultFileConfig()
+	assert.NotNil(t, conf.LoadFile(writeConfigFile(t, dir, "unknown.yaml", "kk: 1\n")))
+	assert.NotNil(t, conf.LoadFile(writeConfigFile(t, dir, "unknown.json", `{"kk": 1}`)))
+	assert.NotNil(t, conf.LoadFile(writeConfigFile(t, dir, "bad.yaml", "tuple_ttl: forever\n")))
+	assert.NotNil(t, conf.LoadFile(writeConfigFile(t, dir, "kelips.ini", "k=1\n")))
+	assert.NotNil(t, conf.LoadFile(filepath.Join(dir, "missing.yaml")))
+	assert.Nil(t, conf.LoadFile(writeConfigFile(t, dir, "empty.yaml", "")))
+}
+
+func Test_FileConfig_LoadEnv(t *testing.T) {
+	env := map[string]string{
+		"KELIPS_K":                     "7",
+		"KELIPS_TUPLE_EXPIRE_MAX":      "1m",
+		"KELIPS_DEBUG":                 "true",
+		"KELIPS_TRANSPORT_TYPE":        "grpc",
+		"KELIPS_TRANSPORT_TLS_CERT":    "cert.pem",
+		"KELIPS_GOSSIP_ADVERTISE_ADDR": "10.0.0.2:5000",
+		"KELIPS_NODE_GROUPS":           "10.0.0.1:4000=0,[::1]:4000=2",
+	}
+	lookup := func(name string) (string, bool) {
+		v, ok := env[name]
+		return v, ok
+	}
+
+	conf := DefaultFileConfig()
+	assert.Nil(t, conf.LoadEnv(lookup))
+	assert.Equal(t, int64(7), conf.K)
+	assert.Equal(t, Duration(time.Minute), conf.TupleExpireMax)
+	assert.True(t, conf.Debug)
+	assert.Equal(t, "grpc", conf.Transport.Type)
+	assert.Equal(t, "cert.pem", conf.Transport.TLS.Cert)
+	assert.Equal(t, "10.0.0.2:5000", conf.Gossip.AdvertiseAddr)
+	assert.Equal(t, map[string]int64{"10.0.0.1:4000": 0, "[::1]:4000": 2}, conf.NodeGroups)
+
+	env["KELIPS_NODE_GROUPS"] = "10.0.0.1:4000"
+	assert.NotNil(t, DefaultFileConfig().LoadEnv(lookup))
+	delete(env, "KELIPS_NODE_GROUPS")
+
+	env["KELIPS_K"] = "seven"
+	assert.NotNil(t, DefaultFileConfig().LoadEnv(lookup))
+}
+
+func Test_FileConfig_Validate(t *testing.T) {
+	invalid := []func(*FileConfig){
+		func(c *FileConfig) { c.K = 0 },
+		func(c *FileConfig) { c.Transport.Type = "carrier-pigeon" },
+		func(c *FileConfig) { c.Tuples.Type = "tape" },
+		func(c *FileConfig) { c.Hash = "crc32" },
+		func(c *FileConfig) { c.Tuples.Type = "file" },
+		func(c *FileConfig) { c.TupleExpireMin, c.TupleExpireMax = Duration(time.Minute), Duration(time.Second) },
+		func(c *FileConfig) { c.Transport.TLS.Cert = "cert.pem" },
+		func(c *FileConfig) {
+			c.Transport.Type = "grpc"
+			c.Transport.TLS = TLSConfig{Cert: "cert.pem", Key: "key.pem", CA: "ca.pem"}
+		},
+		func(c *FileConfig) { c.Gossip.AdvertiseAddr = "10.0.0.1" },
+		func(c *FileConfig) { c.Gossip.BindAddr = "0.0.0.0:port" },
+		func(c *FileConfig) { c.ContactRetries = -2 },
+		func(c *FileConfig) { c.Placement = "nearest" },
+		func(c *FileConfig) { c.GroupMapper = "ring" },
+		func(c *FileConfig) { c.KeyMapper = "ring" },
+		func(c *FileConfig) { c.NodeGroups = map[string]int64{"10.0.0.1:4000": -1} },
+		func(c *FileConfig) { c.LoadInterval = Duration(-time.Second) },
+		func(c *FileConfig) { c.ResizeTimeout = Duration(-time.Second) },
+	}
+
+	assert.Nil(t, DefaultFileConfig().Validate())
+	for i, fn := range invalid {
+		conf := DefaultFileConfig()
+		fn(conf)
+		assert.NotNil(t, conf.Validate(), "config=%d", i)
+	}
+}
+
+func Test_FileConfig_Config(t *testing.T) {
+	fconf := DefaultFileConfig()
+	fconf.K = 4
+	fconf.Transport.Type = "grpc"
+	fconf.Gossip.BindAddr = "0.0.0.0:4001"
+	fconf.Placement = "least-tuples"
+	fconf.Capacity = 1000
+	fconf.MaxContacts = 4
+
+	conf, err := fconf.Config()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(4), conf.K)
+	assert.Equal(t, LeastTuplesPlacement{}, conf.Placement)
+	assert.Equal(t, ModuloMapper{}, conf.Mapper)
+	assert.Equal(t, int64(1000), conf.Capacity)
+	assert.Equal(t, 4, conf.MaxContacts)
+	assert.Equal(t, 30*time.Second, conf.ContactRefresh)
+	assert.IsType(t, &GRPCTransport{}, conf.Transport)
+	assert.IsType(t, &InmemTuples{}, conf.Tuples)
+	assert.Equal(t, time.Duration(fconf.TupleTTL), conf.TupleTTL)
+
+	gconf, err := fconf.GossipConfig()
+	assert.Nil(t, err)
+	assert.Equal(t, "127.0.0.1", gconf.AdvertiseAddr)
+	assert.Equal(t, 10000, gconf.AdvertisePort)
+	assert.Equal(t, "0.0.0.0", gconf.BindAddr)
+	assert.Equal(t, 4001, gconf.BindPort)
+
+	// Registered backends are selected by name
+	custom := NewInmemTuples()
+	RegisterTupleStorage("custom", func(*FileConfig, *log.Logger) (TupleStorage, error) {
+		return custom, nil
+	})
+	fconf.Tuples.Type = "custom"
+	conf, err = fconf.Config()
+	assert.Nil(t, err)
+	assert.Equal(t, custom, conf.Tuples)
+
+	// Pinned nodes with keys mapped separately
+	fconf.NodeGroups = map[string]int64{"10.0.0.1:4000": 1}
+	fconf.KeyMapper = "jump"
+	conf, err = fconf.Config()
+	assert.Nil(t, err)
+	assert.Equal(t, SplitMapper{
+		Keys:  JumpMapper{},
+		Nodes: TableMapper{Nodes: fconf.NodeGroups, Fallback: ModuloMapper{}},
+	}, conf.Mapper)
+}
@@ -1,13 +1,35 @@
 package kelips
 
-import "github.com/pkg/errors"
+import (
+	"context"
+	"math"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
 
 var (
 	errContactNotFound = errors.New("contact not found")
 	errContactExists   = errors.New("contact exists")
 	errNoContacts      = errors.New("no contacts")
+	// returned by bounded stores when the new peer is not kept
+	errContactDropped = errors.New("contact dropped")
 )
 
+// maxRTT is the rtt assigned to peers that could not be reached
+const maxRTT = time.Duration(math.MaxInt64)
+
+// rttSlack is the relative rtt difference under which contacts are ranked
+// by uptime rather than rtt
+const rttSlack = 0.25
+
+// staleRefreshes is the number of refresh intervals after which a contact
+// that has not been reached is no longer considered live
+const staleRefreshes = 3
+
 // PeerContact implements a kelips peer
 type PeerContact interface {
 	// Returns the ip:port of the peer
@@ -32,46 +54,130 @@ type ContactStorage interface {
 	List() []PeerContact
 	// GetClosest returns the closest node to the querying node
 	GetClosest() (PeerContact, bool)
+	// ListClosest returns all peers excluding self ordered closest first
+	ListClosest() []PeerContact
 	// GetRandom returns a random peer from the storage
 	GetRandom() (PeerContact, bool)
 }
 
 type inmemContactsFac struct {
-	// local host
+	// local host and zone
 	host string
+	zone string
+	// transport used to probe peers
+	trans Transport
+	// contacts kept per foreign group and the interval they are refreshed
+	max     int
+	refresh time.Duration
 }
 
 func (fac *inmemContactsFac) New(id int64, home bool) ContactStorage {
-	return &inmemContacts{
-		id: id, host: fac.host,
-		peers: make(map[string]*Peer),
+	c := &inmemContacts{
+		id: id, host: fac.host, zone: fac.zone,
+		trans:  fac.trans,
+		peers:  make(map[string]*Peer),
+		spares: make(map[string]*Peer),
+		stale:  staleRefreshes * fac.refresh,
+	}
+	// The home group needs all members to place tuples
+	if !home {
+		c.max = fac.max
 	}
+	return c
 }
 
 type inmemContacts struct {
-	id    int64 //group id
-	host  string
+	id   int64 //group id
+	host string
+	// local zone whose peers are preferred
+	zone string
+
+	// transport used to measure rtt to peers
+	trans Transport
+
+	// max contacts.  Zero is unlimited
+	max int
+	// time after which an unreached contact is no longer live
+	stale time.Duration
+
+	mu    sync.RWMutex
 	peers map[string]*Peer
+	// candidates replacing contacts on refresh once max is reached
+	spares map[string]*Peer
 }
 
+// Remove removes the peer promoting a spare if it was a contact
 func (c *inmemContacts) Remove(p PeerContact) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
 	host := p.Address()
-	if _, ok := c.peers[host]; !ok {
+	if _, ok := c.spares[host]; ok {
+		delete(c.spares, host)
+		return nil
+	}
+	if _, ok := c.peers[host]; ok {
 		delete(c.peers, host)
+		c.rebalance()
 		return nil
 	}
 	return errContactNotFound
 }
+
+// Add adds the peer as a contact or as a spare once max is reached.  Once
+// the spares are full too the peer replaces the worst ranked spare unless it
+// is live in which case errContactDropped is returned
 func (c *inmemContacts) Add(p PeerContact) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// Re-adding a known peer is a report that it is alive
 	host := p.Address()
-	if _, ok := c.peers[host]; !ok {
-		c.peers[host] = &Peer{Host: host}
-		return nil
+	if peer, ok := c.peers[host]; ok {
+		peer.seen()
+		return errContactExists
+	}
+	if peer, ok := c.spares[host]; ok {
+		peer.seen()
+		return errContactExists
+	}
+
+	peer := &Peer{Host: host, joined: time.Now().UnixNano()}
+	peer.Zone, peer.Rack = contactLabels(p)
+
+	switch {
+	case c.max == 0 || len(c.peers) < c.max:
+		c.peers[host] = peer
+	case len(c.spares) < c.max:
+		c.spares[host] = peer
+	default:
+		// Spares not reached recently give way to the new peer which is
+		// probed on the next refresh
+		worst := c.worstSpare()
+		if isLive(worst, time.Now().UnixNano(), c.stale) {
+			return errContactDropped
+		}
+		delete(c.spares, worst.Host)
+		c.spares[host] = peer
 	}
-	return errContactExists
+	return nil
+}
+
+// worstSpare returns the lowest ranked spare.  It must be called with the
+// lock held and at least one spare
+func (c *inmemContacts) worstSpare() *Peer {
+	spares := make([]*Peer, 0, len(c.spares))
+	for _, p := range c.spares {
+		spares = append(spares, p)
+	}
+	rankPeers(spares, time.Now().UnixNano(), c.stale)
+	return spares[len(spares)-1]
 }
 
 func (c *inmemContacts) List() []PeerContact {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
 	out := make([]PeerContact, 0, len(c.peers))
 	for _, v := range c.peers {
 		vv := *v
@@ -80,20 +186,285 @@ func (c *inmemContacts) List() []PeerContact {
 	return out
 }
 
-// TODO: actually get the closest node
+// GetClosest returns the non-self peer with the lowest measured rtt
+// preferring those in the local zone
 func (c *inmemContacts) GetClosest() (PeerContact, bool) {
+	peers := c.ListClosest()
+	if len(peers) == 0 {
+		return nil, false
+	}
+	return peers[0], true
+}
+
+// ListClosest returns all non-self peers sorted by rtt with those in the
+// local zone first
+func (c *inmemContacts) ListClosest() []PeerContact {
+	c.mu.RLock()
+	peers := make([]*Peer, 0, len(c.peers))
 	for _, v := range c.peers {
 		if v.Address() == c.host {
 			continue
 		}
-		return v, true
+		vv := *v
+		peers = append(peers, &vv)
 	}
-	return nil, false
+	c.mu.RUnlock()
+
+	sort.Stable(sortPeers(peers))
+
+	out := make([]PeerContact, 0, len(peers))
+	for _, p := range peers {
+		out = append(out, p)
+	}
+	return preferZone(out, c.zone)
 }
 
+// GetRandom returns a uniformly random peer which may be self
 func (c *inmemContacts) GetRandom() (PeerContact, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if len(c.peers) == 0 {
+		return nil, false
+	}
+
+	i := rand.Intn(len(c.peers))
 	for _, v := range c.peers {
-		return v, true
+		if i == 0 {
+			vv := *v
+			return &vv, true
+		}
+		i--
 	}
 	return nil, false
 }
+
+// probe pings all non-self peers through the transport recording the rtt.
+// Unreachable peers are assigned the max rtt so they are selected last
+func (c *inmemContacts) probe(ctx context.Context) {
+	peers := c.ListClosest()
+	hosts := make([]string, 0, len(peers))
+	for _, p := range peers {
+		hosts = append(hosts, p.Address())
+	}
+	rtts := c.ping(ctx, hosts)
+
+	c.mu.Lock()
+	for host, rtt := range rtts {
+		// Peer may have been removed while probing
+		if p, ok := c.peers[host]; ok {
+			p.ping(rtt)
+		}
+	}
+	c.mu.Unlock()
+}
+
+// refresh probes the spares, evicting those that are unreachable, and
+// swaps in any that rank better than the current contacts
+func (c *inmemContacts) refresh(ctx context.Context) {
+	if c.max == 0 {
+		return
+	}
+
+	c.mu.RLock()
+	hosts := make([]string, 0, len(c.spares))
+	for host := range c.spares {
+		hosts = append(hosts, host)
+	}
+	c.mu.RUnlock()
+
+	rtts := c.ping(ctx, hosts)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for host, rtt := range rtts {
+		p, ok := c.spares[host]
+		if !ok {
+			continue
+		}
+		if rtt == maxRTT {
+			delete(c.spares, host)
+			continue
+		}
+		p.ping(rtt)
+	}
+	c.rebalance()
+}
+
+// rebalance keeps the best ranked peers upto max as contacts and the next
+// best as spares.  It must be called with the lock held
+func (c *inmemContacts) rebalance() {
+	if c.max == 0 {
+		return
+	}
+
+	all := make([]*Peer, 0, len(c.peers)+len(c.spares))
+	for _, p := range c.peers {
+		all = append(all, p)
+	}
+	for _, p := range c.spares {
+		all = append(all, p)
+	}
+	rankPeers(all, time.Now().UnixNano(), c.stale)
+
+	c.peers = make(map[string]*Peer, c.max)
+	c.spares = make(map[string]*Peer, c.max)
+	for i, p := range all {
+		switch {
+		case i < c.max:
+			c.peers[p.Host] = p
+		case i < 2*c.max:
+			c.spares[p.Host] = p
+		}
+	}
+}
+
+// ping pings each host returning the rtts.  Unreachable hosts are assigned
+// the max rtt
+func (c *inmemContacts) ping(ctx context.Context, hosts []string) map[string]time.Duration {
+	rtts := make(map[string]time.Duration, len(hosts))
+	for _, host := range hosts {
+		start := time.Now()
+		if err := c.trans.Ping(ctx, host); err != nil {
+			rtts[host] = maxRTT
+			continue
+		}
+		rtts[host] = time.Since(start)
+	}
+	return rtts
+}
+
+// rankPeers sorts the peers best first for keeping as contacts.  Peers
+// reached within the stale duration rank first followed by those with a
+// meaningfully lower rtt and then the longest known
+func rankPeers(peers []*Peer, now int64, stale time.Duration) {
+	sort.SliceStable(peers, func(i, j int) bool {
+		a, b := peers[i], peers[j]
+		if la, lb := isLive(a, now, stale), isLive(b, now, stale); la != lb {
+			return la
+		}
+		if rtt, ok := compareRTT(a.rtt, b.rtt); ok {
+			return rtt
+		}
+		return a.joined < b.joined
+	})
+}
+
+// isLive returns true if the peer was reached within the stale duration.
+// Zero stale means reached at any time
+func isLive(p *Peer, now int64, stale time.Duration) bool {
+	return p.lastSeen > 0 && (stale == 0 || now-p.lastSeen < stale.Nanoseconds())
+}
+
+// compareRTT returns whether a is lower than b and whether they differ by
+// more than the slack.  Unmeasured rtts are higher than measured ones
+func compareRTT(a, b time.Duration) (bool, bool) {
+	switch {
+	case a == b:
+		return false, false
+	case a == 0:
+		return false, true
+	case b == 0:
+		return true, true
+	case a < b:
+		return true, float64(b-a) > rttSlack*float64(b)
+	}
+	return false, float64(a-b) > rttSlack*float64(a)
+}
+
+// contactProber is implemented by contact stores that actively measure
+// proximity to their peers
+type contactProber interface {
+	// probe should run a single round of measurements
+	probe(ctx context.Context)
+}
+
+// contactRefresher is implemented by contact stores that bound the number
+// of contacts and periodically replace them with better ones
+type contactRefresher interface {
+	// refresh should run a single round of replacements
+	refresh(ctx context.Context)
+}
+
+// refreshContacts runs a refresh round every interval until the context is
+// done.  Each round is bounded by the interval
+func refreshContacts(ctx context.Context, refresher contactRefresher, interval time.Duration) {
+	for {
+		if !sleepContext(ctx, interval) {
+			return
+		}
+
+		rctx, cancel := context.WithTimeout(ctx, interval)
+		refresher.refresh(rctx)
+		cancel()
+	}
+}
+
+// probeContacts runs a probe round every interval until the context is done.
+// Each round is bounded by the interval
+func probeContacts(ctx context.Context, prober contactProber, interval time.Duration) {
+	for {
+		pctx, cancel := context.WithTimeout(ctx, interval)
+		prober.probe(pctx)
+		cancel()
+
+		if !sleepContext(ctx, interval) {
+			return
+		}
+	}
+}
+
+// suspectContacts tracks contacts that recently failed a request so they
+// are tried last on subsequent requests
+type suspectContacts struct {
+	mu sync.RWMutex
+	// host to time marked suspect
+	m map[string]int64
+	// duration a contact remains suspect
+	timeout time.Duration
+}
+
+func newSuspectContacts(timeout time.Duration) *suspectContacts {
+	return &suspectContacts{m: make(map[string]int64), timeout: timeout}
+}
+
+// mark marks the host as suspect
+func (s *suspectContacts) mark(host string) {
+	s.mu.Lock()
+	s.m[host] = time.Now().UnixNano()
+	s.mu.Unlock()
+}
+
+// clear removes the suspect mark for the host
+func (s *suspectContacts) clear(host string) {
+	s.mu.Lock()
+	delete(s.m, host)
+	s.mu.Unlock()
+}
+
+// isSuspect returns true if the host was marked within the timeout
+func (s *suspectContacts) isSuspect(host string) bool {
+	s.mu.RLock()
+	t, ok := s.m[host]
+	s.mu.RUnlock()
+	if !ok {
+		return false
+	}
+	return time.Now().UnixNano()-t < s.timeout.Nanoseconds()
+}
+
+// order returns the peers with suspects moved to the end, preserving the
+// order otherwise
+func (s *suspectContacts) order(peers []PeerContact) []PeerContact {
+	out := make([]PeerContact, 0, len(peers))
+	suspect := make([]PeerContact, 0)
+	for _, p := range peers {
+		if s.isSuspect(p.Address()) {
+			suspect = append(suspect, p)
+			continue
+		}
+		out = append(out, p)
+	}
+	return append(out, suspect...)
+}
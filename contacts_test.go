@@ -0,0 +1,280 @@
+package kelips
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// pingTransport is a loopback transport with a fixed latency per host.
+// Hosts not in the map are unreachable
+type pingTransport struct {
+	*LoopbackTransport
+	latency map[string]time.Duration
+}
+
+func (trans *pingTransport) Ping(ctx context.Context, host string) error {
+	d, ok := trans.latency[host]
+	if !ok {
+		return fmt.Errorf("unreachable: %s", host)
+	}
+	time.Sleep(d)
+	return nil
+}
+
+func Test_inmemContacts_probe(t *testing.T) {
+	trans := &pingTransport{
+		LoopbackTransport: loopbackTransport("127.0.0.1:9999"),
+		latency: map[string]time.Duration{
+			"127.0.0.1:10000": 30 * time.Millisecond,
+			"127.0.0.1:10001": 1 * time.Millisecond,
+			"127.0.0.1:10002": 15 * time.Millisecond,
+		},
+	}
+	fac := &inmemContactsFac{host: "127.0.0.1:9999", trans: trans}
+	contacts := fac.New(0, true)
+
+	contacts.Add(&Peer{Host: "127.0.0.1:9999"})
+	contacts.Add(&Peer{Host: "127.0.0.1:10000"})
+	contacts.Add(&Peer{Host: "127.0.0.1:10001"})
+	contacts.Add(&Peer{Host: "127.0.0.1:10002"})
+	contacts.Add(&Peer{Host: "127.0.0.1:10003"})
+
+	// Never returns self
+	for i := 0; i < 10; i++ {
+		p, ok := contacts.GetClosest()
+		assert.True(t, ok)
+		assert.NotEqual(t, "127.0.0.1:9999", p.Address())
+	}
+
+	prober, ok := contacts.(contactProber)
+	assert.True(t, ok)
+	prober.probe(context.Background())
+
+	p, ok := contacts.GetClosest()
+	assert.True(t, ok)
+	assert.Equal(t, "127.0.0.1:10001", p.Address())
+	assert.True(t, p.(*Peer).RTT() > 0)
+
+	closest := contacts.ListClosest()
+	assert.Equal(t, 4, len(closest))
+
+	order := make([]string, 0, len(closest))
+	for _, c := range closest {
+		order = append(order, c.Address())
+	}
+	assert.Equal(t, []string{
+		"127.0.0.1:10001",
+		"127.0.0.1:10002",
+		"127.0.0.1:10000",
+		// unreachable
+		"127.0.0.1:10003",
+	}, order)
+}
+
+func Test_inmemContacts_zone(t *testing.T) {
+	trans := &pingTransport{
+		LoopbackTransport: loopbackTransport("127.0.0.1:9999"),
+		latency: map[string]time.Duration{
+			"127.0.0.1:10000": 1 * time.Millisecond,
+			"127.0.0.1:10001": 15 * time.Millisecond,
+			"127.0.0.1:10002": 5 * time.Millisecond,
+		},
+	}
+	fac := &inmemContactsFac{host: "127.0.0.1:9999", zone: "a", trans: trans}
+	contacts := fac.New(0, true)
+
+	contacts.Add(&Peer{Host: "127.0.0.1:9999", Zone: "a"})
+	contacts.Add(&Peer{Host: "127.0.0.1:10000", Zone: "b"})
+	contacts.Add(&Peer{Host: "127.0.0.1:10001", Zone: "a", Rack: "r1"})
+	contacts.Add(&Peer{Host: "127.0.0.1:10002", Zone: "a", Rack: "r2"})
+	contacts.(contactProber).probe(context.Background())
+
+	// Same zone first by rtt even though another zone is closer
+	order := make([]string, 0, 3)
+	for _, c := range contacts.ListClosest() {
+		order = append(order, c.Address())
+	}
+	assert.Equal(t, []string{"127.0.0.1:10002", "127.0.0.1:10001", "127.0.0.1:10000"}, order)
+
+	p, ok := contacts.GetClosest()
+	assert.True(t, ok)
+	assert.Equal(t, "127.0.0.1:10002", p.Address())
+
+	zone, rack := contactLabels(p)
+	assert.Equal(t, "a", zone)
+	assert.Equal(t, "r2", rack)
+}
+
+func Test_inmemContacts_bounded(t *testing.T) {
+	trans := &pingTransport{
+		LoopbackTransport: loopbackTransport("127.0.0.1:9999"),
+		latency: map[string]time.Duration{
+			"127.0.0.1:10001": 30 * time.Millisecond,
+			"127.0.0.1:10002": 25 * time.Millisecond,
+			"127.0.0.1:10003": 1 * time.Millisecond,
+			"127.0.0.1:10004": 40 * time.Millisecond,
+		},
+	}
+	fac := &inmemContactsFac{host: "127.0.0.1:9999", trans: trans, max: 2, refresh: time.Minute}
+
+	// Home groups are not bounded
+	home := fac.New(0, true)
+	for i := 1; i <= 5; i++ {
+		home.Add(&Peer{Host: fmt.Sprintf("127.0.0.1:1000%d", i)})
+	}
+	assert.Equal(t, 5, len(home.List()))
+
+	contacts := fac.New(1, false)
+	for i := 1; i <= 5; i++ {
+		assert.Nil(t, contacts.Add(&Peer{Host: fmt.Sprintf("127.0.0.1:1000%d", i)}))
+		time.Sleep(time.Millisecond)
+	}
+	// Spares are not listed
+	assert.Equal(t, 2, len(contacts.List()))
+	assert.Equal(t, errContactExists, contacts.Add(&Peer{Host: "127.0.0.1:10003"}))
+
+	contacts.(contactProber).probe(context.Background())
+	contacts.(contactRefresher).refresh(context.Background())
+
+	// The fastest spare replaces the longest known of two similar contacts
+	// and the unreachable spare is evicted
+	order := make([]string, 0, 2)
+	for _, c := range contacts.ListClosest() {
+		order = append(order, c.Address())
+	}
+	assert.Equal(t, []string{"127.0.0.1:10003", "127.0.0.1:10001"}, order)
+
+	inmem := contacts.(*inmemContacts)
+	assert.Equal(t, 1, len(inmem.spares))
+	assert.NotNil(t, inmem.spares["127.0.0.1:10002"])
+
+	// Removing a contact promotes a spare
+	assert.Nil(t, contacts.Remove(&Peer{Host: "127.0.0.1:10001"}))
+	assert.Equal(t, 2, len(contacts.List()))
+	assert.Equal(t, 0, len(inmem.spares))
+	assert.Equal(t, errContactNotFound, contacts.Remove(&Peer{Host: "127.0.0.1:10004"}))
+
+	// New peers replace spares not yet reached and are dropped once all
+	// spares have been reached
+	assert.Nil(t, contacts.Add(&Peer{Host: "127.0.0.1:10001"}))
+	assert.Nil(t, contacts.Add(&Peer{Host: "127.0.0.1:10005"}))
+	assert.Nil(t, contacts.Add(&Peer{Host: "127.0.0.1:10004"}))
+	assert.Nil(t, inmem.spares["127.0.0.1:10005"])
+
+	contacts.(contactRefresher).refresh(context.Background())
+	assert.Equal(t, 2, len(inmem.spares))
+	assert.Equal(t, errContactDropped, contacts.Add(&Peer{Host: "127.0.0.1:10005"}))
+}
+
+func Test_compareRTT(t *testing.T) {
+	lower, ok := compareRTT(10*time.Millisecond, 20*time.Millisecond)
+	assert.True(t, ok)
+	assert.True(t, lower)
+
+	// Within the slack
+	_, ok = compareRTT(18*time.Millisecond, 20*time.Millisecond)
+	assert.False(t, ok)
+
+	// Unmeasured is higher
+	lower, ok = compareRTT(0, maxRTT)
+	assert.True(t, ok)
+	assert.False(t, lower)
+}
+
+func Test_inmemContacts_liveness(t *testing.T) {
+	fac := &inmemContactsFac{host: "127.0.0.1:9999", trans: loopbackTransport("127.0.0.1:9999")}
+	contacts := fac.New(0, true)
+
+	start := time.Now()
+	assert.Nil(t, contacts.Add(&Peer{Host: "127.0.0.1:10000"}))
+	p := contacts.List()[0].(*Peer)
+	assert.False(t, p.Joined().Before(start))
+	assert.True(t, p.LastSeen().IsZero())
+
+	// Reported again
+	assert.Equal(t, errContactExists, contacts.Add(&Peer{Host: "127.0.0.1:10000"}))
+	p = contacts.List()[0].(*Peer)
+	assert.False(t, p.LastSeen().Before(start))
+
+	assert.Nil(t, contacts.Remove(p))
+	assert.Equal(t, 0, len(contacts.List()))
+	assert.Equal(t, errContactNotFound, contacts.Remove(p))
+}
+
+func Test_inmemContacts_GetRandom(t *testing.T) {
+	fac := &inmemContactsFac{host: "127.0.0.1:9999", trans: loopbackTransport("127.0.0.1:9999")}
+	contacts := fac.New(0, true)
+
+	_, ok := contacts.GetRandom()
+	assert.False(t, ok)
+
+	for i := 0; i < 4; i++ {
+		contacts.Add(&Peer{Host: fmt.Sprintf("127.0.0.1:1000%d", i)})
+	}
+
+	counts := make(map[string]int)
+	for i := 0; i < 4000; i++ {
+		p, ok := contacts.GetRandom()
+		assert.True(t, ok)
+		counts[p.Address()]++
+	}
+	assert.Equal(t, 4, len(counts))
+	for host, n := range counts {
+		assert.True(t, n > 700 && n < 1300, "host=%s count=%d", host, n)
+	}
+}
+
+// Test_inmemContacts_concurrent is meant to be run with the race detector
+func Test_inmemContacts_concurrent(t *testing.T) {
+	trans := &pingTransport{
+		LoopbackTransport: loopbackTransport("127.0.0.1:9999"),
+		latency:           map[string]time.Duration{},
+	}
+	for i := 0; i < 8; i++ {
+		trans.latency[fmt.Sprintf("127.0.0.1:1000%d", i)] = 0
+	}
+	fac := &inmemContactsFac{host: "127.0.0.1:9999", trans: trans, max: 3, refresh: time.Minute}
+	contacts := fac.New(1, false)
+
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				p := &Peer{Host: fmt.Sprintf("127.0.0.1:1000%d", (i+w)%8)}
+				switch i % 8 {
+				case 0, 1:
+					contacts.Add(p)
+				case 2:
+					contacts.Remove(p)
+				case 3:
+					contacts.List()
+				case 4:
+					contacts.GetClosest()
+				case 5:
+					contacts.GetRandom()
+				case 6:
+					contacts.(contactProber).probe(context.Background())
+				case 7:
+					contacts.(contactRefresher).refresh(context.Background())
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	// Bounds hold and no peer is both a contact and a spare
+	inmem := contacts.(*inmemContacts)
+	assert.True(t, len(inmem.peers) <= 3)
+	assert.True(t, len(inmem.spares) <= 3)
+	for host := range inmem.spares {
+		_, ok := inmem.peers[host]
+		assert.False(t, ok, host)
+	}
+}
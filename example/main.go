@@ -1,49 +1,87 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
 	"strings"
+	"syscall"
+	"time"
 
 	kelips "github.com/euforia/go-kelips"
 	"github.com/euforia/gossip"
-	"github.com/hexablock/iputil"
 )
 
 var (
-	advAddr   = flag.String("adv-addr", "127.0.0.1:10000", "Advertise address")
-	kgroups   = flag.Int64("k", 3, "number of affinity group")
-	joinPeers = flag.String("join", "", "Existing peers to join")
-	debug     = flag.Bool("debug", false, "Debug")
+	configFile = flag.String("config", "", "Config file (.yaml, .toml or .json)")
+	advAddr    = flag.String("adv-addr", "127.0.0.1:10000", "Advertise address")
+	kgroups    = flag.Int64("k", 3, "number of affinity group")
+	joinPeers  = flag.String("join", "", "Existing peers to join")
+	debug      = flag.Bool("debug", false, "Debug")
+	tupleFile  = flag.String("tuples-file", "", "Persist tuples to the given file")
+	tlsCert    = flag.String("tls-cert", "", "Certificate for mutual TLS between peers")
+	tlsKey     = flag.String("tls-key", "", "Key for the TLS certificate")
+	tlsCA      = flag.String("tls-ca", "", "CA used to verify peer certificates")
 )
 
-func makeGossipConfig() *gossip.Config {
-	ip, port, err := iputil.SplitHostPort(*advAddr)
+// metrics are served at /metrics
+var metrics = kelips.NewPrometheusMetrics()
+
+// loadConfig loads the config file and KELIPS_* environment variables.
+// Flags given on the command line take precedence
+func loadConfig() (*kelips.Config, *gossip.Config) {
+	conf, err := kelips.LoadConfig(*configFile)
+	if err == nil {
+		applyFlags(conf)
+		err = conf.Validate()
+	}
+	if err != nil {
+		fmt.Println("Invalid config:", err)
+		os.Exit(1)
+	}
+
+	kconf, err := conf.Config()
+	if err != nil {
+		fmt.Println("Failed to init:", err)
+		os.Exit(1)
+	}
+	kconf.Metrics = metrics
+
+	gconf, err := conf.GossipConfig()
 	if err != nil {
-		fmt.Printf("Failed to parse advertise address=%s: %v\n", *advAddr, err)
+		fmt.Println("Invalid gossip config:", err)
 		os.Exit(1)
 	}
 
-	conf := gossip.DefaultConfig()
-	conf.Name = *advAddr
-	conf.Debug = *debug
-	conf.AdvertiseAddr = ip
-	conf.AdvertisePort = port
-	conf.BindAddr = ip
-	conf.BindPort = port
-	return conf
+	return kconf, gconf
 }
 
-func makeKelipsConfig() *kelips.Config {
-	conf := kelips.DefaultConfig()
-	conf.K = *kgroups
-	conf.Transport = kelips.NewHTTPTransport(true)
-	conf.Tuples = kelips.NewInmemTuples()
-	conf.Logger.EnableDebug(*debug)
-	return conf
+func applyFlags(conf *kelips.FileConfig) {
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "adv-addr":
+			conf.Gossip.AdvertiseAddr = *advAddr
+		case "k":
+			conf.K = *kgroups
+		case "debug":
+			conf.Debug = *debug
+		case "tuples-file":
+			conf.Tuples.Type = "file"
+			conf.Tuples.Path = *tupleFile
+		case "tls-cert":
+			// Peers are dialed by address so the certificate must match it
+			conf.Transport.TLS.Cert = *tlsCert
+			conf.Transport.TLS.VerifyHost = true
+		case "tls-key":
+			conf.Transport.TLS.Key = *tlsKey
+		case "tls-ca":
+			conf.Transport.TLS.CA = *tlsCA
+		}
+	})
 }
 
 func parseJoinPeers() []string {
@@ -60,11 +98,27 @@ func parseJoinPeers() []string {
 	return out
 }
 
+// shutdownOnSignal gracefully shuts down kelips, including leaving gossip
+// pools, on an interrupt or terminate signal
+func shutdownOnSignal(klps *kelips.Kelips) {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	<-sig
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := klps.Shutdown(ctx); err != nil {
+		log.Println("Shutdown:", err)
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
 func main() {
 	flag.Parse()
 
-	kelipsConf := makeKelipsConfig()
-	gossipConf := makeGossipConfig()
+	kelipsConf, gossipConf := loadConfig()
 
 	// Create kelips gossip instance
 	kelipsGossip, err := kelips.NewGossip(kelipsConf, gossipConf)
@@ -94,11 +148,19 @@ func main() {
 		}
 	}
 
+	go shutdownOnSignal(klps)
+
 	// Get a non-muxed TCP listener from gossip layer to use for our http server
 	ln := kelipsGossip.ListenTCP()
 
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics)
+	mux.Handle("/batch/", &batchServer{kelips: klps})
+	mux.Handle("/admin/", http.StripPrefix("/admin", kelips.NewAdminHandler(klps, kelipsGossip)))
+	mux.Handle("/", &httpServer{kelips: klps})
+
 	// Start serving http
-	if err := http.Serve(ln, &httpServer{kelips: klps}); err != nil {
+	if err := http.Serve(ln, mux); err != nil {
 		log.Fatal("HTTP server:", err)
 	}
 
@@ -1,7 +1,9 @@
 package main
 
 import (
+	"encoding/json"
 	"net/http"
+	"strings"
 
 	kelips "github.com/euforia/go-kelips"
 )
@@ -10,12 +12,23 @@ type httpServer struct {
 	kelips *kelips.Kelips
 }
 
+// lookupResult is the tuple for a key along with the lookup path if traced
+type lookupResult struct {
+	Key   string            `json:"key"`
+	Hosts []string          `json:"hosts,omitempty"`
+	Meta  map[string]string `json:"meta,omitempty"`
+	Trace []kelips.TraceHop `json:"trace,omitempty"`
+	Error string            `json:"error,omitempty"`
+}
+
 func (server *httpServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case http.MethodGet:
 		server.handleLookup(w, r)
 	case http.MethodPost:
 		server.handleInsert(w, r)
+	case http.MethodDelete:
+		server.handleDelete(w, r)
 	default:
 		w.WriteHeader(http.StatusMethodNotAllowed)
 	}
@@ -23,22 +36,110 @@ func (server *httpServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 func (server *httpServer) handleLookup(w http.ResponseWriter, r *http.Request) {
 	key := r.URL.Path[1:]
-	host, err := server.kelips.Lookup(&kelips.Request{Key: []byte(key), TTL: 2})
+	req := &kelips.Request{Key: []byte(key), TTL: 2}
+	// Return the lookup path with ?trace=1
+	if r.URL.Query().Get("trace") != "" {
+		req.Trace = &kelips.Trace{}
+	}
+
+	tuple, err := server.kelips.LookupContext(r.Context(), req)
+
+	// Failed lookups return the error along with the trace
+	res := lookupResult{Key: key}
+	if req.Trace != nil {
+		res.Trace = req.Trace.Hops
+	}
+	w.Header().Set("Content-Type", "application/json")
 	if err != nil {
+		res.Error = err.Error()
 		w.WriteHeader(400)
-		w.Write([]byte(err.Error()))
 	} else {
-		w.Write([]byte(host))
+		res.Hosts = tuple.Hosts
+		res.Meta = tuple.Meta
 	}
+	json.NewEncoder(w).Encode(res)
 }
 
 func (server *httpServer) handleInsert(w http.ResponseWriter, r *http.Request) {
 	key := r.URL.Path[1:]
-	host, err := server.kelips.Insert([]byte(key))
+	// Metadata is supplied as query parameters
+	meta := make(map[string]string)
+	for k, v := range r.URL.Query() {
+		meta[k] = v[0]
+	}
+
+	hosts, err := server.kelips.InsertContext(r.Context(), []byte(key), kelips.WithMeta(meta))
 	if err != nil {
 		w.WriteHeader(400)
 		w.Write([]byte(err.Error()))
 	} else {
-		w.Write([]byte(host))
+		w.Write([]byte(strings.Join(hosts, ",")))
+	}
+}
+
+func (server *httpServer) handleDelete(w http.ResponseWriter, r *http.Request) {
+	key := r.URL.Path[1:]
+	if err := server.kelips.DeleteContext(r.Context(), []byte(key)); err != nil {
+		w.WriteHeader(400)
+		w.Write([]byte(err.Error()))
+	}
+}
+
+// batchItem is the result for a single key of a batch request
+type batchItem struct {
+	Key   string            `json:"key"`
+	Hosts []string          `json:"hosts,omitempty"`
+	Meta  map[string]string `json:"meta,omitempty"`
+	Error string            `json:"error,omitempty"`
+}
+
+// batchServer handles inserts and lookups of a JSON list of keys at
+// /batch/insert and /batch/lookup
+type batchServer struct {
+	kelips *kelips.Kelips
+}
+
+func (server *batchServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	var keys []string
+	if err := json.NewDecoder(r.Body).Decode(&keys); err != nil {
+		w.WriteHeader(400)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	bkeys := make([][]byte, 0, len(keys))
+	for _, key := range keys {
+		bkeys = append(bkeys, []byte(key))
 	}
+
+	var results []kelips.BatchResult
+	switch r.URL.Path {
+	case "/batch/insert":
+		results = server.kelips.InsertBatchContext(r.Context(), bkeys)
+	case "/batch/lookup":
+		results = server.kelips.LookupBatchContext(r.Context(), &kelips.BatchRequest{Keys: bkeys, TTL: 2})
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	items := make([]batchItem, 0, len(results))
+	for _, res := range results {
+		item := batchItem{Key: string(res.Key)}
+		if res.Err != nil {
+			item.Error = res.Err.Error()
+		} else {
+			item.Hosts = res.Tuple.Hosts
+			item.Meta = res.Tuple.Meta
+		}
+		items = append(items, item)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(items)
 }
@@ -0,0 +1,519 @@
+package kelips
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/hexablock/log"
+)
+
+// Log record types
+const (
+	recordPut byte = iota + 1
+	recordDelete
+	recordPing
+)
+
+// compactThreshold is the minimum number of superseded records before the
+// log is compacted
+const compactThreshold = 1024
+
+// FileTuples implements a file backed TupleStorage interface.  All changes
+// are appended to a log which is replayed into an in-memory index on open.
+// Heartbeats are kept in memory and only logged on expiry and close.  The
+// log is compacted once superseded records outnumber live tuples
+type FileTuples struct {
+	path string
+
+	mu sync.RWMutex
+	m  map[string]*Tuple
+	// tuples homed per host
+	homed hostCounts
+	// keys pinged since their heartbeats were last logged
+	pinged map[string]struct{}
+	f      *os.File
+	// superseded records in the log
+	garbage int
+
+	log *log.Logger
+}
+
+// NewFileTuples opens or creates the tuple log at path and loads all
+// tuples
+func NewFileTuples(path string, logger *log.Logger) (*FileTuples, error) {
+	if logger == nil {
+		logger = log.NewDefaultLogger()
+	}
+
+	tuples := &FileTuples{
+		path:   path,
+		m:      make(map[string]*Tuple),
+		homed:  make(hostCounts),
+		pinged: make(map[string]struct{}),
+		log:    logger,
+	}
+
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = tuples.replay(f); err != nil {
+		f.Close()
+		return nil, err
+	}
+	tuples.f = f
+
+	return tuples, nil
+}
+
+// replay loads the log into the index.  A partially written trailing record
+// is truncated
+func (tuples *FileTuples) replay(f *os.File) error {
+	r := bufio.NewReader(f)
+
+	var offset int64
+	for {
+		typ, payload, n, err := readRecord(r)
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			tuples.log.Errorf("Truncating tuple log path=%s offset=%d: %v", tuples.path, offset, err)
+			if err = f.Truncate(offset); err != nil {
+				return err
+			}
+			break
+		}
+
+		if err = tuples.apply(typ, payload); err != nil {
+			return err
+		}
+		offset += int64(n)
+	}
+
+	_, err := f.Seek(offset, io.SeekStart)
+	return err
+}
+
+// apply applies a log record to the index
+func (tuples *FileTuples) apply(typ byte, payload []byte) error {
+	switch typ {
+	case recordPut:
+		tuple, err := decodeTupleRecord(payload)
+		if err != nil {
+			return err
+		}
+		if old, ok := tuples.m[string(tuple.Key)]; ok {
+			tuples.homed.add(old.Hosts, -1)
+			tuples.garbage++
+		}
+		tuples.m[string(tuple.Key)] = tuple
+		tuples.homed.add(tuple.Hosts, 1)
+
+	case recordDelete:
+		tuples.garbage++
+		if old, ok := tuples.m[string(payload)]; ok {
+			tuples.homed.add(old.Hosts, -1)
+			delete(tuples.m, string(payload))
+		}
+
+	case recordPing:
+		key, heartbeats, lastseen, err := decodePingRecord(payload)
+		if err != nil {
+			return err
+		}
+		tuples.garbage++
+		if tuple, ok := tuples.m[string(key)]; ok {
+			tuple.heartbeats = heartbeats
+			tuple.lastseen = lastseen
+		}
+
+	default:
+		return fmt.Errorf("unknown record type: %d", typ)
+	}
+
+	return nil
+}
+
+// append writes the records to the log.  It must be called with the lock
+// held
+func (tuples *FileTuples) append(records ...[]byte) {
+	if len(records) == 0 {
+		return
+	}
+
+	_, err := tuples.f.Write(bytes.Join(records, nil))
+	if err != nil {
+		tuples.log.Errorf("Failed to write tuple log path=%s: %v", tuples.path, err)
+		return
+	}
+
+	if tuples.garbage > compactThreshold && tuples.garbage > len(tuples.m) {
+		if err = tuples.compact(); err != nil {
+			tuples.log.Errorf("Failed to compact tuple log path=%s: %v", tuples.path, err)
+		}
+	}
+}
+
+// pingRecords returns the ping records of all tuples pinged since their
+// heartbeats were last logged.  It must be called with the lock held
+func (tuples *FileTuples) pingRecords() [][]byte {
+	records := make([][]byte, 0, len(tuples.pinged))
+	for k := range tuples.pinged {
+		if tuple, ok := tuples.m[k]; ok {
+			records = append(records, encodeRecord(recordPing, encodePingRecord(tuple)))
+			tuples.garbage++
+		}
+		delete(tuples.pinged, k)
+	}
+	return records
+}
+
+// compact rewrites the log with only the live tuples.  It must be called
+// with the lock held
+func (tuples *FileTuples) compact() error {
+	tmp := tuples.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(f)
+	for _, tuple := range tuples.m {
+		if _, err = w.Write(encodeRecord(recordPut, encodeTupleRecord(tuple))); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+
+	if err = os.Rename(tmp, tuples.path); err != nil {
+		f.Close()
+		return err
+	}
+
+	tuples.f.Close()
+	tuples.f = f
+	tuples.garbage = 0
+	// Heartbeats are part of the rewritten tuples
+	tuples.pinged = make(map[string]struct{})
+
+	return nil
+}
+
+// Compact rewrites the log with only the live tuples
+func (tuples *FileTuples) Compact() error {
+	tuples.mu.Lock()
+	defer tuples.mu.Unlock()
+	return tuples.compact()
+}
+
+// Close logs pending heartbeats then syncs and closes the underlying log
+func (tuples *FileTuples) Close() error {
+	tuples.mu.Lock()
+	defer tuples.mu.Unlock()
+
+	tuples.append(tuples.pingRecords()...)
+	if err := tuples.f.Sync(); err != nil {
+		tuples.f.Close()
+		return err
+	}
+	return tuples.f.Close()
+}
+
+// ExpireHost satisfies the TupleStorage interface
+func (tuples *FileTuples) ExpireHost(host string) int {
+	var c int
+	tuples.mu.Lock()
+	records := make([][]byte, 0)
+	for k, v := range tuples.m {
+		if !v.removeHost(host) {
+			continue
+		}
+		tuples.homed.add([]string{host}, -1)
+		if len(v.Hosts) == 0 {
+			delete(tuples.m, k)
+			records = append(records, encodeRecord(recordDelete, v.Key))
+		} else {
+			records = append(records, encodeRecord(recordPut, encodeTupleRecord(v)))
+		}
+		tuples.garbage++
+		c++
+	}
+	tuples.append(records...)
+	tuples.mu.Unlock()
+	return c
+}
+
+// Expire satisfies the TupleStorage interface
+func (tuples *FileTuples) Expire(d time.Duration) int {
+	var c int
+	tuples.mu.Lock()
+	records := make([][]byte, 0)
+	now := time.Now().UnixNano()
+	for k, v := range tuples.m {
+		if v.expired(now, d) {
+			delete(tuples.m, k)
+			tuples.homed.add(v.Hosts, -1)
+			records = append(records, encodeRecord(recordDelete, v.Key))
+			tuples.garbage++
+			c++
+		}
+	}
+	records = append(records, tuples.pingRecords()...)
+	tuples.append(records...)
+	// Expiry runs periodically so sync here rather than on every write
+	if err := tuples.f.Sync(); err != nil {
+		tuples.log.Errorf("Failed to sync tuple log path=%s: %v", tuples.path, err)
+	}
+	tuples.mu.Unlock()
+	return c
+}
+
+// Delete satisfies the TupleStorage interface
+func (tuples *FileTuples) Delete(keys ...[]byte) int {
+	var c int
+	tuples.mu.Lock()
+	records := make([][]byte, 0, len(keys))
+	for _, k := range keys {
+		key := string(k)
+		if v, ok := tuples.m[key]; ok {
+			delete(tuples.m, key)
+			tuples.homed.add(v.Hosts, -1)
+			records = append(records, encodeRecord(recordDelete, k))
+			tuples.garbage++
+			c++
+		}
+	}
+	tuples.append(records...)
+	tuples.mu.Unlock()
+	return c
+}
+
+// Ping satisfies the TupleStorage interface.  Heartbeats are logged on the
+// next expiry or close
+func (tuples *FileTuples) Ping(keys ...[]byte) int {
+	var c int
+	tuples.mu.Lock()
+	for _, key := range keys {
+		val, ok := tuples.m[string(key)]
+		if ok {
+			val.ping()
+			tuples.pinged[string(key)] = struct{}{}
+			c++
+		}
+	}
+	tuples.mu.Unlock()
+	return c
+}
+
+// Renew satisfies the TupleStorage interface
+func (tuples *FileTuples) Renew(key []byte, ttl time.Duration) bool {
+	tuples.mu.Lock()
+	defer tuples.mu.Unlock()
+
+	val, ok := tuples.m[string(key)]
+	if !ok {
+		return false
+	}
+	val.renew(ttl)
+	tuples.garbage++
+	tuples.append(encodeRecord(recordPut, encodeTupleRecord(val)))
+	return true
+}
+
+// Release satisfies the TupleStorage interface
+func (tuples *FileTuples) Release(key []byte, host string) bool {
+	tuples.mu.Lock()
+	defer tuples.mu.Unlock()
+
+	k := string(key)
+	val, ok := tuples.m[k]
+	if !ok || !val.removeHost(host) {
+		return false
+	}
+	tuples.homed.add([]string{host}, -1)
+
+	if len(val.Hosts) == 0 {
+		delete(tuples.m, k)
+		tuples.append(encodeRecord(recordDelete, val.Key))
+	} else {
+		tuples.append(encodeRecord(recordPut, encodeTupleRecord(val)))
+	}
+	tuples.garbage++
+	return true
+}
+
+// Insert satisfies the TupleStorage interface
+func (tuples *FileTuples) Insert(tpls ...*Tuple) int {
+	var c int
+	tuples.mu.Lock()
+	records := make([][]byte, 0, len(tpls))
+	for _, tpl := range tpls {
+		k := string(tpl.Key)
+		if _, ok := tuples.m[k]; !ok {
+			tpl.lastseen = time.Now().UnixNano()
+			tuple := tpl.Clone()
+			tuples.m[k] = tuple
+			tuples.homed.add(tuple.Hosts, 1)
+			records = append(records, encodeRecord(recordPut, encodeTupleRecord(tuple)))
+			c++
+		}
+	}
+	tuples.append(records...)
+	tuples.mu.Unlock()
+	return c
+}
+
+// Count returns the number of tuples in the store
+func (tuples *FileTuples) Count() int {
+	tuples.mu.RLock()
+	defer tuples.mu.RUnlock()
+	return len(tuples.m)
+}
+
+// CountHost returns the number of tuples homed on the host
+func (tuples *FileTuples) CountHost(host string) int {
+	tuples.mu.RLock()
+	defer tuples.mu.RUnlock()
+	return tuples.homed[host]
+}
+
+// Lookup satisfies the TupleStorage interface
+func (tuples *FileTuples) Lookup(key []byte) *Tuple {
+	tuples.mu.RLock()
+	defer tuples.mu.RUnlock()
+
+	if val, ok := tuples.m[string(key)]; ok {
+		return val.Clone()
+	}
+	return nil
+}
+
+// List satisfies the TupleStorage interface
+func (tuples *FileTuples) List() []*Tuple {
+	tuples.mu.RLock()
+	defer tuples.mu.RUnlock()
+
+	out := make([]*Tuple, 0, len(tuples.m))
+	for _, t := range tuples.m {
+		out = append(out, t.Clone())
+	}
+	return out
+}
+
+// encodeRecord frames the payload as type, payload size, payload and a crc32
+// of the type and payload
+func encodeRecord(typ byte, payload []byte) []byte {
+	buf := make([]byte, 1+binary.MaxVarintLen64, 1+binary.MaxVarintLen64+len(payload)+4)
+	buf[0] = typ
+	n := binary.PutUvarint(buf[1:], uint64(len(payload)))
+	buf = append(buf[:1+n], payload...)
+
+	crc := crc32.ChecksumIEEE(append([]byte{typ}, payload...))
+	sum := make([]byte, 4)
+	binary.BigEndian.PutUint32(sum, crc)
+
+	return append(buf, sum...)
+}
+
+// readRecord reads a single record returning its type, payload and total size
+// in bytes
+func readRecord(r *bufio.Reader) (byte, []byte, int, error) {
+	typ, err := r.ReadByte()
+	if err != nil {
+		return 0, nil, 0, err
+	}
+
+	size, err := binary.ReadUvarint(r)
+	if err != nil {
+		return 0, nil, 0, unexpectedEOF(err)
+	}
+	if size > maxRecordSize {
+		return 0, nil, 0, fmt.Errorf("record too large: %d", size)
+	}
+
+	buf := make([]byte, size+4)
+	if _, err = io.ReadFull(r, buf); err != nil {
+		return 0, nil, 0, unexpectedEOF(err)
+	}
+
+	payload := buf[:size]
+	crc := crc32.ChecksumIEEE(append([]byte{typ}, payload...))
+	if binary.BigEndian.Uint32(buf[size:]) != crc {
+		return 0, nil, 0, fmt.Errorf("record checksum mismatch")
+	}
+
+	n := 1 + uvarintSize(size) + len(buf)
+	return typ, payload, n, nil
+}
+
+func uvarintSize(v uint64) int {
+	buf := make([]byte, binary.MaxVarintLen64)
+	return binary.PutUvarint(buf, v)
+}
+
+// encodeTupleRecord encodes the tuple followed by its heartbeats, last seen
+// time and TTL
+func encodeTupleRecord(t *Tuple) []byte {
+	buf := encodeTuple(make([]byte, 0, len(t.Key)+56), t)
+	buf = appendVarint(buf, t.heartbeats)
+	buf = appendVarint(buf, t.lastseen)
+	return appendTupleTTL(buf, t)
+}
+
+func decodeTupleRecord(b []byte) (*Tuple, error) {
+	r := bytes.NewReader(b)
+	tuple, err := decodeTuple(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if tuple.heartbeats, err = binary.ReadVarint(r); err != nil {
+		return nil, err
+	}
+	if tuple.lastseen, err = binary.ReadVarint(r); err != nil {
+		return nil, err
+	}
+
+	return tuple, readTupleTTL(r, tuple)
+}
+
+// encodePingRecord encodes the key, heartbeats and last seen time of the
+// tuple
+func encodePingRecord(t *Tuple) []byte {
+	buf := appendBytes(make([]byte, 0, len(t.Key)+24), t.Key)
+	buf = appendVarint(buf, t.heartbeats)
+	return appendVarint(buf, t.lastseen)
+}
+
+func decodePingRecord(b []byte) ([]byte, int64, int64, error) {
+	r := bytes.NewReader(b)
+	key, err := readBytes(r)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	heartbeats, err := binary.ReadVarint(r)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	lastseen, err := binary.ReadVarint(r)
+	return key, heartbeats, lastseen, err
+}
@@ -1,8 +1,12 @@
 package kelips
 
 import (
+	"bytes"
+	"context"
 	"hash"
 	"net"
+	"sync"
+	"time"
 
 	"strconv"
 
@@ -12,6 +16,10 @@ import (
 
 const globalGossipPoolID uint16 = 123
 
+// defaultLeaveTimeout is the time allowed to leave a pool when shutting down
+// without a context deadline
+const defaultLeaveTimeout = 5 * time.Second
+
 // Gossip is the gossip component of kelips.  It handles all contact and tuple
 // liveliness, pinging as messaging arrive on the gossip network
 type Gossip struct {
@@ -29,9 +37,31 @@ type Gossip struct {
 	delegate *kelipsGossipDelegate
 
 	host   string           // node host used for new contact stores
-	id     int64            // home group id used when joining
 	hasher func() hash.Hash // hash function
-	k      int64            // total affinity groups
+	mapper GroupMapper      // maps hosts to groups
+
+	// guards the fields below which change on resize
+	mu sync.RWMutex
+	id int64 // home group id used when joining
+	k  int64 // total affinity groups
+	// home group gossip pools by group id.  Holds two while resizing
+	pools map[int64]*gossip.Pool
+
+	// contacts kept per foreign group
+	maxContacts int
+
+	// location advertised in the pool metadata
+	zone string
+	rack string
+	// serializes local node metadata updates
+	metaMu sync.Mutex
+
+	// load advertised in the home pool metadata
+	capacity     int64
+	loadInterval time.Duration
+
+	// background go-routines
+	routines routines
 
 	log *log.Logger
 }
@@ -50,11 +80,20 @@ func NewGossip(kconf *Config, conf *gossip.Config) (*Gossip, error) {
 		tuples: kconf.Tuples,
 		gtuples: &gossipTupleStorage{
 			TupleStorage: kconf.Tuples,
+			tombstones:   newTombstones(),
+			metrics:      noopMetrics{},
 			log:          kconf.Logger,
 		},
-		host:   conf.AdvertiseAddr + ":" + strconv.Itoa(conf.AdvertisePort),
-		hasher: kconf.HashFunc,
-		k:      kconf.K,
+		host:         conf.AdvertiseAddr + ":" + strconv.Itoa(conf.AdvertisePort),
+		hasher:       kconf.HashFunc,
+		mapper:       kconf.Mapper,
+		k:            kconf.K,
+		pools:        make(map[int64]*gossip.Pool),
+		maxContacts:  kconf.MaxContacts,
+		zone:         kconf.Zone,
+		rack:         kconf.Rack,
+		capacity:     kconf.Capacity,
+		loadInterval: kconf.LoadInterval,
 		delegate: &kelipsGossipDelegate{
 			log: kconf.Logger,
 		},
@@ -69,19 +108,69 @@ func NewGossip(kconf *Config, conf *gossip.Config) (*Gossip, error) {
 	// Inter affinity group gossip (global)
 	poolConf := gossip.DefaultLANPoolConfig(int32(globalGossipPoolID))
 	poolConf.Events = gs.delegate
+	poolConf.Delegate = gs.delegate
 
 	gs.inter = gsp.RegisterPool(poolConf)
+	gs.setLabels(gs.inter)
 
 	return gs, nil
 }
 
 // Register registers the kelips instance and starts all go-routines.  This should be the last
-// call once eveything has been initialized.  Join can be called after this one
+// call once eveything has been initialized.  Join can be called after this one.  Shutting
+// down the kelips instance also shuts down gossip
 func (st *Gossip) Register(k *Kelips) error {
 	st.delegate.kelips = k
+	k.notifier = st
+	// Hosts are mapped to groups the same way as the instance with its
+	// defaults set
+	st.hasher, st.mapper = k.hasher, k.conf.Mapper
+	k.shutdownHooks = append(k.shutdownHooks, st.Shutdown)
 	return st.start()
 }
 
+// Shutdown leaves the home group and inter-group gossip pools so peers are
+// notified promptly and then shuts down gossip.  Leaving is bounded by the
+// context deadline if any
+func (st *Gossip) Shutdown(ctx context.Context) error {
+	timeout := leaveTimeout(ctx)
+
+	err := st.routines.stop(ctx)
+
+	for _, pool := range append(st.homePools(), st.inter) {
+		if er := pool.Leave(timeout); er != nil && err == nil {
+			err = er
+		}
+	}
+
+	if er := st.gossip.Shutdown(); er != nil && err == nil {
+		err = er
+	}
+
+	return err
+}
+
+// leaveTimeout returns the time allowed to leave a pool within the context
+// deadline if any
+func leaveTimeout(ctx context.Context) time.Duration {
+	if deadline, ok := ctx.Deadline(); ok {
+		return time.Until(deadline)
+	}
+	return defaultLeaveTimeout
+}
+
+// homePools returns the home group gossip pools
+func (st *Gossip) homePools() []*gossip.Pool {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+
+	pools := make([]*gossip.Pool, 0, len(st.pools))
+	for _, pool := range st.pools {
+		pools = append(pools, pool)
+	}
+	return pools
+}
+
 // Join joins the inter-group gossip pool and the home gossip group assuming a home node
 // has been provided
 func (st *Gossip) Join(peers ...string) (int, error) {
@@ -95,7 +184,7 @@ func (st *Gossip) Join(peers ...string) (int, error) {
 	for _, peer := range peers {
 		_, er := st.delegate.kelips.AddPeer(&Peer{Host: peer})
 		if er != nil {
-			if er != errContactExists {
+			if er != errContactExists && er != errContactDropped {
 				err = er
 			}
 			continue
@@ -126,33 +215,133 @@ func (st *Gossip) ListenTCP() net.Listener {
 func (st *Gossip) New(id int64, homeNode bool) ContactStorage {
 	cs := &gossipContactStorage{
 		id:       id,
+		host:     st.host,
+		zone:     st.zone,
 		contacts: make([]string, 0, 1),
+		joined:   make(map[string]int64),
 		log:      st.log,
 	}
 
 	if !homeNode {
+		cs.max = st.maxContacts
 		cs.peers = st.inter.Peers()
 		return cs
 	}
 
-	delegate := &tuplesGossipDelegate{
-		tuples: st.tuples,
-		host:   st.host,
-		log:    st.log,
-	}
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	// The home groups of both rings share a pool while resizing if their
+	// ids match
+	pool, ok := st.pools[id]
+	if !ok {
+		delegate := &tuplesGossipDelegate{
+			id:         id,
+			tuples:     st.tuples,
+			tombstones: st.gtuples.tombstones,
+			host:       st.host,
+			metrics:    st.gtuples.metrics,
+			log:        st.log,
+		}
 
-	conf := gossip.DefaultLANPoolConfig(int32(id))
-	conf.Events = delegate
-	conf.Delegate = delegate
+		conf := gossip.DefaultLANPoolConfig(int32(id))
+		conf.Events = delegate
+		conf.Delegate = delegate
 
-	st.id = id
-	st.gtuples.pool = st.gossip.RegisterPool(conf)
+		pool = st.gossip.RegisterPool(conf)
+		st.setLabels(pool)
+		st.pools[id] = pool
+	}
+
+	// Tuples are broadcast to the first home group.  The home pool of the
+	// next groups is joined once resizing begins
+	if st.id < 0 {
+		st.id = id
+		st.gtuples.setPools(pool, nil)
+	}
 
-	cs.peers = conf.Peers
+	cs.peers = pool.Peers()
 
 	return cs
 }
 
+// broadcastResize sends the resize message to all nodes through the
+// inter-group pool.  It implements the resizeNotifier interface
+func (st *Gossip) broadcastResize(msg *resizeMsg) error {
+	buf := bytes.NewBuffer(nil)
+	if err := writeResizeMsg(buf, msg); err != nil {
+		return err
+	}
+	return st.inter.Broadcast(buf.Bytes())
+}
+
+// resizing leaves the home pools of superseded groups and joins the home
+// pool of the next groups.  Tuples are broadcast to both the current and
+// next home pools until the switch.  It implements the resizeNotifier
+// interface
+func (st *Gossip) resizing(ctx context.Context, k, id int64) {
+	st.mu.Lock()
+	stale := st.dropPools(st.id, id)
+	current, next := st.pools[st.id], st.pools[id]
+	st.mu.Unlock()
+
+	st.gtuples.setPools(current, next)
+	st.leavePools(ctx, stale)
+
+	if next == nil || next == current {
+		return
+	}
+	if err := st.joinPool(next, k, id); err != nil {
+		st.log.Errorf("Failed to join next home pool group=%d: %v", id, err)
+	}
+}
+
+// resized leaves the home pools of the old groups and joins the new home
+// pool.  It implements the resizeNotifier interface
+func (st *Gossip) resized(ctx context.Context, k, id int64) {
+	st.mu.Lock()
+	st.k, st.id = k, id
+	stale := st.dropPools(id)
+	pool := st.pools[id]
+	st.mu.Unlock()
+
+	if pool != nil {
+		st.gtuples.setPools(pool, nil)
+	}
+	st.leavePools(ctx, stale)
+
+	if err := st.joinGroup(); err != nil {
+		st.log.Errorf("Failed to join home pool group=%d: %v", id, err)
+	}
+}
+
+// dropPools removes the home pools of all groups other than the given ids
+// returning them.  It must be called with the lock held
+func (st *Gossip) dropPools(keep ...int64) []*gossip.Pool {
+	stale := make([]*gossip.Pool, 0, 1)
+	for gid, pool := range st.pools {
+		var kept bool
+		for _, id := range keep {
+			kept = kept || gid == id
+		}
+		if !kept {
+			stale = append(stale, pool)
+			delete(st.pools, gid)
+		}
+	}
+	return stale
+}
+
+// leavePools leaves the pools within the context deadline if any
+func (st *Gossip) leavePools(ctx context.Context, pools []*gossip.Pool) {
+	timeout := leaveTimeout(ctx)
+	for _, pool := range pools {
+		if err := pool.Leave(timeout); err != nil {
+			st.log.Errorf("Failed to leave old home pool: %v", err)
+		}
+	}
+}
+
 // Start starts gossip and the underlying kelips instance
 func (st *Gossip) start() error {
 	// Kelips Transport listener
@@ -165,22 +354,113 @@ func (st *Gossip) start() error {
 	if err == nil {
 		err = st.delegate.kelips.Start(ln)
 	}
+	if err == nil {
+		st.routines.start(st.advertiseLoad)
+	}
 	return err
 }
 
-func (st *Gossip) joinGroup() (err error) {
+// setLabels sets the zone and rack in the local node metadata of a pool
+// before it is joined
+func (st *Gossip) setLabels(pool *gossip.Pool) {
+	st.setNodeMeta(pool, func(meta map[string]string) {
+		setLabelMeta(meta, st.zone, st.rack)
+	})
+}
+
+// setNodeMeta replaces the local node metadata of the pool with a copy
+// changed by fn.  The map is never modified in place as the pool may be
+// reading it
+func (st *Gossip) setNodeMeta(pool *gossip.Pool, fn func(map[string]string)) {
+	st.metaMu.Lock()
+	defer st.metaMu.Unlock()
+
+	node := pool.LocalNode()
+	meta := make(map[string]string, len(node.Meta)+2)
+	for k, v := range node.Meta {
+		meta[k] = v
+	}
+	fn(meta)
+	node.Meta = meta
+}
+
+// advertiseLoad updates the load in the home pool metadata every load
+// interval until the context is done
+func (st *Gossip) advertiseLoad(ctx context.Context) {
+	interval := st.loadInterval
+	if interval == 0 {
+		interval = DefaultConfig().LoadInterval
+	}
+
+	for {
+		if err := st.updateLoad(interval); err != nil {
+			st.log.Errorf("Failed to advertise load: %v", err)
+		}
+		if !sleepContext(ctx, interval) {
+			return
+		}
+	}
+}
+
+// homedTuples returns the number of tuples homed on this node
+func (st *Gossip) homedTuples() int64 {
+	if hc, ok := st.tuples.(hostCounter); ok {
+		return int64(hc.CountHost(st.host))
+	}
+
+	var homed int64
+	for _, t := range st.tuples.List() {
+		if t.HasHost(st.host) {
+			homed++
+		}
+	}
+	return homed
+}
+
+// updateLoad sets the number of tuples homed on this node and its capacity
+// in the local node metadata of the home pool
+func (st *Gossip) updateLoad(timeout time.Duration) error {
+	pool := st.gtuples.homePool()
+	if pool == nil {
+		return nil
+	}
+
+	load := PeerLoad{Tuples: st.homedTuples(), Capacity: st.capacity}
+	st.setNodeMeta(pool, func(meta map[string]string) {
+		setLoadMeta(meta, load)
+	})
+
+	return pool.UpdateNode(timeout)
+}
+
+// joinGroup joins the current home pool
+func (st *Gossip) joinGroup() error {
+	st.mu.RLock()
+	k, home := st.k, st.id
+	pool := st.pools[home]
+	st.mu.RUnlock()
+
+	if pool == nil {
+		return nil
+	}
+	return st.joinPool(pool, k, home)
+}
+
+// joinPool joins the home pool of group home out of k groups through the
+// first of its members found in the inter-group pool
+func (st *Gossip) joinPool(pool *gossip.Pool, k, home int64) (err error) {
 	// Get all peers from the global pool
 	peers := st.inter.Peers().List()
 
 	for _, peer := range peers {
 		// Get affinity group for peer
 		addr := peer.Address()
-		id := lookupGroup([]byte(addr), st.k, st.hasher())
+		id := nodeGroup(st.mapper, addr, k, st.hasher)
 
 		// Only join home pool if this is our home group and the host
 		// is not ourself
-		if id == st.id && addr != st.host {
-			_, err = st.gtuples.pool.Join([]string{addr})
+		if id == home && addr != st.host {
+			_, err = pool.Join([]string{addr})
 			if err == nil {
 				return nil
 			}
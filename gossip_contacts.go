@@ -1,9 +1,11 @@
 package kelips
 
 import (
+	"context"
 	"math/rand"
 	"sort"
 	"sync"
+	"time"
 
 	"github.com/euforia/gossip/peers"
 	"github.com/hexablock/log"
@@ -13,48 +15,176 @@ type gossipContactStorage struct {
 	// affinity group id the store belongs to
 	id int64
 
+	// local host excluded from the closest peers
+	host string
+	// local zone whose peers are preferred
+	zone string
+
 	// lib containing all peers in the kelips network ie. local and foreign
 	// affinity groups
 	peers peers.Library
 
+	// max contacts.  Zero is unlimited
+	max int
+
 	// contacts from the library part of the affinity group
 	mu       sync.RWMutex
 	contacts []string
+	// candidates replacing contacts on refresh once max is reached
+	spares []string
+	// unix nano time each contact or spare was added
+	joined map[string]int64
 
 	log *log.Logger
 }
 
+// Add adds the peer as a contact or as a spare once max is reached.  Once
+// the spares are full too the peer replaces the farthest spare if it is
+// closer otherwise errContactDropped is returned
 func (g *gossipContactStorage) Add(p PeerContact) error {
 	g.mu.Lock()
 	defer g.mu.Unlock()
 
 	addr := p.Address()
-	for _, c := range g.contacts {
-		if c == addr {
-			return errContactExists
+	if _, ok := g.joined[addr]; ok {
+		return errContactExists
+	}
+
+	now := time.Now().UnixNano()
+	switch {
+	case g.max == 0 || len(g.contacts) < g.max:
+		g.contacts = append(g.contacts, addr)
+	case len(g.spares) < g.max:
+		g.spares = append(g.spares, addr)
+	default:
+		drop := g.farthestSpare(addr, now)
+		if drop == addr {
+			return errContactDropped
+		}
+		for i, s := range g.spares {
+			if s == drop {
+				g.spares = append(g.spares[:i], g.spares[i+1:]...)
+				break
+			}
 		}
+		delete(g.joined, drop)
+		g.spares = append(g.spares, addr)
 	}
+	g.joined[addr] = now
 
-	g.contacts = append(g.contacts, addr)
 	g.log.Debugf("group=%d contacts=%v", g.id, g.contacts)
 	return nil
 }
 
-// Remove is a no-op that implements the ContractStorage interface
+// Remove removes the peer from the contacts replacing it with a spare
 func (g *gossipContactStorage) Remove(p PeerContact) error {
 	g.mu.Lock()
 	defer g.mu.Unlock()
 
+	addr := p.Address()
+	if _, ok := g.joined[addr]; !ok {
+		return errContactNotFound
+	}
+	delete(g.joined, addr)
+
 	for i, c := range g.contacts {
-		if c == p.Address() {
+		if c == addr {
 			g.contacts = append(g.contacts[:i], g.contacts[i+1:]...)
+			if len(g.spares) > 0 {
+				g.contacts = append(g.contacts, g.spares[0])
+				g.spares = g.spares[1:]
+			}
 			return nil
 		}
 	}
-	return errContactNotFound
+	for i, c := range g.spares {
+		if c == addr {
+			g.spares = append(g.spares[:i], g.spares[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+// farthestSpare returns the spare or the new peer to drop once the spares
+// are full.  Spares that have left the library go first followed by the
+// farthest, dropping the most recently known when equally close.  It must be
+// called with the lock held
+func (g *gossipContactStorage) farthestSpare(addr string, now int64) string {
+	all := append(append([]string{}, g.spares...), addr)
+	var live []*peers.Peer
+	inLib := make(map[string]bool, len(all))
+	for _, p := range g.peers.GetByAddress(all...) {
+		if p != nil {
+			live = append(live, p)
+			inLib[p.Address()] = true
+		}
+	}
+	for _, s := range g.spares {
+		if !inLib[s] {
+			return s
+		}
+	}
+	if !inLib[addr] {
+		return addr
+	}
+
+	joined := func(a string) int64 {
+		if t, ok := g.joined[a]; ok {
+			return t
+		}
+		return now
+	}
+	sort.SliceStable(live, func(i, j int) bool {
+		return joined(live[i].Address()) < joined(live[j].Address())
+	})
+	sort.Stable(peers.ClosestPeers(live))
+	return live[len(live)-1].Address()
 }
 
-// List is a no-op that implements the ContractStorage interface
+// refresh drops contacts and spares that have left the library and keeps
+// the closest upto max as contacts, preferring the longest known when equally
+// close
+func (g *gossipContactStorage) refresh(ctx context.Context) {
+	if g.max == 0 {
+		return
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	all := append(append([]string{}, g.contacts...), g.spares...)
+	var live []*peers.Peer
+	for _, p := range g.peers.GetByAddress(all...) {
+		if p != nil {
+			live = append(live, p)
+		}
+	}
+
+	sort.SliceStable(live, func(i, j int) bool {
+		return g.joined[live[i].Address()] < g.joined[live[j].Address()]
+	})
+	sort.Stable(peers.ClosestPeers(live))
+
+	joined := make(map[string]int64, len(live))
+	g.contacts = make([]string, 0, g.max)
+	g.spares = make([]string, 0, g.max)
+	for i, p := range live {
+		addr := p.Address()
+		switch {
+		case i < g.max:
+			g.contacts = append(g.contacts, addr)
+		case i < 2*g.max:
+			g.spares = append(g.spares, addr)
+		default:
+			continue
+		}
+		joined[addr] = g.joined[addr]
+	}
+	g.joined = joined
+}
+
+// List returns the contacts still in the library.  Spares are not listed
 func (g *gossipContactStorage) List() []PeerContact {
 	g.mu.RLock()
 	libPeers := g.peers.GetByAddress(g.contacts...)
@@ -62,23 +192,42 @@ func (g *gossipContactStorage) List() []PeerContact {
 	return peersToPeerContacts(libPeers)
 }
 
-// GetClosest returns the closest peer excluding self
+// GetClosest returns the closest peer excluding self preferring those in
+// the local zone.  Self is returned if it is the only peer
 func (g *gossipContactStorage) GetClosest() (PeerContact, bool) {
+	if closest := g.ListClosest(); len(closest) > 0 {
+		return closest[0], true
+	}
+
+	g.mu.RLock()
+	lpeers := g.peers.GetByAddress(g.contacts...)
+	g.mu.RUnlock()
+
+	for _, p := range lpeers {
+		if p != nil {
+			return p, true
+		}
+	}
+	return nil, false
+}
+
+// ListClosest returns all peers excluding self ordered by proximity with
+// those in the local zone first
+func (g *gossipContactStorage) ListClosest() []PeerContact {
 	g.mu.RLock()
 	lpeers := g.peers.GetByAddress(g.contacts...)
 	g.mu.RUnlock()
 
 	sort.Sort(peers.ClosestPeers(lpeers))
 
-	l := len(lpeers)
-	switch l {
-	case 0:
-		return nil, false
-	case 1:
-		return lpeers[0], true
+	out := make([]PeerContact, 0, len(lpeers))
+	for _, p := range lpeers {
+		if p == nil || p.Address() == g.host {
+			continue
+		}
+		out = append(out, p)
 	}
-
-	return lpeers[1], true
+	return preferZone(out, g.zone)
 }
 
 // GetRandom returns a random peer from the library and may include self
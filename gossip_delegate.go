@@ -3,6 +3,7 @@ package kelips
 import (
 	"bytes"
 	"net"
+	"strconv"
 
 	"github.com/euforia/gossip/peers/peerspb"
 	"github.com/hexablock/log"
@@ -17,15 +18,59 @@ type kelipsGossipDelegate struct {
 func (g *kelipsGossipDelegate) NotifyJoin(peer *peerspb.Peer) {
 	// Add peer to appropriate group
 	gid, err := g.kelips.AddPeer(peer)
-	if err != nil {
-		g.log.Errorf("Failed to add peer: %s %v", peer.Address(), err)
-	} else {
+	switch err {
+	case nil:
 		g.log.Infof("New peer=%s group=%d", peer.Address(), gid)
+	case errContactDropped:
+		g.log.Debugf("Peer not kept as contact peer=%s group=%d", peer.Address(), gid)
+	default:
+		g.log.Errorf("Failed to add peer: %s %v", peer.Address(), err)
 	}
 }
 
 func (g *kelipsGossipDelegate) NotifyUpdate(peer *peerspb.Peer) {}
 
+// NotifyMsg handles resize messages broadcast by other nodes
+func (g *kelipsGossipDelegate) NotifyMsg(msg []byte) {
+	rm, err := readResizeMsg(bytes.NewReader(msg))
+	if err != nil {
+		g.log.Error("Failed to parse resize message: ", err)
+		return
+	}
+	g.kelips.handleResize(rm)
+}
+
+// MergeRemoteState applies the resize state of a remote node so nodes that
+// missed a resize catch up
+func (g *kelipsGossipDelegate) MergeRemoteState(remote *net.TCPAddr, buf []byte, join bool) {
+	if len(buf) == 0 {
+		return
+	}
+
+	rm, err := readResizeMsg(bytes.NewReader(buf))
+	if err != nil {
+		g.log.Errorf("Failed to parse resize state from=%s: %v", remote.String(), err)
+		return
+	}
+	g.kelips.handleResize(rm)
+}
+
+// LocalState returns the resize state of this node.  It is empty if the
+// cluster was never resized
+func (g *kelipsGossipDelegate) LocalState(join bool) []byte {
+	rm := g.kelips.resizeStatus()
+	if rm == nil {
+		return nil
+	}
+
+	buf := bytes.NewBuffer(nil)
+	if err := writeResizeMsg(buf, rm); err != nil {
+		g.log.Error("Failed to write resize state: ", err)
+		return nil
+	}
+	return buf.Bytes()
+}
+
 func (g *kelipsGossipDelegate) NotifyLeave(peer *peerspb.Peer) {
 	// Remove from appropriate group
 	gid, err := g.kelips.RemovePeer(peer)
@@ -39,10 +84,16 @@ func (g *kelipsGossipDelegate) NotifyLeave(peer *peerspb.Peer) {
 // tuplesGossipDelegate is the gossip pool for a home group i.e. a local group with
 // tuples
 type tuplesGossipDelegate struct {
+	// home group id
+	id int64
 	// local host used for the state exchange header
 	host string
 	// local tuples
 	tuples TupleStorage
+	// deleted keys to exclude when seeding
+	tombstones *tombstones
+	// metrics sink
+	metrics MetricsCollector
 	// logger
 	log *log.Logger
 }
@@ -55,33 +106,108 @@ func (g *tuplesGossipDelegate) NotifyLeave(peer *peerspb.Peer) {
 	addr := peer.Address()
 	c := g.tuples.ExpireHost(addr)
 	g.log.Infof("Peer left peer=%s tuples-expired=%d", addr, c)
+	g.metrics.IncrCounter(metricTuplesExpired, float64(c), groupLabel(g.id))
 }
 
 func (g *tuplesGossipDelegate) NotifyMsg(msg []byte) {
-	// TODO:
-	host := hostBytesToString(msg[:18])
-	tuples, err := readTuples(bytes.NewBuffer(msg[18:]))
+	buf := bytes.NewReader(msg)
+	typ, host, err := readMessageHeader(buf)
+	if err != nil {
+		g.log.Error("Failed to parse message header: ", err)
+		return
+	}
+	g.metrics.IncrCounter(metricGossipReceived, 1, tupleMsgLabel(typ))
+
+	switch typ {
+	case tupleMsgInsert:
+		g.handleInsertMsg(host, buf)
+
+	case tupleMsgDelete:
+		g.handleDeleteMsg(host, buf)
+
+	case tupleMsgRenew:
+		g.handleRenewMsg(host, buf)
+
+	case tupleMsgRelease:
+		g.handleReleaseMsg(host, buf)
+
+	default:
+		g.log.Errorf("Unknown message type=%d from=%s", typ, host)
+
+	}
+}
+
+func (g *tuplesGossipDelegate) handleInsertMsg(host string, buf *bytes.Reader) {
+	tuples, err := readTupleRecords(buf)
 	if err != nil {
-		g.log.Error("Failed to parse tuples: ", err)
+		g.log.Errorf("Failed to parse tuples from=%s: %v", host, err)
 		return
 	}
+
+	// An explicit insert supersedes a prior delete
+	keys := make([][]byte, 0, len(tuples))
+	for _, t := range tuples {
+		keys = append(keys, t.Key)
+	}
+	g.tombstones.remove(keys...)
+
 	inserted := g.tuples.Insert(tuples...)
 	g.log.Infof("Inserted tuples: %d/%d from=%s", inserted, len(tuples), host)
 }
 
+func (g *tuplesGossipDelegate) handleDeleteMsg(host string, buf *bytes.Reader) {
+	keys, err := readKeys(buf)
+	if err != nil {
+		g.log.Errorf("Failed to parse keys from=%s: %v", host, err)
+		return
+	}
+
+	g.tombstones.add(keys...)
+	deleted := g.tuples.Delete(keys...)
+	g.log.Infof("Deleted tuples: %d/%d from=%s", deleted, len(keys), host)
+}
+
+func (g *tuplesGossipDelegate) handleRenewMsg(host string, buf *bytes.Reader) {
+	key, ttl, err := readRenew(buf)
+	if err != nil {
+		g.log.Errorf("Failed to parse renew from=%s: %v", host, err)
+		return
+	}
+
+	renewed := g.tuples.Renew(key, ttl)
+	g.log.Debugf("Renewed tuple key=%q ttl=%v ok=%v from=%s", key, ttl, renewed, host)
+}
+
+func (g *tuplesGossipDelegate) handleReleaseMsg(host string, buf *bytes.Reader) {
+	key, releaser, err := readRelease(buf)
+	if err != nil {
+		g.log.Errorf("Failed to parse release from=%s: %v", host, err)
+		return
+	}
+
+	released := g.tuples.Release(key, releaser)
+	g.log.Debugf("Released tuple key=%q host=%s ok=%v from=%s", key, releaser, released, host)
+}
+
 func (g *tuplesGossipDelegate) MergeRemoteState(remote *net.TCPAddr, buf []byte, join bool) {
 	if len(buf) == 0 {
 		return
 	}
 
-	tuples, err := readTuples(bytes.NewBuffer(buf))
+	tuples, err := readTuples(bytes.NewReader(buf))
 	if err != nil {
-		g.log.Error("Failed to parse tuples: ", err)
+		g.log.Errorf("Failed to parse tuples from=%s: %v", remote.String(), err)
 		return
 	}
 
+	label := Label{Name: "join", Value: strconv.FormatBool(join)}
+	g.metrics.IncrCounter(metricGossipMerges, 1, label)
+	g.metrics.IncrCounter(metricGossipMergeBytes, float64(len(buf)), label)
+	g.metrics.IncrCounter(metricGossipMergeTuples, float64(len(tuples)), label)
+
 	if join {
-		// Insert tuples received from a peer on join
+		// Insert tuples received from a peer on join skipping deleted ones
+		tuples = g.tombstones.filter(tuples)
 		inserted := g.tuples.Insert(tuples...)
 		g.log.Infof("Seeded tuples: %d/%d from=%s", inserted, len(tuples), remote.String())
 	} else {
@@ -94,7 +220,7 @@ func (g *tuplesGossipDelegate) pingRemoteTuples(remote *net.TCPAddr, tuples []*T
 	keys := make([][]byte, 0, len(tuples))
 	for _, tuple := range tuples {
 		// Do not ping tuples that do not belong to the remote node
-		if tuple.Host != remote.String() {
+		if !tuple.HasHost(remote.String()) {
 			continue
 		}
 		keys = append(keys, tuple.Key)
@@ -111,7 +237,7 @@ func (g *tuplesGossipDelegate) pingLocalTuples() []*Tuple {
 	tuples := make([]*Tuple, 0, len(all))
 	keys := make([][]byte, 0, len(tuples))
 	for _, a := range all {
-		if a.Host == g.host {
+		if a.HasHost(g.host) {
 			tuples = append(tuples, a)
 			keys = append(keys, a.Key)
 		}
@@ -138,5 +264,6 @@ func (g *tuplesGossipDelegate) LocalState(join bool) []byte {
 	}
 
 	g.log.Debugf("Sending tuples=%d", len(tuples))
+	g.metrics.IncrCounter(metricGossipStateBytes, float64(buf.Len()))
 	return buf.Bytes()
 }
@@ -2,36 +2,261 @@ package kelips
 
 import (
 	"bytes"
+	"sync"
+	"time"
 
 	"github.com/euforia/gossip"
 	"github.com/hexablock/log"
 )
 
+// Tuple message types broadcasted to the home group gossip pool
+const (
+	tupleMsgInsert byte = iota + 1
+	tupleMsgDelete
+	tupleMsgRenew
+	tupleMsgRelease
+)
+
+// tupleMsgLabel returns the metric label for the message type
+func tupleMsgLabel(typ byte) Label {
+	switch typ {
+	case tupleMsgInsert:
+		return Label{Name: "type", Value: "insert"}
+	case tupleMsgDelete:
+		return Label{Name: "type", Value: "delete"}
+	case tupleMsgRenew:
+		return Label{Name: "type", Value: "renew"}
+	case tupleMsgRelease:
+		return Label{Name: "type", Value: "release"}
+	}
+	return Label{Name: "type", Value: "unknown"}
+}
+
+// tombstones tracks recently deleted keys so they are not re-introduced by
+// a remote state exchange before the deletion has reached all members
+type tombstones struct {
+	mu sync.RWMutex
+	m  map[string]int64
+}
+
+func newTombstones() *tombstones {
+	return &tombstones{m: make(map[string]int64)}
+}
+
+// add marks the keys as deleted
+func (ts *tombstones) add(keys ...[]byte) {
+	now := time.Now().UnixNano()
+	ts.mu.Lock()
+	for _, k := range keys {
+		ts.m[string(k)] = now
+	}
+	ts.mu.Unlock()
+}
+
+// remove clears the deleted mark for the keys i.e. on re-insertion
+func (ts *tombstones) remove(keys ...[]byte) {
+	ts.mu.Lock()
+	for _, k := range keys {
+		delete(ts.m, string(k))
+	}
+	ts.mu.Unlock()
+}
+
+// filter returns the tuples that have not been deleted
+func (ts *tombstones) filter(tuples []*Tuple) []*Tuple {
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+
+	out := make([]*Tuple, 0, len(tuples))
+	for _, t := range tuples {
+		if _, ok := ts.m[string(t.Key)]; !ok {
+			out = append(out, t)
+		}
+	}
+	return out
+}
+
+// expire removes all tombstones older than d returning the number removed
+func (ts *tombstones) expire(d time.Duration) int {
+	var c int
+	marker := time.Now().UnixNano() - d.Nanoseconds()
+	ts.mu.Lock()
+	for k, v := range ts.m {
+		if v < marker {
+			delete(ts.m, k)
+			c++
+		}
+	}
+	ts.mu.Unlock()
+	return c
+}
+
 type gossipTupleStorage struct {
+	// home group pool tuples are broadcast to and while resizing the home
+	// pool of the next groups.  Replaced on resize
+	mu   sync.RWMutex
 	pool *gossip.Pool
-	log  *log.Logger
+	next *gossip.Pool
+	// keys deleted locally or by a remote
+	tombstones *tombstones
+	metrics    MetricsCollector
+	log        *log.Logger
 	TupleStorage
 }
 
+// homePool returns the pool tuples are broadcast to
+func (g *gossipTupleStorage) homePool() *gossip.Pool {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	return g.pool
+}
+
+// setPools sets the pool tuples are broadcast to and the next one if
+// resizing
+func (g *gossipTupleStorage) setPools(pool, next *gossip.Pool) {
+	g.mu.Lock()
+	g.pool, g.next = pool, next
+	g.mu.Unlock()
+}
+
+// pools returns the distinct pools tuples are broadcast to
+func (g *gossipTupleStorage) pools() []*gossip.Pool {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	if g.next == nil || g.next == g.pool {
+		return []*gossip.Pool{g.pool}
+	}
+	return []*gossip.Pool{g.pool, g.next}
+}
+
+// setMetrics sets the collector for broadcasts and the underlying store
+func (g *gossipTupleStorage) setMetrics(m MetricsCollector) {
+	g.metrics = m
+	if ms, ok := g.TupleStorage.(metricsSetter); ok {
+		ms.setMetrics(m)
+	}
+}
+
+// Count returns the number of tuples if the underlying store can count
+// them
+func (g *gossipTupleStorage) Count() int {
+	if counter, ok := g.TupleStorage.(tupleCounter); ok {
+		return counter.Count()
+	}
+	return len(g.TupleStorage.List())
+}
+
 func (g *gossipTupleStorage) Insert(tuples ...*Tuple) int {
 	n := g.TupleStorage.Insert(tuples...)
 
-	local := g.pool.LocalNode()
-	buf := bytes.NewBuffer(hostStringToBytes(local.Address()))
-
+	keys := make([][]byte, 0, len(tuples))
 	for _, t := range tuples {
-		line := append(hostStringToBytes(t.Host), t.Key...)
-		_, err := buf.Write(append([]byte{uint8(len(line))}, line...))
-		if err != nil {
-			g.log.Error("Failed to write insert buffer: ", err)
-			return n
-		}
+		keys = append(keys, t.Key)
+	}
+	g.tombstones.remove(keys...)
+
+	buf, err := g.newMessage(tupleMsgInsert)
+	if err == nil {
+		err = writeTupleRecords(buf, tuples)
+	}
+	if err != nil {
+		g.log.Error("Failed to write insert buffer: ", err)
+		return n
 	}
 
-	err := g.pool.Broadcast(buf.Bytes())
+	g.broadcast(tupleMsgInsert, buf.Bytes())
+
+	return n
+}
+
+// Delete deletes the keys locally and broadcasts the deletion to the home
+// group
+func (g *gossipTupleStorage) Delete(keys ...[]byte) int {
+	n := g.TupleStorage.Delete(keys...)
+	g.tombstones.add(keys...)
+
+	buf, err := g.newMessage(tupleMsgDelete)
+	if err == nil {
+		err = writeKeys(buf, keys)
+	}
 	if err != nil {
-		g.log.Error("Failed to broadcast: ", err)
+		g.log.Error("Failed to write delete buffer: ", err)
+		return n
 	}
 
+	g.broadcast(tupleMsgDelete, buf.Bytes())
+
 	return n
 }
+
+// Renew renews the lease locally and broadcasts it to the home group
+func (g *gossipTupleStorage) Renew(key []byte, ttl time.Duration) bool {
+	if !g.TupleStorage.Renew(key, ttl) {
+		return false
+	}
+
+	buf, err := g.newMessage(tupleMsgRenew)
+	if err == nil {
+		err = writeRenew(buf, key, ttl)
+	}
+	if err != nil {
+		g.log.Error("Failed to write renew buffer: ", err)
+		return true
+	}
+
+	g.broadcast(tupleMsgRenew, buf.Bytes())
+	return true
+}
+
+// Release releases the lease held by the host locally and broadcasts it to
+// the home group
+func (g *gossipTupleStorage) Release(key []byte, host string) bool {
+	if !g.TupleStorage.Release(key, host) {
+		return false
+	}
+
+	buf, err := g.newMessage(tupleMsgRelease)
+	if err == nil {
+		err = writeRelease(buf, key, host)
+	}
+	if err != nil {
+		g.log.Error("Failed to write release buffer: ", err)
+		return true
+	}
+
+	g.broadcast(tupleMsgRelease, buf.Bytes())
+	return true
+}
+
+// Expire expires tuples along with tombstones older than d
+func (g *gossipTupleStorage) Expire(d time.Duration) int {
+	n := g.TupleStorage.Expire(d)
+	if c := g.tombstones.expire(d); c > 0 {
+		g.log.Debugf("Expired tombstones=%d", c)
+	}
+	return n
+}
+
+// newMessage returns a buffer with the message header for the local host
+func (g *gossipTupleStorage) newMessage(typ byte) (*bytes.Buffer, error) {
+	local := g.homePool().LocalNode()
+	buf := bytes.NewBuffer(nil)
+	err := writeMessageHeader(buf, typ, local.Address())
+	return buf, err
+}
+
+// broadcast sends the message to the home pool and the next one if resizing
+// so members of both groups apply it
+func (g *gossipTupleStorage) broadcast(typ byte, msg []byte) {
+	label := tupleMsgLabel(typ)
+	for _, pool := range g.pools() {
+		if err := pool.Broadcast(msg); err != nil {
+			g.log.Error("Failed to broadcast: ", err)
+			continue
+		}
+
+		g.metrics.IncrCounter(metricGossipBroadcasts, 1, label)
+		g.metrics.IncrCounter(metricGossipBroadcastBytes, float64(len(msg)), label)
+	}
+}
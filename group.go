@@ -1,8 +1,12 @@
 package kelips
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/hexablock/log"
@@ -44,25 +48,47 @@ type affinityGroup struct {
 	tupleExpMin time.Duration
 	tupleExpMax time.Duration
 
+	// Interval to probe contacts if supported by the store
+	probeInterval time.Duration
+
+	// Number of home nodes to assign to each tuple and the policy selecting
+	// them
+	replicas  int
+	placement PlacementPolicy
+
+	// Contacts that recently failed and number of alternates to try
+	suspects *suspectContacts
+	retries  int
+
 	// Network transport
 	trans Transport
 
 	// gossip
 	gossip *gossip.Pool
 
-	log *log.Logger
+	// background go-routines
+	routines routines
+
+	metrics MetricsCollector
+	log     *log.Logger
 }
 
 func newAffinityGroup(g *GroupContact, conf *Config) *affinityGroup {
 	group := &affinityGroup{
-		GroupContact: *g,
-		trans:        conf.Transport,
-		contacts:     conf.Contacts.New(g.ID, true),
-		tuples:       conf.Tuples,
-		tupleTTL:     conf.TupleTTL,
-		tupleExpMin:  conf.TupleExpireMinInt,
-		tupleExpMax:  conf.TupleExpireMaxInt,
-		log:          conf.Logger,
+		GroupContact:  *g,
+		trans:         conf.Transport,
+		contacts:      conf.Contacts.New(g.ID, true),
+		tuples:        conf.Tuples,
+		tupleTTL:      conf.TupleTTL,
+		tupleExpMin:   conf.TupleExpireMinInt,
+		tupleExpMax:   conf.TupleExpireMaxInt,
+		probeInterval: conf.ProbeInterval,
+		replicas:      conf.ReplicationFactor,
+		placement:     conf.Placement,
+		suspects:      newSuspectContacts(conf.SuspectTimeout),
+		retries:       conf.ContactRetries,
+		metrics:       conf.Metrics,
+		log:           conf.Logger,
 	}
 
 	group.trans.Register(group.GroupContact, group)
@@ -74,18 +100,40 @@ func (group *affinityGroup) Start() {
 	group.log.Infof("Tuple expiration group=%d min=%v max=%v",
 		group.ID, group.tupleExpMin, group.tupleExpMax)
 
-	go group.expireTuples()
+	group.routines.start(group.expireTuples)
+
+	if prober, ok := group.contacts.(contactProber); ok {
+		group.routines.start(func(ctx context.Context) {
+			probeContacts(ctx, prober, group.probeInterval)
+		})
+	}
+}
+
+// Stop stops all go-routines waiting for them to exit or the context to be
+// done
+func (group *affinityGroup) Stop(ctx context.Context) error {
+	return group.routines.stop(ctx)
 }
 
-func (group *affinityGroup) expireTuples() {
+func (group *affinityGroup) expireTuples(ctx context.Context) {
 	for {
 		sleepFor := randExpire(group.tupleExpMin, group.tupleExpMax)
-		// fmt.Println(sleepFor)
-		time.Sleep(sleepFor)
+		if !sleepContext(ctx, sleepFor) {
+			return
+		}
 
 		if c := group.tuples.Expire(group.tupleTTL); c > 0 {
 			group.log.Infof("Expired group=%d tuples=%d", group.ID, c)
+			group.metrics.IncrCounter(metricTuplesExpired, float64(c), groupLabel(group.ID))
 		}
+		group.updateTupleCount()
+	}
+}
+
+// updateTupleCount sets the tuple gauge if the store can count its tuples
+func (group *affinityGroup) updateTupleCount() {
+	if counter, ok := group.tuples.(tupleCounter); ok {
+		group.metrics.SetGauge(metricGroupTuples, float64(counter.Count()), groupLabel(group.ID))
 	}
 }
 
@@ -97,29 +145,23 @@ func (group *affinityGroup) IsLocal() bool {
 	return true
 }
 
-func (group *affinityGroup) Lookup(req *Request) (string, error) {
+func (group *affinityGroup) Lookup(ctx context.Context, req *Request) (*Tuple, error) {
+	start := time.Now()
+
 	// Try local first
 	if tuple := group.tuples.Lookup(req.Key); tuple != nil {
-		return tuple.Host, nil
+		req.Trace.add(newTraceHop(group.GroupContact, TraceLocalHit, start))
+		countLookup(group.metrics, group.ID, TraceLocalHit)
+		return tuple, nil
 	}
 
 	// Check ttl before trying another peer
 	if req.TTL == 0 {
-		return "", errReqTTLReached
-	}
-
-	// Try the next closest node in our group
-	p, ok := group.contacts.GetClosest()
-	if !ok {
-		// TODO:
-		return "", errNoContacts
+		req.Trace.add(newTraceHop(group.GroupContact, TraceTTLReached, start))
+		countLookup(group.metrics, group.ID, TraceTTLReached)
+		return nil, errReqTTLReached
 	}
 
-	if p.Address() == req.Originator.Host {
-		group.log.Errorf("TODO: Local selected=originator local=%s %s", group.Host, p.Address())
-	}
-
-	c := GroupContact{ID: group.ID, Host: p.Address()}
 	nreq := &Request{
 		Key:        make([]byte, len(req.Key)),
 		TTL:        req.TTL - 1, // Decrement ttl
@@ -127,27 +169,295 @@ func (group *affinityGroup) Lookup(req *Request) (string, error) {
 	}
 	copy(nreq.Key, req.Key)
 
-	return group.trans.Lookup(c, nreq)
+	// Try the next closest nodes in our group
+	var tuple *Tuple
+	err := tryContacts(ctx, group.contacts.ListClosest(), group.suspects, group.retries, func(p PeerContact) error {
+		if p.Address() == req.Originator.Host {
+			group.log.Debugf("Lookup forwarded to originator group=%d local=%s originator=%s", group.ID, group.Host, p.Address())
+		}
+
+		c := GroupContact{ID: group.ID, Host: p.Address()}
+		return req.Trace.forward(group.GroupContact, p.Address(), func(tr *Trace) (err error) {
+			nreq.Trace = tr
+			tuple, err = group.trans.Lookup(ctx, c, nreq)
+			return err
+		})
+	})
+	switch err {
+	case nil:
+		countLookup(group.metrics, group.ID, TraceForwarded)
+	case errNoContacts:
+		req.Trace.add(newTraceHop(group.GroupContact, TraceNoContacts, start))
+		countLookup(group.metrics, group.ID, TraceNoContacts)
+	default:
+		countLookup(group.metrics, group.ID, TraceForwardFailed)
+	}
+
+	return tuple, err
 }
 
-func (group *affinityGroup) AddPeer(host PeerContact) error {
-	return group.contacts.Add(host)
+func (group *affinityGroup) AddPeer(ctx context.Context, host PeerContact) error {
+	err := group.contacts.Add(host)
+	updateContactCount(group.metrics, group.ID, group.contacts)
+	return err
 }
 
-func (group *affinityGroup) RemovePeer(host PeerContact) error {
-	return group.contacts.Remove(host)
+func (group *affinityGroup) RemovePeer(ctx context.Context, host PeerContact) error {
+	err := group.contacts.Remove(host)
+	updateContactCount(group.metrics, group.ID, group.contacts)
+	return err
 }
 
-func (group *affinityGroup) Insert(key []byte) (string, error) {
-	p, ok := group.contacts.GetRandom()
-	if !ok {
-		return "", errNoContacts
+// Insert assigns the key to replication factor number of distinct group
+// members. If the key exists the existing home nodes are returned
+func (group *affinityGroup) Insert(ctx context.Context, tuple *Tuple) ([]string, error) {
+	if existing := group.tuples.Lookup(tuple.Key); existing != nil {
+		return existing.Hosts, nil
 	}
 
-	tuple := &Tuple{Key: key, Host: p.Address()}
-	group.tuples.Insert(tuple)
+	if err := tuple.validate(); err != nil {
+		return nil, err
+	}
 
-	return p.Address(), nil
+	candidates, err := group.placementCandidates()
+	if err != nil {
+		return nil, err
+	}
+	hosts := group.selectHomeNodes(tuple.Key, candidates)
+	if len(hosts) == 0 {
+		return nil, errNoContacts
+	}
+
+	group.tuples.Insert(&Tuple{Key: tuple.Key, Hosts: hosts, Meta: tuple.Meta, TTL: tuple.TTL})
+	group.updateTupleCount()
+
+	return hosts, nil
+}
+
+// placementCandidates returns the group members that are not full along
+// with their advertised load
+func (group *affinityGroup) placementCandidates() ([]PlacementCandidate, error) {
+	candidates, full := placementCandidates(group.contacts.List())
+	if len(candidates) > 0 {
+		return candidates, nil
+	}
+	if full {
+		return nil, errNoCapacity
+	}
+	return nil, errNoContacts
+}
+
+// selectHomeNodes returns the home nodes of the key from the candidates
+// spread across zones and racks
+func (group *affinityGroup) selectHomeNodes(key []byte, candidates []PlacementCandidate) []string {
+	if group.replicas == 1 || !spansLocations(candidates) {
+		return group.placement.Select(key, group.replicas, candidates)
+	}
+	ranked := group.placement.Select(key, len(candidates), candidates)
+	return spreadTopology(ranked, group.replicas, candidates)
+}
+
+// addLoad accounts for a tuple homed on the hosts so later selections in the
+// same batch see it
+func addLoad(candidates []PlacementCandidate, hosts []string) []PlacementCandidate {
+	out := candidates[:0]
+	for _, c := range candidates {
+		for _, h := range hosts {
+			if c.Host == h {
+				c.Load.Tuples++
+				break
+			}
+		}
+		if !c.Load.Full() {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+// Delete removes the key from the local tuple store.  Deleting a key that
+// does not exist is not an error
+func (group *affinityGroup) Delete(ctx context.Context, key []byte) error {
+	if c := group.tuples.Delete(key); c > 0 {
+		group.log.Debugf("Deleted group=%d key=%q", group.ID, key)
+		group.updateTupleCount()
+	}
+	return nil
+}
+
+// Renew restarts the lease of the key in the local tuple store
+func (group *affinityGroup) Renew(ctx context.Context, key []byte, ttl time.Duration) error {
+	if ttl < 0 {
+		return errInvalidTupleTTL
+	}
+	if !group.tuples.Renew(key, ttl) {
+		return errTupleNotFound
+	}
+	group.log.Debugf("Renewed group=%d key=%q ttl=%v", group.ID, key, ttl)
+	return nil
+}
+
+// Release removes the host from the home nodes of the key in the local tuple
+// store
+func (group *affinityGroup) Release(ctx context.Context, key []byte, host string) error {
+	if !group.tuples.Release(key, host) {
+		return errTupleNotFound
+	}
+	group.log.Debugf("Released group=%d key=%q host=%s", group.ID, key, host)
+	group.updateTupleCount()
+	return nil
+}
+
+// InsertBatch inserts all tuples that do not exist with a single store
+// insert.  Existing tuples return their current home nodes.  The hosts of
+// the given tuples are ignored and keys repeated in the batch are placed
+// once
+func (group *affinityGroup) InsertBatch(ctx context.Context, tuples []*Tuple) []BatchResult {
+	results := make([]BatchResult, len(tuples))
+	inserts := make([]*Tuple, 0, len(tuples))
+	// Index of the first result of each key
+	seen := make(map[string]int, len(tuples))
+
+	candidates, cerr := group.placementCandidates()
+
+	for i, tuple := range tuples {
+		results[i].Key = tuple.Key
+
+		if j, ok := seen[string(tuple.Key)]; ok {
+			results[i] = results[j].clone()
+			continue
+		}
+		seen[string(tuple.Key)] = i
+
+		if existing := group.tuples.Lookup(tuple.Key); existing != nil {
+			results[i].Tuple = existing
+			continue
+		}
+
+		if err := tuple.validate(); err != nil {
+			results[i].Err = err
+			continue
+		}
+
+		if len(candidates) == 0 {
+			if cerr == nil {
+				cerr = errNoCapacity
+			}
+			results[i].Err = cerr
+			continue
+		}
+		hosts := group.selectHomeNodes(tuple.Key, candidates)
+		if len(hosts) == 0 {
+			results[i].Err = errNoContacts
+			continue
+		}
+		candidates = addLoad(candidates, hosts)
+
+		t := &Tuple{Key: tuple.Key, Hosts: hosts, Meta: tuple.Meta, TTL: tuple.TTL}
+		results[i].Tuple = t.Clone()
+		inserts = append(inserts, t)
+	}
+
+	if len(inserts) > 0 {
+		group.tuples.Insert(inserts...)
+		group.updateTupleCount()
+	}
+
+	return results
+}
+
+// MoveBatch inserts all tuples that do not exist keeping their home nodes
+// with a single store insert.  Existing tuples return their current home
+// nodes
+func (group *affinityGroup) MoveBatch(ctx context.Context, tuples []*Tuple) []BatchResult {
+	results := make([]BatchResult, len(tuples))
+	inserts := make([]*Tuple, 0, len(tuples))
+	// Index of the first result of each key
+	seen := make(map[string]int, len(tuples))
+
+	for i, tuple := range tuples {
+		results[i].Key = tuple.Key
+
+		if j, ok := seen[string(tuple.Key)]; ok {
+			results[i] = results[j].clone()
+			continue
+		}
+		seen[string(tuple.Key)] = i
+
+		if existing := group.tuples.Lookup(tuple.Key); existing != nil {
+			results[i].Tuple = existing
+			continue
+		}
+
+		if len(tuple.Hosts) == 0 {
+			results[i].Err = errTupleNoHosts
+			continue
+		}
+		if err := tuple.validate(); err != nil {
+			results[i].Err = err
+			continue
+		}
+
+		t := tuple.Clone()
+		results[i].Tuple = t.Clone()
+		inserts = append(inserts, t)
+	}
+
+	if len(inserts) > 0 {
+		group.tuples.Insert(inserts...)
+		group.updateTupleCount()
+	}
+
+	return results
+}
+
+// LookupBatch looks up all keys locally forwarding the ones not found to the
+// next closest node in a single request
+func (group *affinityGroup) LookupBatch(ctx context.Context, req *BatchRequest) []BatchResult {
+	results := make([]BatchResult, len(req.Keys))
+	// Indexes of keys not found locally
+	missing := make([]int, 0)
+
+	for i, key := range req.Keys {
+		results[i].Key = key
+		if tuple := group.tuples.Lookup(key); tuple != nil {
+			results[i].Tuple = tuple
+			continue
+		}
+		missing = append(missing, i)
+	}
+
+	if len(missing) == 0 {
+		return results
+	}
+
+	// Check ttl before trying another peer
+	if req.TTL == 0 {
+		for _, i := range missing {
+			results[i].Err = errReqTTLReached
+		}
+		return results
+	}
+
+	nreq := &BatchRequest{
+		Keys:       make([][]byte, 0, len(missing)),
+		TTL:        req.TTL - 1, // Decrement ttl
+		Originator: group.GroupContact,
+	}
+	for _, i := range missing {
+		nreq.Keys = append(nreq.Keys, req.Keys[i])
+	}
+
+	var remote []BatchResult
+	err := tryContacts(ctx, group.contacts.ListClosest(), group.suspects, group.retries, func(p PeerContact) (err error) {
+		c := GroupContact{ID: group.ID, Host: p.Address()}
+		remote, err = group.trans.LookupBatch(ctx, c, nreq)
+		return err
+	})
+
+	mergeBatchResults(results, missing, remote, err)
+
+	return results
 }
 
 type remoteAffinityGroup struct {
@@ -160,18 +470,35 @@ type remoteAffinityGroup struct {
 	// Subset of peers in this affinity group
 	contacts ContactStorage
 
+	// Contacts that recently failed and number of alternates to try
+	suspects *suspectContacts
+	retries  int
+
+	// Interval to probe and refresh contacts if supported by the store
+	probeInterval time.Duration
+	refresh       time.Duration
+
 	// Network transport
 	trans Transport
 
-	log *log.Logger
+	// background go-routines
+	routines routines
+
+	metrics MetricsCollector
+	log     *log.Logger
 }
 
 func newRemoteAffinityGroup(gc *GroupContact, conf *Config) *remoteAffinityGroup {
 	g := &remoteAffinityGroup{
-		GroupContact: *gc,
-		trans:        conf.Transport,
-		contacts:     conf.Contacts.New(gc.ID, false),
-		log:          conf.Logger,
+		GroupContact:  *gc,
+		trans:         conf.Transport,
+		contacts:      conf.Contacts.New(gc.ID, false),
+		suspects:      newSuspectContacts(conf.SuspectTimeout),
+		retries:       conf.ContactRetries,
+		probeInterval: conf.ProbeInterval,
+		refresh:       conf.ContactRefresh,
+		metrics:       conf.Metrics,
+		log:           conf.Logger,
 	}
 	return g
 }
@@ -185,48 +512,315 @@ func (group *remoteAffinityGroup) IsLocal() bool {
 }
 
 func (group *remoteAffinityGroup) beat() {
-	group.heartbeats++
+	atomic.AddInt64(&group.heartbeats, 1)
 }
 
-func (group *remoteAffinityGroup) RemovePeer(host PeerContact) error {
-	return group.contacts.Remove(host)
+func (group *remoteAffinityGroup) RemovePeer(ctx context.Context, host PeerContact) error {
+	err := group.contacts.Remove(host)
+	updateContactCount(group.metrics, group.ID, group.contacts)
+	return err
 }
 
-func (group *remoteAffinityGroup) AddPeer(host PeerContact) error {
-	return group.contacts.Add(host)
+func (group *remoteAffinityGroup) AddPeer(ctx context.Context, host PeerContact) error {
+	err := group.contacts.Add(host)
+	updateContactCount(group.metrics, group.ID, group.contacts)
+	return err
 }
 
-func (group *remoteAffinityGroup) Lookup(req *Request) (string, error) {
-	peer, ok := group.contacts.GetClosest()
-	if !ok {
-		return "", errNoContacts
+func (group *remoteAffinityGroup) Lookup(ctx context.Context, req *Request) (*Tuple, error) {
+	start := time.Now()
+	orig := req.Originator.Host
+	trace := req.Trace
+
+	nreq := *req
+	nreq.Originator = group.GroupContact
+
+	var tuple *Tuple
+	err := tryContacts(ctx, group.contacts.ListClosest(), group.suspects, group.retries, func(peer PeerContact) error {
+		if peer.Address() == orig {
+			group.log.Debugf("Lookup forwarded to originator group=%d originator=%s", group.ID, peer.Address())
+		}
+
+		c := GroupContact{ID: group.ID, Host: peer.Address()}
+		return trace.forward(group.GroupContact, peer.Address(), func(tr *Trace) (err error) {
+			nreq.Trace = tr
+			tuple, err = group.trans.Lookup(ctx, c, &nreq)
+			return err
+		})
+	})
+	switch err {
+	case nil:
+		countLookup(group.metrics, group.ID, TraceForwarded)
+		group.beat()
+	case errNoContacts:
+		trace.add(newTraceHop(group.GroupContact, TraceNoContacts, start))
+		countLookup(group.metrics, group.ID, TraceNoContacts)
+	default:
+		countLookup(group.metrics, group.ID, TraceForwardFailed)
 	}
 
-	if peer.Address() == req.Originator.Host {
-		group.log.Error("TODO: Remote selected=originator", peer.Address())
+	return tuple, err
+}
+
+func (group *remoteAffinityGroup) Insert(ctx context.Context, tuple *Tuple) ([]string, error) {
+	var hosts []string
+	err := tryContacts(ctx, group.contacts.ListClosest(), group.suspects, group.retries, func(peer PeerContact) (err error) {
+		hosts, err = group.trans.Insert(ctx, GroupContact{ID: group.ID, Host: peer.Address()}, tuple)
+		return err
+	})
+	if err == nil {
+		group.beat()
 	}
 
-	req.Originator = group.GroupContact
-	host, err := group.trans.Lookup(GroupContact{ID: group.ID, Host: peer.Address()}, req)
+	return hosts, err
+}
+
+func (group *remoteAffinityGroup) Delete(ctx context.Context, key []byte) error {
+	err := tryContacts(ctx, group.contacts.ListClosest(), group.suspects, group.retries, func(peer PeerContact) error {
+		return group.trans.Delete(ctx, GroupContact{ID: group.ID, Host: peer.Address()}, key)
+	})
+	if err == nil {
+		group.beat()
+	}
+
+	return err
+}
+
+func (group *remoteAffinityGroup) Renew(ctx context.Context, key []byte, ttl time.Duration) error {
+	err := tryContacts(ctx, group.contacts.ListClosest(), group.suspects, group.retries, func(peer PeerContact) error {
+		return group.trans.Renew(ctx, GroupContact{ID: group.ID, Host: peer.Address()}, key, ttl)
+	})
+	if err == nil {
+		group.beat()
+	}
+
+	return err
+}
+
+func (group *remoteAffinityGroup) Release(ctx context.Context, key []byte, host string) error {
+	err := tryContacts(ctx, group.contacts.ListClosest(), group.suspects, group.retries, func(peer PeerContact) error {
+		return group.trans.Release(ctx, GroupContact{ID: group.ID, Host: peer.Address()}, key, host)
+	})
 	if err == nil {
 		group.beat()
 	}
 
-	return host, err
+	return err
 }
 
-func (group *remoteAffinityGroup) Insert(key []byte) (string, error) {
-	peer, ok := group.contacts.GetClosest()
-	if !ok {
-		return "", errNoContacts
+func (group *remoteAffinityGroup) InsertBatch(ctx context.Context, tuples []*Tuple) []BatchResult {
+	var results []BatchResult
+	err := tryContacts(ctx, group.contacts.ListClosest(), group.suspects, group.retries, func(peer PeerContact) (err error) {
+		results, err = group.trans.InsertBatch(ctx, GroupContact{ID: group.ID, Host: peer.Address()}, tuples)
+		return err
+	})
+	if err == nil {
+		group.beat()
 	}
 
-	host, err := group.trans.Insert(GroupContact{ID: group.ID, Host: peer.Address()}, key)
+	out := make([]BatchResult, len(tuples))
+	indexes := make([]int, len(tuples))
+	for i, t := range tuples {
+		out[i].Key = t.Key
+		indexes[i] = i
+	}
+	mergeBatchResults(out, indexes, results, err)
+
+	return out
+}
+
+func (group *remoteAffinityGroup) MoveBatch(ctx context.Context, tuples []*Tuple) []BatchResult {
+	var results []BatchResult
+	err := tryContacts(ctx, group.contacts.ListClosest(), group.suspects, group.retries, func(peer PeerContact) (err error) {
+		results, err = group.trans.MoveBatch(ctx, GroupContact{ID: group.ID, Host: peer.Address()}, tuples)
+		return err
+	})
+	if err == nil {
+		group.beat()
+	}
+
+	out := make([]BatchResult, len(tuples))
+	indexes := make([]int, len(tuples))
+	for i, t := range tuples {
+		out[i].Key = t.Key
+		indexes[i] = i
+	}
+	mergeBatchResults(out, indexes, results, err)
+
+	return out
+}
+
+func (group *remoteAffinityGroup) LookupBatch(ctx context.Context, req *BatchRequest) []BatchResult {
+	req.Originator = group.GroupContact
+
+	var results []BatchResult
+	err := tryContacts(ctx, group.contacts.ListClosest(), group.suspects, group.retries, func(peer PeerContact) (err error) {
+		results, err = group.trans.LookupBatch(ctx, GroupContact{ID: group.ID, Host: peer.Address()}, req)
+		return err
+	})
 	if err == nil {
 		group.beat()
 	}
 
-	return host, err
+	out := make([]BatchResult, len(req.Keys))
+	indexes := make([]int, len(req.Keys))
+	for i, k := range req.Keys {
+		out[i].Key = k
+		indexes[i] = i
+	}
+	mergeBatchResults(out, indexes, results, err)
+
+	return out
+}
+
+func (group *remoteAffinityGroup) Start() {
+	if prober, ok := group.contacts.(contactProber); ok {
+		group.routines.start(func(ctx context.Context) {
+			probeContacts(ctx, prober, group.probeInterval)
+		})
+	}
+	if refresher, ok := group.contacts.(contactRefresher); ok {
+		group.routines.start(func(ctx context.Context) {
+			refreshContacts(ctx, refresher, group.refresh)
+		})
+	}
+}
+
+// Stop stops all go-routines waiting for them to exit or the context to be
+// done
+func (group *remoteAffinityGroup) Stop(ctx context.Context) error {
+	return group.routines.stop(ctx)
+}
+
+// tryContacts calls fn with each peer in order, non-suspects first, until it
+// succeeds or fails with a non-transport error.  At most retries alternate
+// peers are tried after the first.  Peers failing with a transport error are
+// marked suspect
+func tryContacts(ctx context.Context, peers []PeerContact, suspects *suspectContacts, retries int, fn func(PeerContact) error) error {
+	peers = suspects.order(peers)
+	if len(peers) == 0 {
+		return errNoContacts
+	}
+
+	n := 1
+	if retries > 0 {
+		n += retries
+	}
+	if len(peers) > n {
+		peers = peers[:n]
+	}
+
+	var err error
+	for _, p := range peers {
+		if err = fn(p); err == nil {
+			suspects.clear(p.Address())
+			return nil
+		}
+
+		if !isTransportError(ctx, err) {
+			return err
+		}
+		suspects.mark(p.Address())
+	}
+
+	return err
+}
+
+// groupContacts returns the contact store of the group or nil if it is not
+// one of the built-in groups
+func groupContacts(group AffinityGroup) ContactStorage {
+	switch g := group.(type) {
+	case *affinityGroup:
+		return g.contacts
+	case *remoteAffinityGroup:
+		return g.contacts
+	}
+	return nil
+}
+
+// groupSuspects returns the suspect contacts of the group or nil if it is
+// not one of the built-in groups
+func groupSuspects(group AffinityGroup) *suspectContacts {
+	switch g := group.(type) {
+	case *affinityGroup:
+		return g.suspects
+	case *remoteAffinityGroup:
+		return g.suspects
+	}
+	return nil
+}
+
+// routines manages the life of a set of background go-routines
+type routines struct {
+	mu     sync.Mutex
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// start runs fn in a go-routine.  The context passed to fn is done once stop
+// is called
+func (r *routines) start(fn func(ctx context.Context)) {
+	r.mu.Lock()
+	if r.ctx == nil {
+		r.ctx, r.cancel = context.WithCancel(context.Background())
+	}
+	ctx := r.ctx
+	r.wg.Add(1)
+	r.mu.Unlock()
+
+	go func() {
+		defer r.wg.Done()
+		fn(ctx)
+	}()
 }
 
-func (group *remoteAffinityGroup) Start() {}
+// stop signals all go-routines to exit and waits for them to do so or for
+// the context to be done
+func (r *routines) stop(ctx context.Context) error {
+	r.mu.Lock()
+	if r.cancel != nil {
+		r.cancel()
+	}
+	r.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		r.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// countLookup records the decision made by a group handling a lookup
+func countLookup(m MetricsCollector, id int64, decision TraceDecision) {
+	m.IncrCounter(metricLookups, 1, groupLabel(id), Label{Name: "decision", Value: string(decision)})
+}
+
+func updateContactCount(m MetricsCollector, id int64, contacts ContactStorage) {
+	m.SetGauge(metricGroupContacts, float64(len(contacts.List())), groupLabel(id))
+}
+
+// mergeBatchResults sets the remote results at the given indexes of results.
+// If the request failed or returned an unexpected number of results the error
+// is set on all of them
+func mergeBatchResults(results []BatchResult, indexes []int, remote []BatchResult, err error) {
+	if err == nil && len(remote) != len(indexes) {
+		err = fmt.Errorf("batch result count mismatch: %d != %d", len(remote), len(indexes))
+	}
+
+	for j, i := range indexes {
+		if err != nil {
+			results[i].Err = err
+			continue
+		}
+		results[i].Tuple = remote[j].Tuple
+		results[i].Err = remote[j].Err
+	}
+}
@@ -0,0 +1,588 @@
+package kelips
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/euforia/gossip/transport"
+	"google.golang.org/grpc"
+
+	"github.com/euforia/go-kelips/kelipspb"
+)
+
+// GRPCTransport implements a gRPC based Transport interface.  Keys are sent
+// as bytes so they may contain any value
+type GRPCTransport struct {
+	// local advertise host
+	host string
+	// Registered groups.  Groups are registered at runtime when resizing
+	groupsMu sync.RWMutex
+	groups   map[int64]AffinityGroup
+
+	server *grpc.Server
+
+	dialOpts []grpc.DialOption
+
+	mu sync.Mutex
+	// Client connections by host
+	conns map[string]*grpc.ClientConn
+}
+
+// NewGRPCTransport returns a new GRPCTransport.  If enableMagic is true,
+// connections are dialed with the magic number so the transport can be served
+// from a muxed gossip listener
+func NewGRPCTransport(enableMagic bool) *GRPCTransport {
+	trans := &GRPCTransport{
+		groups:   make(map[int64]AffinityGroup),
+		conns:    make(map[string]*grpc.ClientConn),
+		dialOpts: []grpc.DialOption{grpc.WithInsecure()},
+	}
+
+	if enableMagic {
+		dialer := func(addr string, timeout time.Duration) (net.Conn, error) {
+			return transport.DialTimeout(addr, kelipsMagic, timeout)
+		}
+		trans.dialOpts = append(trans.dialOpts, grpc.WithDialer(dialer))
+	}
+
+	return trans
+}
+
+// Start starts serving on the transport in a separate go-routine
+func (trans *GRPCTransport) Start(ln net.Listener) error {
+	trans.server = grpc.NewServer()
+	kelipspb.RegisterKelipsServer(trans.server, &grpcService{trans: trans})
+	go trans.server.Serve(ln)
+
+	return nil
+}
+
+// Shutdown gracefully stops the server and closes all client connections.
+// The server is stopped forcefully if the context is done first
+func (trans *GRPCTransport) Shutdown(ctx context.Context) error {
+	var err error
+	if trans.server != nil {
+		done := make(chan struct{})
+		go func() {
+			trans.server.GracefulStop()
+			close(done)
+		}()
+
+		select {
+		case <-done:
+		case <-ctx.Done():
+			trans.server.Stop()
+			err = ctx.Err()
+		}
+	}
+
+	trans.mu.Lock()
+	for host, conn := range trans.conns {
+		conn.Close()
+		delete(trans.conns, host)
+	}
+	trans.mu.Unlock()
+
+	return err
+}
+
+// Register the affinity group with the transport
+func (trans *GRPCTransport) Register(contact GroupContact, group AffinityGroup) {
+	trans.groupsMu.Lock()
+	trans.groups[contact.ID] = group
+	trans.groupsMu.Unlock()
+
+	// All registrations will be the local node
+	if trans.host != contact.Host {
+		trans.host = contact.Host
+	}
+}
+
+// Unregister removes the affinity group from the transport
+func (trans *GRPCTransport) Unregister(contact GroupContact) {
+	trans.groupsMu.Lock()
+	delete(trans.groups, contact.ID)
+	trans.groupsMu.Unlock()
+}
+
+// client returns a client for the host, dialing a new connection if needed
+func (trans *GRPCTransport) client(host string) (kelipspb.KelipsClient, error) {
+	trans.mu.Lock()
+	defer trans.mu.Unlock()
+
+	conn, ok := trans.conns[host]
+	if !ok {
+		var err error
+		conn, err = grpc.Dial(host, trans.dialOpts...)
+		if err != nil {
+			return nil, err
+		}
+		trans.conns[host] = conn
+	}
+
+	return kelipspb.NewKelipsClient(conn), nil
+}
+
+// Insert tuple at remote group
+func (trans *GRPCTransport) Insert(ctx context.Context, contact GroupContact, tuple *Tuple) ([]string, error) {
+	client, err := trans.client(contact.Host)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := requestContext(ctx)
+	defer cancel()
+
+	resp, err := client.Insert(ctx, &kelipspb.InsertRequest{
+		Group: contact.ID,
+		Tuple: tupleToPB(tuple),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if resp.Error != nil {
+		return nil, errorFromPB(resp.Error)
+	}
+
+	return resp.Hosts, nil
+}
+
+// Lookup should return the tuple with the home nodes of the key
+func (trans *GRPCTransport) Lookup(ctx context.Context, contact GroupContact, req *Request) (*Tuple, error) {
+	client, err := trans.client(contact.Host)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := requestContext(ctx)
+	defer cancel()
+
+	resp, err := client.Lookup(ctx, &kelipspb.LookupRequest{
+		Group:      contact.ID,
+		Key:        req.Key,
+		Ttl:        int32(req.TTL),
+		Originator: groupContactToPB(req.Originator),
+		Trace:      req.Trace != nil,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// The remote hops are returned on failure as well
+	if req.Trace != nil {
+		for _, hop := range resp.Trace {
+			req.Trace.add(traceHopFromPB(hop))
+		}
+	}
+
+	if resp.Error != nil {
+		return nil, errorFromPB(resp.Error)
+	}
+
+	return tupleFromPB(resp.Tuple), nil
+}
+
+// Delete makes a remote request to delete the key from the group
+func (trans *GRPCTransport) Delete(ctx context.Context, contact GroupContact, key []byte) error {
+	client, err := trans.client(contact.Host)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := requestContext(ctx)
+	defer cancel()
+
+	resp, err := client.Delete(ctx, &kelipspb.DeleteRequest{Group: contact.ID, Key: key})
+	if err != nil {
+		return err
+	}
+	return errorFromPB(resp.Error)
+}
+
+// Renew makes a remote request to renew the lease of the key
+func (trans *GRPCTransport) Renew(ctx context.Context, contact GroupContact, key []byte, ttl time.Duration) error {
+	client, err := trans.client(contact.Host)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := requestContext(ctx)
+	defer cancel()
+
+	resp, err := client.Renew(ctx, &kelipspb.RenewRequest{Group: contact.ID, Key: key, Ttl: int64(ttl)})
+	if err != nil {
+		return err
+	}
+	return errorFromPB(resp.Error)
+}
+
+// Release makes a remote request to remove the host from the home nodes of
+// the key
+func (trans *GRPCTransport) Release(ctx context.Context, contact GroupContact, key []byte, host string) error {
+	client, err := trans.client(contact.Host)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := requestContext(ctx)
+	defer cancel()
+
+	resp, err := client.Release(ctx, &kelipspb.ReleaseRequest{Group: contact.ID, Key: key, Host: host})
+	if err != nil {
+		return err
+	}
+	return errorFromPB(resp.Error)
+}
+
+// InsertBatch inserts all tuples at the remote group in a single request
+func (trans *GRPCTransport) InsertBatch(ctx context.Context, contact GroupContact, tuples []*Tuple) ([]BatchResult, error) {
+	client, err := trans.client(contact.Host)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := requestContext(ctx)
+	defer cancel()
+
+	req := &kelipspb.InsertBatchRequest{
+		Group:  contact.ID,
+		Tuples: make([]*kelipspb.Tuple, 0, len(tuples)),
+	}
+	for _, t := range tuples {
+		pt := tupleToPB(t)
+		pt.Hosts = nil
+		req.Tuples = append(req.Tuples, pt)
+	}
+
+	resp, err := client.InsertBatch(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return batchResultsFromPB(resp)
+}
+
+// MoveBatch inserts all tuples keeping their hosts at the remote group in a
+// single request
+func (trans *GRPCTransport) MoveBatch(ctx context.Context, contact GroupContact, tuples []*Tuple) ([]BatchResult, error) {
+	client, err := trans.client(contact.Host)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := requestContext(ctx)
+	defer cancel()
+
+	req := &kelipspb.InsertBatchRequest{
+		Group:  contact.ID,
+		Tuples: make([]*kelipspb.Tuple, 0, len(tuples)),
+	}
+	for _, t := range tuples {
+		req.Tuples = append(req.Tuples, tupleToPB(t))
+	}
+
+	resp, err := client.MoveBatch(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return batchResultsFromPB(resp)
+}
+
+// LookupBatch looks up all keys at the remote group in a single request
+func (trans *GRPCTransport) LookupBatch(ctx context.Context, contact GroupContact, req *BatchRequest) ([]BatchResult, error) {
+	client, err := trans.client(contact.Host)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := requestContext(ctx)
+	defer cancel()
+
+	resp, err := client.LookupBatch(ctx, &kelipspb.LookupBatchRequest{
+		Group:      contact.ID,
+		Keys:       req.Keys,
+		Ttl:        int32(req.TTL),
+		Originator: groupContactToPB(req.Originator),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return batchResultsFromPB(resp)
+}
+
+// AddPeer makes a remote request to add a peer to a group
+func (trans *GRPCTransport) AddPeer(ctx context.Context, contact GroupContact, peer PeerContact) error {
+	client, err := trans.client(contact.Host)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := requestContext(ctx)
+	defer cancel()
+
+	resp, err := client.AddPeer(ctx, &kelipspb.AddPeerRequest{
+		Group: contact.ID,
+		Host:  peer.Address(),
+	})
+	if err != nil {
+		return err
+	}
+	return errorFromPB(resp.Error)
+}
+
+// Ping makes a round trip request to the host
+func (trans *GRPCTransport) Ping(ctx context.Context, host string) error {
+	client, err := trans.client(host)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := requestContext(ctx)
+	defer cancel()
+
+	_, err = client.Ping(ctx, &kelipspb.PingRequest{})
+	return err
+}
+
+// grpcService serves the registered groups of a GRPCTransport.  Errors from
+// the groups are returned in the response so gRPC errors are always
+// transport errors
+type grpcService struct {
+	trans *GRPCTransport
+}
+
+func (svc *grpcService) getGroup(id int64) (AffinityGroup, *kelipspb.Error) {
+	svc.trans.groupsMu.RLock()
+	group, ok := svc.trans.groups[id]
+	svc.trans.groupsMu.RUnlock()
+	if !ok {
+		return nil, &kelipspb.Error{
+			Code:    kelipspb.ErrorCode_GROUP_NOT_FOUND,
+			Message: "group not found",
+		}
+	}
+	return group, nil
+}
+
+func (svc *grpcService) Insert(ctx context.Context, req *kelipspb.InsertRequest) (*kelipspb.InsertResponse, error) {
+	group, perr := svc.getGroup(req.Group)
+	if perr != nil {
+		return &kelipspb.InsertResponse{Error: perr}, nil
+	}
+
+	hosts, err := group.Insert(ctx, tupleFromPB(req.Tuple))
+	return &kelipspb.InsertResponse{Hosts: hosts, Error: errorToPB(err)}, nil
+}
+
+func (svc *grpcService) Lookup(ctx context.Context, req *kelipspb.LookupRequest) (*kelipspb.LookupResponse, error) {
+	group, perr := svc.getGroup(req.Group)
+	if perr != nil {
+		return &kelipspb.LookupResponse{Error: perr}, nil
+	}
+
+	r := &Request{
+		Key:        req.Key,
+		TTL:        int(req.Ttl),
+		Originator: groupContactFromPB(req.Originator),
+	}
+	if req.Trace {
+		r.Trace = &Trace{}
+	}
+
+	tuple, err := group.Lookup(ctx, r)
+
+	resp := &kelipspb.LookupResponse{Error: errorToPB(err)}
+	if err == nil {
+		resp.Tuple = tupleToPB(tuple)
+	}
+	if r.Trace != nil {
+		for _, hop := range r.Trace.Hops {
+			resp.Trace = append(resp.Trace, traceHopToPB(hop))
+		}
+	}
+
+	return resp, nil
+}
+
+func (svc *grpcService) Delete(ctx context.Context, req *kelipspb.DeleteRequest) (*kelipspb.DeleteResponse, error) {
+	group, perr := svc.getGroup(req.Group)
+	if perr != nil {
+		return &kelipspb.DeleteResponse{Error: perr}, nil
+	}
+
+	err := group.Delete(ctx, req.Key)
+	return &kelipspb.DeleteResponse{Error: errorToPB(err)}, nil
+}
+
+func (svc *grpcService) Renew(ctx context.Context, req *kelipspb.RenewRequest) (*kelipspb.RenewResponse, error) {
+	group, perr := svc.getGroup(req.Group)
+	if perr != nil {
+		return &kelipspb.RenewResponse{Error: perr}, nil
+	}
+
+	err := group.Renew(ctx, req.Key, time.Duration(req.Ttl))
+	return &kelipspb.RenewResponse{Error: errorToPB(err)}, nil
+}
+
+func (svc *grpcService) Release(ctx context.Context, req *kelipspb.ReleaseRequest) (*kelipspb.ReleaseResponse, error) {
+	group, perr := svc.getGroup(req.Group)
+	if perr != nil {
+		return &kelipspb.ReleaseResponse{Error: perr}, nil
+	}
+
+	err := group.Release(ctx, req.Key, req.Host)
+	return &kelipspb.ReleaseResponse{Error: errorToPB(err)}, nil
+}
+
+func (svc *grpcService) AddPeer(ctx context.Context, req *kelipspb.AddPeerRequest) (*kelipspb.AddPeerResponse, error) {
+	group, perr := svc.getGroup(req.Group)
+	if perr != nil {
+		return &kelipspb.AddPeerResponse{Error: perr}, nil
+	}
+
+	err := group.AddPeer(ctx, &Peer{Host: req.Host})
+	return &kelipspb.AddPeerResponse{Error: errorToPB(err)}, nil
+}
+
+func (svc *grpcService) Ping(ctx context.Context, req *kelipspb.PingRequest) (*kelipspb.PingResponse, error) {
+	return &kelipspb.PingResponse{}, nil
+}
+
+func (svc *grpcService) InsertBatch(ctx context.Context, req *kelipspb.InsertBatchRequest) (*kelipspb.BatchResponse, error) {
+	group, perr := svc.getGroup(req.Group)
+	if perr != nil {
+		return &kelipspb.BatchResponse{Error: perr}, nil
+	}
+
+	tuples := make([]*Tuple, 0, len(req.Tuples))
+	for _, t := range req.Tuples {
+		tuple := tupleFromPB(t)
+		tuple.Hosts = nil
+		tuples = append(tuples, tuple)
+	}
+
+	return batchResultsToPB(group.InsertBatch(ctx, tuples)), nil
+}
+
+func (svc *grpcService) MoveBatch(ctx context.Context, req *kelipspb.InsertBatchRequest) (*kelipspb.BatchResponse, error) {
+	group, perr := svc.getGroup(req.Group)
+	if perr != nil {
+		return &kelipspb.BatchResponse{Error: perr}, nil
+	}
+
+	tuples := make([]*Tuple, 0, len(req.Tuples))
+	for _, t := range req.Tuples {
+		tuples = append(tuples, tupleFromPB(t))
+	}
+
+	return batchResultsToPB(group.MoveBatch(ctx, tuples)), nil
+}
+
+func (svc *grpcService) LookupBatch(ctx context.Context, req *kelipspb.LookupBatchRequest) (*kelipspb.BatchResponse, error) {
+	group, perr := svc.getGroup(req.Group)
+	if perr != nil {
+		return &kelipspb.BatchResponse{Error: perr}, nil
+	}
+
+	results := group.LookupBatch(ctx, &BatchRequest{
+		Keys:       req.Keys,
+		TTL:        int(req.Ttl),
+		Originator: groupContactFromPB(req.Originator),
+	})
+	return batchResultsToPB(results), nil
+}
+
+func tupleToPB(t *Tuple) *kelipspb.Tuple {
+	if t == nil {
+		return nil
+	}
+	return &kelipspb.Tuple{Key: t.Key, Hosts: t.Hosts, Meta: t.Meta, Ttl: int64(t.TTL)}
+}
+
+func tupleFromPB(t *kelipspb.Tuple) *Tuple {
+	if t == nil {
+		return &Tuple{}
+	}
+	return &Tuple{Key: t.Key, Hosts: t.Hosts, Meta: t.Meta, TTL: time.Duration(t.Ttl)}
+}
+
+func traceHopToPB(hop TraceHop) *kelipspb.TraceHop {
+	return &kelipspb.TraceHop{
+		Host:     hop.Host,
+		Group:    hop.Group,
+		Decision: string(hop.Decision),
+		To:       hop.To,
+		Latency:  int64(hop.Latency),
+		Error:    hop.Error,
+	}
+}
+
+func traceHopFromPB(hop *kelipspb.TraceHop) TraceHop {
+	return TraceHop{
+		Host:     hop.Host,
+		Group:    hop.Group,
+		Decision: TraceDecision(hop.Decision),
+		To:       hop.To,
+		Latency:  time.Duration(hop.Latency),
+		Error:    hop.Error,
+	}
+}
+
+func groupContactToPB(gc GroupContact) *kelipspb.GroupContact {
+	return &kelipspb.GroupContact{Id: gc.ID, Host: gc.Host}
+}
+
+func groupContactFromPB(gc *kelipspb.GroupContact) GroupContact {
+	return GroupContact{ID: gc.GetId(), Host: gc.GetHost()}
+}
+
+// errorToPB returns the protobuf error with its code or nil if err is nil.
+// The ErrorCode values match the protobuf enum
+func errorToPB(err error) *kelipspb.Error {
+	if err == nil {
+		return nil
+	}
+	return &kelipspb.Error{
+		Code:    kelipspb.ErrorCode(errorCode(err)),
+		Message: err.Error(),
+	}
+}
+
+// errorFromPB returns a RemoteError for the protobuf error or nil
+func errorFromPB(e *kelipspb.Error) error {
+	if e == nil {
+		return nil
+	}
+	return &RemoteError{Code: ErrorCode(e.Code), Message: e.Message}
+}
+
+func batchResultsToPB(results []BatchResult) *kelipspb.BatchResponse {
+	resp := &kelipspb.BatchResponse{
+		Results: make([]*kelipspb.BatchResult, 0, len(results)),
+	}
+	for _, res := range results {
+		resp.Results = append(resp.Results, &kelipspb.BatchResult{
+			Key:   res.Key,
+			Tuple: tupleToPB(res.Tuple),
+			Error: errorToPB(res.Err),
+		})
+	}
+	return resp
+}
+
+func batchResultsFromPB(resp *kelipspb.BatchResponse) ([]BatchResult, error) {
+	if resp.Error != nil {
+		return nil, errorFromPB(resp.Error)
+	}
+
+	results := make([]BatchResult, 0, len(resp.Results))
+	for _, res := range resp.Results {
+		br := BatchResult{Key: res.Key, Err: errorFromPB(res.Error)}
+		if br.Err == nil {
+			br.Tuple = tupleFromPB(res.Tuple)
+		}
+		results = append(results, br)
+	}
+	return results, nil
+}
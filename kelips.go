@@ -5,8 +5,12 @@ import (
 	"context"
 	"fmt"
 	"hash"
+	"io"
 	"net"
+	"sync"
+	"time"
 
+	"github.com/hexablock/log"
 	"github.com/pkg/errors"
 )
 
@@ -15,6 +19,29 @@ type Request struct {
 	Key        []byte       // Key to lookup or insert
 	TTL        int          // number of hops
 	Originator GroupContact // Group originating the request
+	Trace      *Trace       // Records the lookup path if non-nil
+}
+
+// BatchRequest is a lookup request for multiple keys
+type BatchRequest struct {
+	Keys       [][]byte     // Keys to lookup
+	TTL        int          // number of hops
+	Originator GroupContact // Group originating the request
+}
+
+// BatchResult is the result for a single key of a batch operation.  Tuple is
+// set on success and contains the home nodes of the key
+type BatchResult struct {
+	Key   []byte
+	Tuple *Tuple
+	Err   error
+}
+
+func (res BatchResult) clone() BatchResult {
+	if res.Tuple != nil {
+		res.Tuple = res.Tuple.Clone()
+	}
+	return res
 }
 
 // AffinityGroup implements a kelips affinity group
@@ -23,120 +50,563 @@ type AffinityGroup interface {
 	IsLocal() bool
 	// Contact returns this nodes group contact information
 	Contact() GroupContact
-	// Insert a key into the affinity group returning the homenode
-	Insert(key []byte) (string, error)
-	// Lookup a key returning the homenode
-	Lookup(*Request) (string, error)
+	// Insert a tuple into the affinity group returning the homenodes. The
+	// hosts of the given tuple are ignored
+	Insert(ctx context.Context, tuple *Tuple) ([]string, error)
+	// Lookup a key returning the tuple with its homenodes
+	Lookup(ctx context.Context, req *Request) (*Tuple, error)
+	// Delete a key from the affinity group
+	Delete(ctx context.Context, key []byte) error
+	// Renew restarts the lease of the key setting a new TTL if ttl is
+	// non-zero
+	Renew(ctx context.Context, key []byte, ttl time.Duration) error
+	// Release removes the host from the home nodes of the key
+	Release(ctx context.Context, key []byte, host string) error
+	// InsertBatch inserts all tuples returning a result per tuple in the
+	// same order.  The hosts of the given tuples are ignored
+	InsertBatch(ctx context.Context, tuples []*Tuple) []BatchResult
+	// MoveBatch inserts all tuples moved from another group by a resize
+	// keeping their hosts.  It returns a result per tuple in the same order
+	MoveBatch(ctx context.Context, tuples []*Tuple) []BatchResult
+	// LookupBatch looks up all keys returning a result per key in the same
+	// order
+	LookupBatch(ctx context.Context, req *BatchRequest) []BatchResult
 	// Add a peer to the group
-	AddPeer(peer PeerContact) error
+	AddPeer(ctx context.Context, peer PeerContact) error
 	// Remove a peer from the group
-	RemovePeer(peer PeerContact) error
+	RemovePeer(ctx context.Context, peer PeerContact) error
 	// Starts all go-routines for the group
 	Start()
+	// Stop all go-routines for the group waiting for them to exit or the
+	// context to be done
+	Stop(ctx context.Context) error
 }
 
 // Transport implements a kelips transport interface
 type Transport interface {
-	Insert(contact GroupContact, key []byte) (string, error)
-	// Lookup should return the home node of the key
-	Lookup(contact GroupContact, req *Request) (string, error)
+	// Insert should insert the tuple in the group returning the home nodes.
+	// The context bounds the life of the request
+	Insert(ctx context.Context, contact GroupContact, tuple *Tuple) ([]string, error)
+	// Lookup should return the tuple for the key including its home nodes
+	Lookup(ctx context.Context, contact GroupContact, req *Request) (*Tuple, error)
+	// Delete should remove the key from the group
+	Delete(ctx context.Context, contact GroupContact, key []byte) error
+	// Renew should restart the lease of the key in the group
+	Renew(ctx context.Context, contact GroupContact, key []byte, ttl time.Duration) error
+	// Release should remove the host from the home nodes of the key in the
+	// group
+	Release(ctx context.Context, contact GroupContact, key []byte, host string) error
+	// InsertBatch should insert all tuples in a single request returning a
+	// result per tuple.  An error is returned only if the request failed
+	InsertBatch(ctx context.Context, contact GroupContact, tuples []*Tuple) ([]BatchResult, error)
+	// MoveBatch should insert all tuples moved by a resize keeping their
+	// hosts in a single request returning a result per tuple.  An error is
+	// returned only if the request failed
+	MoveBatch(ctx context.Context, contact GroupContact, tuples []*Tuple) ([]BatchResult, error)
+	// LookupBatch should lookup all keys in a single request returning a
+	// result per key.  An error is returned only if the request failed
+	LookupBatch(ctx context.Context, contact GroupContact, req *BatchRequest) ([]BatchResult, error)
 	// Add a peer to the group
-	AddPeer(contact GroupContact, host PeerContact) error
+	AddPeer(ctx context.Context, contact GroupContact, host PeerContact) error
+	// Ping should make a round trip to the host.  It is used to measure the
+	// latency to a peer
+	Ping(ctx context.Context, host string) error
 	// Registers the affinity group with the transport
 	Register(contact GroupContact, group AffinityGroup)
+	// Unregister removes the affinity group registered for the contact i.e.
+	// the home group of superseded groups when resizing
+	Unregister(contact GroupContact)
 	// Start the transport.  This should be non-blocking
 	Start(net.Listener) error
 	// Shutdown the transport
 	Shutdown(ctx context.Context) error
 }
 
+// RemoteError is returned by a Transport when the remote peer was reached
+// but failed to serve the request.  Requests failing with any other error
+// are retried against alternate contacts
+type RemoteError struct {
+	Code    ErrorCode
+	Message string
+}
+
+func (e *RemoteError) Error() string {
+	return e.Message
+}
+
+// ErrorCode classifies a RemoteError.  Transports that cannot carry a code
+// return ErrCodeUnknown
+type ErrorCode int32
+
+const (
+	// ErrCodeUnknown is an unclassified error
+	ErrCodeUnknown ErrorCode = iota
+	// ErrCodeTTLReached is returned when a lookup ran out of hops
+	ErrCodeTTLReached
+	// ErrCodeNoContacts is returned when a group has no contacts to serve
+	// the request
+	ErrCodeNoContacts
+	// ErrCodeInvalid is returned for invalid requests i.e. oversized
+	// metadata
+	ErrCodeInvalid
+	// ErrCodeGroupNotFound is returned when the group is not registered
+	// with the remote transport
+	ErrCodeGroupNotFound
+	// ErrCodeNotFound is returned when renewing or releasing a key that
+	// does not exist
+	ErrCodeNotFound
+)
+
+// errorCode returns the code for the error
+func errorCode(err error) ErrorCode {
+	switch cause := errors.Cause(err).(type) {
+	case *RemoteError:
+		return cause.Code
+	}
+
+	switch errors.Cause(err) {
+	case errReqTTLReached:
+		return ErrCodeTTLReached
+	case errNoContacts:
+		return ErrCodeNoContacts
+	case errTupleMetaTooLarge, errInvalidTupleTTL, errTupleNoHosts:
+		return ErrCodeInvalid
+	case errTupleNotFound:
+		return ErrCodeNotFound
+	}
+	return ErrCodeUnknown
+}
+
+// isTransportError returns true if the error was due to the transport rather
+// than the remote peer or the context
+func isTransportError(ctx context.Context, err error) bool {
+	if err == nil || ctx.Err() != nil {
+		return false
+	}
+	_, ok := errors.Cause(err).(*RemoteError)
+	return !ok
+}
+
+// InsertOption sets optional tuple fields on insert
+type InsertOption func(*Tuple)
+
+// WithMeta sets the metadata stored with the tuple.  The total size of all
+// keys and values is limited to MaxTupleMetaSize
+func WithMeta(meta map[string]string) InsertOption {
+	return func(t *Tuple) {
+		t.Meta = meta
+	}
+}
+
+// WithTTL leases the tuple for the given duration.  The lease must be renewed
+// by a home node with Renew before it runs out or the tuple expires
+// regardless of heartbeats.  Without it the tuple uses Config.TupleTTL
+func WithTTL(ttl time.Duration) InsertOption {
+	return func(t *Tuple) {
+		t.TTL = ttl
+	}
+}
+
 // Kelips is the user interface to interact with the kelips DHT
 type Kelips struct {
-	// home group id
-	id int64
-	// total number of affinity groups
-	k int64
+	// current affinity groups.  Replaced when a resize completes
+	ring
 	// hash function
 	hasher func() hash.Hash
-	// list of groups objects
-	groups []AffinityGroup
 	// kelips transport
 	trans Transport
+	// metrics sink
+	metrics MetricsCollector
+
+	// local host and config used to create groups when resizing
+	host string
+	conf *Config
+
+	// guards the ring and resize state
+	mu sync.RWMutex
+	// epoch of the current ring.  Incremented by each resize
+	epoch uint64
+	// resize in progress if any
+	resize *resizeState
+	// spreads resize messages.  Resizes are local if nil
+	notifier resizeNotifier
+	// set once started
+	running bool
+
+	// background go-routines
+	routines routines
+
+	log *log.Logger
+
+	// called in order on shutdown before the transport and groups are
+	// stopped e.g. to leave gossip pools
+	shutdownHooks []func(context.Context) error
 }
 
 // New returns a new Kelips instance based on the advertisable address and
-// config. advAddr is the address others will use to connect to this node
+// config. advAddr is the address others will use to connect to this node.
+// Unset config values are set to their defaults.  Call Config.Validate
+// first to check the config
 func New(host string, conf *Config) *Kelips {
-	conf.Validate()
+	conf.setDefaults()
+
+	// Components created before the config record to the same collector
+	if ms, ok := conf.Transport.(metricsSetter); ok {
+		ms.setMetrics(conf.Metrics)
+	}
+	if ms, ok := conf.Tuples.(metricsSetter); ok {
+		ms.setMetrics(conf.Metrics)
+	}
 
 	// Set default contact store
 	if conf.Contacts == nil {
-		conf.Contacts = &inmemContactsFac{host: host}
+		conf.Contacts = &inmemContactsFac{
+			host:    host,
+			zone:    conf.Zone,
+			trans:   conf.Transport,
+			max:     conf.MaxContacts,
+			refresh: conf.ContactRefresh,
+		}
 	}
 
 	k := &Kelips{
-		hasher: conf.HashFunc,
-		k:      conf.K,
-		groups: make([]AffinityGroup, conf.K),
-		trans:  conf.Transport,
+		hasher:  conf.HashFunc,
+		trans:   conf.Transport,
+		metrics: conf.Metrics,
+		host:    host,
+		conf:    conf,
+		log:     conf.Logger,
 	}
+	k.ring = *k.newRing(conf.K)
 
-	// Set affinity group
-	k.id = lookupGroup([]byte(host), k.k, k.hasher())
+	return k
+}
 
-	for i := int64(0); i < conf.K; i++ {
-		gc := &GroupContact{ID: i, Host: host}
-		if k.id == i {
-			k.groups[i] = newAffinityGroup(gc, conf)
+// newRing returns new affinity groups for k groups with this node as the
+// only contact
+func (klp *Kelips) newRing(k int64) *ring {
+	r := &ring{
+		k:      k,
+		groups: make([]AffinityGroup, k),
+		mapper: klp.conf.Mapper,
+		hasher: klp.hasher,
+	}
+	r.id, _ = r.nodeGroup(klp.host)
+
+	for i := int64(0); i < k; i++ {
+		gc := &GroupContact{ID: i, Host: klp.host}
+		if r.id == i {
+			r.groups[i] = newAffinityGroup(gc, klp.conf)
 		} else {
-			k.groups[i] = newRemoteAffinityGroup(gc, conf)
+			r.groups[i] = newRemoteAffinityGroup(gc, klp.conf)
 		}
 	}
 
-	k.groups[k.id].AddPeer(&Peer{Host: host})
+	self := &Peer{Host: klp.host, Zone: klp.conf.Zone, Rack: klp.conf.Rack}
+	r.groups[r.id].AddPeer(context.Background(), self)
 
-	return k
+	return r
+}
+
+// addPeer adds the peer to the group it belongs to
+func (r *ring) addPeer(ctx context.Context, host PeerContact) (int64, error) {
+	idx, group := r.nodeGroup(host.Address())
+	return idx, group.AddPeer(ctx, host)
+}
+
+// removePeer removes the peer from the group it belongs to
+func (r *ring) removePeer(ctx context.Context, host PeerContact) (int64, error) {
+	idx, group := r.nodeGroup(host.Address())
+	return idx, group.RemovePeer(ctx, host)
+}
+
+// localGroup returns the home affinity group
+func (klp *Kelips) localGroup() *affinityGroup {
+	current, _ := klp.rings()
+	return current.groups[current.id].(*affinityGroup)
 }
 
 // RemovePeer removes a peer from a group
 func (klp *Kelips) RemovePeer(host PeerContact) (int64, error) {
-	key := []byte(host.Address())
-
-	idx := lookupGroup(key, klp.k, klp.hasher())
-	group := klp.groups[idx]
+	return klp.RemovePeerContext(context.Background(), host)
+}
 
-	return idx, group.RemovePeer(host)
+// RemovePeerContext removes a peer from a group using the given context
+func (klp *Kelips) RemovePeerContext(ctx context.Context, host PeerContact) (int64, error) {
+	current, next := klp.rings()
+	if next != nil {
+		next.removePeer(ctx, host)
+	}
+	return current.removePeer(ctx, host)
 }
 
 // AddPeer adds the peer as a contact to the affinity group it belongs to
 func (klp *Kelips) AddPeer(host PeerContact) (int64, error) {
-	key := []byte(host.Address())
+	return klp.AddPeerContext(context.Background(), host)
+}
 
-	idx := lookupGroup(key, klp.k, klp.hasher())
-	group := klp.groups[idx]
+// AddPeerContext adds the peer as a contact to the affinity group it belongs
+// to using the given context
+func (klp *Kelips) AddPeerContext(ctx context.Context, host PeerContact) (int64, error) {
+	current, next := klp.rings()
+	if next != nil {
+		next.addPeer(ctx, host)
+	}
+	return current.addPeer(ctx, host)
+}
+
+// Insert inserts the key into the DHT returning the home nodes
+func (klp *Kelips) Insert(key []byte, opts ...InsertOption) ([]string, error) {
+	return klp.InsertContext(context.Background(), key, opts...)
+}
+
+// InsertContext inserts the key into the DHT.  The context bounds the
+// request across all hops
+func (klp *Kelips) InsertContext(ctx context.Context, key []byte, opts ...InsertOption) ([]string, error) {
+	tuple := &Tuple{Key: key}
+	for _, opt := range opts {
+		opt(tuple)
+	}
+	if err := tuple.validate(); err != nil {
+		return nil, err
+	}
+
+	// New tuples go to the next groups while resizing falling back to the
+	// current ones
+	var (
+		hosts []string
+		err   error
+	)
+	start := time.Now()
+	for _, rt := range klp.routes(key) {
+		if hosts, err = rt.group.Insert(ctx, tuple); err == nil {
+			break
+		}
+		err = errors.Wrap(err, fmt.Sprintf("group %d", rt.idx))
+	}
+	klp.observe("insert", start, err)
+	if err != nil {
+		return nil, err
+	}
+	return hosts, nil
+}
 
-	return idx, group.AddPeer(host)
+// Lookup returns the tuple for the given key.  Its hosts are the home nodes
+// i.e. replicas for the key
+func (klp *Kelips) Lookup(req *Request) (*Tuple, error) {
+	return klp.LookupContext(context.Background(), req)
 }
 
-// Insert inserts the key into the DHT
-func (klp *Kelips) Insert(key []byte) (string, error) {
-	idx := lookupGroup(key, klp.k, klp.hasher())
-	group := klp.groups[idx]
+// LookupContext returns known peers for the given key.  The context bounds
+// the request across all hops.  While resizing the key is looked up in the
+// next groups followed by the current ones
+func (klp *Kelips) LookupContext(ctx context.Context, req *Request) (*Tuple, error) {
+	start := time.Now()
 
-	host, err := group.Insert(key)
-	if err == nil {
-		return host, nil
+	var (
+		tuple *Tuple
+		err   error
+	)
+	for _, rt := range klp.routes(req.Key) {
+		if tuple, err = rt.group.Lookup(ctx, req); err == nil {
+			break
+		}
 	}
+	klp.observe("lookup", start, err)
 
-	return "", errors.Wrap(err, fmt.Sprintf("group %d", idx))
+	return tuple, err
 }
 
-// Lookup returns known peers for the given key
-func (klp *Kelips) Lookup(req *Request) (string, error) {
-	idx := lookupGroup(req.Key, klp.k, klp.hasher())
-	group := klp.groups[idx]
+// Delete removes the key from the DHT
+func (klp *Kelips) Delete(key []byte) error {
+	return klp.DeleteContext(context.Background(), key)
+}
+
+// DeleteContext removes the key from the DHT.  The context bounds the
+// request across all hops
+func (klp *Kelips) DeleteContext(ctx context.Context, key []byte) error {
+	start := time.Now()
+	err := klp.each(key, func(group AffinityGroup) error {
+		return group.Delete(ctx, key)
+	})
+	klp.observe("delete", start, err)
 
-	return group.Lookup(req)
+	return err
+}
+
+// Renew restarts the lease of the key.  A non-zero ttl replaces the lease
+// duration
+func (klp *Kelips) Renew(key []byte, ttl time.Duration) error {
+	return klp.RenewContext(context.Background(), key, ttl)
+}
+
+// RenewContext restarts the lease of the key.  The context bounds the
+// request across all hops
+func (klp *Kelips) RenewContext(ctx context.Context, key []byte, ttl time.Duration) error {
+	if ttl < 0 {
+		return errInvalidTupleTTL
+	}
+
+	start := time.Now()
+	err := klp.each(key, func(group AffinityGroup) error {
+		return group.Renew(ctx, key, ttl)
+	})
+	klp.observe("renew", start, err)
+
+	return err
+}
+
+// Release gives up the lease held by the home node host on the key.  The
+// tuple is removed once no home nodes are left
+func (klp *Kelips) Release(key []byte, host string) error {
+	return klp.ReleaseContext(context.Background(), key, host)
+}
+
+// ReleaseContext gives up the lease held by the host on the key.  The
+// context bounds the request across all hops
+func (klp *Kelips) ReleaseContext(ctx context.Context, key []byte, host string) error {
+	start := time.Now()
+	err := klp.each(key, func(group AffinityGroup) error {
+		return group.Release(ctx, key, host)
+	})
+	klp.observe("release", start, err)
+
+	return err
+}
+
+// each calls fn with every group of the key returning nil if any call
+// succeeded and the first error otherwise.  There is more than one group
+// only while resizing
+func (klp *Kelips) each(key []byte, fn func(AffinityGroup) error) error {
+	var (
+		ok  bool
+		err error
+	)
+	for _, rt := range klp.routes(key) {
+		er := fn(rt.group)
+		if er == nil {
+			ok = true
+		} else if err == nil {
+			err = errors.Wrap(er, fmt.Sprintf("group %d", rt.idx))
+		}
+	}
+
+	if ok {
+		return nil
+	}
+	return err
+}
+
+// InsertBatch inserts all keys into the DHT returning a result per key in
+// the same order
+func (klp *Kelips) InsertBatch(keys [][]byte) []BatchResult {
+	return klp.InsertBatchContext(context.Background(), keys)
+}
+
+// InsertBatchContext inserts all keys into the DHT with a single request per
+// affinity group.  The context bounds all requests
+func (klp *Kelips) InsertBatchContext(ctx context.Context, keys [][]byte) []BatchResult {
+	current, next := klp.rings()
+	if next != nil {
+		current = next
+	}
+
+	return klp.batch(current, keys, func(group AffinityGroup, keys [][]byte) []BatchResult {
+		tuples := make([]*Tuple, 0, len(keys))
+		for _, key := range keys {
+			tuples = append(tuples, &Tuple{Key: key})
+		}
+		return group.InsertBatch(ctx, tuples)
+	})
+}
+
+// LookupBatch returns the tuples for all keys in the request with a result
+// per key in the same order
+func (klp *Kelips) LookupBatch(req *BatchRequest) []BatchResult {
+	return klp.LookupBatchContext(context.Background(), req)
+}
+
+// LookupBatchContext returns the tuples for all keys with a single request
+// per affinity group.  The context bounds all requests.  While resizing keys
+// not found in the next groups are looked up in the current ones
+func (klp *Kelips) LookupBatchContext(ctx context.Context, req *BatchRequest) []BatchResult {
+	lookup := func(group AffinityGroup, keys [][]byte) []BatchResult {
+		return group.LookupBatch(ctx, &BatchRequest{
+			Keys:       keys,
+			TTL:        req.TTL,
+			Originator: req.Originator,
+		})
+	}
+
+	current, next := klp.rings()
+	if next == nil {
+		return klp.batch(current, req.Keys, lookup)
+	}
+
+	results := klp.batch(next, req.Keys, lookup)
+
+	failed := make([]int, 0)
+	keys := make([][]byte, 0)
+	for i, res := range results {
+		if res.Err != nil {
+			failed = append(failed, i)
+			keys = append(keys, res.Key)
+		}
+	}
+	if len(failed) == 0 {
+		return results
+	}
+
+	for j, res := range klp.batch(current, keys, lookup) {
+		if res.Err == nil {
+			results[failed[j]] = res
+		}
+	}
+	return results
+}
+
+// batch splits the keys by affinity group of the ring and calls fn for each
+// group concurrently, merging the results in the original key order
+func (klp *Kelips) batch(r *ring, keys [][]byte, fn func(AffinityGroup, [][]byte) []BatchResult) []BatchResult {
+	// group index to key indexes
+	batches := make(map[int64][]int)
+	for i, key := range keys {
+		idx, _ := r.lookup(key)
+		batches[idx] = append(batches[idx], i)
+	}
+
+	results := make([]BatchResult, len(keys))
+
+	var wg sync.WaitGroup
+	for idx, indexes := range batches {
+		wg.Add(1)
+		go func(idx int64, indexes []int) {
+			defer wg.Done()
+
+			gkeys := make([][]byte, 0, len(indexes))
+			for _, i := range indexes {
+				gkeys = append(gkeys, keys[i])
+			}
+
+			res := fn(r.groups[idx], gkeys)
+			for j, i := range indexes {
+				results[i] = res[j]
+				if res[j].Err != nil {
+					results[i].Err = errors.Wrap(res[j].Err, fmt.Sprintf("group %d", idx))
+				}
+			}
+		}(idx, indexes)
+	}
+	wg.Wait()
+
+	return results
+}
+
+// observe records the outcome and duration of a request
+func (klp *Kelips) observe(op string, start time.Time, err error) {
+	opLabel := Label{Name: "op", Value: op}
+	klp.metrics.IncrCounter(metricRequests, 1, opLabel, resultLabel(err))
+	klp.metrics.Observe(metricRequestDuration, sinceSeconds(start), opLabel)
 }
 
 // Start starts listening for connections on the given listener and starts
@@ -147,15 +617,64 @@ func (klp *Kelips) Start(ln net.Listener) error {
 		return err
 	}
 
+	klp.mu.Lock()
+	klp.running = true
+	current := klp.ring
+	var next *ring
+	if klp.resize != nil {
+		next = klp.resize.next
+	}
+	klp.mu.Unlock()
+
 	// Start all groups
-	for _, group := range klp.groups {
+	for _, group := range current.groups {
 		group.Start()
 	}
+	if next != nil {
+		for _, group := range next.groups {
+			group.Start()
+		}
+	}
 
 	return err
 }
 
-// Shutdown shuts down the kelips node
+// Shutdown gracefully shuts down the kelips node.  Shutdown hooks are called
+// first followed by stopping the transport and all group go-routines.  The
+// tuple store is closed last if it implements io.Closer.  It returns the first error encountered or the context error if it is done
+// before everything has stopped
 func (klp *Kelips) Shutdown(ctx context.Context) error {
-	return klp.trans.Shutdown(ctx)
+	var err error
+	for _, hook := range klp.shutdownHooks {
+		if er := hook(ctx); er != nil && err == nil {
+			err = er
+		}
+	}
+
+	if er := klp.routines.stop(ctx); er != nil && err == nil {
+		err = er
+	}
+
+	if er := klp.trans.Shutdown(ctx); er != nil && err == nil {
+		err = er
+	}
+
+	current, next := klp.rings()
+	groups := current.groups
+	if next != nil {
+		groups = append(append([]AffinityGroup{}, groups...), next.groups...)
+	}
+	for _, group := range groups {
+		if er := group.Stop(ctx); er != nil && err == nil {
+			err = er
+		}
+	}
+
+	if closer, ok := klp.conf.Tuples.(io.Closer); ok {
+		if er := closer.Close(); er != nil && err == nil {
+			err = er
+		}
+	}
+
+	return err
 }
@@ -4,7 +4,9 @@ import (
 	"context"
 	"fmt"
 	"net"
+	"net/http"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -14,63 +16,6 @@ import (
 	"github.com/stretchr/testify/assert"
 )
 
-type mockTransport struct {
-	groups []AffinityGroup
-}
-
-func newMockTransport(n int) *mockTransport {
-	return &mockTransport{
-		groups: make([]AffinityGroup, n),
-	}
-}
-
-func (trans *mockTransport) groupIndex() int64 {
-	for i, g := range trans.groups {
-		if g != nil {
-			return int64(i)
-		}
-	}
-	return -1
-}
-
-func (trans *mockTransport) groupCount() int {
-	var c int
-	for _, g := range trans.groups {
-		if g != nil {
-			c++
-		}
-	}
-	return c
-}
-
-func (trans *mockTransport) AddPeer(c GroupContact, host PeerContact) error {
-	g := trans.groups[c.ID]
-	if g != nil {
-		return g.AddPeer(host)
-	}
-	return nil
-}
-
-func (trans *mockTransport) Insert(c GroupContact, key []byte) (string, error) {
-	return trans.groups[c.ID].Insert(key)
-}
-
-func (trans *mockTransport) Lookup(c GroupContact, req *Request) (string, error) {
-	return trans.groups[c.ID].Lookup(req)
-}
-
-func (trans *mockTransport) Register(c GroupContact, g AffinityGroup) {
-	trans.groups[c.ID] = g
-}
-
-func (trans *mockTransport) Start(ln net.Listener) error {
-	return nil
-}
-
-func (trans *mockTransport) Shutdown(context.Context) error {
-	return nil
-}
-
 var (
 	testKeys = [][]byte{
 		[]byte("foo/bar/bas"),
@@ -93,13 +38,7 @@ func testKelipsNew(ip string, port, k int, trans Transport) *Kelips {
 }
 
 func Test_New(t *testing.T) {
-	klp := testKelipsNew("127.0.0.1", 9999, 3, newMockTransport(3))
-	// klp := New(&Config{
-	// 	K: 3,
-	// 	// AdvAddr:   "127.0.0.1:9999",
-	// 	HashFunc:  sha256.New,
-	// 	Transport: newMockTransport(3),
-	// })
+	klp := testKelipsNew("127.0.0.1", 9999, 3, loopbackTransport("127.0.0.1:9999"))
 	assert.Equal(t, 3, len(klp.groups))
 
 	klp.AddPeer(&Peer{Host: "127.0.0.1:10000"})
@@ -115,64 +54,199 @@ func Test_New(t *testing.T) {
 		} else {
 			remote++
 			g := grp.(*remoteAffinityGroup)
-			trans := g.trans.(*mockTransport)
-			var a int
-			for _, g := range trans.groups {
-				if g != nil {
-					a++
-				}
-			}
-			assert.Equal(t, 1, a)
+			assert.Equal(t, klp.trans, g.trans)
 		}
 	}
 
 	assert.Equal(t, 1, local)
 	assert.Equal(t, 2, remote)
+	// Only the home group is registered
+	assert.Equal(t, 1, len(registered(klp)))
 }
 
-func makeMockNetwork(start, k int) []*Kelips {
-	knet := make([]*Kelips, k)
-	for i := 0; i < k; i++ {
-		// addr := fmt.Sprintf("127.0.0.1:%d", start+i)
-		knet[i] = testKelipsNew("127.0.0.1", start+i, k, newMockTransport(k))
+func Test_Kelips_replication(t *testing.T) {
+	conf := DefaultConfig()
+	conf.K = 1
+	conf.ReplicationFactor = 3
+	conf.Transport = loopbackTransport("127.0.0.1:9999")
+	klp := New("127.0.0.1:9999", conf)
+
+	// Fewer members than the replication factor
+	hosts, err := klp.Insert([]byte("key1"))
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"127.0.0.1:9999"}, hosts)
+
+	klp.AddPeer(&Peer{Host: "127.0.0.1:10000"})
+	klp.AddPeer(&Peer{Host: "127.0.0.1:10001"})
+	klp.AddPeer(&Peer{Host: "127.0.0.1:10002"})
+
+	hosts, err = klp.Insert([]byte("key2"))
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(hosts))
+
+	uniq := make(map[string]bool)
+	for _, h := range hosts {
+		uniq[h] = true
 	}
+	assert.Equal(t, 3, len(uniq))
 
-	for i, kn := range knet {
+	found, err := klp.Lookup(testRequest("key2"))
+	assert.Nil(t, err)
+	assert.Equal(t, hosts, found.Hosts)
 
-		for j, kns := range knet {
-			if i == j {
-				continue
-			}
+	// Re-inserting returns the existing home nodes
+	again, err := klp.Insert([]byte("key2"))
+	assert.Nil(t, err)
+	assert.Equal(t, hosts, again)
+}
 
-			_, err := kn.AddPeer(&Peer{Host: kns.groups[kns.id].Contact().Host})
-			if err != nil {
-				panic(err)
-			}
-		}
+func Test_tryContacts(t *testing.T) {
+	peers := []PeerContact{
+		&Peer{Host: "127.0.0.1:10000"},
+		&Peer{Host: "127.0.0.1:10001"},
+		&Peer{Host: "127.0.0.1:10002"},
 	}
+	suspects := newSuspectContacts(time.Minute)
+	ctx := context.Background()
+	down := fmt.Errorf("connection refused")
+
+	// Fail over to the next contact marking the first suspect
+	tried := make([]string, 0)
+	err := tryContacts(ctx, peers, suspects, 2, func(p PeerContact) error {
+		tried = append(tried, p.Address())
+		if p.Address() == "127.0.0.1:10000" {
+			return down
+		}
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"127.0.0.1:10000", "127.0.0.1:10001"}, tried)
+	assert.True(t, suspects.isSuspect("127.0.0.1:10000"))
+
+	// Suspects are tried last
+	tried = tried[:0]
+	err = tryContacts(ctx, peers, suspects, 2, func(p PeerContact) error {
+		tried = append(tried, p.Address())
+		return down
+	})
+	assert.Equal(t, down, err)
+	assert.Equal(t, []string{"127.0.0.1:10001", "127.0.0.1:10002", "127.0.0.1:10000"}, tried)
+
+	// Retry limit
+	tried = tried[:0]
+	tryContacts(ctx, peers, newSuspectContacts(time.Minute), 0, func(p PeerContact) error {
+		tried = append(tried, p.Address())
+		return down
+	})
+	assert.Equal(t, 1, len(tried))
+
+	tried = tried[:0]
+	tryContacts(ctx, peers, newSuspectContacts(time.Minute), NoContactRetries, func(p PeerContact) error {
+		tried = append(tried, p.Address())
+		return down
+	})
+	assert.Equal(t, 1, len(tried))
+
+	// Remote errors are not retried
+	tried = tried[:0]
+	err = tryContacts(ctx, peers, newSuspectContacts(time.Minute), 2, func(p PeerContact) error {
+		tried = append(tried, p.Address())
+		return &RemoteError{Message: errReqTTLReached.Error()}
+	})
+	assert.NotNil(t, err)
+	assert.Equal(t, 1, len(tried))
+
+	// Cancelled contexts are not retried
+	cctx, cancel := context.WithCancel(ctx)
+	cancel()
+	tried = tried[:0]
+	tryContacts(cctx, peers, newSuspectContacts(time.Minute), 2, func(p PeerContact) error {
+		tried = append(tried, p.Address())
+		return cctx.Err()
+	})
+	assert.Equal(t, 1, len(tried))
+
+	assert.Equal(t, errNoContacts, tryContacts(ctx, nil, suspects, 2, nil))
+}
 
-	return knet
+// countingTuples counts the number of expiry runs and closes
+type countingTuples struct {
+	TupleStorage
+	mu      sync.Mutex
+	expires int
+	closed  int
+}
+
+func (tuples *countingTuples) Close() error {
+	tuples.mu.Lock()
+	tuples.closed++
+	tuples.mu.Unlock()
+	return nil
+}
+
+func (tuples *countingTuples) Expire(d time.Duration) int {
+	tuples.mu.Lock()
+	tuples.expires++
+	tuples.mu.Unlock()
+	return tuples.TupleStorage.Expire(d)
+}
+
+func (tuples *countingTuples) count() int {
+	tuples.mu.Lock()
+	defer tuples.mu.Unlock()
+	return tuples.expires
+}
+
+func Test_Kelips_Shutdown(t *testing.T) {
+	tuples := &countingTuples{TupleStorage: NewInmemTuples()}
+	conf := DefaultConfig()
+	conf.K = 3
+	conf.Transport = loopbackTransport("127.0.0.1:9999")
+	conf.Tuples = tuples
+	conf.TupleExpireMinInt = 10 * time.Millisecond
+	conf.TupleExpireMaxInt = 20 * time.Millisecond
+	conf.ProbeInterval = 10 * time.Millisecond
+	klp := New("127.0.0.1:9999", conf)
+
+	var hooked bool
+	klp.shutdownHooks = append(klp.shutdownHooks, func(context.Context) error {
+		hooked = true
+		return nil
+	})
+
+	assert.Nil(t, klp.Start(nil))
+	<-time.After(100 * time.Millisecond)
+	assert.True(t, tuples.count() > 0)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	assert.Nil(t, klp.Shutdown(ctx))
+	assert.True(t, hooked)
+	assert.Equal(t, 1, tuples.closed)
+
+	// Expiry no longer runs
+	c := tuples.count()
+	<-time.After(100 * time.Millisecond)
+	assert.Equal(t, c, tuples.count())
+
+	// Go-routines that do not exit are bounded by the context
+	var r routines
+	r.start(func(context.Context) { time.Sleep(time.Second) })
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, r.stop(ctx))
 }
 
 func Test_Kelips_assignments(t *testing.T) {
-	knet := makeMockNetwork(44400, 3)
+	hosts := []string{"127.0.0.1:44400", "127.0.0.1:44401", "127.0.0.1:44402"}
+	knet := makeLoopbackHosts(NewLoopbackNetwork(1), hosts, 3, nil)
 
-	// Check group assignment
+	// Only the home group is registered
 	for _, kn := range knet {
-		for _, group := range kn.groups {
-			var trans *mockTransport
-
-			if group.IsLocal() {
-				gc := group.Contact()
-				g := group.(*affinityGroup)
-				trans = g.trans.(*mockTransport)
-				assert.Equal(t, gc.ID, trans.groupIndex())
-			} else {
-				g := group.(*remoteAffinityGroup)
-				trans = g.trans.(*mockTransport)
-			}
-			assert.Equal(t, 1, trans.groupCount())
-		}
+		groups := registered(kn)
+		assert.Equal(t, 1, len(groups))
+		assert.NotNil(t, groups[kn.id])
+		assert.True(t, kn.groups[kn.id].IsLocal())
 	}
 
 	// Check peer assignments
@@ -199,12 +273,16 @@ func Test_Kelips_assignments(t *testing.T) {
 }
 
 func makeTestNetwork(start, k int) []*Kelips {
+	return makeTestNetworkWith(start, k, func() Transport { return NewHTTPTransport(false) })
+}
+
+func makeTestNetworkWith(start, k int, newTransport func() Transport) []*Kelips {
 	knet := make([]*Kelips, k)
 	for i := 0; i < k; i++ {
 		addr := fmt.Sprintf("127.0.0.1:%d", start+i)
 		conf := &Config{
 			K:                 int64(k),
-			Transport:         NewHTTPTransport(false),
+			Transport:         newTransport(),
 			TupleTTL:          1 * time.Second,
 			TupleExpireMinInt: 750 * time.Millisecond,
 			TupleExpireMaxInt: 1 * time.Second,
@@ -258,11 +336,11 @@ func Test_Kelips(t *testing.T) {
 	h3, err := knet[2].Lookup(testRequest("foobar"))
 	assert.Nil(t, err)
 
-	assert.Equal(t, hi, h1)
-	assert.Equal(t, hi, h2)
-	assert.Equal(t, hi, h3)
+	assert.Equal(t, hi, h1.Hosts)
+	assert.Equal(t, hi, h2.Hosts)
+	assert.Equal(t, hi, h3.Hosts)
 
-	results := make([]string, 0, len(testKeys))
+	results := make([][]string, 0, len(testKeys))
 	for i, k := range testKeys {
 		n := i % 3
 		host, err := knet[n].Insert(k)
@@ -273,14 +351,14 @@ func Test_Kelips(t *testing.T) {
 			t.Fatal(err)
 		}
 
-		t.Logf("%s -> %s", k, host)
+		t.Logf("%s -> %v", k, host)
 		results = append(results, host)
 
 		// Check inserted on all peers
 		for _, kn := range knet {
-			lhost, err := kn.Lookup(&Request{Key: k, TTL: 1})
+			tuple, err := kn.Lookup(&Request{Key: k, TTL: 1})
 			assert.Nil(t, err)
-			assert.Equal(t, host, lhost)
+			assert.Equal(t, host, tuple.Hosts)
 		}
 
 	}
@@ -292,8 +370,8 @@ func Test_Kelips(t *testing.T) {
 
 	for i, kn := range knet {
 		for _, key := range testKeys {
-			host, err := kn.Lookup(&Request{Key: key, TTL: 2})
-			assert.NotNil(t, err, "node=%d host=%s", i, host)
+			tuple, err := kn.Lookup(&Request{Key: key, TTL: 2})
+			assert.NotNil(t, err, "node=%d tuple=%v", i, tuple)
 		}
 	}
 
@@ -305,6 +383,218 @@ func Test_Kelips(t *testing.T) {
 	}
 }
 
+func Test_Kelips_meta(t *testing.T) {
+	knet := makeTestNetwork(55700, 3)
+
+	key := []byte("with/meta")
+	meta := map[string]string{
+		"size":         "1024",
+		"content-type": "application/octet-stream",
+		"checksum":     "sha256:abc=&;",
+	}
+	hosts, err := knet[0].Insert(key, WithMeta(meta))
+	assert.Nil(t, err)
+
+	for i, kn := range knet {
+		tuple, err := kn.Lookup(&Request{Key: key, TTL: 1})
+		assert.Nil(t, err, "node=%d", i)
+		assert.Equal(t, hosts, tuple.Hosts)
+		assert.Equal(t, meta, tuple.Meta)
+	}
+
+	large := map[string]string{"value": strings.Repeat("x", MaxTupleMetaSize+1)}
+	_, err = knet[1].Insert([]byte("too/large"), WithMeta(large))
+	assert.Equal(t, errTupleMetaTooLarge, err)
+
+	ctx := context.Background()
+	for _, kn := range knet {
+		kn.Shutdown(ctx)
+	}
+}
+
+func Test_Kelips_Delete(t *testing.T) {
+	knet := makeTestNetwork(55600, 3)
+
+	key := []byte("delete/me")
+	host, err := knet[0].Insert(key)
+	assert.Nil(t, err)
+
+	for _, kn := range knet {
+		tuple, err := kn.Lookup(&Request{Key: key, TTL: 1})
+		assert.Nil(t, err)
+		assert.Equal(t, host, tuple.Hosts)
+	}
+
+	err = knet[1].Delete(key)
+	assert.Nil(t, err)
+
+	for i, kn := range knet {
+		_, err := kn.Lookup(&Request{Key: key, TTL: 1})
+		assert.NotNil(t, err, "node=%d", i)
+	}
+
+	// Deleting a non-existent key is not an error
+	assert.Nil(t, knet[2].Delete(key))
+
+	ctx := context.Background()
+	for _, kn := range knet {
+		kn.Shutdown(ctx)
+	}
+}
+
+func Test_Kelips_batch(t *testing.T) {
+	knet := makeTestNetwork(55800, 3)
+
+	keys := testKeys
+	results := knet[0].InsertBatch(keys)
+	assert.Equal(t, len(keys), len(results))
+
+	inserted := make(map[string][]string)
+	for i, res := range results {
+		assert.Equal(t, keys[i], res.Key)
+		if res.Err != nil {
+			assert.Contains(t, res.Err.Error(), "no contacts")
+			continue
+		}
+		inserted[string(res.Key)] = res.Tuple.Hosts
+	}
+	assert.NotEqual(t, 0, len(inserted))
+
+	// Single lookups see batch inserts
+	for k, hosts := range inserted {
+		tuple, err := knet[2].Lookup(&Request{Key: []byte(k), TTL: 1})
+		assert.Nil(t, err)
+		assert.Equal(t, hosts, tuple.Hosts)
+	}
+
+	// Re-inserting returns the existing home nodes
+	for i, res := range knet[0].InsertBatch(keys) {
+		if hosts, ok := inserted[string(keys[i])]; ok {
+			assert.Nil(t, res.Err)
+			assert.Equal(t, hosts, res.Tuple.Hosts)
+		}
+	}
+
+	missing := []byte("batch/missing")
+	req := &BatchRequest{Keys: append(keys, missing), TTL: 1}
+	for i, kn := range knet {
+		results := kn.LookupBatch(req)
+		assert.Equal(t, len(req.Keys), len(results))
+		for j, res := range results {
+			assert.Equal(t, req.Keys[j], res.Key)
+			hosts, ok := inserted[string(res.Key)]
+			if !ok {
+				assert.NotNil(t, res.Err, "node=%d key=%s", i, res.Key)
+				continue
+			}
+			assert.Nil(t, res.Err, "node=%d key=%s", i, res.Key)
+			assert.Equal(t, hosts, res.Tuple.Hosts)
+		}
+	}
+
+	ctx := context.Background()
+	for _, kn := range knet {
+		kn.Shutdown(ctx)
+	}
+}
+
+func Test_Kelips_lease(t *testing.T) {
+	transports := map[int]func() Transport{
+		56210: func() Transport { return NewHTTPTransport(false) },
+		56440: func() Transport { return NewGRPCTransport(false) },
+	}
+
+	for start, newTransport := range transports {
+		knet := makeTestNetworkWith(start, 3, newTransport)
+
+		key := []byte("lease/me")
+		hosts, err := knet[0].Insert(key, WithTTL(time.Minute))
+		assert.Nil(t, err)
+
+		// Leases outlive the 1s store wide ttl
+		time.Sleep(2 * time.Second)
+		for i, kn := range knet {
+			tuple, err := kn.Lookup(&Request{Key: key, TTL: 1})
+			assert.Nil(t, err, "port=%d node=%d", start, i)
+			if err == nil {
+				assert.Equal(t, time.Minute, tuple.TTL)
+			}
+		}
+
+		assert.Nil(t, knet[1].Renew(key, 2*time.Minute))
+		tuple, err := knet[2].Lookup(&Request{Key: key, TTL: 1})
+		assert.Nil(t, err)
+		assert.Equal(t, 2*time.Minute, tuple.TTL)
+
+		// The http transport does not carry error codes
+		err = knet[2].Renew([]byte("lease/missing"), 0)
+		if assert.NotNil(t, err) {
+			assert.Contains(t, err.Error(), errTupleNotFound.Error())
+		}
+		assert.NotNil(t, knet[0].Renew(key, -time.Second))
+		_, err = knet[0].Insert([]byte("lease/invalid"), WithTTL(-time.Second))
+		assert.NotNil(t, err)
+
+		assert.Nil(t, knet[2].Release(key, hosts[0]))
+		for i, kn := range knet {
+			_, err := kn.Lookup(&Request{Key: key, TTL: 1})
+			assert.NotNil(t, err, "port=%d node=%d", start, i)
+		}
+
+		ctx := context.Background()
+		for _, kn := range knet {
+			kn.Shutdown(ctx)
+		}
+	}
+}
+
+func Test_GRPCTransport(t *testing.T) {
+	knet := makeTestNetworkWith(55900, 3, func() Transport { return NewGRPCTransport(false) })
+
+	// Keys are not restricted to url safe values
+	key := []byte("grpc/bin\x00\xff/key")
+	meta := map[string]string{"content-type": "application/octet-stream"}
+	hosts, err := knet[0].Insert(key, WithMeta(meta))
+	assert.Nil(t, err)
+
+	for i, kn := range knet {
+		tuple, err := kn.Lookup(&Request{Key: key, TTL: 1})
+		assert.Nil(t, err, "node=%d", i)
+		assert.Equal(t, hosts, tuple.Hosts)
+		assert.Equal(t, meta, tuple.Meta)
+	}
+
+	results := knet[1].LookupBatch(&BatchRequest{Keys: [][]byte{key}, TTL: 1})
+	assert.Nil(t, results[0].Err)
+	assert.Equal(t, hosts, results[0].Tuple.Hosts)
+
+	// Errors carry their code across the transport
+	for i, kn := range knet {
+		_, err := kn.Lookup(&Request{Key: []byte("grpc/missing"), TTL: 0})
+		assert.Equal(t, ErrCodeTTLReached, errorCode(err), "node=%d", i)
+	}
+
+	trans := knet[0].trans
+	err = trans.Delete(context.Background(), GroupContact{ID: 100, Host: "127.0.0.1:55901"}, key)
+	assert.Equal(t, ErrCodeGroupNotFound, errorCode(err))
+	assert.False(t, isTransportError(context.Background(), err))
+
+	assert.Nil(t, knet[2].Delete(key))
+	for i, kn := range knet {
+		_, err := kn.Lookup(&Request{Key: key, TTL: 1})
+		assert.NotNil(t, err, "node=%d", i)
+	}
+
+	ctx := context.Background()
+	for _, kn := range knet {
+		kn.Shutdown(ctx)
+	}
+
+	// Unreachable hosts are transport errors
+	err = trans.Ping(ctx, "127.0.0.1:55901")
+	assert.True(t, isTransportError(ctx, err))
+}
+
 func makeTestKelipsGossip(port int, k int64) (*Kelips, *Gossip, error) {
 	ip := "127.0.0.1"
 	addr := fmt.Sprintf("%s:%d", ip, port)
@@ -332,3 +622,35 @@ func makeTestKelipsGossip(port int, k int64) (*Kelips, *Gossip, error) {
 
 	return New(addr, conf), g, nil
 }
+
+func Test_HTTPTransport_context(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	slow := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(2 * time.Second):
+		case <-r.Context().Done():
+		}
+	})
+	go http.Serve(ln, slow)
+
+	trans := NewHTTPTransport(false)
+	contact := GroupContact{ID: 0, Host: ln.Addr().String()}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err = trans.Lookup(ctx, contact, testRequest("foobar"))
+	assert.NotNil(t, err)
+	assert.True(t, time.Since(start) < time.Second)
+
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	_, err = trans.Insert(ctx, contact, &Tuple{Key: []byte("foobar")})
+	assert.NotNil(t, err)
+}
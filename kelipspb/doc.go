@@ -0,0 +1,4 @@
+// Package kelipspb contains the protobuf messages and gRPC service used by
+// the kelips GRPCTransport.  kelips.pb.go is generated from kelips.proto with
+// `make protoc`.
+package kelipspb
@@ -0,0 +1,1660 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// source: kelipspb/kelips.proto
+
+package kelipspb
+
+import (
+	context "context"
+	fmt "fmt"
+	proto "github.com/golang/protobuf/proto"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+	math "math"
+)
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ = proto.Marshal
+var _ = fmt.Errorf
+var _ = math.Inf
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the proto package it is being compiled against.
+// A compilation error at this line likely means your copy of the
+// proto package needs to be updated.
+const _ = proto.ProtoPackageIsVersion3 // please upgrade the proto package
+
+// ErrorCode classifies errors returned by a remote group
+type ErrorCode int32
+
+const (
+	ErrorCode_UNKNOWN         ErrorCode = 0
+	ErrorCode_TTL_REACHED     ErrorCode = 1
+	ErrorCode_NO_CONTACTS     ErrorCode = 2
+	ErrorCode_INVALID         ErrorCode = 3
+	ErrorCode_GROUP_NOT_FOUND ErrorCode = 4
+	ErrorCode_NOT_FOUND       ErrorCode = 5
+)
+
+var ErrorCode_name = map[int32]string{
+	0: "UNKNOWN",
+	1: "TTL_REACHED",
+	2: "NO_CONTACTS",
+	3: "INVALID",
+	4: "GROUP_NOT_FOUND",
+	5: "NOT_FOUND",
+}
+
+var ErrorCode_value = map[string]int32{
+	"UNKNOWN":         0,
+	"TTL_REACHED":     1,
+	"NO_CONTACTS":     2,
+	"INVALID":         3,
+	"GROUP_NOT_FOUND": 4,
+	"NOT_FOUND":       5,
+}
+
+func (x ErrorCode) String() string {
+	return proto.EnumName(ErrorCode_name, int32(x))
+}
+
+func (ErrorCode) EnumDescriptor() ([]byte, []int) {
+	return fileDescriptor_321c233726d64680, []int{0}
+}
+
+// Error is returned in a response when the remote group was reached but
+// failed to serve the request
+type Error struct {
+	Code                 ErrorCode `protobuf:"varint,1,opt,name=code,proto3,enum=kelipspb.ErrorCode" json:"code,omitempty"`
+	Message              string    `protobuf:"bytes,2,opt,name=message,proto3" json:"message,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}  `json:"-"`
+	XXX_unrecognized     []byte    `json:"-"`
+	XXX_sizecache        int32     `json:"-"`
+}
+
+func (m *Error) Reset()         { *m = Error{} }
+func (m *Error) String() string { return proto.CompactTextString(m) }
+func (*Error) ProtoMessage()    {}
+func (*Error) Descriptor() ([]byte, []int) {
+	return fileDescriptor_321c233726d64680, []int{0}
+}
+
+func (m *Error) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_Error.Unmarshal(m, b)
+}
+func (m *Error) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_Error.Marshal(b, m, deterministic)
+}
+func (m *Error) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_Error.Merge(m, src)
+}
+func (m *Error) XXX_Size() int {
+	return xxx_messageInfo_Error.Size(m)
+}
+func (m *Error) XXX_DiscardUnknown() {
+	xxx_messageInfo_Error.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_Error proto.InternalMessageInfo
+
+func (m *Error) GetCode() ErrorCode {
+	if m != nil {
+		return m.Code
+	}
+	return ErrorCode_UNKNOWN
+}
+
+func (m *Error) GetMessage() string {
+	if m != nil {
+		return m.Message
+	}
+	return ""
+}
+
+type Tuple struct {
+	Key   []byte            `protobuf:"bytes,1,opt,name=key,proto3" json:"key,omitempty"`
+	Hosts []string          `protobuf:"bytes,2,rep,name=hosts,proto3" json:"hosts,omitempty"`
+	Meta  map[string]string `protobuf:"bytes,3,rep,name=meta,proto3" json:"meta,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
+	// Lease duration in nanoseconds.  Zero uses the store wide TTL
+	Ttl                  int64    `protobuf:"varint,4,opt,name=ttl,proto3" json:"ttl,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *Tuple) Reset()         { *m = Tuple{} }
+func (m *Tuple) String() string { return proto.CompactTextString(m) }
+func (*Tuple) ProtoMessage()    {}
+func (*Tuple) Descriptor() ([]byte, []int) {
+	return fileDescriptor_321c233726d64680, []int{1}
+}
+
+func (m *Tuple) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_Tuple.Unmarshal(m, b)
+}
+func (m *Tuple) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_Tuple.Marshal(b, m, deterministic)
+}
+func (m *Tuple) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_Tuple.Merge(m, src)
+}
+func (m *Tuple) XXX_Size() int {
+	return xxx_messageInfo_Tuple.Size(m)
+}
+func (m *Tuple) XXX_DiscardUnknown() {
+	xxx_messageInfo_Tuple.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_Tuple proto.InternalMessageInfo
+
+func (m *Tuple) GetKey() []byte {
+	if m != nil {
+		return m.Key
+	}
+	return nil
+}
+
+func (m *Tuple) GetHosts() []string {
+	if m != nil {
+		return m.Hosts
+	}
+	return nil
+}
+
+func (m *Tuple) GetMeta() map[string]string {
+	if m != nil {
+		return m.Meta
+	}
+	return nil
+}
+
+func (m *Tuple) GetTtl() int64 {
+	if m != nil {
+		return m.Ttl
+	}
+	return 0
+}
+
+type GroupContact struct {
+	Id                   int64    `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
+	Host                 string   `protobuf:"bytes,2,opt,name=host,proto3" json:"host,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *GroupContact) Reset()         { *m = GroupContact{} }
+func (m *GroupContact) String() string { return proto.CompactTextString(m) }
+func (*GroupContact) ProtoMessage()    {}
+func (*GroupContact) Descriptor() ([]byte, []int) {
+	return fileDescriptor_321c233726d64680, []int{2}
+}
+
+func (m *GroupContact) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_GroupContact.Unmarshal(m, b)
+}
+func (m *GroupContact) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_GroupContact.Marshal(b, m, deterministic)
+}
+func (m *GroupContact) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_GroupContact.Merge(m, src)
+}
+func (m *GroupContact) XXX_Size() int {
+	return xxx_messageInfo_GroupContact.Size(m)
+}
+func (m *GroupContact) XXX_DiscardUnknown() {
+	xxx_messageInfo_GroupContact.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_GroupContact proto.InternalMessageInfo
+
+func (m *GroupContact) GetId() int64 {
+	if m != nil {
+		return m.Id
+	}
+	return 0
+}
+
+func (m *GroupContact) GetHost() string {
+	if m != nil {
+		return m.Host
+	}
+	return ""
+}
+
+type InsertRequest struct {
+	Group                int64    `protobuf:"varint,1,opt,name=group,proto3" json:"group,omitempty"`
+	Tuple                *Tuple   `protobuf:"bytes,2,opt,name=tuple,proto3" json:"tuple,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *InsertRequest) Reset()         { *m = InsertRequest{} }
+func (m *InsertRequest) String() string { return proto.CompactTextString(m) }
+func (*InsertRequest) ProtoMessage()    {}
+func (*InsertRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_321c233726d64680, []int{3}
+}
+
+func (m *InsertRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_InsertRequest.Unmarshal(m, b)
+}
+func (m *InsertRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_InsertRequest.Marshal(b, m, deterministic)
+}
+func (m *InsertRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_InsertRequest.Merge(m, src)
+}
+func (m *InsertRequest) XXX_Size() int {
+	return xxx_messageInfo_InsertRequest.Size(m)
+}
+func (m *InsertRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_InsertRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_InsertRequest proto.InternalMessageInfo
+
+func (m *InsertRequest) GetGroup() int64 {
+	if m != nil {
+		return m.Group
+	}
+	return 0
+}
+
+func (m *InsertRequest) GetTuple() *Tuple {
+	if m != nil {
+		return m.Tuple
+	}
+	return nil
+}
+
+type InsertResponse struct {
+	Hosts                []string `protobuf:"bytes,1,rep,name=hosts,proto3" json:"hosts,omitempty"`
+	Error                *Error   `protobuf:"bytes,2,opt,name=error,proto3" json:"error,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *InsertResponse) Reset()         { *m = InsertResponse{} }
+func (m *InsertResponse) String() string { return proto.CompactTextString(m) }
+func (*InsertResponse) ProtoMessage()    {}
+func (*InsertResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_321c233726d64680, []int{4}
+}
+
+func (m *InsertResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_InsertResponse.Unmarshal(m, b)
+}
+func (m *InsertResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_InsertResponse.Marshal(b, m, deterministic)
+}
+func (m *InsertResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_InsertResponse.Merge(m, src)
+}
+func (m *InsertResponse) XXX_Size() int {
+	return xxx_messageInfo_InsertResponse.Size(m)
+}
+func (m *InsertResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_InsertResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_InsertResponse proto.InternalMessageInfo
+
+func (m *InsertResponse) GetHosts() []string {
+	if m != nil {
+		return m.Hosts
+	}
+	return nil
+}
+
+func (m *InsertResponse) GetError() *Error {
+	if m != nil {
+		return m.Error
+	}
+	return nil
+}
+
+// TraceHop is a single decision made by a node along a traced lookup path
+type TraceHop struct {
+	Host     string `protobuf:"bytes,1,opt,name=host,proto3" json:"host,omitempty"`
+	Group    int64  `protobuf:"varint,2,opt,name=group,proto3" json:"group,omitempty"`
+	Decision string `protobuf:"bytes,3,opt,name=decision,proto3" json:"decision,omitempty"`
+	To       string `protobuf:"bytes,4,opt,name=to,proto3" json:"to,omitempty"`
+	// nanoseconds
+	Latency              int64    `protobuf:"varint,5,opt,name=latency,proto3" json:"latency,omitempty"`
+	Error                string   `protobuf:"bytes,6,opt,name=error,proto3" json:"error,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *TraceHop) Reset()         { *m = TraceHop{} }
+func (m *TraceHop) String() string { return proto.CompactTextString(m) }
+func (*TraceHop) ProtoMessage()    {}
+func (*TraceHop) Descriptor() ([]byte, []int) {
+	return fileDescriptor_321c233726d64680, []int{5}
+}
+
+func (m *TraceHop) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_TraceHop.Unmarshal(m, b)
+}
+func (m *TraceHop) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_TraceHop.Marshal(b, m, deterministic)
+}
+func (m *TraceHop) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_TraceHop.Merge(m, src)
+}
+func (m *TraceHop) XXX_Size() int {
+	return xxx_messageInfo_TraceHop.Size(m)
+}
+func (m *TraceHop) XXX_DiscardUnknown() {
+	xxx_messageInfo_TraceHop.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_TraceHop proto.InternalMessageInfo
+
+func (m *TraceHop) GetHost() string {
+	if m != nil {
+		return m.Host
+	}
+	return ""
+}
+
+func (m *TraceHop) GetGroup() int64 {
+	if m != nil {
+		return m.Group
+	}
+	return 0
+}
+
+func (m *TraceHop) GetDecision() string {
+	if m != nil {
+		return m.Decision
+	}
+	return ""
+}
+
+func (m *TraceHop) GetTo() string {
+	if m != nil {
+		return m.To
+	}
+	return ""
+}
+
+func (m *TraceHop) GetLatency() int64 {
+	if m != nil {
+		return m.Latency
+	}
+	return 0
+}
+
+func (m *TraceHop) GetError() string {
+	if m != nil {
+		return m.Error
+	}
+	return ""
+}
+
+type LookupRequest struct {
+	Group      int64         `protobuf:"varint,1,opt,name=group,proto3" json:"group,omitempty"`
+	Key        []byte        `protobuf:"bytes,2,opt,name=key,proto3" json:"key,omitempty"`
+	Ttl        int32         `protobuf:"varint,3,opt,name=ttl,proto3" json:"ttl,omitempty"`
+	Originator *GroupContact `protobuf:"bytes,4,opt,name=originator,proto3" json:"originator,omitempty"`
+	// Return the hops taken by the lookup
+	Trace                bool     `protobuf:"varint,5,opt,name=trace,proto3" json:"trace,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *LookupRequest) Reset()         { *m = LookupRequest{} }
+func (m *LookupRequest) String() string { return proto.CompactTextString(m) }
+func (*LookupRequest) ProtoMessage()    {}
+func (*LookupRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_321c233726d64680, []int{6}
+}
+
+func (m *LookupRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_LookupRequest.Unmarshal(m, b)
+}
+func (m *LookupRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_LookupRequest.Marshal(b, m, deterministic)
+}
+func (m *LookupRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_LookupRequest.Merge(m, src)
+}
+func (m *LookupRequest) XXX_Size() int {
+	return xxx_messageInfo_LookupRequest.Size(m)
+}
+func (m *LookupRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_LookupRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_LookupRequest proto.InternalMessageInfo
+
+func (m *LookupRequest) GetGroup() int64 {
+	if m != nil {
+		return m.Group
+	}
+	return 0
+}
+
+func (m *LookupRequest) GetKey() []byte {
+	if m != nil {
+		return m.Key
+	}
+	return nil
+}
+
+func (m *LookupRequest) GetTtl() int32 {
+	if m != nil {
+		return m.Ttl
+	}
+	return 0
+}
+
+func (m *LookupRequest) GetOriginator() *GroupContact {
+	if m != nil {
+		return m.Originator
+	}
+	return nil
+}
+
+func (m *LookupRequest) GetTrace() bool {
+	if m != nil {
+		return m.Trace
+	}
+	return false
+}
+
+type LookupResponse struct {
+	Tuple                *Tuple      `protobuf:"bytes,1,opt,name=tuple,proto3" json:"tuple,omitempty"`
+	Error                *Error      `protobuf:"bytes,2,opt,name=error,proto3" json:"error,omitempty"`
+	Trace                []*TraceHop `protobuf:"bytes,3,rep,name=trace,proto3" json:"trace,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}    `json:"-"`
+	XXX_unrecognized     []byte      `json:"-"`
+	XXX_sizecache        int32       `json:"-"`
+}
+
+func (m *LookupResponse) Reset()         { *m = LookupResponse{} }
+func (m *LookupResponse) String() string { return proto.CompactTextString(m) }
+func (*LookupResponse) ProtoMessage()    {}
+func (*LookupResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_321c233726d64680, []int{7}
+}
+
+func (m *LookupResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_LookupResponse.Unmarshal(m, b)
+}
+func (m *LookupResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_LookupResponse.Marshal(b, m, deterministic)
+}
+func (m *LookupResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_LookupResponse.Merge(m, src)
+}
+func (m *LookupResponse) XXX_Size() int {
+	return xxx_messageInfo_LookupResponse.Size(m)
+}
+func (m *LookupResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_LookupResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_LookupResponse proto.InternalMessageInfo
+
+func (m *LookupResponse) GetTuple() *Tuple {
+	if m != nil {
+		return m.Tuple
+	}
+	return nil
+}
+
+func (m *LookupResponse) GetError() *Error {
+	if m != nil {
+		return m.Error
+	}
+	return nil
+}
+
+func (m *LookupResponse) GetTrace() []*TraceHop {
+	if m != nil {
+		return m.Trace
+	}
+	return nil
+}
+
+type DeleteRequest struct {
+	Group                int64    `protobuf:"varint,1,opt,name=group,proto3" json:"group,omitempty"`
+	Key                  []byte   `protobuf:"bytes,2,opt,name=key,proto3" json:"key,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *DeleteRequest) Reset()         { *m = DeleteRequest{} }
+func (m *DeleteRequest) String() string { return proto.CompactTextString(m) }
+func (*DeleteRequest) ProtoMessage()    {}
+func (*DeleteRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_321c233726d64680, []int{8}
+}
+
+func (m *DeleteRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_DeleteRequest.Unmarshal(m, b)
+}
+func (m *DeleteRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_DeleteRequest.Marshal(b, m, deterministic)
+}
+func (m *DeleteRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_DeleteRequest.Merge(m, src)
+}
+func (m *DeleteRequest) XXX_Size() int {
+	return xxx_messageInfo_DeleteRequest.Size(m)
+}
+func (m *DeleteRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_DeleteRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_DeleteRequest proto.InternalMessageInfo
+
+func (m *DeleteRequest) GetGroup() int64 {
+	if m != nil {
+		return m.Group
+	}
+	return 0
+}
+
+func (m *DeleteRequest) GetKey() []byte {
+	if m != nil {
+		return m.Key
+	}
+	return nil
+}
+
+type DeleteResponse struct {
+	Error                *Error   `protobuf:"bytes,1,opt,name=error,proto3" json:"error,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *DeleteResponse) Reset()         { *m = DeleteResponse{} }
+func (m *DeleteResponse) String() string { return proto.CompactTextString(m) }
+func (*DeleteResponse) ProtoMessage()    {}
+func (*DeleteResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_321c233726d64680, []int{9}
+}
+
+func (m *DeleteResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_DeleteResponse.Unmarshal(m, b)
+}
+func (m *DeleteResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_DeleteResponse.Marshal(b, m, deterministic)
+}
+func (m *DeleteResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_DeleteResponse.Merge(m, src)
+}
+func (m *DeleteResponse) XXX_Size() int {
+	return xxx_messageInfo_DeleteResponse.Size(m)
+}
+func (m *DeleteResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_DeleteResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_DeleteResponse proto.InternalMessageInfo
+
+func (m *DeleteResponse) GetError() *Error {
+	if m != nil {
+		return m.Error
+	}
+	return nil
+}
+
+type RenewRequest struct {
+	Group int64  `protobuf:"varint,1,opt,name=group,proto3" json:"group,omitempty"`
+	Key   []byte `protobuf:"bytes,2,opt,name=key,proto3" json:"key,omitempty"`
+	// nanoseconds.  Zero keeps the current lease duration
+	Ttl                  int64    `protobuf:"varint,3,opt,name=ttl,proto3" json:"ttl,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *RenewRequest) Reset()         { *m = RenewRequest{} }
+func (m *RenewRequest) String() string { return proto.CompactTextString(m) }
+func (*RenewRequest) ProtoMessage()    {}
+func (*RenewRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_321c233726d64680, []int{10}
+}
+
+func (m *RenewRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_RenewRequest.Unmarshal(m, b)
+}
+func (m *RenewRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_RenewRequest.Marshal(b, m, deterministic)
+}
+func (m *RenewRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_RenewRequest.Merge(m, src)
+}
+func (m *RenewRequest) XXX_Size() int {
+	return xxx_messageInfo_RenewRequest.Size(m)
+}
+func (m *RenewRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_RenewRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_RenewRequest proto.InternalMessageInfo
+
+func (m *RenewRequest) GetGroup() int64 {
+	if m != nil {
+		return m.Group
+	}
+	return 0
+}
+
+func (m *RenewRequest) GetKey() []byte {
+	if m != nil {
+		return m.Key
+	}
+	return nil
+}
+
+func (m *RenewRequest) GetTtl() int64 {
+	if m != nil {
+		return m.Ttl
+	}
+	return 0
+}
+
+type RenewResponse struct {
+	Error                *Error   `protobuf:"bytes,1,opt,name=error,proto3" json:"error,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *RenewResponse) Reset()         { *m = RenewResponse{} }
+func (m *RenewResponse) String() string { return proto.CompactTextString(m) }
+func (*RenewResponse) ProtoMessage()    {}
+func (*RenewResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_321c233726d64680, []int{11}
+}
+
+func (m *RenewResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_RenewResponse.Unmarshal(m, b)
+}
+func (m *RenewResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_RenewResponse.Marshal(b, m, deterministic)
+}
+func (m *RenewResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_RenewResponse.Merge(m, src)
+}
+func (m *RenewResponse) XXX_Size() int {
+	return xxx_messageInfo_RenewResponse.Size(m)
+}
+func (m *RenewResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_RenewResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_RenewResponse proto.InternalMessageInfo
+
+func (m *RenewResponse) GetError() *Error {
+	if m != nil {
+		return m.Error
+	}
+	return nil
+}
+
+type ReleaseRequest struct {
+	Group                int64    `protobuf:"varint,1,opt,name=group,proto3" json:"group,omitempty"`
+	Key                  []byte   `protobuf:"bytes,2,opt,name=key,proto3" json:"key,omitempty"`
+	Host                 string   `protobuf:"bytes,3,opt,name=host,proto3" json:"host,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *ReleaseRequest) Reset()         { *m = ReleaseRequest{} }
+func (m *ReleaseRequest) String() string { return proto.CompactTextString(m) }
+func (*ReleaseRequest) ProtoMessage()    {}
+func (*ReleaseRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_321c233726d64680, []int{12}
+}
+
+func (m *ReleaseRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_ReleaseRequest.Unmarshal(m, b)
+}
+func (m *ReleaseRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_ReleaseRequest.Marshal(b, m, deterministic)
+}
+func (m *ReleaseRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_ReleaseRequest.Merge(m, src)
+}
+func (m *ReleaseRequest) XXX_Size() int {
+	return xxx_messageInfo_ReleaseRequest.Size(m)
+}
+func (m *ReleaseRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_ReleaseRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_ReleaseRequest proto.InternalMessageInfo
+
+func (m *ReleaseRequest) GetGroup() int64 {
+	if m != nil {
+		return m.Group
+	}
+	return 0
+}
+
+func (m *ReleaseRequest) GetKey() []byte {
+	if m != nil {
+		return m.Key
+	}
+	return nil
+}
+
+func (m *ReleaseRequest) GetHost() string {
+	if m != nil {
+		return m.Host
+	}
+	return ""
+}
+
+type ReleaseResponse struct {
+	Error                *Error   `protobuf:"bytes,1,opt,name=error,proto3" json:"error,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *ReleaseResponse) Reset()         { *m = ReleaseResponse{} }
+func (m *ReleaseResponse) String() string { return proto.CompactTextString(m) }
+func (*ReleaseResponse) ProtoMessage()    {}
+func (*ReleaseResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_321c233726d64680, []int{13}
+}
+
+func (m *ReleaseResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_ReleaseResponse.Unmarshal(m, b)
+}
+func (m *ReleaseResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_ReleaseResponse.Marshal(b, m, deterministic)
+}
+func (m *ReleaseResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_ReleaseResponse.Merge(m, src)
+}
+func (m *ReleaseResponse) XXX_Size() int {
+	return xxx_messageInfo_ReleaseResponse.Size(m)
+}
+func (m *ReleaseResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_ReleaseResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_ReleaseResponse proto.InternalMessageInfo
+
+func (m *ReleaseResponse) GetError() *Error {
+	if m != nil {
+		return m.Error
+	}
+	return nil
+}
+
+type AddPeerRequest struct {
+	Group                int64    `protobuf:"varint,1,opt,name=group,proto3" json:"group,omitempty"`
+	Host                 string   `protobuf:"bytes,2,opt,name=host,proto3" json:"host,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *AddPeerRequest) Reset()         { *m = AddPeerRequest{} }
+func (m *AddPeerRequest) String() string { return proto.CompactTextString(m) }
+func (*AddPeerRequest) ProtoMessage()    {}
+func (*AddPeerRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_321c233726d64680, []int{14}
+}
+
+func (m *AddPeerRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_AddPeerRequest.Unmarshal(m, b)
+}
+func (m *AddPeerRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_AddPeerRequest.Marshal(b, m, deterministic)
+}
+func (m *AddPeerRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_AddPeerRequest.Merge(m, src)
+}
+func (m *AddPeerRequest) XXX_Size() int {
+	return xxx_messageInfo_AddPeerRequest.Size(m)
+}
+func (m *AddPeerRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_AddPeerRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_AddPeerRequest proto.InternalMessageInfo
+
+func (m *AddPeerRequest) GetGroup() int64 {
+	if m != nil {
+		return m.Group
+	}
+	return 0
+}
+
+func (m *AddPeerRequest) GetHost() string {
+	if m != nil {
+		return m.Host
+	}
+	return ""
+}
+
+type AddPeerResponse struct {
+	Error                *Error   `protobuf:"bytes,1,opt,name=error,proto3" json:"error,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *AddPeerResponse) Reset()         { *m = AddPeerResponse{} }
+func (m *AddPeerResponse) String() string { return proto.CompactTextString(m) }
+func (*AddPeerResponse) ProtoMessage()    {}
+func (*AddPeerResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_321c233726d64680, []int{15}
+}
+
+func (m *AddPeerResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_AddPeerResponse.Unmarshal(m, b)
+}
+func (m *AddPeerResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_AddPeerResponse.Marshal(b, m, deterministic)
+}
+func (m *AddPeerResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_AddPeerResponse.Merge(m, src)
+}
+func (m *AddPeerResponse) XXX_Size() int {
+	return xxx_messageInfo_AddPeerResponse.Size(m)
+}
+func (m *AddPeerResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_AddPeerResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_AddPeerResponse proto.InternalMessageInfo
+
+func (m *AddPeerResponse) GetError() *Error {
+	if m != nil {
+		return m.Error
+	}
+	return nil
+}
+
+type PingRequest struct {
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *PingRequest) Reset()         { *m = PingRequest{} }
+func (m *PingRequest) String() string { return proto.CompactTextString(m) }
+func (*PingRequest) ProtoMessage()    {}
+func (*PingRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_321c233726d64680, []int{16}
+}
+
+func (m *PingRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_PingRequest.Unmarshal(m, b)
+}
+func (m *PingRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_PingRequest.Marshal(b, m, deterministic)
+}
+func (m *PingRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_PingRequest.Merge(m, src)
+}
+func (m *PingRequest) XXX_Size() int {
+	return xxx_messageInfo_PingRequest.Size(m)
+}
+func (m *PingRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_PingRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_PingRequest proto.InternalMessageInfo
+
+type PingResponse struct {
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *PingResponse) Reset()         { *m = PingResponse{} }
+func (m *PingResponse) String() string { return proto.CompactTextString(m) }
+func (*PingResponse) ProtoMessage()    {}
+func (*PingResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_321c233726d64680, []int{17}
+}
+
+func (m *PingResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_PingResponse.Unmarshal(m, b)
+}
+func (m *PingResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_PingResponse.Marshal(b, m, deterministic)
+}
+func (m *PingResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_PingResponse.Merge(m, src)
+}
+func (m *PingResponse) XXX_Size() int {
+	return xxx_messageInfo_PingResponse.Size(m)
+}
+func (m *PingResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_PingResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_PingResponse proto.InternalMessageInfo
+
+type InsertBatchRequest struct {
+	Group                int64    `protobuf:"varint,1,opt,name=group,proto3" json:"group,omitempty"`
+	Tuples               []*Tuple `protobuf:"bytes,2,rep,name=tuples,proto3" json:"tuples,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *InsertBatchRequest) Reset()         { *m = InsertBatchRequest{} }
+func (m *InsertBatchRequest) String() string { return proto.CompactTextString(m) }
+func (*InsertBatchRequest) ProtoMessage()    {}
+func (*InsertBatchRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_321c233726d64680, []int{18}
+}
+
+func (m *InsertBatchRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_InsertBatchRequest.Unmarshal(m, b)
+}
+func (m *InsertBatchRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_InsertBatchRequest.Marshal(b, m, deterministic)
+}
+func (m *InsertBatchRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_InsertBatchRequest.Merge(m, src)
+}
+func (m *InsertBatchRequest) XXX_Size() int {
+	return xxx_messageInfo_InsertBatchRequest.Size(m)
+}
+func (m *InsertBatchRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_InsertBatchRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_InsertBatchRequest proto.InternalMessageInfo
+
+func (m *InsertBatchRequest) GetGroup() int64 {
+	if m != nil {
+		return m.Group
+	}
+	return 0
+}
+
+func (m *InsertBatchRequest) GetTuples() []*Tuple {
+	if m != nil {
+		return m.Tuples
+	}
+	return nil
+}
+
+type LookupBatchRequest struct {
+	Group                int64         `protobuf:"varint,1,opt,name=group,proto3" json:"group,omitempty"`
+	Keys                 [][]byte      `protobuf:"bytes,2,rep,name=keys,proto3" json:"keys,omitempty"`
+	Ttl                  int32         `protobuf:"varint,3,opt,name=ttl,proto3" json:"ttl,omitempty"`
+	Originator           *GroupContact `protobuf:"bytes,4,opt,name=originator,proto3" json:"originator,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}      `json:"-"`
+	XXX_unrecognized     []byte        `json:"-"`
+	XXX_sizecache        int32         `json:"-"`
+}
+
+func (m *LookupBatchRequest) Reset()         { *m = LookupBatchRequest{} }
+func (m *LookupBatchRequest) String() string { return proto.CompactTextString(m) }
+func (*LookupBatchRequest) ProtoMessage()    {}
+func (*LookupBatchRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_321c233726d64680, []int{19}
+}
+
+func (m *LookupBatchRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_LookupBatchRequest.Unmarshal(m, b)
+}
+func (m *LookupBatchRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_LookupBatchRequest.Marshal(b, m, deterministic)
+}
+func (m *LookupBatchRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_LookupBatchRequest.Merge(m, src)
+}
+func (m *LookupBatchRequest) XXX_Size() int {
+	return xxx_messageInfo_LookupBatchRequest.Size(m)
+}
+func (m *LookupBatchRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_LookupBatchRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_LookupBatchRequest proto.InternalMessageInfo
+
+func (m *LookupBatchRequest) GetGroup() int64 {
+	if m != nil {
+		return m.Group
+	}
+	return 0
+}
+
+func (m *LookupBatchRequest) GetKeys() [][]byte {
+	if m != nil {
+		return m.Keys
+	}
+	return nil
+}
+
+func (m *LookupBatchRequest) GetTtl() int32 {
+	if m != nil {
+		return m.Ttl
+	}
+	return 0
+}
+
+func (m *LookupBatchRequest) GetOriginator() *GroupContact {
+	if m != nil {
+		return m.Originator
+	}
+	return nil
+}
+
+type BatchResult struct {
+	Key                  []byte   `protobuf:"bytes,1,opt,name=key,proto3" json:"key,omitempty"`
+	Tuple                *Tuple   `protobuf:"bytes,2,opt,name=tuple,proto3" json:"tuple,omitempty"`
+	Error                *Error   `protobuf:"bytes,3,opt,name=error,proto3" json:"error,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *BatchResult) Reset()         { *m = BatchResult{} }
+func (m *BatchResult) String() string { return proto.CompactTextString(m) }
+func (*BatchResult) ProtoMessage()    {}
+func (*BatchResult) Descriptor() ([]byte, []int) {
+	return fileDescriptor_321c233726d64680, []int{20}
+}
+
+func (m *BatchResult) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_BatchResult.Unmarshal(m, b)
+}
+func (m *BatchResult) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_BatchResult.Marshal(b, m, deterministic)
+}
+func (m *BatchResult) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_BatchResult.Merge(m, src)
+}
+func (m *BatchResult) XXX_Size() int {
+	return xxx_messageInfo_BatchResult.Size(m)
+}
+func (m *BatchResult) XXX_DiscardUnknown() {
+	xxx_messageInfo_BatchResult.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_BatchResult proto.InternalMessageInfo
+
+func (m *BatchResult) GetKey() []byte {
+	if m != nil {
+		return m.Key
+	}
+	return nil
+}
+
+func (m *BatchResult) GetTuple() *Tuple {
+	if m != nil {
+		return m.Tuple
+	}
+	return nil
+}
+
+func (m *BatchResult) GetError() *Error {
+	if m != nil {
+		return m.Error
+	}
+	return nil
+}
+
+type BatchResponse struct {
+	Results []*BatchResult `protobuf:"bytes,1,rep,name=results,proto3" json:"results,omitempty"`
+	// Set if the request as a whole failed
+	Error                *Error   `protobuf:"bytes,2,opt,name=error,proto3" json:"error,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *BatchResponse) Reset()         { *m = BatchResponse{} }
+func (m *BatchResponse) String() string { return proto.CompactTextString(m) }
+func (*BatchResponse) ProtoMessage()    {}
+func (*BatchResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_321c233726d64680, []int{21}
+}
+
+func (m *BatchResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_BatchResponse.Unmarshal(m, b)
+}
+func (m *BatchResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_BatchResponse.Marshal(b, m, deterministic)
+}
+func (m *BatchResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_BatchResponse.Merge(m, src)
+}
+func (m *BatchResponse) XXX_Size() int {
+	return xxx_messageInfo_BatchResponse.Size(m)
+}
+func (m *BatchResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_BatchResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_BatchResponse proto.InternalMessageInfo
+
+func (m *BatchResponse) GetResults() []*BatchResult {
+	if m != nil {
+		return m.Results
+	}
+	return nil
+}
+
+func (m *BatchResponse) GetError() *Error {
+	if m != nil {
+		return m.Error
+	}
+	return nil
+}
+
+func init() {
+	proto.RegisterEnum("kelipspb.ErrorCode", ErrorCode_name, ErrorCode_value)
+	proto.RegisterType((*Error)(nil), "kelipspb.Error")
+	proto.RegisterType((*Tuple)(nil), "kelipspb.Tuple")
+	proto.RegisterMapType((map[string]string)(nil), "kelipspb.Tuple.MetaEntry")
+	proto.RegisterType((*GroupContact)(nil), "kelipspb.GroupContact")
+	proto.RegisterType((*InsertRequest)(nil), "kelipspb.InsertRequest")
+	proto.RegisterType((*InsertResponse)(nil), "kelipspb.InsertResponse")
+	proto.RegisterType((*TraceHop)(nil), "kelipspb.TraceHop")
+	proto.RegisterType((*LookupRequest)(nil), "kelipspb.LookupRequest")
+	proto.RegisterType((*LookupResponse)(nil), "kelipspb.LookupResponse")
+	proto.RegisterType((*DeleteRequest)(nil), "kelipspb.DeleteRequest")
+	proto.RegisterType((*DeleteResponse)(nil), "kelipspb.DeleteResponse")
+	proto.RegisterType((*RenewRequest)(nil), "kelipspb.RenewRequest")
+	proto.RegisterType((*RenewResponse)(nil), "kelipspb.RenewResponse")
+	proto.RegisterType((*ReleaseRequest)(nil), "kelipspb.ReleaseRequest")
+	proto.RegisterType((*ReleaseResponse)(nil), "kelipspb.ReleaseResponse")
+	proto.RegisterType((*AddPeerRequest)(nil), "kelipspb.AddPeerRequest")
+	proto.RegisterType((*AddPeerResponse)(nil), "kelipspb.AddPeerResponse")
+	proto.RegisterType((*PingRequest)(nil), "kelipspb.PingRequest")
+	proto.RegisterType((*PingResponse)(nil), "kelipspb.PingResponse")
+	proto.RegisterType((*InsertBatchRequest)(nil), "kelipspb.InsertBatchRequest")
+	proto.RegisterType((*LookupBatchRequest)(nil), "kelipspb.LookupBatchRequest")
+	proto.RegisterType((*BatchResult)(nil), "kelipspb.BatchResult")
+	proto.RegisterType((*BatchResponse)(nil), "kelipspb.BatchResponse")
+}
+
+func init() {
+	proto.RegisterFile("kelipspb/kelips.proto", fileDescriptor_321c233726d64680)
+}
+
+var fileDescriptor_321c233726d64680 = []byte{
+	// 890 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xac, 0x56, 0x5f, 0x6b, 0xe3, 0x46,
+	0x10, 0x3f, 0x49, 0x96, 0x13, 0x8f, 0x6c, 0xd9, 0xec, 0xf5, 0xee, 0x14, 0xd1, 0x07, 0x23, 0x38,
+	0xce, 0x14, 0x9a, 0x03, 0x17, 0x2e, 0x21, 0x50, 0x68, 0x6a, 0xe7, 0x2e, 0xe9, 0x39, 0x72, 0xd8,
+	0x73, 0xda, 0xc7, 0xa0, 0xb3, 0x17, 0x9f, 0xb0, 0xa2, 0x55, 0xa5, 0xf5, 0x95, 0x7c, 0x83, 0x3e,
+	0xf6, 0xa1, 0xd0, 0xcf, 0xd0, 0x6f, 0x59, 0x76, 0x57, 0x7f, 0xd6, 0xb1, 0x31, 0x36, 0xe9, 0xdb,
+	0xce, 0xce, 0xcc, 0x6f, 0x7e, 0x9a, 0x7f, 0x2b, 0x78, 0xb1, 0x20, 0x51, 0x98, 0x64, 0xc9, 0xe7,
+	0xb7, 0xf2, 0x70, 0x9c, 0xa4, 0x94, 0x51, 0x74, 0x58, 0x5c, 0x7b, 0xbf, 0x80, 0x79, 0x91, 0xa6,
+	0x34, 0x45, 0x6f, 0xa0, 0x36, 0xa5, 0x33, 0xe2, 0x68, 0x5d, 0xad, 0x67, 0xf7, 0x9f, 0x1f, 0x17,
+	0x16, 0xc7, 0x42, 0x3d, 0xa0, 0x33, 0x82, 0x85, 0x01, 0x72, 0xe0, 0xe0, 0x9e, 0x64, 0x59, 0x30,
+	0x27, 0x8e, 0xde, 0xd5, 0x7a, 0x0d, 0x5c, 0x88, 0xde, 0xbf, 0x1a, 0x98, 0x93, 0x65, 0x12, 0x11,
+	0xd4, 0x01, 0x63, 0x41, 0x1e, 0x04, 0x56, 0x13, 0xf3, 0x23, 0xfa, 0x06, 0xcc, 0x2f, 0x34, 0x63,
+	0x99, 0xa3, 0x77, 0x8d, 0x5e, 0x03, 0x4b, 0x01, 0x7d, 0x0f, 0xb5, 0x7b, 0xc2, 0x02, 0xc7, 0xe8,
+	0x1a, 0x3d, 0xab, 0x7f, 0x54, 0x05, 0x15, 0x30, 0xc7, 0xd7, 0x84, 0x05, 0x17, 0x31, 0x4b, 0x1f,
+	0xb0, 0x30, 0xe3, 0xb0, 0x8c, 0x45, 0x4e, 0xad, 0xab, 0xf5, 0x0c, 0xcc, 0x8f, 0xee, 0x09, 0x34,
+	0x4a, 0x23, 0x35, 0x6a, 0xa3, 0x8c, 0xfa, 0x35, 0x88, 0x96, 0x05, 0x53, 0x29, 0x9c, 0xe9, 0xa7,
+	0x9a, 0xd7, 0x87, 0xe6, 0x87, 0x94, 0x2e, 0x93, 0x01, 0x8d, 0x59, 0x30, 0x65, 0xc8, 0x06, 0x3d,
+	0x9c, 0x09, 0x57, 0x03, 0xeb, 0xe1, 0x0c, 0x21, 0xa8, 0x71, 0x8a, 0xb9, 0xa3, 0x38, 0x7b, 0x23,
+	0x68, 0x5d, 0xc5, 0x19, 0x49, 0x19, 0x26, 0xbf, 0x2f, 0x49, 0xc6, 0x38, 0xfc, 0x9c, 0x83, 0xe4,
+	0x7e, 0x52, 0x40, 0xaf, 0xc1, 0x64, 0x9c, 0xbe, 0xf0, 0xb5, 0xfa, 0xed, 0x47, 0x5f, 0x85, 0xa5,
+	0xd6, 0xbb, 0x06, 0xbb, 0x40, 0xcb, 0x12, 0x1a, 0x67, 0xa4, 0xca, 0x91, 0xa6, 0xe6, 0xe8, 0x35,
+	0x98, 0x84, 0x97, 0x60, 0x1d, 0x4e, 0x54, 0x06, 0x4b, 0xad, 0xf7, 0x97, 0x06, 0x87, 0x93, 0x34,
+	0x98, 0x92, 0x4b, 0x9a, 0x94, 0xec, 0xb5, 0x8a, 0x7d, 0x45, 0x56, 0x57, 0xc9, 0xba, 0x70, 0x38,
+	0x23, 0xd3, 0x30, 0x0b, 0x69, 0xec, 0x18, 0xc2, 0xba, 0x94, 0x79, 0x4e, 0x18, 0x15, 0xd9, 0x6e,
+	0x60, 0x9d, 0x51, 0x5e, 0xf9, 0x28, 0x60, 0x24, 0x9e, 0x3e, 0x38, 0xa6, 0xc0, 0x28, 0x44, 0x8e,
+	0x2d, 0x39, 0xd6, 0x65, 0x9e, 0x25, 0xa5, 0x7f, 0x34, 0x68, 0x8d, 0x28, 0x5d, 0x2c, 0x93, 0xed,
+	0x09, 0xcb, 0xeb, 0xa6, 0x57, 0xdd, 0x92, 0x17, 0x9a, 0x13, 0x32, 0x45, 0xa1, 0xd1, 0x3b, 0x00,
+	0x9a, 0x86, 0xf3, 0x30, 0x0e, 0x18, 0x4d, 0x05, 0x27, 0xab, 0xff, 0xb2, 0x4a, 0x85, 0x5a, 0x4b,
+	0xac, 0x58, 0xf2, 0x88, 0x8c, 0x67, 0x45, 0x30, 0x3e, 0xc4, 0x52, 0xf0, 0xfe, 0xd4, 0xc0, 0x2e,
+	0x98, 0xe5, 0xc9, 0x2f, 0xab, 0xa6, 0x6d, 0xab, 0xda, 0x8e, 0xd5, 0x40, 0xbd, 0x22, 0xac, 0xec,
+	0x6c, 0xa4, 0xa0, 0xe5, 0x35, 0x2a, 0xa8, 0x9c, 0x40, 0x6b, 0x48, 0x22, 0xc2, 0xc8, 0x9e, 0x39,
+	0xf2, 0x4e, 0xc0, 0x2e, 0x1c, 0xab, 0x4f, 0x90, 0xdc, 0xb4, 0xad, 0x9d, 0x72, 0x09, 0x4d, 0x4c,
+	0x62, 0xf2, 0xc7, 0x13, 0x8a, 0x22, 0xa7, 0xcf, 0x7b, 0x07, 0xad, 0x1c, 0x69, 0x3f, 0x06, 0x23,
+	0xb0, 0x31, 0x89, 0x48, 0x90, 0xed, 0xfb, 0xd1, 0x65, 0x63, 0x1b, 0xca, 0x58, 0x9e, 0x42, 0xbb,
+	0x44, 0xdb, 0x8f, 0xc7, 0x19, 0xd8, 0xe7, 0xb3, 0xd9, 0x0d, 0x21, 0xe9, 0x76, 0x1e, 0x9b, 0x96,
+	0xc1, 0x29, 0xb4, 0x4b, 0xdf, 0xfd, 0xa2, 0xb6, 0xc0, 0xba, 0x09, 0xe3, 0x79, 0x1e, 0xd2, 0xb3,
+	0xa1, 0x29, 0x45, 0x89, 0xe2, 0x7d, 0x02, 0x24, 0xf7, 0xc2, 0xcf, 0x01, 0x9b, 0x7e, 0xd9, 0x4e,
+	0xec, 0x0d, 0xd4, 0x45, 0x5b, 0xca, 0xb5, 0xba, 0xa1, 0x6b, 0x73, 0x35, 0x6f, 0x78, 0x24, 0x1b,
+	0x7e, 0x07, 0x54, 0x04, 0xb5, 0x05, 0x79, 0x90, 0x98, 0x4d, 0x2c, 0xce, 0xff, 0xdf, 0x44, 0x7a,
+	0xf7, 0x60, 0xe5, 0x1c, 0xb2, 0x65, 0xc4, 0x36, 0x3c, 0x15, 0xbb, 0xed, 0xcf, 0x2a, 0xdb, 0xc6,
+	0xd6, 0x6c, 0xcf, 0xa1, 0x55, 0x84, 0x93, 0x55, 0x7a, 0x0b, 0x07, 0xa9, 0x08, 0x2d, 0xf7, 0xac,
+	0xd5, 0x7f, 0x51, 0x79, 0x2a, 0xc4, 0x70, 0x61, 0xb5, 0xe3, 0xc8, 0x7f, 0xb7, 0x80, 0x46, 0xf9,
+	0x54, 0x22, 0x0b, 0x0e, 0x6e, 0xfd, 0x8f, 0xfe, 0xf8, 0x37, 0xbf, 0xf3, 0x0c, 0xb5, 0xc1, 0x9a,
+	0x4c, 0x46, 0x77, 0xf8, 0xe2, 0x7c, 0x70, 0x79, 0x31, 0xec, 0x68, 0xfc, 0xc2, 0x1f, 0xdf, 0x0d,
+	0xc6, 0xfe, 0xe4, 0x7c, 0x30, 0xf9, 0xd4, 0xd1, 0xb9, 0xf9, 0x95, 0xff, 0xeb, 0xf9, 0xe8, 0x6a,
+	0xd8, 0x31, 0xd0, 0x73, 0x68, 0x7f, 0xc0, 0xe3, 0xdb, 0x9b, 0x3b, 0x7f, 0x3c, 0xb9, 0x7b, 0x3f,
+	0xbe, 0xf5, 0x87, 0x9d, 0x1a, 0x6a, 0x41, 0xa3, 0x12, 0xcd, 0xfe, 0xdf, 0x26, 0xd4, 0x3f, 0x0a,
+	0x1a, 0xe8, 0x47, 0xa8, 0xcb, 0x7e, 0x41, 0xaf, 0x2a, 0x66, 0x2b, 0xef, 0x94, 0xeb, 0xac, 0x2b,
+	0xf2, 0x66, 0x7b, 0xc6, 0xdd, 0x65, 0x63, 0xa8, 0xee, 0x2b, 0x5b, 0xdb, 0x75, 0xd6, 0x15, 0xaa,
+	0xbb, 0xdc, 0x42, 0xaa, 0xfb, 0xca, 0x42, 0x73, 0x9d, 0x75, 0x45, 0xe9, 0x7e, 0x06, 0xa6, 0xd8,
+	0x20, 0x48, 0xe9, 0x1c, 0x75, 0x39, 0xb9, 0xaf, 0xd6, 0xee, 0x4b, 0xdf, 0x9f, 0xe0, 0x20, 0x9f,
+	0x7b, 0xe4, 0xa8, 0x56, 0xea, 0x62, 0x71, 0x8f, 0x36, 0x68, 0x54, 0x84, 0x7c, 0x86, 0x55, 0x84,
+	0xd5, 0x95, 0xe0, 0x1e, 0x6d, 0xd0, 0x94, 0x08, 0x27, 0x50, 0xe3, 0xc3, 0x8b, 0x94, 0x1e, 0x52,
+	0x66, 0xdb, 0x7d, 0xf9, 0xf8, 0xba, 0x74, 0x7c, 0x0f, 0x96, 0x32, 0xe5, 0xe8, 0xdb, 0xc7, 0x15,
+	0x52, 0xc7, 0x54, 0x4d, 0xc2, 0x4a, 0x2f, 0x4b, 0x1c, 0x65, 0xae, 0x55, 0x9c, 0xf5, 0x71, 0xdf,
+	0x86, 0x33, 0x84, 0xc6, 0x35, 0xfd, 0x4a, 0x9e, 0xc6, 0xe6, 0x73, 0x5d, 0xfc, 0x5e, 0xfe, 0xf0,
+	0xdf, 0x00, 0xc7, 0xb3, 0x53, 0xec, 0x77, 0x0a, 0x00, 0x00,
+}
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ context.Context
+var _ grpc.ClientConnInterface
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+const _ = grpc.SupportPackageIsVersion6
+
+// KelipsClient is the client API for Kelips service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://godoc.org/google.golang.org/grpc#ClientConn.NewStream.
+type KelipsClient interface {
+	Insert(ctx context.Context, in *InsertRequest, opts ...grpc.CallOption) (*InsertResponse, error)
+	Lookup(ctx context.Context, in *LookupRequest, opts ...grpc.CallOption) (*LookupResponse, error)
+	Delete(ctx context.Context, in *DeleteRequest, opts ...grpc.CallOption) (*DeleteResponse, error)
+	Renew(ctx context.Context, in *RenewRequest, opts ...grpc.CallOption) (*RenewResponse, error)
+	Release(ctx context.Context, in *ReleaseRequest, opts ...grpc.CallOption) (*ReleaseResponse, error)
+	AddPeer(ctx context.Context, in *AddPeerRequest, opts ...grpc.CallOption) (*AddPeerResponse, error)
+	Ping(ctx context.Context, in *PingRequest, opts ...grpc.CallOption) (*PingResponse, error)
+	InsertBatch(ctx context.Context, in *InsertBatchRequest, opts ...grpc.CallOption) (*BatchResponse, error)
+	LookupBatch(ctx context.Context, in *LookupBatchRequest, opts ...grpc.CallOption) (*BatchResponse, error)
+	// MoveBatch inserts tuples moved between groups by a resize keeping
+	// their hosts
+	MoveBatch(ctx context.Context, in *InsertBatchRequest, opts ...grpc.CallOption) (*BatchResponse, error)
+}
+
+type kelipsClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewKelipsClient(cc grpc.ClientConnInterface) KelipsClient {
+	return &kelipsClient{cc}
+}
+
+func (c *kelipsClient) Insert(ctx context.Context, in *InsertRequest, opts ...grpc.CallOption) (*InsertResponse, error) {
+	out := new(InsertResponse)
+	err := c.cc.Invoke(ctx, "/kelipspb.Kelips/Insert", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *kelipsClient) Lookup(ctx context.Context, in *LookupRequest, opts ...grpc.CallOption) (*LookupResponse, error) {
+	out := new(LookupResponse)
+	err := c.cc.Invoke(ctx, "/kelipspb.Kelips/Lookup", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *kelipsClient) Delete(ctx context.Context, in *DeleteRequest, opts ...grpc.CallOption) (*DeleteResponse, error) {
+	out := new(DeleteResponse)
+	err := c.cc.Invoke(ctx, "/kelipspb.Kelips/Delete", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *kelipsClient) Renew(ctx context.Context, in *RenewRequest, opts ...grpc.CallOption) (*RenewResponse, error) {
+	out := new(RenewResponse)
+	err := c.cc.Invoke(ctx, "/kelipspb.Kelips/Renew", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *kelipsClient) Release(ctx context.Context, in *ReleaseRequest, opts ...grpc.CallOption) (*ReleaseResponse, error) {
+	out := new(ReleaseResponse)
+	err := c.cc.Invoke(ctx, "/kelipspb.Kelips/Release", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *kelipsClient) AddPeer(ctx context.Context, in *AddPeerRequest, opts ...grpc.CallOption) (*AddPeerResponse, error) {
+	out := new(AddPeerResponse)
+	err := c.cc.Invoke(ctx, "/kelipspb.Kelips/AddPeer", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *kelipsClient) Ping(ctx context.Context, in *PingRequest, opts ...grpc.CallOption) (*PingResponse, error) {
+	out := new(PingResponse)
+	err := c.cc.Invoke(ctx, "/kelipspb.Kelips/Ping", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *kelipsClient) InsertBatch(ctx context.Context, in *InsertBatchRequest, opts ...grpc.CallOption) (*BatchResponse, error) {
+	out := new(BatchResponse)
+	err := c.cc.Invoke(ctx, "/kelipspb.Kelips/InsertBatch", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *kelipsClient) LookupBatch(ctx context.Context, in *LookupBatchRequest, opts ...grpc.CallOption) (*BatchResponse, error) {
+	out := new(BatchResponse)
+	err := c.cc.Invoke(ctx, "/kelipspb.Kelips/LookupBatch", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *kelipsClient) MoveBatch(ctx context.Context, in *InsertBatchRequest, opts ...grpc.CallOption) (*BatchResponse, error) {
+	out := new(BatchResponse)
+	err := c.cc.Invoke(ctx, "/kelipspb.Kelips/MoveBatch", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// KelipsServer is the server API for Kelips service.
+type KelipsServer interface {
+	Insert(context.Context, *InsertRequest) (*InsertResponse, error)
+	Lookup(context.Context, *LookupRequest) (*LookupResponse, error)
+	Delete(context.Context, *DeleteRequest) (*DeleteResponse, error)
+	Renew(context.Context, *RenewRequest) (*RenewResponse, error)
+	Release(context.Context, *ReleaseRequest) (*ReleaseResponse, error)
+	AddPeer(context.Context, *AddPeerRequest) (*AddPeerResponse, error)
+	Ping(context.Context, *PingRequest) (*PingResponse, error)
+	InsertBatch(context.Context, *InsertBatchRequest) (*BatchResponse, error)
+	LookupBatch(context.Context, *LookupBatchRequest) (*BatchResponse, error)
+	// MoveBatch inserts tuples moved between groups by a resize keeping
+	// their hosts
+	MoveBatch(context.Context, *InsertBatchRequest) (*BatchResponse, error)
+}
+
+// UnimplementedKelipsServer can be embedded to have forward compatible implementations.
+type UnimplementedKelipsServer struct {
+}
+
+func (*UnimplementedKelipsServer) Insert(ctx context.Context, req *InsertRequest) (*InsertResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Insert not implemented")
+}
+func (*UnimplementedKelipsServer) Lookup(ctx context.Context, req *LookupRequest) (*LookupResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Lookup not implemented")
+}
+func (*UnimplementedKelipsServer) Delete(ctx context.Context, req *DeleteRequest) (*DeleteResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Delete not implemented")
+}
+func (*UnimplementedKelipsServer) Renew(ctx context.Context, req *RenewRequest) (*RenewResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Renew not implemented")
+}
+func (*UnimplementedKelipsServer) Release(ctx context.Context, req *ReleaseRequest) (*ReleaseResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Release not implemented")
+}
+func (*UnimplementedKelipsServer) AddPeer(ctx context.Context, req *AddPeerRequest) (*AddPeerResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method AddPeer not implemented")
+}
+func (*UnimplementedKelipsServer) Ping(ctx context.Context, req *PingRequest) (*PingResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Ping not implemented")
+}
+func (*UnimplementedKelipsServer) InsertBatch(ctx context.Context, req *InsertBatchRequest) (*BatchResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method InsertBatch not implemented")
+}
+func (*UnimplementedKelipsServer) LookupBatch(ctx context.Context, req *LookupBatchRequest) (*BatchResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method LookupBatch not implemented")
+}
+func (*UnimplementedKelipsServer) MoveBatch(ctx context.Context, req *InsertBatchRequest) (*BatchResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method MoveBatch not implemented")
+}
+
+func RegisterKelipsServer(s *grpc.Server, srv KelipsServer) {
+	s.RegisterService(&_Kelips_serviceDesc, srv)
+}
+
+func _Kelips_Insert_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(InsertRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(KelipsServer).Insert(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/kelipspb.Kelips/Insert",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(KelipsServer).Insert(ctx, req.(*InsertRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Kelips_Lookup_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(LookupRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(KelipsServer).Lookup(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/kelipspb.Kelips/Lookup",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(KelipsServer).Lookup(ctx, req.(*LookupRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Kelips_Delete_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeleteRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(KelipsServer).Delete(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/kelipspb.Kelips/Delete",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(KelipsServer).Delete(ctx, req.(*DeleteRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Kelips_Renew_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RenewRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(KelipsServer).Renew(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/kelipspb.Kelips/Renew",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(KelipsServer).Renew(ctx, req.(*RenewRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Kelips_Release_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ReleaseRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(KelipsServer).Release(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/kelipspb.Kelips/Release",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(KelipsServer).Release(ctx, req.(*ReleaseRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Kelips_AddPeer_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AddPeerRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(KelipsServer).AddPeer(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/kelipspb.Kelips/AddPeer",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(KelipsServer).AddPeer(ctx, req.(*AddPeerRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Kelips_Ping_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PingRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(KelipsServer).Ping(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/kelipspb.Kelips/Ping",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(KelipsServer).Ping(ctx, req.(*PingRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Kelips_InsertBatch_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(InsertBatchRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(KelipsServer).InsertBatch(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/kelipspb.Kelips/InsertBatch",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(KelipsServer).InsertBatch(ctx, req.(*InsertBatchRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Kelips_LookupBatch_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(LookupBatchRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(KelipsServer).LookupBatch(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/kelipspb.Kelips/LookupBatch",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(KelipsServer).LookupBatch(ctx, req.(*LookupBatchRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Kelips_MoveBatch_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(InsertBatchRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(KelipsServer).MoveBatch(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/kelipspb.Kelips/MoveBatch",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(KelipsServer).MoveBatch(ctx, req.(*InsertBatchRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+var _Kelips_serviceDesc = grpc.ServiceDesc{
+	ServiceName: "kelipspb.Kelips",
+	HandlerType: (*KelipsServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Insert",
+			Handler:    _Kelips_Insert_Handler,
+		},
+		{
+			MethodName: "Lookup",
+			Handler:    _Kelips_Lookup_Handler,
+		},
+		{
+			MethodName: "Delete",
+			Handler:    _Kelips_Delete_Handler,
+		},
+		{
+			MethodName: "Renew",
+			Handler:    _Kelips_Renew_Handler,
+		},
+		{
+			MethodName: "Release",
+			Handler:    _Kelips_Release_Handler,
+		},
+		{
+			MethodName: "AddPeer",
+			Handler:    _Kelips_AddPeer_Handler,
+		},
+		{
+			MethodName: "Ping",
+			Handler:    _Kelips_Ping_Handler,
+		},
+		{
+			MethodName: "InsertBatch",
+			Handler:    _Kelips_InsertBatch_Handler,
+		},
+		{
+			MethodName: "LookupBatch",
+			Handler:    _Kelips_LookupBatch_Handler,
+		},
+		{
+			MethodName: "MoveBatch",
+			Handler:    _Kelips_MoveBatch_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "kelipspb/kelips.proto",
+}
@@ -0,0 +1,407 @@
+package kelips
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+)
+
+var (
+	errHostUnreachable = errors.New("host unreachable")
+	errMessageDropped  = errors.New("message dropped")
+)
+
+// Hop is a directed link between two hosts of a LoopbackNetwork
+type Hop struct {
+	From string
+	To   string
+}
+
+// LoopbackNetwork routes requests between LoopbackTransports in the same
+// process.  Latency, message drops and partitions can be injected and
+// messages are counted per hop, allowing routing to be tested
+// deterministically without binding ports
+type LoopbackNetwork struct {
+	mu sync.RWMutex
+
+	// Transports by host
+	hosts map[string]*LoopbackTransport
+
+	// Latency applied to every message unless overridden for the hop
+	latency     time.Duration
+	hopLatency  map[Hop]time.Duration
+	droppedHops map[Hop]bool
+	// Probability of dropping any message
+	dropRate float64
+	rand     *rand.Rand
+
+	// Partition id by host. Hosts in different partitions cannot reach each
+	// other.  Hosts without a partition can reach everyone
+	partitions map[string]int
+
+	// Delivered messages per hop
+	counts map[Hop]int
+}
+
+// NewLoopbackNetwork returns a new network.  The seed is used for random
+// message drops so simulations are reproducible
+func NewLoopbackNetwork(seed int64) *LoopbackNetwork {
+	return &LoopbackNetwork{
+		hosts:       make(map[string]*LoopbackTransport),
+		hopLatency:  make(map[Hop]time.Duration),
+		droppedHops: make(map[Hop]bool),
+		rand:        rand.New(rand.NewSource(seed)),
+		partitions:  make(map[string]int),
+		counts:      make(map[Hop]int),
+	}
+}
+
+// Transport returns a new transport for the host attached to the network.
+// The host is reachable once the transport is started
+func (n *LoopbackNetwork) Transport(host string) *LoopbackTransport {
+	trans := &LoopbackTransport{
+		host:   host,
+		net:    n,
+		groups: make(map[int64]AffinityGroup),
+	}
+
+	n.mu.Lock()
+	n.hosts[host] = trans
+	n.mu.Unlock()
+
+	return trans
+}
+
+// SetLatency sets the latency applied to all messages
+func (n *LoopbackNetwork) SetLatency(d time.Duration) {
+	n.mu.Lock()
+	n.latency = d
+	n.mu.Unlock()
+}
+
+// SetHopLatency sets the latency of messages from one host to another
+// overriding the network latency
+func (n *LoopbackNetwork) SetHopLatency(from, to string, d time.Duration) {
+	n.mu.Lock()
+	n.hopLatency[Hop{From: from, To: to}] = d
+	n.mu.Unlock()
+}
+
+// SetDropRate sets the probability in [0, 1] of dropping any message
+func (n *LoopbackNetwork) SetDropRate(rate float64) {
+	n.mu.Lock()
+	n.dropRate = rate
+	n.mu.Unlock()
+}
+
+// DropHop drops all messages from one host to another until healed
+func (n *LoopbackNetwork) DropHop(from, to string) {
+	n.mu.Lock()
+	n.droppedHops[Hop{From: from, To: to}] = true
+	n.mu.Unlock()
+}
+
+// Partition splits the network so hosts in different sets cannot reach each
+// other.  Hosts not in any set can reach all hosts.  It replaces any
+// previous partitioning
+func (n *LoopbackNetwork) Partition(sets ...[]string) {
+	n.mu.Lock()
+	n.partitions = make(map[string]int)
+	for i, set := range sets {
+		for _, host := range set {
+			n.partitions[host] = i + 1
+		}
+	}
+	n.mu.Unlock()
+}
+
+// Heal removes all partitions, dropped hops and the drop rate.  Latencies are
+// left unchanged
+func (n *LoopbackNetwork) Heal() {
+	n.mu.Lock()
+	n.partitions = make(map[string]int)
+	n.droppedHops = make(map[Hop]bool)
+	n.dropRate = 0
+	n.mu.Unlock()
+}
+
+// Messages returns the number of messages delivered from one host to another
+func (n *LoopbackNetwork) Messages(from, to string) int {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	return n.counts[Hop{From: from, To: to}]
+}
+
+// Counts returns a copy of the delivered message counts per hop
+func (n *LoopbackNetwork) Counts() map[Hop]int {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+
+	out := make(map[Hop]int, len(n.counts))
+	for k, v := range n.counts {
+		out[k] = v
+	}
+	return out
+}
+
+// TotalMessages returns the number of messages delivered across all hops
+func (n *LoopbackNetwork) TotalMessages() int {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+
+	var c int
+	for _, v := range n.counts {
+		c += v
+	}
+	return c
+}
+
+// ResetCounts clears all message counts
+func (n *LoopbackNetwork) ResetCounts() {
+	n.mu.Lock()
+	n.counts = make(map[Hop]int)
+	n.mu.Unlock()
+}
+
+// deliver routes a message from one host to another applying faults and
+// latency.  It returns the destination transport once the message has
+// arrived
+func (n *LoopbackNetwork) deliver(ctx context.Context, from, to string) (*LoopbackTransport, error) {
+	hop := Hop{From: from, To: to}
+
+	n.mu.Lock()
+	dst, ok := n.hosts[to]
+	if !ok || !dst.isRunning() || !n.reachable(from, to) {
+		n.mu.Unlock()
+		return nil, fmt.Errorf("%s: %v", to, errHostUnreachable)
+	}
+
+	if n.droppedHops[hop] || (n.dropRate > 0 && n.rand.Float64() < n.dropRate) {
+		n.mu.Unlock()
+		return nil, fmt.Errorf("%s: %v", to, errMessageDropped)
+	}
+
+	latency, ok := n.hopLatency[hop]
+	if !ok {
+		latency = n.latency
+	}
+	n.mu.Unlock()
+
+	if latency > 0 && !sleepContext(ctx, latency) {
+		return nil, ctx.Err()
+	}
+
+	n.mu.Lock()
+	n.counts[hop]++
+	n.mu.Unlock()
+
+	return dst, nil
+}
+
+// reachable must be called with the lock held
+func (n *LoopbackNetwork) reachable(from, to string) bool {
+	pf, ok := n.partitions[from]
+	if !ok {
+		return true
+	}
+	pt, ok := n.partitions[to]
+	if !ok {
+		return true
+	}
+	return pf == pt
+}
+
+// LoopbackTransport implements an in-process Transport interface attached to
+// a LoopbackNetwork
+type LoopbackTransport struct {
+	// local host
+	host string
+	net  *LoopbackNetwork
+
+	mu      sync.RWMutex
+	running bool
+	// Registered groups
+	groups map[int64]AffinityGroup
+}
+
+// Start makes the host reachable on the network.  The listener is not used
+// and may be nil
+func (trans *LoopbackTransport) Start(ln net.Listener) error {
+	trans.mu.Lock()
+	trans.running = true
+	trans.mu.Unlock()
+	return nil
+}
+
+// Shutdown makes the host unreachable
+func (trans *LoopbackTransport) Shutdown(ctx context.Context) error {
+	trans.mu.Lock()
+	trans.running = false
+	trans.mu.Unlock()
+	return nil
+}
+
+// Register the affinity group with the transport
+func (trans *LoopbackTransport) Register(contact GroupContact, group AffinityGroup) {
+	trans.mu.Lock()
+	trans.groups[contact.ID] = group
+	trans.mu.Unlock()
+}
+
+// Unregister removes the affinity group from the transport
+func (trans *LoopbackTransport) Unregister(contact GroupContact) {
+	trans.mu.Lock()
+	delete(trans.groups, contact.ID)
+	trans.mu.Unlock()
+}
+
+func (trans *LoopbackTransport) isRunning() bool {
+	trans.mu.RLock()
+	defer trans.mu.RUnlock()
+	return trans.running
+}
+
+// group delivers a message to the contact returning its group
+func (trans *LoopbackTransport) group(ctx context.Context, contact GroupContact) (AffinityGroup, error) {
+	dst, err := trans.net.deliver(ctx, trans.host, contact.Host)
+	if err != nil {
+		return nil, err
+	}
+
+	dst.mu.RLock()
+	group, ok := dst.groups[contact.ID]
+	dst.mu.RUnlock()
+	if !ok {
+		return nil, &RemoteError{Code: ErrCodeGroupNotFound, Message: "group not found"}
+	}
+	return group, nil
+}
+
+// Insert tuple at remote group
+func (trans *LoopbackTransport) Insert(ctx context.Context, contact GroupContact, tuple *Tuple) ([]string, error) {
+	group, err := trans.group(ctx, contact)
+	if err != nil {
+		return nil, err
+	}
+
+	hosts, err := group.Insert(ctx, tuple.Clone())
+	return hosts, loopbackRemoteError(err)
+}
+
+// Lookup should return the tuple with the home nodes of the key
+func (trans *LoopbackTransport) Lookup(ctx context.Context, contact GroupContact, req *Request) (*Tuple, error) {
+	group, err := trans.group(ctx, contact)
+	if err != nil {
+		return nil, err
+	}
+
+	r := *req
+	tuple, err := group.Lookup(ctx, &r)
+	return tuple, loopbackRemoteError(err)
+}
+
+// Delete should remove the key from the group
+func (trans *LoopbackTransport) Delete(ctx context.Context, contact GroupContact, key []byte) error {
+	group, err := trans.group(ctx, contact)
+	if err != nil {
+		return err
+	}
+	return loopbackRemoteError(group.Delete(ctx, key))
+}
+
+// Renew should restart the lease of the key in the group
+func (trans *LoopbackTransport) Renew(ctx context.Context, contact GroupContact, key []byte, ttl time.Duration) error {
+	group, err := trans.group(ctx, contact)
+	if err != nil {
+		return err
+	}
+	return loopbackRemoteError(group.Renew(ctx, key, ttl))
+}
+
+// Release should remove the host from the home nodes of the key in the group
+func (trans *LoopbackTransport) Release(ctx context.Context, contact GroupContact, key []byte, host string) error {
+	group, err := trans.group(ctx, contact)
+	if err != nil {
+		return err
+	}
+	return loopbackRemoteError(group.Release(ctx, key, host))
+}
+
+// InsertBatch inserts all tuples at the remote group in a single message
+func (trans *LoopbackTransport) InsertBatch(ctx context.Context, contact GroupContact, tuples []*Tuple) ([]BatchResult, error) {
+	group, err := trans.group(ctx, contact)
+	if err != nil {
+		return nil, err
+	}
+
+	cloned := make([]*Tuple, 0, len(tuples))
+	for _, t := range tuples {
+		cloned = append(cloned, t.Clone())
+	}
+
+	return loopbackRemoteErrors(group.InsertBatch(ctx, cloned)), nil
+}
+
+// MoveBatch inserts all tuples keeping their hosts at the remote group in a
+// single message
+func (trans *LoopbackTransport) MoveBatch(ctx context.Context, contact GroupContact, tuples []*Tuple) ([]BatchResult, error) {
+	group, err := trans.group(ctx, contact)
+	if err != nil {
+		return nil, err
+	}
+
+	cloned := make([]*Tuple, 0, len(tuples))
+	for _, t := range tuples {
+		cloned = append(cloned, t.Clone())
+	}
+
+	return loopbackRemoteErrors(group.MoveBatch(ctx, cloned)), nil
+}
+
+// LookupBatch looks up all keys at the remote group in a single message
+func (trans *LoopbackTransport) LookupBatch(ctx context.Context, contact GroupContact, req *BatchRequest) ([]BatchResult, error) {
+	group, err := trans.group(ctx, contact)
+	if err != nil {
+		return nil, err
+	}
+
+	r := *req
+	return loopbackRemoteErrors(group.LookupBatch(ctx, &r)), nil
+}
+
+// AddPeer adds the peer to the remote group
+func (trans *LoopbackTransport) AddPeer(ctx context.Context, contact GroupContact, peer PeerContact) error {
+	group, err := trans.group(ctx, contact)
+	if err != nil {
+		return err
+	}
+	p := &Peer{Host: peer.Address()}
+	p.Zone, p.Rack = contactLabels(peer)
+	return loopbackRemoteError(group.AddPeer(ctx, p))
+}
+
+// Ping makes a round trip to the host
+func (trans *LoopbackTransport) Ping(ctx context.Context, host string) error {
+	_, err := trans.net.deliver(ctx, trans.host, host)
+	return err
+}
+
+// loopbackRemoteError converts errors returned by a reached group as a
+// network transport would
+func loopbackRemoteError(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &RemoteError{Code: errorCode(err), Message: err.Error()}
+}
+
+func loopbackRemoteErrors(results []BatchResult) []BatchResult {
+	for i := range results {
+		results[i].Err = loopbackRemoteError(results[i].Err)
+	}
+	return results
+}
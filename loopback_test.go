@@ -0,0 +1,189 @@
+package kelips
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/hexablock/log"
+	"github.com/stretchr/testify/assert"
+)
+
+// makeLoopbackNetwork returns n fully connected nodes on the network.  Only
+// the transports are started so no background messages are sent
+func makeLoopbackNetwork(network *LoopbackNetwork, n int, k int64) []*Kelips {
+	return makeLoopbackNetworkWith(network, n, k, nil)
+}
+
+// makeLoopbackNetworkWith is makeLoopbackNetwork with all nodes recording to
+// the given collector
+func makeLoopbackNetworkWith(network *LoopbackNetwork, n int, k int64, metrics MetricsCollector) []*Kelips {
+	hosts := make([]string, n)
+	for i := range hosts {
+		hosts[i] = fmt.Sprintf("10.0.0.%d:4000", i+1)
+	}
+	return makeLoopbackHosts(network, hosts, k, metrics)
+}
+
+// makeLoopbackHosts returns fully connected nodes with the given hosts
+func makeLoopbackHosts(network *LoopbackNetwork, hosts []string, k int64, metrics MetricsCollector) []*Kelips {
+	knet := make([]*Kelips, len(hosts))
+	for i, host := range hosts {
+		knet[i] = New(host, &Config{
+			K:              k,
+			ContactRetries: 2,
+			Transport:      network.Transport(host),
+			Metrics:        metrics,
+			Logger:         log.NewDefaultLogger(),
+		})
+		knet[i].trans.Start(nil)
+	}
+
+	for _, kn := range knet {
+		for _, peer := range knet {
+			if kn != peer {
+				kn.AddPeer(&Peer{Host: peer.groups[peer.id].Contact().Host})
+			}
+		}
+	}
+
+	return knet
+}
+
+// loopbackTransport returns a transport for the host on a network of its
+// own
+func loopbackTransport(host string) *LoopbackTransport {
+	return NewLoopbackNetwork(1).Transport(host)
+}
+
+func hostOf(klp *Kelips) string {
+	return klp.groups[klp.id].Contact().Host
+}
+
+func Test_LoopbackNetwork(t *testing.T) {
+	network := NewLoopbackNetwork(1)
+	knet := makeLoopbackNetwork(network, 9, 3)
+
+	// Pick a group with two members along with a key in it so forwarding
+	// within the group is deterministic
+	members := make(map[int64][]*Kelips)
+	for _, kn := range knet {
+		members[kn.id] = append(members[kn.id], kn)
+	}
+	var gid int64 = -1
+	for id, m := range members {
+		if len(m) == 2 {
+			gid = id
+			break
+		}
+	}
+	if gid < 0 {
+		t.Fatal("no group with two members")
+	}
+
+	var key []byte
+	for i := 0; ; i++ {
+		key = []byte(fmt.Sprintf("key-%d", i))
+		if lookupGroup(key, 3, knet[0].hasher()) == gid {
+			break
+		}
+	}
+
+	var src, dst *Kelips
+	for _, kn := range knet {
+		if kn.id != gid {
+			if src == nil {
+				src = kn
+			} else if dst == nil {
+				dst = kn
+			}
+		}
+	}
+
+	// A remote insert is a single hop
+	hosts, err := src.Insert(key)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, network.TotalMessages())
+
+	holder, other := members[gid][0], members[gid][1]
+	if network.Messages(hostOf(src), hostOf(other)) == 1 {
+		holder, other = other, holder
+	}
+	assert.NotNil(t, holder.groups[gid].(*affinityGroup).tuples.Lookup(key))
+	assert.Nil(t, other.groups[gid].(*affinityGroup).tuples.Lookup(key))
+
+	for i, kn := range knet {
+		tuple, err := kn.Lookup(&Request{Key: key, TTL: 1})
+		assert.Nil(t, err, "node=%d", i)
+		assert.Equal(t, hosts, tuple.Hosts)
+	}
+
+	// Never more than one hop into the group plus one forward within it
+	network.ResetCounts()
+	_, err = dst.Lookup(&Request{Key: key, TTL: 1})
+	assert.Nil(t, err)
+	assert.True(t, network.TotalMessages() <= 2)
+
+	// Isolate the group from dst
+	network.Partition([]string{hostOf(holder), hostOf(other)}, []string{hostOf(dst)})
+
+	network.ResetCounts()
+	_, err = dst.Lookup(&Request{Key: key, TTL: 1})
+	assert.NotNil(t, err)
+	assert.Equal(t, 0, network.TotalMessages())
+
+	// Dropping the hop to the holder is retried against the other member
+	// which forwards the lookup
+	network.Heal()
+	network.ResetCounts()
+	network.DropHop(hostOf(dst), hostOf(holder))
+	tuple, err := dst.Lookup(&Request{Key: key, TTL: 1})
+	assert.Nil(t, err)
+	assert.Equal(t, hosts, tuple.Hosts)
+	assert.Equal(t, 0, network.Messages(hostOf(dst), hostOf(holder)))
+	assert.Equal(t, 1, network.Messages(hostOf(dst), hostOf(other)))
+	assert.Equal(t, 1, network.Messages(hostOf(other), hostOf(holder)))
+
+	// Errors from a reached group are remote errors
+	network.Heal()
+	_, err = dst.Lookup(&Request{Key: []byte("missing"), TTL: 0})
+	assert.Equal(t, ErrCodeTTLReached, errorCode(err))
+
+	// Latency is bounded by the context
+	network.SetLatency(50 * time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = dst.LookupContext(ctx, &Request{Key: key, TTL: 1})
+	assert.NotNil(t, err)
+	network.SetLatency(0)
+
+	// Stopped hosts are unreachable
+	for _, m := range members[gid] {
+		m.Shutdown(context.Background())
+	}
+	_, err = dst.Lookup(&Request{Key: key, TTL: 1})
+	assert.NotNil(t, err)
+}
+
+func Test_LoopbackNetwork_dropRate(t *testing.T) {
+	network := NewLoopbackNetwork(7)
+	knet := makeLoopbackNetwork(network, 2, 1)
+
+	src, dst := hostOf(knet[0]), hostOf(knet[1])
+	ping := knet[0].trans
+
+	network.SetDropRate(1)
+	assert.NotNil(t, ping.Ping(context.Background(), dst))
+
+	network.SetDropRate(0.5)
+	var dropped int
+	for i := 0; i < 100; i++ {
+		if ping.Ping(context.Background(), dst) != nil {
+			dropped++
+		}
+	}
+	assert.True(t, dropped > 0 && dropped < 100, "dropped=%d", dropped)
+	assert.Equal(t, 100-dropped, network.Messages(src, dst))
+	assert.Equal(t, 100-dropped, network.Counts()[Hop{From: src, To: dst}])
+}
@@ -0,0 +1,131 @@
+package kelips
+
+import (
+	"encoding/binary"
+	"hash"
+)
+
+// GroupMapper maps keys and node hosts to one of k affinity groups.  All
+// nodes in a cluster must use the same mapping
+type GroupMapper interface {
+	// KeyGroup returns the group the key belongs to
+	KeyGroup(key []byte, k int64, h hash.Hash) int64
+	// NodeGroup returns the home group of the host
+	NodeGroup(host string, k int64, h hash.Hash) int64
+}
+
+// ModuloMapper maps the hash of keys and hosts modulo k.  Changing k moves
+// most keys to another group
+type ModuloMapper struct{}
+
+// KeyGroup satisfies the GroupMapper interface
+func (ModuloMapper) KeyGroup(key []byte, k int64, h hash.Hash) int64 {
+	return lookupGroup(key, k, h)
+}
+
+// NodeGroup satisfies the GroupMapper interface
+func (ModuloMapper) NodeGroup(host string, k int64, h hash.Hash) int64 {
+	return lookupGroup([]byte(host), k, h)
+}
+
+// JumpMapper maps the hash of keys and hosts with jump consistent hashing.
+// Growing from k to k+1 groups only moves about 1/(k+1) of the keys
+type JumpMapper struct{}
+
+// KeyGroup satisfies the GroupMapper interface
+func (JumpMapper) KeyGroup(key []byte, k int64, h hash.Hash) int64 {
+	return jumpGroup(key, k, h)
+}
+
+// NodeGroup satisfies the GroupMapper interface
+func (JumpMapper) NodeGroup(host string, k int64, h hash.Hash) int64 {
+	return jumpGroup([]byte(host), k, h)
+}
+
+// TableMapper pins hosts to groups.  Hosts not in the table or pinned to a
+// group outside of k, along with all keys, are mapped by the fallback which
+// defaults to ModuloMapper
+type TableMapper struct {
+	Nodes    map[string]int64
+	Fallback GroupMapper
+}
+
+// KeyGroup satisfies the GroupMapper interface
+func (m TableMapper) KeyGroup(key []byte, k int64, h hash.Hash) int64 {
+	return m.fallback().KeyGroup(key, k, h)
+}
+
+// NodeGroup satisfies the GroupMapper interface
+func (m TableMapper) NodeGroup(host string, k int64, h hash.Hash) int64 {
+	if id, ok := m.Nodes[host]; ok && id >= 0 && id < k {
+		return id
+	}
+	return m.fallback().NodeGroup(host, k, h)
+}
+
+func (m TableMapper) fallback() GroupMapper {
+	if m.Fallback == nil {
+		return ModuloMapper{}
+	}
+	return m.Fallback
+}
+
+// SplitMapper maps keys and hosts with separate mappers.  Either defaults
+// to ModuloMapper
+type SplitMapper struct {
+	Keys  GroupMapper
+	Nodes GroupMapper
+}
+
+// KeyGroup satisfies the GroupMapper interface
+func (m SplitMapper) KeyGroup(key []byte, k int64, h hash.Hash) int64 {
+	if m.Keys == nil {
+		return ModuloMapper{}.KeyGroup(key, k, h)
+	}
+	return m.Keys.KeyGroup(key, k, h)
+}
+
+// NodeGroup satisfies the GroupMapper interface
+func (m SplitMapper) NodeGroup(host string, k int64, h hash.Hash) int64 {
+	if m.Nodes == nil {
+		return ModuloMapper{}.NodeGroup(host, k, h)
+	}
+	return m.Nodes.NodeGroup(host, k, h)
+}
+
+// keyGroup returns the group of the key.  Keys mapped outside of k groups
+// are mapped by ModuloMapper instead
+func keyGroup(m GroupMapper, key []byte, k int64, hasher func() hash.Hash) int64 {
+	if idx := m.KeyGroup(key, k, hasher()); idx >= 0 && idx < k {
+		return idx
+	}
+	return ModuloMapper{}.KeyGroup(key, k, hasher())
+}
+
+// nodeGroup returns the home group of the host.  Hosts mapped outside of k
+// groups are mapped by ModuloMapper instead
+func nodeGroup(m GroupMapper, host string, k int64, hasher func() hash.Hash) int64 {
+	if idx := m.NodeGroup(host, k, hasher()); idx >= 0 && idx < k {
+		return idx
+	}
+	return ModuloMapper{}.NodeGroup(host, k, hasher())
+}
+
+// jumpGroup returns the group of the key using the jump consistent hash of
+// Lamping and Veach over the first 8 bytes of the key hash
+func jumpGroup(key []byte, k int64, h hash.Hash) int64 {
+	h.Write(key)
+	sum := h.Sum(nil)
+
+	var buf [8]byte
+	copy(buf[:], sum)
+	x := binary.BigEndian.Uint64(buf[:])
+
+	var b, j int64 = -1, 0
+	for j < k {
+		b = j
+		x = x*2862933555777941757 + 1
+		j = int64(float64(b+1) * (float64(int64(1)<<31) / float64((x>>33)+1)))
+	}
+	return b
+}
@@ -0,0 +1,128 @@
+package kelips
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"hash"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_ModuloMapper(t *testing.T) {
+	var m ModuloMapper
+	for i := 0; i < 100; i++ {
+		key := []byte(fmt.Sprintf("key-%d", i))
+		assert.Equal(t, lookupGroup(key, 7, sha256.New()), m.KeyGroup(key, 7, sha256.New()))
+		assert.Equal(t, m.KeyGroup(key, 7, sha256.New()), m.NodeGroup(string(key), 7, sha256.New()))
+	}
+}
+
+func Test_JumpMapper(t *testing.T) {
+	var m JumpMapper
+
+	const n = 10000
+	var moved int
+	counts := make([]int, 11)
+	for i := 0; i < n; i++ {
+		key := []byte(fmt.Sprintf("key-%d", i))
+		before := m.KeyGroup(key, 10, sha256.New())
+		after := m.KeyGroup(key, 11, sha256.New())
+		assert.True(t, before >= 0 && before < 10)
+		assert.Equal(t, before, m.KeyGroup(key, 10, sha256.New()))
+		assert.Equal(t, before, m.NodeGroup(string(key), 10, sha256.New()))
+
+		// Keys only move to the new group
+		if after != before {
+			assert.Equal(t, int64(10), after)
+			moved++
+		}
+		counts[after]++
+	}
+
+	// About 1/11 of the keys move and groups are balanced
+	assert.InDelta(t, n/11, moved, n/50)
+	for i, c := range counts {
+		assert.InDelta(t, n/11, c, n/50, "group=%d", i)
+	}
+	assert.Equal(t, int64(0), m.KeyGroup([]byte("key"), 1, sha256.New()))
+}
+
+func Test_TableMapper(t *testing.T) {
+	m := TableMapper{Nodes: map[string]int64{"10.0.0.1:4000": 2, "10.0.0.2:4000": 5}}
+
+	assert.Equal(t, int64(2), m.NodeGroup("10.0.0.1:4000", 3, sha256.New()))
+	// Unknown hosts and groups outside k use the fallback
+	assert.Equal(t, lookupGroup([]byte("10.0.0.2:4000"), 3, sha256.New()), m.NodeGroup("10.0.0.2:4000", 3, sha256.New()))
+	assert.Equal(t, lookupGroup([]byte("10.0.0.3:4000"), 3, sha256.New()), m.NodeGroup("10.0.0.3:4000", 3, sha256.New()))
+	assert.Equal(t, lookupGroup([]byte("key"), 3, sha256.New()), m.KeyGroup([]byte("key"), 3, sha256.New()))
+
+	m.Fallback = JumpMapper{}
+	assert.Equal(t, jumpGroup([]byte("key"), 3, sha256.New()), m.KeyGroup([]byte("key"), 3, sha256.New()))
+	assert.Equal(t, int64(5), m.NodeGroup("10.0.0.2:4000", 6, sha256.New()))
+}
+
+func Test_SplitMapper(t *testing.T) {
+	m := SplitMapper{Keys: JumpMapper{}, Nodes: TableMapper{Nodes: map[string]int64{"10.0.0.1:4000": 1}}}
+	assert.Equal(t, jumpGroup([]byte("key"), 4, sha256.New()), m.KeyGroup([]byte("key"), 4, sha256.New()))
+	assert.Equal(t, int64(1), m.NodeGroup("10.0.0.1:4000", 4, sha256.New()))
+
+	// Unset mappers default to modulo
+	m = SplitMapper{Keys: JumpMapper{}}
+	assert.Equal(t, lookupGroup([]byte("10.0.0.1:4000"), 4, sha256.New()), m.NodeGroup("10.0.0.1:4000", 4, sha256.New()))
+	m = SplitMapper{}
+	assert.Equal(t, lookupGroup([]byte("key"), 4, sha256.New()), m.KeyGroup([]byte("key"), 4, sha256.New()))
+}
+
+// fixedMapper maps all keys and hosts to the same group
+type fixedMapper int64
+
+func (m fixedMapper) KeyGroup(key []byte, k int64, h hash.Hash) int64   { return int64(m) }
+func (m fixedMapper) NodeGroup(host string, k int64, h hash.Hash) int64 { return int64(m) }
+
+func Test_Kelips_GroupMapper_outOfRange(t *testing.T) {
+	for _, m := range []fixedMapper{-1, 3} {
+		klp := New("10.0.0.1:4000", &Config{K: 3, Mapper: m, Transport: loopbackTransport("10.0.0.1:4000")})
+		assert.Equal(t, lookupGroup([]byte("10.0.0.1:4000"), 3, sha256.New()), klp.id)
+
+		idx, _ := klp.lookup([]byte("key"))
+		assert.Equal(t, lookupGroup([]byte("key"), 3, sha256.New()), idx)
+	}
+}
+
+func Test_Kelips_GroupMapper(t *testing.T) {
+	network := NewLoopbackNetwork(1)
+
+	// Pin all nodes to groups 0 and 1 of 2
+	hosts := []string{"10.0.0.1:4000", "10.0.0.2:4000", "10.0.0.3:4000", "10.0.0.4:4000"}
+	mapper := SplitMapper{
+		Keys:  JumpMapper{},
+		Nodes: TableMapper{Nodes: map[string]int64{hosts[0]: 0, hosts[1]: 0, hosts[2]: 1, hosts[3]: 1}},
+	}
+
+	knet := make([]*Kelips, len(hosts))
+	for i, host := range hosts {
+		knet[i] = New(host, &Config{K: 2, Mapper: mapper, Transport: network.Transport(host)})
+		knet[i].trans.Start(nil)
+		assert.Equal(t, int64(i/2), knet[i].id)
+	}
+	for _, kn := range knet {
+		for _, peer := range hosts {
+			gid, err := kn.AddPeer(&Peer{Host: peer})
+			if peer != kn.host {
+				assert.Nil(t, err)
+			}
+			assert.Equal(t, mapper.Nodes.NodeGroup(peer, 2, nil), gid)
+		}
+	}
+
+	for i := 0; i < 10; i++ {
+		key := []byte(fmt.Sprintf("key-%d", i))
+		gid := jumpGroup(key, 2, sha256.New())
+
+		hosts, err := knet[i%4].Insert(key)
+		assert.Nil(t, err)
+		assert.Equal(t, 1, len(hosts))
+		assert.Equal(t, gid, mapper.Nodes.NodeGroup(hosts[0], 2, nil))
+	}
+}
@@ -0,0 +1,297 @@
+package kelips
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Metric names.  Durations are in seconds
+const (
+	// Kelips requests by op and result
+	metricRequests        = "kelips_requests_total"
+	metricRequestDuration = "kelips_request_duration_seconds"
+
+	// Lookups handled by a group by decision.  A forwarded decision is a
+	// hop served by the node forwarded to, forward-failed is one no node
+	// served and ttl-reached is TTL exhaustion
+	metricLookups = "kelips_group_lookups_total"
+	// Tuples and contacts per group
+	metricGroupTuples   = "kelips_group_tuples"
+	metricGroupContacts = "kelips_group_contacts"
+	// Tuples expired by a group including those of peers that left
+	metricTuplesExpired = "kelips_group_tuples_expired_total"
+
+	// Tuple store operations by op
+	metricStoreOps = "kelips_tuple_store_ops_total"
+	// Tuples in the store
+	metricStoreTuples = "kelips_tuple_store_tuples"
+
+	// Outgoing transport requests by endpoint and result
+	metricTransportRequests        = "kelips_transport_requests_total"
+	metricTransportRequestDuration = "kelips_transport_request_duration_seconds"
+	// Incoming transport requests by endpoint
+	metricTransportServed = "kelips_transport_served_total"
+
+	// Gossip messages and bytes by message type
+	metricGossipBroadcasts     = "kelips_gossip_broadcasts_total"
+	metricGossipBroadcastBytes = "kelips_gossip_broadcast_bytes_total"
+	metricGossipReceived       = "kelips_gossip_messages_received_total"
+	// Remote state merges and the tuples they carried
+	metricGossipMerges      = "kelips_gossip_merges_total"
+	metricGossipMergeBytes  = "kelips_gossip_merge_bytes_total"
+	metricGossipMergeTuples = "kelips_gossip_merge_tuples_total"
+	// Local state sent to peers
+	metricGossipStateBytes = "kelips_gossip_state_bytes_total"
+)
+
+// DefaultBuckets are the histogram buckets used by PrometheusMetrics.  They
+// are suited to request latencies in seconds
+var DefaultBuckets = []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Label is a metric dimension
+type Label struct {
+	Name  string
+	Value string
+}
+
+// MetricsCollector receives the metrics recorded by all components.  It can
+// be implemented to forward metrics to any system.  Implementations must be
+// safe for concurrent use
+type MetricsCollector interface {
+	// IncrCounter adds v to the counter
+	IncrCounter(name string, v float64, labels ...Label)
+	// SetGauge sets the gauge to v
+	SetGauge(name string, v float64, labels ...Label)
+	// Observe adds an observation i.e. a latency to a histogram
+	Observe(name string, v float64, labels ...Label)
+}
+
+// metricsSetter is implemented by components that record metrics and are
+// created before the config is applied i.e. transports and tuple stores
+type metricsSetter interface {
+	setMetrics(MetricsCollector)
+}
+
+// noopMetrics discards all metrics.  It is used if no collector is
+// configured
+type noopMetrics struct{}
+
+func (noopMetrics) IncrCounter(string, float64, ...Label) {}
+func (noopMetrics) SetGauge(string, float64, ...Label)    {}
+func (noopMetrics) Observe(string, float64, ...Label)     {}
+
+func groupLabel(id int64) Label {
+	return Label{Name: "group", Value: strconv.FormatInt(id, 10)}
+}
+
+func resultLabel(err error) Label {
+	if err != nil {
+		return Label{Name: "result", Value: "error"}
+	}
+	return Label{Name: "result", Value: "ok"}
+}
+
+func sinceSeconds(start time.Time) float64 {
+	return time.Since(start).Seconds()
+}
+
+type metricType int
+
+const (
+	counterMetric metricType = iota
+	gaugeMetric
+	histogramMetric
+)
+
+func (t metricType) String() string {
+	switch t {
+	case gaugeMetric:
+		return "gauge"
+	case histogramMetric:
+		return "histogram"
+	}
+	return "counter"
+}
+
+// series is a single metric with a set of labels
+type series struct {
+	labels []Label
+	value  float64
+	// histograms only
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+type metricFamily struct {
+	typ    metricType
+	series map[string]*series
+}
+
+// PrometheusMetrics is an in-memory MetricsCollector that exports metrics
+// in the Prometheus text format.  It can be served directly as a HTTP
+// handler
+type PrometheusMetrics struct {
+	buckets []float64
+
+	mu       sync.Mutex
+	families map[string]*metricFamily
+}
+
+// NewPrometheusMetrics returns a collector using the given histogram
+// buckets or DefaultBuckets if none are given
+func NewPrometheusMetrics(buckets ...float64) *PrometheusMetrics {
+	if len(buckets) == 0 {
+		buckets = DefaultBuckets
+	}
+	sorted := append([]float64{}, buckets...)
+	sort.Float64s(sorted)
+
+	return &PrometheusMetrics{
+		buckets:  sorted,
+		families: make(map[string]*metricFamily),
+	}
+}
+
+// IncrCounter satisfies the MetricsCollector interface
+func (pm *PrometheusMetrics) IncrCounter(name string, v float64, labels ...Label) {
+	pm.mu.Lock()
+	pm.get(name, counterMetric, labels).value += v
+	pm.mu.Unlock()
+}
+
+// SetGauge satisfies the MetricsCollector interface
+func (pm *PrometheusMetrics) SetGauge(name string, v float64, labels ...Label) {
+	pm.mu.Lock()
+	pm.get(name, gaugeMetric, labels).value = v
+	pm.mu.Unlock()
+}
+
+// Observe satisfies the MetricsCollector interface
+func (pm *PrometheusMetrics) Observe(name string, v float64, labels ...Label) {
+	pm.mu.Lock()
+	s := pm.get(name, histogramMetric, labels)
+	for i, b := range pm.buckets {
+		if v <= b {
+			s.counts[i]++
+		}
+	}
+	s.sum += v
+	s.count++
+	pm.mu.Unlock()
+}
+
+// get returns the series creating it if needed.  It must be called with the
+// lock held
+func (pm *PrometheusMetrics) get(name string, typ metricType, labels []Label) *series {
+	fam, ok := pm.families[name]
+	if !ok {
+		fam = &metricFamily{typ: typ, series: make(map[string]*series)}
+		pm.families[name] = fam
+	}
+
+	labels = sortLabels(labels)
+	key := formatLabels(labels)
+	s, ok := fam.series[key]
+	if !ok {
+		s = &series{labels: labels}
+		if typ == histogramMetric {
+			s.counts = make([]uint64, len(pm.buckets))
+		}
+		fam.series[key] = s
+	}
+	return s
+}
+
+// WritePrometheus writes all metrics in the Prometheus text exposition
+// format
+func (pm *PrometheusMetrics) WritePrometheus(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+
+	pm.mu.Lock()
+	names := make([]string, 0, len(pm.families))
+	for name := range pm.families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		fam := pm.families[name]
+		fmt.Fprintf(bw, "# TYPE %s %s\n", name, fam.typ)
+
+		keys := make([]string, 0, len(fam.series))
+		for k := range fam.series {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		for _, k := range keys {
+			s := fam.series[k]
+			if fam.typ != histogramMetric {
+				fmt.Fprintf(bw, "%s%s %s\n", name, k, formatFloat(s.value))
+				continue
+			}
+
+			for i, b := range pm.buckets {
+				le := Label{Name: "le", Value: formatFloat(b)}
+				fmt.Fprintf(bw, "%s_bucket%s %d\n", name, formatLabels(append(s.labels, le)), s.counts[i])
+			}
+			inf := Label{Name: "le", Value: "+Inf"}
+			fmt.Fprintf(bw, "%s_bucket%s %d\n", name, formatLabels(append(s.labels, inf)), s.count)
+			fmt.Fprintf(bw, "%s_sum%s %s\n", name, k, formatFloat(s.sum))
+			fmt.Fprintf(bw, "%s_count%s %d\n", name, k, s.count)
+		}
+	}
+	pm.mu.Unlock()
+
+	return bw.Flush()
+}
+
+// ServeHTTP serves the metrics for scraping
+func (pm *PrometheusMetrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	pm.WritePrometheus(w)
+}
+
+func sortLabels(labels []Label) []Label {
+	out := make([]Label, len(labels))
+	copy(out, labels)
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+func formatLabels(labels []Label) string {
+	if len(labels) == 0 {
+		return ""
+	}
+
+	pairs := make([]string, 0, len(labels))
+	for _, l := range labels {
+		pairs = append(pairs, l.Name+`="`+escapeLabelValue(l.Value)+`"`)
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabelValue(v string) string {
+	return labelEscaper.Replace(v)
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,134 @@
+package kelips
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_PrometheusMetrics(t *testing.T) {
+	pm := NewPrometheusMetrics(0.1, 1)
+	pm.IncrCounter("test_total", 1, Label{"op", "insert"}, Label{"group", "0"})
+	pm.IncrCounter("test_total", 2, Label{"group", "0"}, Label{"op", "insert"})
+	pm.SetGauge("test_gauge", 5)
+	pm.SetGauge("test_gauge", 3)
+	pm.SetGauge("test_escape", 1, Label{"v", "a\"b\\c\nd"})
+	pm.Observe("test_seconds", 0.05)
+	pm.Observe("test_seconds", 0.5)
+	pm.Observe("test_seconds", 2)
+
+	buf := bytes.NewBuffer(nil)
+	assert.Nil(t, pm.WritePrometheus(buf))
+	out := buf.String()
+
+	assert.Contains(t, out, "# TYPE test_total counter\n")
+	// Labels are sorted so order does not create new series
+	assert.Contains(t, out, `test_total{group="0",op="insert"} 3`+"\n")
+	assert.Contains(t, out, "# TYPE test_gauge gauge\ntest_gauge 3\n")
+	assert.Contains(t, out, `test_escape{v="a\"b\\c\nd"} 1`)
+
+	assert.Contains(t, out, "# TYPE test_seconds histogram\n")
+	assert.Contains(t, out, `test_seconds_bucket{le="0.1"} 1`+"\n")
+	assert.Contains(t, out, `test_seconds_bucket{le="1"} 2`+"\n")
+	assert.Contains(t, out, `test_seconds_bucket{le="+Inf"} 3`+"\n")
+	assert.Contains(t, out, "test_seconds_sum 2.55\n")
+	assert.Contains(t, out, "test_seconds_count 3\n")
+
+	w := httptest.NewRecorder()
+	pm.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	assert.Equal(t, 200, w.Code)
+	assert.True(t, strings.HasPrefix(w.Header().Get("Content-Type"), "text/plain"))
+	assert.Equal(t, out, w.Body.String())
+}
+
+func Test_Kelips_metrics(t *testing.T) {
+	network := NewLoopbackNetwork(1)
+	pm := NewPrometheusMetrics()
+	knet := makeLoopbackNetworkWith(network, 9, 3, pm)
+
+	// A key in the group with two members so a lookup with a TTL of one
+	// always reaches the holder
+	var gid int64 = -1
+	members := make(map[int64]int)
+	for _, kn := range knet {
+		members[kn.id]++
+	}
+	for id, n := range members {
+		if n == 2 {
+			gid = id
+		}
+	}
+	key := []byte("metrics/key")
+	for i := 0; lookupGroup(key, 3, knet[0].hasher()) != gid; i++ {
+		key = []byte("metrics/key" + string(rune('a'+i)))
+	}
+
+	src := knet[0]
+	for _, kn := range knet {
+		if kn.id != gid {
+			src = kn
+			break
+		}
+	}
+
+	_, err := src.Insert(key)
+	assert.Nil(t, err)
+	_, err = src.Lookup(&Request{Key: key, TTL: 1})
+	assert.Nil(t, err)
+	_, err = src.Lookup(&Request{Key: []byte("metrics/missing"), TTL: 0})
+	assert.NotNil(t, err)
+
+	// A missing key in the group of the inserted one fails once forwarded
+	missing := []byte("metrics/missing")
+	for i := 0; lookupGroup(missing, 3, knet[0].hasher()) != gid; i++ {
+		missing = []byte("metrics/missing" + string(rune('a'+i)))
+	}
+	_, err = src.Lookup(&Request{Key: missing, TTL: 0})
+	assert.NotNil(t, err)
+
+	buf := bytes.NewBuffer(nil)
+	pm.WritePrometheus(buf)
+	out := buf.String()
+
+	assert.Contains(t, out, `kelips_requests_total{op="insert",result="ok"} 1`)
+	assert.Contains(t, out, `kelips_requests_total{op="lookup",result="ok"} 1`)
+	assert.Contains(t, out, `kelips_requests_total{op="lookup",result="error"} 2`)
+	assert.Contains(t, out, `kelips_request_duration_seconds_count{op="lookup"} 3`)
+	assert.Contains(t, out, `decision="forwarded"`)
+	assert.Contains(t, out, `decision="forward-failed"`)
+	assert.Contains(t, out, `decision="local-hit"`)
+	assert.Contains(t, out, `decision="ttl-reached"`)
+	assert.Contains(t, out, "kelips_group_tuples{")
+	assert.Contains(t, out, fmt.Sprintf(`kelips_group_contacts{group="%d"} 2`, gid))
+
+	for _, kn := range knet {
+		kn.Shutdown(context.Background())
+	}
+}
+
+func Test_HTTPTransport_metrics(t *testing.T) {
+	pm := NewPrometheusMetrics()
+	trans := NewHTTPTransport(false)
+	trans.setMetrics(pm)
+
+	srv := httptest.NewServer(trans)
+	defer srv.Close()
+
+	host := strings.TrimPrefix(srv.URL, "http://")
+	assert.Nil(t, trans.Ping(context.Background(), host))
+	assert.NotNil(t, trans.Delete(context.Background(), GroupContact{ID: 1, Host: host}, []byte("key")))
+
+	buf := bytes.NewBuffer(nil)
+	pm.WritePrometheus(buf)
+	out := buf.String()
+
+	assert.Contains(t, out, `kelips_transport_requests_total{endpoint="ping",result="ok"} 1`)
+	assert.Contains(t, out, `kelips_transport_requests_total{endpoint="delete",result="error"} 1`)
+	assert.Contains(t, out, `kelips_transport_request_duration_seconds_count{endpoint="ping"} 1`)
+	assert.Contains(t, out, `kelips_transport_served_total{endpoint="delete"} 1`)
+}
@@ -6,9 +6,16 @@ import (
 
 // Peer node
 type Peer struct {
-	Host       string
+	Host string
+	// Location labels.  Empty if unknown
+	Zone string
+	Rack string
+
 	rtt        time.Duration
 	heartbeats int64
+	// unix nano times the peer was added and last reached
+	joined   int64
+	lastSeen int64
 }
 
 // Address satisfies the PeerContact interface
@@ -16,23 +23,64 @@ func (p *Peer) Address() string {
 	return p.Host
 }
 
-// ping updates the rtt and increments the heartbeat count
-// func (p *peer) ping(rtt time.Duration) {
-// 	p.rtt = rtt
-// 	p.heartbeats++
-// }
+// RTT returns the last measured round trip time to the peer.  Zero means it
+// has not been measured
+func (p *Peer) RTT() time.Duration {
+	return p.rtt
+}
+
+// Joined returns the time the peer was added to the contact store.  It is
+// zero if unknown
+func (p *Peer) Joined() time.Time {
+	return unixNanoTime(p.joined)
+}
 
-// sortPeers implements the sort interface to sort peers by rtt
-// type sortPeers []*peer
+// LastSeen returns the time the peer was last reached or reported alive.
+// It is zero if never
+func (p *Peer) LastSeen() time.Time {
+	return unixNanoTime(p.lastSeen)
+}
 
-// func (p sortPeers) Len() int {
-// 	return len(p)
-// }
+// seen marks the peer as alive now
+func (p *Peer) seen() {
+	p.lastSeen = time.Now().UnixNano()
+}
 
-// func (p sortPeers) Less(i, j int) bool {
-// 	return p[i].rtt < p[j].rtt
-// }
+func unixNanoTime(ns int64) time.Time {
+	if ns == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, ns)
+}
 
-// func (p sortPeers) Swap(i, j int) {
-// 	p[i], p[j] = p[j], p[i]
-// }
+// ping updates the rtt and increments the heartbeat count.  Reachable
+// peers are marked as seen
+func (p *Peer) ping(rtt time.Duration) {
+	p.rtt = rtt
+	p.heartbeats++
+	if rtt != maxRTT {
+		p.seen()
+	}
+}
+
+// sortPeers implements the sort interface to sort peers by rtt.  Peers
+// without a measured rtt are sorted last
+type sortPeers []*Peer
+
+func (p sortPeers) Len() int {
+	return len(p)
+}
+
+func (p sortPeers) Less(i, j int) bool {
+	if p[j].rtt == 0 {
+		return p[i].rtt != 0
+	}
+	if p[i].rtt == 0 {
+		return false
+	}
+	return p[i].rtt < p[j].rtt
+}
+
+func (p sortPeers) Swap(i, j int) {
+	p[i], p[j] = p[j], p[i]
+}
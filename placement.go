@@ -0,0 +1,251 @@
+package kelips
+
+import (
+	"errors"
+	"hash/fnv"
+	"math/rand"
+	"sort"
+	"strconv"
+
+	"github.com/euforia/gossip/peers"
+)
+
+// Gossip metadata keys a node advertises its load with
+const (
+	MetaTuples   = "kelips.tuples"
+	MetaCapacity = "kelips.capacity"
+)
+
+var (
+	errNoCapacity = errors.New("no group member has capacity")
+)
+
+// PeerLoad is the load advertised by a group member
+type PeerLoad struct {
+	// Tuples homed on the peer
+	Tuples int64
+	// Max tuples the peer accepts.  Zero is unlimited
+	Capacity int64
+}
+
+// Full returns true if the peer has reached its capacity
+func (load PeerLoad) Full() bool {
+	return load.Capacity > 0 && load.Tuples >= load.Capacity
+}
+
+// free returns the remaining capacity or -1 if unlimited
+func (load PeerLoad) free() int64 {
+	if load.Capacity == 0 {
+		return -1
+	}
+	if load.Full() {
+		return 0
+	}
+	return load.Capacity - load.Tuples
+}
+
+// PlacementCandidate is a group member a new tuple may be homed on
+type PlacementCandidate struct {
+	Host string
+	Zone string
+	Rack string
+	Load PeerLoad
+	// False if the peer has not advertised its load
+	Known bool
+}
+
+// PlacementPolicy selects the home nodes of new tuples from the members of
+// the affinity group.  Candidates that are full have already been removed.
+// When the group spans zones or racks all candidates are ranked and the
+// home nodes are spread across them in rank order
+type PlacementPolicy interface {
+	// Select returns upto n distinct hosts from the candidates to home the
+	// key
+	Select(key []byte, n int, candidates []PlacementCandidate) []string
+}
+
+// RandomPlacement selects random group members
+type RandomPlacement struct{}
+
+// Select satisfies the PlacementPolicy interface
+func (RandomPlacement) Select(key []byte, n int, candidates []PlacementCandidate) []string {
+	hosts := make([]string, 0, n)
+	for _, i := range rand.Perm(len(candidates)) {
+		if len(hosts) == n {
+			break
+		}
+		hosts = append(hosts, candidates[i].Host)
+	}
+	return hosts
+}
+
+// LeastTuplesPlacement selects the group members homing the fewest tuples.
+// Ties are broken at random.  Peers that have not advertised their load are
+// treated as empty
+type LeastTuplesPlacement struct{}
+
+// Select satisfies the PlacementPolicy interface
+func (LeastTuplesPlacement) Select(key []byte, n int, candidates []PlacementCandidate) []string {
+	shuffled := make([]PlacementCandidate, len(candidates))
+	for i, j := range rand.Perm(len(candidates)) {
+		shuffled[i] = candidates[j]
+	}
+	sort.SliceStable(shuffled, func(i, j int) bool {
+		return shuffled[i].Load.Tuples < shuffled[j].Load.Tuples
+	})
+
+	return candidateHosts(shuffled, n)
+}
+
+// CapacityWeightedPlacement selects group members at random weighted by
+// their remaining capacity.  Peers with unlimited or unknown capacity are
+// weighted by the mean remaining capacity of the others or equally if no
+// peer advertises one
+type CapacityWeightedPlacement struct{}
+
+// Select satisfies the PlacementPolicy interface
+func (CapacityWeightedPlacement) Select(key []byte, n int, candidates []PlacementCandidate) []string {
+	weights := make([]float64, len(candidates))
+
+	var sum float64
+	var limited int
+	for _, c := range candidates {
+		if free := c.Load.free(); free >= 0 {
+			sum += float64(free)
+			limited++
+		}
+	}
+	def := 1.0
+	if limited > 0 {
+		def = sum / float64(limited)
+	}
+
+	var total float64
+	for i, c := range candidates {
+		weights[i] = def
+		if free := c.Load.free(); free >= 0 {
+			weights[i] = float64(free)
+		}
+		total += weights[i]
+	}
+
+	hosts := make([]string, 0, n)
+	for len(hosts) < n && total > 0 {
+		// Falls back to the last weighted candidate on rounding errors
+		r := rand.Float64() * total
+		pick := -1
+		for i, w := range weights {
+			if w == 0 {
+				continue
+			}
+			pick = i
+			if r < w {
+				break
+			}
+			r -= w
+		}
+		if pick < 0 {
+			break
+		}
+
+		hosts = append(hosts, candidates[pick].Host)
+		total -= weights[pick]
+		weights[pick] = 0
+	}
+	return hosts
+}
+
+// ConsistentHashPlacement selects the group members with the highest
+// rendezvous hash of the key and host so a key maps to the same members
+// for as long as they are in the group.  Membership changes only move the
+// keys of the members joining or leaving
+type ConsistentHashPlacement struct{}
+
+// Select satisfies the PlacementPolicy interface
+func (ConsistentHashPlacement) Select(key []byte, n int, candidates []PlacementCandidate) []string {
+	scores := make(map[string]uint64, len(candidates))
+	sorted := make([]PlacementCandidate, len(candidates))
+	copy(sorted, candidates)
+
+	for _, c := range sorted {
+		h := fnv.New64a()
+		h.Write(key)
+		h.Write([]byte{0})
+		h.Write([]byte(c.Host))
+		scores[c.Host] = h.Sum64()
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		si, sj := scores[sorted[i].Host], scores[sorted[j].Host]
+		if si == sj {
+			return sorted[i].Host < sorted[j].Host
+		}
+		return si > sj
+	})
+
+	return candidateHosts(sorted, n)
+}
+
+// candidateHosts returns the hosts of the first n candidates
+func candidateHosts(candidates []PlacementCandidate, n int) []string {
+	if n > len(candidates) {
+		n = len(candidates)
+	}
+	hosts := make([]string, 0, n)
+	for _, c := range candidates[:n] {
+		hosts = append(hosts, c.Host)
+	}
+	return hosts
+}
+
+// placementCandidates returns the group members with their advertised load
+// excluding those that are full.  It also returns whether any were full
+func placementCandidates(contacts []PeerContact) ([]PlacementCandidate, bool) {
+	candidates := make([]PlacementCandidate, 0, len(contacts))
+
+	var full bool
+	for _, p := range contacts {
+		c := PlacementCandidate{Host: p.Address()}
+		c.Zone, c.Rack = contactLabels(p)
+		c.Load, c.Known = contactLoad(p)
+		if c.Load.Full() {
+			full = true
+			continue
+		}
+		candidates = append(candidates, c)
+	}
+
+	return candidates, full
+}
+
+// contactLoad returns the load advertised in the gossip metadata of the
+// contact if any
+func contactLoad(p PeerContact) (PeerLoad, bool) {
+	if peer, ok := p.(*peers.Peer); ok {
+		return parseLoadMeta(peer.Meta)
+	}
+	return PeerLoad{}, false
+}
+
+// parseLoadMeta returns the load in the metadata.  Invalid values are
+// ignored
+func parseLoadMeta(meta map[string]string) (PeerLoad, bool) {
+	var load PeerLoad
+	var known bool
+
+	if v, err := strconv.ParseInt(meta[MetaTuples], 10, 64); err == nil && v >= 0 {
+		load.Tuples = v
+		known = true
+	}
+	if v, err := strconv.ParseInt(meta[MetaCapacity], 10, 64); err == nil && v >= 0 {
+		load.Capacity = v
+		known = true
+	}
+
+	return load, known
+}
+
+// setLoadMeta sets the load in the metadata
+func setLoadMeta(meta map[string]string, load PeerLoad) {
+	meta[MetaTuples] = strconv.FormatInt(load.Tuples, 10)
+	meta[MetaCapacity] = strconv.FormatInt(load.Capacity, 10)
+}
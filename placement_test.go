@@ -0,0 +1,149 @@
+package kelips
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/euforia/gossip/peers"
+	"github.com/stretchr/testify/assert"
+)
+
+func testCandidates(loads ...PeerLoad) []PlacementCandidate {
+	candidates := make([]PlacementCandidate, len(loads))
+	for i, load := range loads {
+		candidates[i] = PlacementCandidate{
+			Host:  fmt.Sprintf("10.0.0.%d:4000", i+1),
+			Load:  load,
+			Known: true,
+		}
+	}
+	return candidates
+}
+
+func Test_PlacementPolicy(t *testing.T) {
+	key := []byte("key")
+	candidates := testCandidates(
+		PeerLoad{Tuples: 30, Capacity: 100},
+		PeerLoad{Tuples: 10, Capacity: 100},
+		PeerLoad{Tuples: 99, Capacity: 100},
+		PeerLoad{Tuples: 20, Capacity: 100},
+	)
+
+	policies := []PlacementPolicy{
+		RandomPlacement{},
+		LeastTuplesPlacement{},
+		CapacityWeightedPlacement{},
+		ConsistentHashPlacement{},
+	}
+	for _, policy := range policies {
+		name := fmt.Sprintf("%T", policy)
+		assert.Equal(t, 2, len(policy.Select(key, 2, candidates)), name)
+		// Distinct hosts upto the number of candidates
+		selected := policy.Select(key, 10, candidates)
+		assert.Equal(t, 4, len(selected), name)
+		seen := make(map[string]bool)
+		for _, h := range selected {
+			assert.False(t, seen[h], name)
+			seen[h] = true
+		}
+		assert.Equal(t, 0, len(policy.Select(key, 1, nil)), name)
+	}
+
+	// Least loaded first
+	assert.Equal(t, []string{"10.0.0.2:4000", "10.0.0.4:4000"},
+		LeastTuplesPlacement{}.Select(key, 2, candidates))
+
+	// The nearly full peer is rarely picked
+	counts := make(map[string]int)
+	for i := 0; i < 1000; i++ {
+		counts[CapacityWeightedPlacement{}.Select(key, 1, candidates)[0]]++
+	}
+	assert.True(t, counts["10.0.0.2:4000"] > counts["10.0.0.1:4000"])
+	assert.True(t, counts["10.0.0.3:4000"] < 20, "count=%d", counts["10.0.0.3:4000"])
+
+	// Unlimited peers are picked
+	unlimited := testCandidates(PeerLoad{Tuples: 5}, PeerLoad{Tuples: 50})
+	assert.Equal(t, 2, len(CapacityWeightedPlacement{}.Select(key, 2, unlimited)))
+
+	// The same key maps to the same hosts regardless of order and removing
+	// an unselected member does not move it
+	ch := ConsistentHashPlacement{}
+	hosts := ch.Select(key, 2, candidates)
+	reversed := make([]PlacementCandidate, len(candidates))
+	for i, c := range candidates {
+		reversed[len(candidates)-1-i] = c
+	}
+	assert.Equal(t, hosts, ch.Select(key, 2, reversed))
+
+	var remaining []PlacementCandidate
+	for _, c := range candidates {
+		if c.Host == hosts[0] || c.Host == hosts[1] {
+			remaining = append(remaining, c)
+		}
+	}
+	assert.Equal(t, hosts, ch.Select(key, 2, remaining))
+}
+
+func Test_placementCandidates(t *testing.T) {
+	full := &peers.Peer{Addr: "10.0.0.1:4000", Meta: map[string]string{}}
+	setLoadMeta(full.Meta, PeerLoad{Tuples: 10, Capacity: 10})
+	loaded := &peers.Peer{Addr: "10.0.0.2:4000", Meta: map[string]string{}}
+	setLoadMeta(loaded.Meta, PeerLoad{Tuples: 3})
+	unknown := &Peer{Host: "10.0.0.3:4000"}
+
+	candidates, isFull := placementCandidates([]PeerContact{full, loaded, unknown})
+	assert.True(t, isFull)
+	assert.Equal(t, []PlacementCandidate{
+		{Host: "10.0.0.2:4000", Load: PeerLoad{Tuples: 3}, Known: true},
+		{Host: "10.0.0.3:4000"},
+	}, candidates)
+
+	// Invalid values are ignored
+	load, known := parseLoadMeta(map[string]string{MetaTuples: "-1", MetaCapacity: "many"})
+	assert.False(t, known)
+	assert.Equal(t, PeerLoad{}, load)
+
+	assert.Equal(t, int64(-1), PeerLoad{Tuples: 3}.free())
+	assert.Equal(t, int64(0), PeerLoad{Tuples: 11, Capacity: 10}.free())
+	assert.Equal(t, int64(7), PeerLoad{Tuples: 3, Capacity: 10}.free())
+}
+
+func Test_affinityGroup_InsertBatch(t *testing.T) {
+	conf := &Config{
+		K:         1,
+		Placement: LeastTuplesPlacement{},
+		Transport: loopbackTransport("10.0.0.1:4000"),
+		Contacts:  &inmemContactsFac{host: "10.0.0.1:4000"},
+	}
+	conf.setDefaults()
+	group := newAffinityGroup(&GroupContact{ID: 0, Host: "10.0.0.1:4000"}, conf)
+	group.AddPeer(context.Background(), &Peer{Host: "10.0.0.1:4000"})
+	group.AddPeer(context.Background(), &Peer{Host: "10.0.0.2:4000"})
+
+	// Repeated keys are placed once and given hosts are ignored
+	ctx := context.Background()
+	results := group.InsertBatch(ctx, []*Tuple{
+		{Key: []byte("dup")},
+		{Key: []byte("dup")},
+		{Key: []byte("hosted"), Hosts: []string{"10.0.0.9:4000"}},
+	})
+	stored := group.tuples.Lookup([]byte("dup"))
+	for _, res := range results[:2] {
+		assert.Nil(t, res.Err)
+		assert.Equal(t, stored.Hosts, res.Tuple.Hosts)
+	}
+	assert.Nil(t, results[2].Err)
+	assert.NotContains(t, results[2].Tuple.Hosts, "10.0.0.9:4000")
+
+	// Moved tuples keep their hosts
+	results = group.MoveBatch(ctx, []*Tuple{
+		{Key: []byte("moved"), Hosts: []string{"10.0.0.9:4000"}},
+		{Key: []byte("unhosted")},
+		{Key: []byte("dup"), Hosts: []string{"10.0.0.9:4000"}},
+	})
+	assert.Nil(t, results[0].Err)
+	assert.Equal(t, []string{"10.0.0.9:4000"}, group.tuples.Lookup([]byte("moved")).Hosts)
+	assert.Equal(t, errTupleNoHosts, results[1].Err)
+	assert.Equal(t, stored.Hosts, results[2].Tuple.Hosts)
+}
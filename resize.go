@@ -0,0 +1,513 @@
+package kelips
+
+import (
+	"context"
+	"fmt"
+	"hash"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Resize message types
+const (
+	// resizeMsgPropose proposes a new number of affinity groups
+	resizeMsgPropose byte = iota + 1
+	// resizeMsgDone reports the sender has moved its tuples
+	resizeMsgDone
+	// resizeMsgComplete reports the sender has switched to the new groups
+	resizeMsgComplete
+)
+
+// resizeRetryInterval is the time between attempts to move tuples and
+// between done reports while resizing
+var resizeRetryInterval = time.Second
+
+// resizeMsg proposes a new number of affinity groups or reports the progress
+// of a host resizing to it.  Proposals with a higher epoch supersede lower
+// ones.  Proposals with the same epoch are ordered by k
+type resizeMsg struct {
+	Type  byte
+	Host  string
+	Epoch uint64
+	K     int64
+}
+
+// resizeNotifier spreads resize messages to all nodes and is told once the
+// node starts resizing and once it has switched to the new groups.  It is
+// implemented by Gossip
+type resizeNotifier interface {
+	// broadcastResize should deliver the message to all known nodes
+	broadcastResize(msg *resizeMsg) error
+	// resizing is called once the node starts moving to k groups with id
+	// as the next home group
+	resizing(ctx context.Context, k, id int64)
+	// resized is called after switching to k groups with id as the home
+	// group
+	resized(ctx context.Context, k, id int64)
+}
+
+// ring holds the affinity groups for a number of groups
+type ring struct {
+	// home group id
+	id int64
+	// total number of affinity groups
+	k int64
+	// list of groups objects
+	groups []AffinityGroup
+
+	// maps keys and hosts to the groups
+	mapper GroupMapper
+	hasher func() hash.Hash
+}
+
+// lookup returns the id of the group the key belongs to and the group
+func (r *ring) lookup(key []byte) (int64, AffinityGroup) {
+	idx := keyGroup(r.mapper, key, r.k, r.hasher)
+	return idx, r.groups[idx]
+}
+
+// nodeGroup returns the id of the home group of the host and the group
+func (r *ring) nodeGroup(host string) (int64, AffinityGroup) {
+	idx := nodeGroup(r.mapper, host, r.k, r.hasher)
+	return idx, r.groups[idx]
+}
+
+// contacts returns the distinct contacts of all groups in the ring
+func (r *ring) contacts() []PeerContact {
+	seen := make(map[string]bool)
+	out := make([]PeerContact, 0)
+	for _, group := range r.groups {
+		contacts := groupContacts(group)
+		if contacts == nil {
+			continue
+		}
+		for _, c := range contacts.List() {
+			if host := c.Address(); !seen[host] {
+				seen[host] = true
+				out = append(out, c)
+			}
+		}
+	}
+	return out
+}
+
+// isSuspect returns true if the host recently failed a request to its group
+func (r *ring) isSuspect(host string) bool {
+	_, group := r.nodeGroup(host)
+	suspects := groupSuspects(group)
+	return suspects != nil && suspects.isSuspect(host)
+}
+
+// resizeState is a resize in progress
+type resizeState struct {
+	epoch uint64
+	next  *ring
+	// hosts that have moved their tuples
+	done map[string]bool
+	// time the resize began.  Reports are awaited upto the resize timeout
+	started time.Time
+	// set once any node has switched so reports are no longer awaited
+	complete bool
+
+	// done once the resize is completed or superseded
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// route is a group a key is sent to
+type route struct {
+	idx   int64
+	group AffinityGroup
+}
+
+// Resize changes the number of affinity groups in the cluster to k.  The
+// new k is spread to all nodes which move their tuples to the new groups
+// while lookups consult both.  Each node switches to the new groups once it
+// and all nodes it knows have moved their tuples which requires each new
+// group to have a member.  A later resize supersedes one in progress
+func (klp *Kelips) Resize(k int64) error {
+	if k <= 0 {
+		return fmt.Errorf("invalid k %d: must be greater than 0", k)
+	}
+
+	klp.mu.RLock()
+	msg := &resizeMsg{
+		Type:  resizeMsgPropose,
+		Host:  klp.host,
+		Epoch: klp.latestEpoch() + 1,
+		K:     k,
+	}
+	klp.mu.RUnlock()
+
+	klp.handleResize(msg)
+	return klp.broadcastResize(msg)
+}
+
+// Resizing returns the number of groups being resized to or zero if no
+// resize is in progress
+func (klp *Kelips) Resizing() int64 {
+	klp.mu.RLock()
+	defer klp.mu.RUnlock()
+
+	if klp.resize == nil {
+		return 0
+	}
+	return klp.resize.next.k
+}
+
+// handleResize applies a resize message from any node including this one
+func (klp *Kelips) handleResize(msg *resizeMsg) {
+	switch msg.Type {
+	case resizeMsgPropose, resizeMsgComplete:
+		klp.beginResize(msg.Epoch, msg.K, msg.Type == resizeMsgComplete)
+
+	case resizeMsgDone:
+		klp.mu.Lock()
+		if rs := klp.resize; rs != nil && rs.epoch == msg.Epoch && rs.next.k == msg.K {
+			rs.done[msg.Host] = true
+		}
+		klp.mu.Unlock()
+		klp.maybeSwitch(msg.Epoch)
+	}
+}
+
+// resizeStatus returns the message describing the latest groups known to
+// this node or nil if the cluster was never resized
+func (klp *Kelips) resizeStatus() *resizeMsg {
+	klp.mu.RLock()
+	defer klp.mu.RUnlock()
+
+	if rs := klp.resize; rs != nil {
+		return &resizeMsg{Type: resizeMsgPropose, Host: klp.host, Epoch: rs.epoch, K: rs.next.k}
+	}
+	if klp.epoch == 0 {
+		return nil
+	}
+	return &resizeMsg{Type: resizeMsgComplete, Host: klp.host, Epoch: klp.epoch, K: klp.k}
+}
+
+// beginResize starts moving tuples to k groups unless the epoch is older
+// than the latest known.  Complete is set if a node has already switched
+func (klp *Kelips) beginResize(epoch uint64, k int64, complete bool) {
+	klp.mu.Lock()
+
+	prev := klp.resize
+	latest := klp.latestEpoch()
+	switch {
+	case prev != nil && prev.epoch == epoch && prev.next.k == k:
+		if complete && !prev.complete {
+			prev.complete = true
+			klp.mu.Unlock()
+			klp.maybeSwitch(epoch)
+			return
+		}
+		klp.mu.Unlock()
+		return
+
+	case epoch < latest:
+		klp.mu.Unlock()
+		return
+
+	case epoch == latest && (prev == nil || k < prev.next.k):
+		klp.mu.Unlock()
+		return
+	}
+
+	// Resizing to the current groups needs no moves
+	if k == klp.k {
+		klp.epoch = epoch
+		klp.resize = nil
+		klp.mu.Unlock()
+
+		if prev != nil {
+			klp.abortResize(prev)
+		}
+		klp.log.Infof("Resize epoch=%d k=%d unchanged", epoch, k)
+		return
+	}
+
+	current := klp.ring
+	klp.mu.Unlock()
+
+	// The groups register with the transport and contact stores so are
+	// created without the lock
+	next := klp.newRing(k)
+
+	klp.mu.Lock()
+	if klp.resize != prev || klp.latestEpoch() != latest {
+		// Another resize was applied meanwhile
+		klp.mu.Unlock()
+		current, pending := klp.rings()
+		klp.releaseRing(next, pending, current)
+		klp.beginResize(epoch, k, complete)
+		return
+	}
+
+	rs := &resizeState{
+		epoch:    epoch,
+		next:     next,
+		done:     make(map[string]bool),
+		started:  time.Now(),
+		complete: complete,
+	}
+	rs.ctx, rs.cancel = context.WithCancel(context.Background())
+
+	// Copy all contacts so the new groups can be reached
+	for _, c := range current.contacts() {
+		rs.next.addPeer(context.Background(), c)
+	}
+
+	klp.resize = rs
+	running := klp.running
+	klp.mu.Unlock()
+
+	if prev != nil {
+		prev.cancel()
+		klp.releaseRing(prev.next, rs.next, &current)
+	}
+
+	klp.log.Infof("Resizing epoch=%d k=%d->%d group=%d->%d", epoch, current.k, k, current.id, rs.next.id)
+
+	if klp.notifier != nil {
+		klp.notifier.resizing(context.Background(), k, rs.next.id)
+	}
+
+	if running {
+		for _, group := range rs.next.groups {
+			group.Start()
+		}
+	}
+
+	klp.routines.start(func(ctx context.Context) {
+		select {
+		case <-ctx.Done():
+			rs.cancel()
+		case <-rs.ctx.Done():
+		}
+	})
+	klp.routines.start(func(context.Context) {
+		klp.migrate(rs)
+	})
+}
+
+// abortResize stops a superseded resize releasing its groups
+func (klp *Kelips) abortResize(rs *resizeState) {
+	rs.cancel()
+
+	current, _ := klp.rings()
+	klp.releaseRing(rs.next, current)
+
+	if klp.notifier != nil {
+		klp.notifier.resized(context.Background(), current.k, current.id)
+	}
+}
+
+// migrate moves the local tuples to their groups in the next ring retrying
+// until all have moved and then reports this node as done until the resize
+// is completed or superseded
+func (klp *Kelips) migrate(rs *resizeState) {
+	for {
+		n, err := klp.moveTuples(rs.ctx, rs.next, false)
+		if err == nil {
+			klp.log.Infof("Resize epoch=%d moved tuples=%d", rs.epoch, n)
+			break
+		}
+		klp.log.Errorf("Resize epoch=%d failed to move tuples: %v", rs.epoch, err)
+
+		if !sleepContext(rs.ctx, resizeRetryInterval) {
+			return
+		}
+	}
+
+	done := &resizeMsg{Type: resizeMsgDone, Host: klp.host, Epoch: rs.epoch, K: rs.next.k}
+	for {
+		klp.handleResize(done)
+		if err := klp.broadcastResize(done); err != nil {
+			klp.log.Errorf("Resize epoch=%d failed to report done: %v", rs.epoch, err)
+		}
+
+		if !sleepContext(rs.ctx, resizeRetryInterval) {
+			return
+		}
+	}
+}
+
+// moveTuples inserts the local tuples belonging to other groups of the ring
+// into those groups keeping their home nodes.  It returns the number moved.
+// Moved tuples are deleted locally if del is set
+func (klp *Kelips) moveTuples(ctx context.Context, r *ring, del bool) (int, error) {
+	home := r.groups[r.id].(*affinityGroup)
+
+	// Each member of the old group drops its own copies so deletes are not
+	// spread to the home group nor mark the moved keys as deleted
+	local := home.tuples
+	if gs, ok := local.(*gossipTupleStorage); ok {
+		local = gs.TupleStorage
+	}
+
+	batches := make(map[int64][]*Tuple)
+	for _, t := range home.tuples.List() {
+		if idx, _ := r.lookup(t.Key); idx != r.id {
+			batches[idx] = append(batches[idx], t)
+		}
+	}
+
+	var (
+		moved int
+		err   error
+	)
+	for idx, tuples := range batches {
+		results := r.groups[idx].MoveBatch(ctx, tuples)
+		for i, res := range results {
+			if res.Err != nil {
+				if err == nil {
+					err = errors.Wrap(res.Err, fmt.Sprintf("group %d", idx))
+				}
+				continue
+			}
+
+			moved++
+			if del {
+				local.Delete(tuples[i].Key)
+			}
+		}
+	}
+
+	if del && moved > 0 {
+		home.updateTupleCount()
+	}
+	return moved, err
+}
+
+// maybeSwitch switches to the next ring once this node has moved its
+// tuples and all live nodes it knows have too or any node has switched.
+// Nodes are no longer awaited once the resize timeout has passed
+func (klp *Kelips) maybeSwitch(epoch uint64) {
+	klp.mu.Lock()
+
+	rs := klp.resize
+	if rs == nil || rs.epoch != epoch || !rs.done[klp.host] {
+		klp.mu.Unlock()
+		return
+	}
+	if !rs.complete && time.Since(rs.started) < klp.conf.ResizeTimeout {
+		for _, c := range rs.next.contacts() {
+			host := c.Address()
+			if !rs.done[host] && !klp.ring.isSuspect(host) && !rs.next.isSuspect(host) {
+				klp.mu.Unlock()
+				return
+			}
+		}
+	}
+
+	prev := klp.ring
+	klp.ring = *rs.next
+	klp.epoch = rs.epoch
+	klp.resize = nil
+	klp.mu.Unlock()
+
+	rs.cancel()
+	klp.log.Infof("Resized epoch=%d k=%d group=%d", rs.epoch, rs.next.k, rs.next.id)
+
+	klp.releaseRing(&prev, rs.next)
+	if klp.notifier != nil {
+		klp.notifier.resized(context.Background(), rs.next.k, rs.next.id)
+	}
+
+	// Nodes still awaiting the reports of this one may switch too
+	complete := &resizeMsg{Type: resizeMsgComplete, Host: klp.host, Epoch: rs.epoch, K: rs.next.k}
+	if err := klp.broadcastResize(complete); err != nil {
+		klp.log.Errorf("Resize epoch=%d failed to report complete: %v", rs.epoch, err)
+	}
+
+	// Tuples inserted through the old groups after moving are moved
+	// before local copies are dropped
+	klp.routines.start(func(ctx context.Context) {
+		for {
+			klp.mu.RLock()
+			current := klp.epoch == rs.epoch && klp.resize == nil
+			klp.mu.RUnlock()
+			if !current {
+				return
+			}
+
+			_, err := klp.moveTuples(ctx, rs.next, true)
+			if err == nil {
+				return
+			}
+			klp.log.Errorf("Resize epoch=%d failed to move tuples: %v", rs.epoch, err)
+
+			if !sleepContext(ctx, resizeRetryInterval) {
+				return
+			}
+		}
+	})
+}
+
+// broadcastResize sends the message to all nodes if a notifier is set
+func (klp *Kelips) broadcastResize(msg *resizeMsg) error {
+	if klp.notifier == nil {
+		return nil
+	}
+	return klp.notifier.broadcastResize(msg)
+}
+
+// latestEpoch returns the epoch of the resize in progress or the current
+// one.  It must be called with the lock held
+func (klp *Kelips) latestEpoch() uint64 {
+	if klp.resize != nil {
+		return klp.resize.epoch
+	}
+	return klp.epoch
+}
+
+// rings returns the current ring and the next one if resizing
+func (klp *Kelips) rings() (*ring, *ring) {
+	klp.mu.RLock()
+	defer klp.mu.RUnlock()
+
+	current := klp.ring
+	if klp.resize == nil {
+		return &current, nil
+	}
+	return &current, klp.resize.next
+}
+
+// routes returns the groups of the key in the order they are tried.  While
+// resizing the group in the next ring is tried first
+func (klp *Kelips) routes(key []byte) []route {
+	current, next := klp.rings()
+
+	routes := make([]route, 0, 2)
+	if next != nil {
+		idx, group := next.lookup(key)
+		routes = append(routes, route{idx, group})
+	}
+	idx, group := current.lookup(key)
+	return append(routes, route{idx, group})
+}
+
+// releaseRing stops the groups of a ring no longer used and unregisters its
+// home group from the transport.  If a ring in use has a home group with the
+// same id the first such group is registered again instead.  Nil rings are
+// skipped
+func (klp *Kelips) releaseRing(r *ring, inUse ...*ring) {
+	stopGroups(r)
+
+	for _, u := range inUse {
+		if u != nil && u.id == r.id {
+			home := u.groups[u.id].(*affinityGroup)
+			klp.trans.Register(home.GroupContact, home)
+			return
+		}
+	}
+	klp.trans.Unregister(r.groups[r.id].(*affinityGroup).GroupContact)
+}
+
+// stopGroups stops the go-routines of all groups in the ring
+func stopGroups(r *ring) {
+	for _, group := range r.groups {
+		group.Stop(context.Background())
+	}
+}
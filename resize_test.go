@@ -0,0 +1,339 @@
+package kelips
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// loopbackNotifier delivers resize messages to all nodes directly.  Done
+// reports are dropped while held
+type loopbackNotifier struct {
+	mu    sync.Mutex
+	nodes []*Kelips
+	hold  bool
+}
+
+func (n *loopbackNotifier) broadcastResize(msg *resizeMsg) error {
+	n.mu.Lock()
+	drop := n.hold && msg.Type == resizeMsgDone
+	n.mu.Unlock()
+
+	if !drop {
+		for _, kn := range n.nodes {
+			if kn.host != msg.Host {
+				kn.handleResize(msg)
+			}
+		}
+	}
+	return nil
+}
+
+func (n *loopbackNotifier) resizing(ctx context.Context, k, id int64) {}
+
+func (n *loopbackNotifier) resized(ctx context.Context, k, id int64) {}
+
+func (n *loopbackNotifier) setHold(hold bool) {
+	n.mu.Lock()
+	n.hold = hold
+	n.mu.Unlock()
+}
+
+// waitResized waits for all nodes to switch to k groups at the epoch
+func waitResized(t *testing.T, knet []*Kelips, epoch uint64, k int64) {
+	deadline := time.Now().Add(5 * time.Second)
+	for _, kn := range knet {
+		for {
+			kn.mu.RLock()
+			ok := kn.epoch == epoch && kn.resize == nil && kn.k == k
+			kn.mu.RUnlock()
+			if ok {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("%s not resized to epoch=%d k=%d", kn.host, epoch, k)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+}
+
+// waitMoved waits for all nodes to move their tuples to the next groups
+func waitMoved(t *testing.T, knet []*Kelips) {
+	deadline := time.Now().Add(5 * time.Second)
+	for _, kn := range knet {
+		for {
+			kn.mu.RLock()
+			ok := kn.resize != nil && kn.resize.done[kn.host]
+			kn.mu.RUnlock()
+			if ok {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("%s tuples not moved", kn.host)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+}
+
+func Test_Kelips_Resize(t *testing.T) {
+	defer func(d time.Duration) { resizeRetryInterval = d }(resizeRetryInterval)
+	resizeRetryInterval = 10 * time.Millisecond
+
+	// Every group has one or two members with both 3 and 4 groups so a
+	// lookup reaching a member without the tuple is forwarded to the one
+	// with it
+	hosts := []string{"10.0.0.1:4000", "10.0.0.4:4000", "10.0.0.5:4000", "10.0.0.7:4000", "10.0.0.9:4000", "10.0.0.19:4000"}
+	network := NewLoopbackNetwork(1)
+	knet := makeLoopbackHosts(network, hosts, 3, nil)
+	notifier := &loopbackNotifier{nodes: knet}
+	for _, kn := range knet {
+		kn.notifier = notifier
+		defer kn.routines.stop(context.Background())
+	}
+
+	keys := make([][]byte, 30)
+	homes := make(map[string][]string)
+	for i := range keys {
+		keys[i] = []byte(fmt.Sprintf("key-%d", i))
+		h, err := knet[i%len(knet)].Insert(keys[i])
+		assert.Nil(t, err)
+		homes[string(keys[i])] = h
+	}
+
+	assert.NotNil(t, knet[0].Resize(0))
+
+	// Nodes wait for each other while done reports are held
+	notifier.setHold(true)
+	assert.Nil(t, knet[0].Resize(4))
+	for _, kn := range knet {
+		assert.Equal(t, int64(4), kn.Resizing(), kn.host)
+	}
+	waitMoved(t, knet)
+
+	// Lookups consult both rings and inserts go to the new groups
+	added := []byte("added-while-resizing")
+	h, err := knet[4].Insert(added)
+	assert.Nil(t, err)
+	homes[string(added)] = h
+	keys = append(keys, added)
+
+	for _, kn := range knet {
+		for _, key := range keys {
+			tuple, err := kn.Lookup(&Request{Key: key, TTL: 1})
+			if assert.Nil(t, err, "node=%s key=%s", kn.host, key) {
+				assert.Equal(t, homes[string(key)], tuple.Hosts)
+			}
+		}
+	}
+
+	// Stale proposals are ignored
+	knet[3].handleResize(&resizeMsg{Type: resizeMsgPropose, Host: knet[1].host, Epoch: 1, K: 2})
+	assert.Equal(t, int64(4), knet[3].Resizing())
+
+	notifier.setHold(false)
+	waitResized(t, knet, 1, 4)
+
+	for _, kn := range knet {
+		current, next := kn.rings()
+		assert.Nil(t, next)
+		assert.Equal(t, 4, len(current.groups))
+		assert.Equal(t, int64(0), kn.Resizing())
+
+		for _, key := range keys {
+			tuple, err := kn.Lookup(&Request{Key: key, TTL: 1})
+			if assert.Nil(t, err, "node=%s key=%s", kn.host, key) {
+				assert.Equal(t, homes[string(key)], tuple.Hosts)
+			}
+		}
+	}
+
+	// Shrinking works the same way
+	assert.Nil(t, knet[5].Resize(3))
+	waitResized(t, knet, 2, 3)
+	for _, key := range keys {
+		_, err := knet[2].Lookup(&Request{Key: key, TTL: 1})
+		assert.Nil(t, err, "key=%s", key)
+	}
+
+	// A late node catches up from the complete state
+	status := knet[0].resizeStatus()
+	assert.Equal(t, resizeMsgComplete, status.Type)
+	assert.Equal(t, uint64(2), status.Epoch)
+}
+
+func Test_Kelips_Resize_unreported(t *testing.T) {
+	defer func(d time.Duration) { resizeRetryInterval = d }(resizeRetryInterval)
+	resizeRetryInterval = 10 * time.Millisecond
+
+	hosts := []string{"10.0.0.1:4000", "10.0.0.4:4000", "10.0.0.5:4000"}
+	network := NewLoopbackNetwork(1)
+	knet := makeLoopbackHosts(network, hosts, 3, nil)
+	notifier := &loopbackNotifier{nodes: knet, hold: true}
+	for _, kn := range knet {
+		kn.notifier = notifier
+		defer kn.routines.stop(context.Background())
+	}
+
+	// Nodes are awaited upto the resize timeout
+	assert.Nil(t, knet[0].Resize(2))
+	waitMoved(t, knet)
+	assert.Equal(t, int64(2), knet[1].Resizing())
+
+	knet[2].mu.Lock()
+	timeout := knet[2].conf.ResizeTimeout
+	knet[2].conf.ResizeTimeout = 10 * time.Millisecond
+	knet[2].mu.Unlock()
+	waitResized(t, knet, 1, 2)
+
+	knet[2].mu.Lock()
+	knet[2].conf.ResizeTimeout = timeout
+	knet[2].mu.Unlock()
+
+	// Suspect nodes are not awaited
+	assert.Nil(t, knet[1].Resize(3))
+	waitMoved(t, knet)
+	assert.Equal(t, int64(3), knet[2].Resizing())
+
+	_, next := knet[0].rings()
+	for _, kn := range knet[1:] {
+		_, group := next.nodeGroup(kn.host)
+		groupSuspects(group).mark(kn.host)
+	}
+	waitResized(t, knet, 2, 3)
+}
+
+// registered returns the groups registered with the loopback transport of
+// the node
+func registered(kn *Kelips) map[int64]AffinityGroup {
+	trans := kn.trans.(*LoopbackTransport)
+	trans.mu.RLock()
+	defer trans.mu.RUnlock()
+
+	out := make(map[int64]AffinityGroup, len(trans.groups))
+	for id, group := range trans.groups {
+		out[id] = group
+	}
+	return out
+}
+
+func Test_Kelips_Resize_superseded(t *testing.T) {
+	hosts := []string{"10.0.0.1:4000", "10.0.0.4:4000", "10.0.0.5:4000"}
+	network := NewLoopbackNetwork(1)
+	knet := makeLoopbackHosts(network, hosts, 3, nil)
+	notifier := &loopbackNotifier{nodes: knet, hold: true}
+	for _, kn := range knet {
+		kn.notifier = notifier
+		defer kn.routines.stop(context.Background())
+	}
+
+	// Only the home groups of the current and next rings are registered
+	check := func() {
+		for _, kn := range knet {
+			current, next := kn.rings()
+			groups := registered(kn)
+			if next != nil && next.id != current.id {
+				assert.Equal(t, 2, len(groups), kn.host)
+				assert.True(t, groups[next.id] == next.groups[next.id], kn.host)
+			} else {
+				assert.Equal(t, 1, len(groups), kn.host)
+			}
+			if next == nil || next.id != current.id {
+				assert.True(t, groups[current.id] == current.groups[current.id], kn.host)
+			}
+		}
+	}
+
+	assert.Nil(t, knet[0].Resize(4))
+	check()
+	assert.Nil(t, knet[1].Resize(5))
+	assert.Equal(t, int64(5), knet[2].Resizing())
+	check()
+
+	// Resizing back to the current groups aborts the resize
+	assert.Nil(t, knet[2].Resize(3))
+	for _, kn := range knet {
+		assert.Equal(t, int64(0), kn.Resizing())
+	}
+	check()
+}
+
+func Test_Gossip_Resize(t *testing.T) {
+	defer func(d time.Duration) { resizeRetryInterval = d }(resizeRetryInterval)
+	resizeRetryInterval = 10 * time.Millisecond
+
+	klp, g, err := makeTestKelipsGossip(55810, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer klp.routines.stop(context.Background())
+	g.delegate.kelips = klp
+	g.hasher, g.mapper = klp.hasher, klp.conf.Mapper
+	klp.notifier = g
+
+	// Resize to groups where this node has a different home
+	k := int64(4)
+	for g.mapper.NodeGroup(klp.host, k, klp.hasher()) == klp.id {
+		k++
+	}
+	nextID := g.mapper.NodeGroup(klp.host, k, klp.hasher())
+
+	// A tuple belonging to a group without contacts holds the resize
+	var held []byte
+	for i := 0; held == nil; i++ {
+		key := []byte(fmt.Sprintf("key-%d", i))
+		if g.mapper.KeyGroup(key, k, klp.hasher()) != nextID {
+			held = key
+		}
+	}
+	g.tuples.Insert(&Tuple{Key: held, Hosts: []string{klp.host}})
+
+	g.mu.RLock()
+	current := g.pools[klp.id]
+	g.mu.RUnlock()
+	assert.NotNil(t, current)
+
+	assert.Nil(t, klp.Resize(k))
+	assert.Equal(t, k, klp.Resizing())
+
+	// Tuples are broadcast to both home pools until the switch
+	g.mu.RLock()
+	next := g.pools[nextID]
+	assert.Equal(t, 2, len(g.pools))
+	assert.Equal(t, int64(3), g.k)
+	g.mu.RUnlock()
+	assert.NotNil(t, next)
+	assert.True(t, g.gtuples.homePool() == current)
+	pools := g.gtuples.pools()
+	if assert.Equal(t, 2, len(pools)) {
+		assert.True(t, pools[0] == current)
+		assert.True(t, pools[1] == next)
+	}
+
+	g.tuples.Delete(held)
+	waitResized(t, []*Kelips{klp}, 1, k)
+
+	// The old home pool is left once switched
+	g.mu.RLock()
+	assert.Equal(t, 1, len(g.pools))
+	assert.True(t, g.pools[nextID] == next)
+	assert.Equal(t, k, g.k)
+	assert.Equal(t, nextID, g.id)
+	g.mu.RUnlock()
+	assert.True(t, g.gtuples.homePool() == next)
+	assert.Equal(t, 1, len(g.gtuples.pools()))
+
+	// Late nodes learn of the resize from the inter-group state
+	buf := g.delegate.LocalState(false)
+	msg, err := readResizeMsg(bytes.NewBuffer(buf))
+	if assert.Nil(t, err) {
+		assert.Equal(t, resizeMsgComplete, msg.Type)
+		assert.Equal(t, k, msg.K)
+	}
+}
@@ -0,0 +1,189 @@
+package kelips
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// certCheckInterval is the minimum time between checks of the certificate
+// files for changes
+const certCheckInterval = time.Second
+
+// CertReloader loads a certificate and key pair from disk and reloads it
+// when either file changes, allowing certificates to be rotated without a
+// restart.  Use GetCertificate and GetClientCertificate in a tls.Config
+type CertReloader struct {
+	certFile string
+	keyFile  string
+
+	mu      sync.RWMutex
+	cert    *tls.Certificate
+	modTime time.Time
+	checked time.Time
+}
+
+// NewCertReloader loads the certificate and key pair at the given paths
+func NewCertReloader(certFile, keyFile string) (*CertReloader, error) {
+	cr := &CertReloader{certFile: certFile, keyFile: keyFile}
+	if err := cr.Reload(); err != nil {
+		return nil, err
+	}
+	return cr, nil
+}
+
+// Reload loads the certificate and key pair from disk
+func (cr *CertReloader) Reload() error {
+	modTime, err := cr.latestModTime()
+	if err != nil {
+		return err
+	}
+
+	cert, err := tls.LoadX509KeyPair(cr.certFile, cr.keyFile)
+	if err != nil {
+		return err
+	}
+
+	cr.mu.Lock()
+	cr.cert = &cert
+	cr.modTime = modTime
+	cr.checked = time.Now()
+	cr.mu.Unlock()
+
+	return nil
+}
+
+// GetCertificate satisfies tls.Config.GetCertificate
+func (cr *CertReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return cr.certificate()
+}
+
+// GetClientCertificate satisfies tls.Config.GetClientCertificate
+func (cr *CertReloader) GetClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	return cr.certificate()
+}
+
+// certificate returns the current certificate reloading it first if the
+// files have changed.  On reload failure the previous certificate is kept
+func (cr *CertReloader) certificate() (*tls.Certificate, error) {
+	cr.mu.RLock()
+	cert := cr.cert
+	stale := time.Since(cr.checked) >= certCheckInterval
+	modTime := cr.modTime
+	cr.mu.RUnlock()
+
+	if !stale {
+		return cert, nil
+	}
+
+	cr.mu.Lock()
+	cr.checked = time.Now()
+	cr.mu.Unlock()
+
+	latest, err := cr.latestModTime()
+	if err != nil || !latest.After(modTime) {
+		return cert, nil
+	}
+
+	if err = cr.Reload(); err != nil {
+		return cert, nil
+	}
+
+	cr.mu.RLock()
+	defer cr.mu.RUnlock()
+	return cr.cert, nil
+}
+
+func (cr *CertReloader) latestModTime() (time.Time, error) {
+	var latest time.Time
+	for _, path := range []string{cr.certFile, cr.keyFile} {
+		fi, err := os.Stat(path)
+		if err != nil {
+			return latest, err
+		}
+		if fi.ModTime().After(latest) {
+			latest = fi.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+// NewCertPool returns a pool with all PEM encoded certificates in the given
+// files
+func NewCertPool(files ...string) (*x509.CertPool, error) {
+	pool := x509.NewCertPool()
+	for _, file := range files {
+		b, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		if !pool.AppendCertsFromPEM(b) {
+			return nil, fmt.Errorf("no certificates found: %s", file)
+		}
+	}
+	return pool, nil
+}
+
+// clientTLSConfig returns the config used to dial addr.  If verifyHost is
+// true the peer certificate must be valid for the host being dialed i.e. the
+// GroupContact host, otherwise only the certificate chain is verified
+func clientTLSConfig(conf *tls.Config, addr string, verifyHost bool) *tls.Config {
+	conf = conf.Clone()
+	if conf.InsecureSkipVerify {
+		return conf
+	}
+
+	if verifyHost {
+		if conf.ServerName == "" {
+			host, _, err := net.SplitHostPort(addr)
+			if err != nil {
+				host = addr
+			}
+			conf.ServerName = host
+		}
+		return conf
+	}
+
+	// Verify the chain ourselves skipping the host name check
+	roots := conf.RootCAs
+	conf.InsecureSkipVerify = true
+	conf.VerifyPeerCertificate = func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+		return verifyCertChain(rawCerts, roots)
+	}
+
+	return conf
+}
+
+// verifyCertChain verifies the leaf certificate against the roots using the
+// remaining certificates as intermediates.  The system pool is used if roots
+// is nil
+func verifyCertChain(rawCerts [][]byte, roots *x509.CertPool) error {
+	if len(rawCerts) == 0 {
+		return fmt.Errorf("no peer certificate")
+	}
+
+	certs := make([]*x509.Certificate, 0, len(rawCerts))
+	for _, raw := range rawCerts {
+		cert, err := x509.ParseCertificate(raw)
+		if err != nil {
+			return err
+		}
+		certs = append(certs, cert)
+	}
+
+	opts := x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: x509.NewCertPool(),
+	}
+	for _, cert := range certs[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+
+	_, err := certs[0].Verify(opts)
+	return err
+}
@@ -0,0 +1,214 @@
+package kelips
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pool *x509.CertPool
+}
+
+func newTestCA(t *testing.T) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "kelips test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+
+	return &testCA{cert: cert, key: key, pool: pool}
+}
+
+// issue returns a PEM encoded certificate and key valid for the ip
+func (ca *testCA) issue(t *testing.T, serial int64, ip string) ([]byte, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: ip},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP(ip)},
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+func (ca *testCA) keyPair(t *testing.T, serial int64, ip string) tls.Certificate {
+	certPEM, keyPEM := ca.issue(t, serial, ip)
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+// startTLSTransport starts a transport serving a single group with mutual
+// TLS
+func startTLSTransport(t *testing.T, ca *testCA, cert tls.Certificate) (*HTTPTransport, string) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	trans := NewHTTPTransport(false, WithServerTLS(&tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    ca.pool,
+	}))
+	trans.Register(GroupContact{ID: 0, Host: ln.Addr().String()}, &affinityGroup{})
+	trans.Start(ln)
+
+	return trans, ln.Addr().String()
+}
+
+func Test_HTTPTransport_mTLS(t *testing.T) {
+	ca := newTestCA(t)
+	server, addr := startTLSTransport(t, ca, ca.keyPair(t, 2, "127.0.0.1"))
+	defer server.Shutdown(context.Background())
+
+	ctx := context.Background()
+	clientCert := ca.keyPair(t, 3, "127.0.0.1")
+
+	client := NewHTTPTransport(false, WithClientTLS(&tls.Config{
+		Certificates: []tls.Certificate{clientCert},
+		RootCAs:      ca.pool,
+	}, true))
+	assert.Nil(t, client.Ping(ctx, addr))
+
+	// No client certificate
+	noCert := NewHTTPTransport(false, WithClientTLS(&tls.Config{RootCAs: ca.pool}, true))
+	assert.NotNil(t, noCert.Ping(ctx, addr))
+
+	// Untrusted server
+	other := newTestCA(t)
+	untrusted := NewHTTPTransport(false, WithClientTLS(&tls.Config{
+		Certificates: []tls.Certificate{clientCert},
+		RootCAs:      other.pool,
+	}, false))
+	assert.NotNil(t, untrusted.Ping(ctx, addr))
+
+	// Plain text client
+	assert.NotNil(t, NewHTTPTransport(false).Ping(ctx, addr))
+}
+
+func Test_HTTPTransport_TLS_verifyHost(t *testing.T) {
+	ca := newTestCA(t)
+	// Certificate for a different host than the one dialed
+	server, addr := startTLSTransport(t, ca, ca.keyPair(t, 2, "10.0.0.1"))
+	defer server.Shutdown(context.Background())
+
+	conf := &tls.Config{
+		Certificates: []tls.Certificate{ca.keyPair(t, 3, "127.0.0.1")},
+		RootCAs:      ca.pool,
+	}
+
+	ctx := context.Background()
+	strict := NewHTTPTransport(false, WithClientTLS(conf, true))
+	assert.NotNil(t, strict.Ping(ctx, addr))
+
+	relaxed := NewHTTPTransport(false, WithClientTLS(conf, false))
+	assert.Nil(t, relaxed.Ping(ctx, addr))
+}
+
+func Test_CertReloader(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kelips-tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+
+	ca := newTestCA(t)
+	writePair := func(serial int64) {
+		certPEM, keyPEM := ca.issue(t, serial, "127.0.0.1")
+		assert.Nil(t, ioutil.WriteFile(certFile, certPEM, 0600))
+		assert.Nil(t, ioutil.WriteFile(keyFile, keyPEM, 0600))
+	}
+
+	writePair(10)
+	cr, err := NewCertReloader(certFile, keyFile)
+	assert.Nil(t, err)
+
+	serial := func() int64 {
+		cert, err := cr.GetCertificate(nil)
+		assert.Nil(t, err)
+		leaf, err := x509.ParseCertificate(cert.Certificate[0])
+		assert.Nil(t, err)
+		return leaf.SerialNumber.Int64()
+	}
+	assert.Equal(t, int64(10), serial())
+
+	// Rotate
+	writePair(11)
+	future := time.Now().Add(time.Minute)
+	os.Chtimes(certFile, future, future)
+	os.Chtimes(keyFile, future, future)
+
+	// Not checked before the interval
+	assert.Equal(t, int64(10), serial())
+
+	cr.mu.Lock()
+	cr.checked = time.Time{}
+	cr.mu.Unlock()
+	assert.Equal(t, int64(11), serial())
+
+	// A broken rotation keeps the previous certificate
+	assert.Nil(t, ioutil.WriteFile(keyFile, []byte("garbage"), 0600))
+	future = future.Add(time.Minute)
+	os.Chtimes(keyFile, future, future)
+	cr.mu.Lock()
+	cr.checked = time.Time{}
+	cr.mu.Unlock()
+	assert.Equal(t, int64(11), serial())
+}
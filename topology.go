@@ -0,0 +1,105 @@
+package kelips
+
+import (
+	"github.com/euforia/gossip/peers"
+)
+
+// Gossip metadata keys a node advertises its location with
+const (
+	MetaZone = "kelips.zone"
+	MetaRack = "kelips.rack"
+)
+
+// contactLabels returns the zone and rack of the contact if known
+func contactLabels(p PeerContact) (string, string) {
+	switch peer := p.(type) {
+	case *Peer:
+		return peer.Zone, peer.Rack
+	case *peers.Peer:
+		return peer.Meta[MetaZone], peer.Meta[MetaRack]
+	}
+	return "", ""
+}
+
+// setLabelMeta sets the zone and rack in the metadata.  Empty labels are
+// not set
+func setLabelMeta(meta map[string]string, zone, rack string) {
+	if zone != "" {
+		meta[MetaZone] = zone
+	}
+	if rack != "" {
+		meta[MetaRack] = rack
+	}
+}
+
+// preferZone returns the peers in the zone followed by the rest, preserving
+// the order otherwise.  The order is unchanged if the zone is empty
+func preferZone(contacts []PeerContact, zone string) []PeerContact {
+	if zone == "" {
+		return contacts
+	}
+
+	out := make([]PeerContact, 0, len(contacts))
+	other := make([]PeerContact, 0)
+	for _, p := range contacts {
+		if z, _ := contactLabels(p); z == zone {
+			out = append(out, p)
+			continue
+		}
+		other = append(other, p)
+	}
+	return append(out, other...)
+}
+
+// spreadTopology returns upto n hosts from the ranked candidates preferring
+// distinct zones and then distinct racks over rank
+func spreadTopology(ranked []string, n int, candidates []PlacementCandidate) []string {
+	type location struct{ zone, rack string }
+
+	locs := make(map[string]location, len(candidates))
+	for _, c := range candidates {
+		locs[c.Host] = location{c.Zone, c.Rack}
+	}
+
+	hosts := make([]string, 0, n)
+	picked := make(map[string]bool, n)
+	zones := make(map[string]bool)
+	racks := make(map[location]bool)
+
+	// Distinct zones, then distinct racks and then by rank alone
+	passes := []func(l location) bool{
+		func(l location) bool { return !zones[l.zone] },
+		func(l location) bool { return !racks[l] },
+		func(l location) bool { return true },
+	}
+	for _, accept := range passes {
+		for _, host := range ranked {
+			if len(hosts) == n {
+				return hosts
+			}
+			l := locs[host]
+			if picked[host] || !accept(l) {
+				continue
+			}
+			hosts = append(hosts, host)
+			picked[host] = true
+			zones[l.zone] = true
+			racks[l] = true
+		}
+	}
+	return hosts
+}
+
+// spansLocations returns true if the candidates are in more than one zone
+// or rack
+func spansLocations(candidates []PlacementCandidate) bool {
+	if len(candidates) == 0 {
+		return false
+	}
+	for _, c := range candidates[1:] {
+		if c.Zone != candidates[0].Zone || c.Rack != candidates[0].Rack {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,82 @@
+package kelips
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/euforia/gossip/peers"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_contactLabels(t *testing.T) {
+	peer := &peers.Peer{Addr: "10.0.0.1:4000", Meta: map[string]string{}}
+	setLabelMeta(peer.Meta, "us-east-1a", "")
+	zone, rack := contactLabels(peer)
+	assert.Equal(t, "us-east-1a", zone)
+	assert.Equal(t, "", rack)
+	_, ok := peer.Meta[MetaRack]
+	assert.False(t, ok)
+
+	zone, rack = contactLabels(&Peer{Host: "10.0.0.2:4000", Zone: "b", Rack: "r1"})
+	assert.Equal(t, "b", zone)
+	assert.Equal(t, "r1", rack)
+}
+
+func Test_spreadTopology(t *testing.T) {
+	candidates := []PlacementCandidate{
+		{Host: "h1", Zone: "a", Rack: "r1"},
+		{Host: "h2", Zone: "a", Rack: "r1"},
+		{Host: "h3", Zone: "a", Rack: "r2"},
+		{Host: "h4", Zone: "b", Rack: "r1"},
+	}
+	ranked := []string{"h1", "h2", "h3", "h4"}
+
+	assert.True(t, spansLocations(candidates))
+	assert.False(t, spansLocations(candidates[:2]))
+	assert.False(t, spansLocations(nil))
+
+	// Zones first, then racks and then rank
+	assert.Equal(t, []string{"h1"}, spreadTopology(ranked, 1, candidates))
+	assert.Equal(t, []string{"h1", "h4"}, spreadTopology(ranked, 2, candidates))
+	assert.Equal(t, []string{"h1", "h4", "h3"}, spreadTopology(ranked, 3, candidates))
+	assert.Equal(t, []string{"h1", "h4", "h3", "h2"}, spreadTopology(ranked, 5, candidates))
+
+	// Consistent hashing still picks one host per zone
+	hosts := ConsistentHashPlacement{}.Select([]byte("key"), len(candidates), candidates)
+	hosts = spreadTopology(hosts, 2, candidates)
+	zones := make(map[string]bool)
+	for _, c := range candidates {
+		for _, h := range hosts {
+			if c.Host == h {
+				zones[c.Zone] = true
+			}
+		}
+	}
+	assert.Equal(t, 2, len(zones))
+}
+
+func Test_affinityGroup_spreadZones(t *testing.T) {
+	conf := &Config{
+		K:                 1,
+		ReplicationFactor: 2,
+		Placement:         LeastTuplesPlacement{},
+		Transport:         loopbackTransport("10.0.0.1:4000"),
+		Contacts:          &inmemContactsFac{host: "10.0.0.1:4000", zone: "a"},
+	}
+	conf.setDefaults()
+	group := newAffinityGroup(&GroupContact{ID: 0, Host: "10.0.0.1:4000"}, conf)
+
+	group.AddPeer(context.Background(), &Peer{Host: "10.0.0.1:4000", Zone: "a"})
+	group.AddPeer(context.Background(), &Peer{Host: "10.0.0.2:4000", Zone: "a"})
+	group.AddPeer(context.Background(), &Peer{Host: "10.0.0.3:4000", Zone: "a"})
+	group.AddPeer(context.Background(), &Peer{Host: "10.0.0.4:4000", Zone: "b"})
+
+	for i := 0; i < 10; i++ {
+		key := []byte(fmt.Sprintf("key-%d", i))
+		hosts, err := group.Insert(context.Background(), &Tuple{Key: key})
+		assert.Nil(t, err)
+		assert.Equal(t, 2, len(hosts))
+		assert.Contains(t, hosts, "10.0.0.4:4000")
+	}
+}
@@ -0,0 +1,91 @@
+package kelips
+
+import (
+	"time"
+)
+
+// TraceDecision is the action taken by a node handling a traced lookup
+type TraceDecision string
+
+const (
+	// TraceLocalHit means the tuple was found in the local store
+	TraceLocalHit TraceDecision = "local-hit"
+	// TraceForwarded means the lookup was sent to another node.  The hop
+	// error is set if the node could not serve it
+	TraceForwarded TraceDecision = "forwarded"
+	// TraceTTLReached means the tuple was not found locally and the request
+	// had no hops left
+	TraceTTLReached TraceDecision = "ttl-reached"
+	// TraceNoContacts means there were no contacts to forward to
+	TraceNoContacts TraceDecision = "no-contacts"
+	// TraceForwardFailed means no contact forwarded to served the lookup.
+	// It is only counted in metrics as each failed forward is traced with
+	// its error
+	TraceForwardFailed TraceDecision = "forward-failed"
+)
+
+// TraceHop is a single decision made by a node along the lookup path
+type TraceHop struct {
+	// Node handling the request
+	Host string `json:"host"`
+	// Group the request was handled for
+	Group    int64         `json:"group"`
+	Decision TraceDecision `json:"decision"`
+	// Node the request was forwarded to
+	To string `json:"to,omitempty"`
+	// Time taken by the decision including the round trip of a forward
+	Latency time.Duration `json:"latency"`
+	// Error returned by the node forwarded to
+	Error string `json:"error,omitempty"`
+}
+
+// Trace collects the hops of a lookup.  Set a non-nil Trace on a Request to
+// enable tracing.  The hops are recorded in order whether or not the lookup
+// succeeds
+type Trace struct {
+	Hops []TraceHop `json:"hops"`
+}
+
+func newTraceHop(gc GroupContact, decision TraceDecision, start time.Time) TraceHop {
+	return TraceHop{
+		Host:     gc.Host,
+		Group:    gc.ID,
+		Decision: decision,
+		Latency:  time.Since(start),
+	}
+}
+
+// add records the hop.  It is a no-op on a nil trace
+func (tr *Trace) add(hop TraceHop) {
+	if tr != nil {
+		tr.Hops = append(tr.Hops, hop)
+	}
+}
+
+// forward calls fn with a trace for the remote node if tracing is enabled,
+// recording the forward decision followed by the remote hops
+func (tr *Trace) forward(gc GroupContact, to string, fn func(*Trace) error) error {
+	if tr == nil {
+		return fn(nil)
+	}
+
+	remote := &Trace{}
+	start := time.Now()
+	err := fn(remote)
+
+	hop := TraceHop{
+		Host:     gc.Host,
+		Group:    gc.ID,
+		Decision: TraceForwarded,
+		To:       to,
+		Latency:  time.Since(start),
+	}
+	if err != nil {
+		hop.Error = err.Error()
+	}
+
+	tr.Hops = append(tr.Hops, hop)
+	tr.Hops = append(tr.Hops, remote.Hops...)
+
+	return err
+}
@@ -0,0 +1,173 @@
+package kelips
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hexablock/log"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Trace_loopback(t *testing.T) {
+	network := NewLoopbackNetwork(1)
+	knet := makeLoopbackNetwork(network, 9, 3)
+
+	members := make(map[int64][]*Kelips)
+	for _, kn := range knet {
+		members[kn.id] = append(members[kn.id], kn)
+	}
+	var gid int64 = -1
+	for id, m := range members {
+		if len(m) == 2 {
+			gid = id
+			break
+		}
+	}
+	if gid < 0 {
+		t.Fatal("no group with two members")
+	}
+
+	key := []byte("trace/key")
+	for i := 0; lookupGroup(key, 3, knet[0].hasher()) != gid; i++ {
+		key = []byte("trace/key" + string(rune('a'+i)))
+	}
+
+	var src *Kelips
+	for _, kn := range knet {
+		if kn.id != gid {
+			src = kn
+			break
+		}
+	}
+
+	_, err := src.Insert(key)
+	assert.Nil(t, err)
+
+	holder, other := members[gid][0], members[gid][1]
+	if holder.groups[gid].(*affinityGroup).tuples.Lookup(key) == nil {
+		holder, other = other, holder
+	}
+
+	// Force the lookup through the member without the tuple
+	network.DropHop(hostOf(src), hostOf(holder))
+
+	req := &Request{Key: key, TTL: 1, Trace: &Trace{}}
+	_, err = src.Lookup(req)
+	assert.Nil(t, err)
+
+	hops := make([]TraceHop, 0, len(req.Trace.Hops))
+	for _, hop := range req.Trace.Hops {
+		if hop.Error == "" {
+			hops = append(hops, hop)
+			continue
+		}
+		// Only the dropped hop fails
+		assert.Equal(t, hostOf(holder), hop.To)
+	}
+
+	assert.Equal(t, 3, len(hops))
+	assert.Equal(t, TraceHop{Host: hostOf(src), Group: gid, Decision: TraceForwarded, To: hostOf(other)},
+		TraceHop{Host: hops[0].Host, Group: hops[0].Group, Decision: hops[0].Decision, To: hops[0].To})
+	assert.Equal(t, TraceHop{Host: hostOf(other), Group: gid, Decision: TraceForwarded, To: hostOf(holder)},
+		TraceHop{Host: hops[1].Host, Group: hops[1].Group, Decision: hops[1].Decision, To: hops[1].To})
+	assert.Equal(t, hostOf(holder), hops[2].Host)
+	assert.Equal(t, TraceLocalHit, hops[2].Decision)
+	// The forward includes the downstream hops
+	assert.True(t, hops[0].Latency >= hops[1].Latency)
+
+	// Failed lookups still return the path
+	network.Heal()
+	req = &Request{Key: []byte("trace/missing"), TTL: 0, Trace: &Trace{}}
+	_, err = members[gid][0].Lookup(req)
+	assert.NotNil(t, err)
+	last := req.Trace.Hops[len(req.Trace.Hops)-1]
+	assert.Equal(t, TraceTTLReached, last.Decision)
+
+	// Untraced lookups record nothing
+	req = &Request{Key: key, TTL: 1}
+	_, err = src.Lookup(req)
+	assert.Nil(t, err)
+	assert.Nil(t, req.Trace)
+}
+
+func Test_Trace_noContacts(t *testing.T) {
+	network := NewLoopbackNetwork(1)
+	klp := New("10.0.0.1:4000", &Config{
+		K:         2,
+		Transport: network.Transport("10.0.0.1:4000"),
+		Logger:    log.NewDefaultLogger(),
+	})
+
+	key := []byte("trace/key")
+	for i := 0; lookupGroup(key, 2, klp.hasher()) == klp.id; i++ {
+		key = []byte("trace/key" + string(rune('a'+i)))
+	}
+
+	req := &Request{Key: key, TTL: 1, Trace: &Trace{}}
+	_, err := klp.Lookup(req)
+	assert.Equal(t, errNoContacts, err)
+	assert.Equal(t, 1, len(req.Trace.Hops))
+	assert.Equal(t, TraceNoContacts, req.Trace.Hops[0].Decision)
+}
+
+func Test_Trace_HTTPTransport(t *testing.T) {
+	knet := makeTestNetwork(56000, 3)
+	defer func() {
+		for _, kn := range knet {
+			kn.Shutdown(context.Background())
+		}
+	}()
+
+	// Keys in a group with a member
+	homed := func(prefix string) ([]byte, int64) {
+		for i := 0; ; i++ {
+			key := []byte(prefix + string(rune('a'+i)))
+			gid := lookupGroup(key, 3, knet[0].hasher())
+			for _, kn := range knet {
+				if kn.id == gid {
+					return key, gid
+				}
+			}
+		}
+	}
+
+	key, gid := homed("trace/http/")
+	_, err := knet[0].Insert(key)
+	assert.Nil(t, err)
+
+	for i, kn := range knet {
+		if kn.id == gid {
+			continue
+		}
+
+		req := &Request{Key: key, TTL: 1, Trace: &Trace{}}
+		_, err := kn.Lookup(req)
+		assert.Nil(t, err, "node=%d", i)
+
+		hops := req.Trace.Hops
+		if !assert.True(t, len(hops) >= 2, "node=%d hops=%v", i, hops) {
+			continue
+		}
+		assert.Equal(t, hostOf(kn), hops[0].Host)
+		assert.Equal(t, TraceForwarded, hops[0].Decision)
+		// Remote hops are carried back in the response
+		assert.Equal(t, hops[0].To, hops[1].Host)
+		assert.Equal(t, TraceLocalHit, hops[len(hops)-1].Decision)
+	}
+
+	// Hops are returned with a remote error
+	missing, gid := homed("trace/http/missing/")
+	for i, kn := range knet {
+		if kn.id == gid {
+			continue
+		}
+
+		req := &Request{Key: missing, TTL: 0, Trace: &Trace{}}
+		_, err := kn.Lookup(req)
+		assert.NotNil(t, err, "node=%d", i)
+		hops := req.Trace.Hops
+		assert.Equal(t, 2, len(hops), "node=%d", i)
+		assert.NotEqual(t, "", hops[0].Error)
+		assert.Equal(t, TraceTTLReached, hops[len(hops)-1].Decision)
+	}
+}
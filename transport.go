@@ -1,45 +1,99 @@
 package kelips
 
 import (
+	"bytes"
 	"context"
+	"crypto/tls"
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"net"
 	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/euforia/gossip/transport"
-	"github.com/pkg/errors"
 )
 
 const (
 	kelipsMagic uint16 = 9
 )
 
+// defaultRequestTimeout is applied to outgoing requests whose context does
+// not carry a deadline
+const defaultRequestTimeout = 5 * time.Second
+
 const (
 	endpointKelips = "/kelips"
 	endpointPeer   = "/peer"
+	endpointPing   = "/ping"
+	endpointLease  = "/lease"
+
+	endpointInsertBatch = "/batch/insert"
+	endpointLookupBatch = "/batch/lookup"
+	endpointMoveBatch   = "/batch/move"
 )
 
 // HTTPTransport implements a HTTP based Transport interface
 type HTTPTransport struct {
 	// local advertise host
 	host string
-	// Registered group
-	groups map[int64]AffinityGroup
+	// Registered groups.  Groups are registered at runtime when resizing
+	groupsMu sync.RWMutex
+	groups   map[int64]AffinityGroup
 
 	server *http.Server
 	client *http.Client
+
+	// http or https
+	scheme string
+	// TLS config for serving.  Set ClientAuth and ClientCAs for mTLS
+	serverTLS *tls.Config
+	// TLS config for dialing peers
+	clientTLS *tls.Config
+	// Require the peer certificate to match the contact host
+	verifyHost bool
+
+	metrics MetricsCollector
+}
+
+// HTTPTransportOption sets optional HTTPTransport settings
+type HTTPTransportOption func(*HTTPTransport)
+
+// WithServerTLS serves the transport over TLS.  Require client certificates
+// with ClientAuth and ClientCAs for mutual TLS.  Use a CertReloader as
+// GetCertificate to pick up rotated certificates
+func WithServerTLS(conf *tls.Config) HTTPTransportOption {
+	return func(trans *HTTPTransport) {
+		trans.serverTLS = conf
+	}
+}
+
+// WithClientTLS dials peers over TLS verifying them against RootCAs.  If
+// verifyHost is true the peer certificate must also be valid for the contact
+// host being dialed.  Set Certificates or GetClientCertificate for mutual TLS
+func WithClientTLS(conf *tls.Config, verifyHost bool) HTTPTransportOption {
+	return func(trans *HTTPTransport) {
+		trans.clientTLS = conf
+		trans.verifyHost = verifyHost
+	}
 }
 
 // NewHTTPTransport returns a new HTTPTransport.  If enableMagic is true, a muxed
 // client with the magic number is used instead of the default
-func NewHTTPTransport(enableMagic bool) *HTTPTransport {
+func NewHTTPTransport(enableMagic bool, opts ...HTTPTransportOption) *HTTPTransport {
 	trans := &HTTPTransport{
-		groups: make(map[int64]AffinityGroup),
+		groups:  make(map[int64]AffinityGroup),
+		scheme:  "http",
+		metrics: noopMetrics{},
+	}
+
+	for _, opt := range opts {
+		opt(trans)
 	}
 
 	trans.initClient(enableMagic)
@@ -47,8 +101,13 @@ func NewHTTPTransport(enableMagic bool) *HTTPTransport {
 	return trans
 }
 
-// Start starts serving on the transport in a separate go-routine
+// Start starts serving on the transport in a separate go-routine.  The
+// listener is wrapped with TLS if a server TLS config was provided
 func (trans *HTTPTransport) Start(ln net.Listener) error {
+	if trans.serverTLS != nil {
+		ln = tls.NewListener(ln, trans.serverTLS)
+	}
+
 	trans.server = &http.Server{
 		Addr:    trans.host,
 		Handler: trans,
@@ -60,6 +119,9 @@ func (trans *HTTPTransport) Start(ln net.Listener) error {
 
 // Shutdown gracefully shuts down the transport
 func (trans *HTTPTransport) Shutdown(ctx context.Context) error {
+	if trans.server == nil {
+		return nil
+	}
 	return trans.server.Shutdown(ctx)
 	// return trans.server.Close()
 }
@@ -81,14 +143,115 @@ func (trans *HTTPTransport) initClient(magic bool) {
 		}).Dial
 	}
 
-	trans.client = &http.Client{
-		Transport: tr,
-		Timeout:   5 * time.Second,
+	if trans.clientTLS != nil {
+		trans.scheme = "https"
+		// Dial ourselves so TLS also runs over the magic dialer and the
+		// host check uses the dialed contact
+		dial := tr.Dial
+		tr.DialTLS = func(network, addr string) (net.Conn, error) {
+			conn, err := dial(network, addr)
+			if err != nil {
+				return nil, err
+			}
+
+			tconn := tls.Client(conn, clientTLSConfig(trans.clientTLS, addr, trans.verifyHost))
+			conn.SetDeadline(time.Now().Add(tr.TLSHandshakeTimeout))
+			if err = tconn.Handshake(); err != nil {
+				conn.Close()
+				return nil, err
+			}
+			conn.SetDeadline(time.Time{})
+
+			return tconn, nil
+		}
 	}
+
+	// Request timeouts are governed by the context of each call
+	trans.client = &http.Client{Transport: tr}
+}
+
+// do executes the request bound to the context returning the response body.
+// If the context does not have a deadline the default request timeout is
+// applied
+func (trans *HTTPTransport) do(ctx context.Context, req *http.Request) ([]byte, error) {
+	b, _, err := trans.doHeader(ctx, req)
+	return b, err
+}
+
+// doHeader is the same as do but also returns the response headers
+func (trans *HTTPTransport) doHeader(ctx context.Context, req *http.Request) (b []byte, header http.Header, err error) {
+	ctx, cancel := requestContext(ctx)
+	defer cancel()
+
+	start := time.Now()
+	defer func() {
+		endpoint := endpointLabel(req)
+		trans.metrics.IncrCounter(metricTransportRequests, 1, endpoint, resultLabel(err))
+		trans.metrics.Observe(metricTransportRequestDuration, sinceSeconds(start), endpoint)
+	}()
+
+	resp, err := trans.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	b, err = readResponse(resp)
+	return b, resp.Header, err
+}
+
+func (trans *HTTPTransport) setMetrics(m MetricsCollector) {
+	trans.metrics = m
+}
+
+// endpointLabel returns the metric label for the operation requested
+func endpointLabel(r *http.Request) Label {
+	var name string
+	switch {
+	case r.URL.Path == endpointPing:
+		name = "ping"
+	case r.URL.Path == endpointInsertBatch:
+		name = "insert-batch"
+	case r.URL.Path == endpointLookupBatch:
+		name = "lookup-batch"
+	case r.URL.Path == endpointMoveBatch:
+		name = "move-batch"
+	case strings.HasPrefix(r.URL.Path, endpointPeer):
+		name = "add-peer"
+	case strings.HasPrefix(r.URL.Path, endpointLease):
+		switch r.Method {
+		case http.MethodPut:
+			name = "renew"
+		case http.MethodDelete:
+			name = "release"
+		}
+	case strings.HasPrefix(r.URL.Path, endpointKelips):
+		switch r.Method {
+		case http.MethodGet:
+			name = "lookup"
+		case http.MethodPost:
+			name = "insert"
+		case http.MethodDelete:
+			name = "delete"
+		}
+	}
+	if name == "" {
+		name = "unknown"
+	}
+	return Label{Name: "endpoint", Value: name}
+}
+
+// requestContext applies the default request timeout if the context does
+// not have a deadline
+func requestContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if _, ok := ctx.Deadline(); ok {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, defaultRequestTimeout)
 }
 
 func (trans *HTTPTransport) makeRequest(contact GroupContact, endpoint, method, key string, ttl int) *http.Request {
-	url := "http://" + contact.Host + endpoint + "/" + key
+	url := trans.scheme + "://" + contact.Host + endpoint + "/" + key
 	req, _ := http.NewRequest(method, url, nil)
 	req.Header.Set("Affinity-Group", fmt.Sprintf("%d", contact.ID))
 	// Default originator
@@ -100,57 +263,190 @@ func (trans *HTTPTransport) makeRequest(contact GroupContact, endpoint, method,
 	return req
 }
 
-// Insert key at remote group
-func (trans *HTTPTransport) Insert(contact GroupContact, key []byte) (string, error) {
-	req := trans.makeRequest(contact, endpointKelips, http.MethodPost, string(key), 3)
-	resp, err := trans.client.Do(req)
+// Insert tuple at remote group
+func (trans *HTTPTransport) Insert(ctx context.Context, contact GroupContact, tuple *Tuple) ([]string, error) {
+	req := trans.makeRequest(contact, endpointKelips, http.MethodPost, string(tuple.Key), 3)
+	setMetaHeader(req.Header, tuple.Meta)
+	setTupleTTLHeader(req.Header, tuple.TTL)
+
+	b, err := trans.do(ctx, req)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
-	defer resp.Body.Close()
 
-	b, err := readResponse(resp)
-
-	return string(b), err
+	return splitHosts(b), nil
 }
 
-// Lookup should return the home node of the key
-func (trans *HTTPTransport) Lookup(contact GroupContact, r *Request) (string, error) {
+// Lookup should return the tuple with the home nodes of the key
+func (trans *HTTPTransport) Lookup(ctx context.Context, contact GroupContact, r *Request) (*Tuple, error) {
 	req := trans.makeRequest(contact, endpointKelips, http.MethodGet, string(r.Key), r.TTL)
 	req.Header.Set("Originator", r.Originator.String())
+	if r.Trace != nil {
+		req.Header.Set("Kelips-Trace", "1")
+	}
 
-	resp, err := trans.client.Do(req)
+	b, header, err := trans.doHeader(ctx, req)
+	// The remote hops are returned on failure as well
+	if r.Trace != nil && header != nil {
+		if er := parseTraceHeader(header, r.Trace); er != nil && err == nil {
+			err = er
+		}
+	}
 	if err != nil {
-		return "", err
+		return nil, err
 	}
-	defer resp.Body.Close()
 
-	b, err := readResponse(resp)
+	tuple := &Tuple{Key: r.Key, Hosts: splitHosts(b)}
+	if tuple.Meta, err = parseMetaHeader(header); err != nil {
+		return nil, err
+	}
+	tuple.TTL, err = parseTupleTTLHeader(header)
 
-	return string(b), err
+	return tuple, err
 }
 
-// AddPeer makes a remote request to add a peer to a group
-func (trans *HTTPTransport) AddPeer(contact GroupContact, host PeerContact) error {
-	req := trans.makeRequest(contact, endpointPeer, http.MethodPost, host.Address(), -1)
-	resp, err := trans.client.Do(req)
+// Delete makes a remote request to delete the key from the group
+func (trans *HTTPTransport) Delete(ctx context.Context, contact GroupContact, key []byte) error {
+	req := trans.makeRequest(contact, endpointKelips, http.MethodDelete, string(key), -1)
+	_, err := trans.do(ctx, req)
+	return err
+}
+
+// Renew makes a remote request to renew the lease of the key
+func (trans *HTTPTransport) Renew(ctx context.Context, contact GroupContact, key []byte, ttl time.Duration) error {
+	req := trans.makeRequest(contact, endpointLease, http.MethodPut, string(key), -1)
+	setTupleTTLHeader(req.Header, ttl)
+	_, err := trans.do(ctx, req)
+	return err
+}
+
+// Release makes a remote request to remove the host from the home nodes of
+// the key
+func (trans *HTTPTransport) Release(ctx context.Context, contact GroupContact, key []byte, host string) error {
+	req := trans.makeRequest(contact, endpointLease, http.MethodDelete, string(key), -1)
+	req.Header.Set("Kelips-Lease-Host", host)
+	_, err := trans.do(ctx, req)
+	return err
+}
+
+// InsertBatch inserts all tuples at the remote group in a single request
+func (trans *HTTPTransport) InsertBatch(ctx context.Context, contact GroupContact, tuples []*Tuple) ([]BatchResult, error) {
+	items := make([]batchItem, 0, len(tuples))
+	for _, t := range tuples {
+		items = append(items, batchItem{Key: t.Key, Meta: t.Meta, TTL: t.TTL})
+	}
+
+	req, err := trans.makeBatchRequest(contact, endpointInsertBatch, items, 3)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	defer resp.Body.Close()
 
-	_, err = readResponse(resp)
+	return trans.doBatch(ctx, req)
+}
+
+// MoveBatch inserts all tuples keeping their hosts at the remote group in a
+// single request
+func (trans *HTTPTransport) MoveBatch(ctx context.Context, contact GroupContact, tuples []*Tuple) ([]BatchResult, error) {
+	items := make([]batchItem, 0, len(tuples))
+	for _, t := range tuples {
+		items = append(items, batchItem{Key: t.Key, Hosts: t.Hosts, Meta: t.Meta, TTL: t.TTL})
+	}
+
+	req, err := trans.makeBatchRequest(contact, endpointMoveBatch, items, 3)
+	if err != nil {
+		return nil, err
+	}
+
+	return trans.doBatch(ctx, req)
+}
+
+// LookupBatch looks up all keys at the remote group in a single request
+func (trans *HTTPTransport) LookupBatch(ctx context.Context, contact GroupContact, r *BatchRequest) ([]BatchResult, error) {
+	items := make([]batchItem, 0, len(r.Keys))
+	for _, k := range r.Keys {
+		items = append(items, batchItem{Key: k})
+	}
+
+	req, err := trans.makeBatchRequest(contact, endpointLookupBatch, items, r.TTL)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Originator", r.Originator.String())
+
+	return trans.doBatch(ctx, req)
+}
+
+func (trans *HTTPTransport) makeBatchRequest(contact GroupContact, endpoint string, items []batchItem, ttl int) (*http.Request, error) {
+	b, err := json.Marshal(items)
+	if err != nil {
+		return nil, err
+	}
+
+	req := trans.makeRequest(contact, endpoint, http.MethodPost, "", ttl)
+	// makeRequest always adds a key path
+	req.URL.Path = endpoint
+	req.Body = ioutil.NopCloser(bytes.NewReader(b))
+	req.ContentLength = int64(len(b))
+	req.Header.Set("Content-Type", "application/json")
+
+	return req, nil
+}
+
+func (trans *HTTPTransport) doBatch(ctx context.Context, req *http.Request) ([]BatchResult, error) {
+	b, err := trans.do(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	var items []batchItem
+	if err = json.Unmarshal(b, &items); err != nil {
+		return nil, err
+	}
+
+	return batchResults(items), nil
+}
+
+// Ping makes a round trip request to the host
+func (trans *HTTPTransport) Ping(ctx context.Context, host string) error {
+	req, _ := http.NewRequest(http.MethodGet, trans.scheme+"://"+host+endpointPing, nil)
+	_, err := trans.do(ctx, req)
+	return err
+}
+
+// AddPeer makes a remote request to add a peer to a group
+func (trans *HTTPTransport) AddPeer(ctx context.Context, contact GroupContact, host PeerContact) error {
+	req := trans.makeRequest(contact, endpointPeer, http.MethodPost, host.Address(), -1)
+	_, err := trans.do(ctx, req)
 	return err
 }
 
 // Register the affinity group with the transport
 func (trans *HTTPTransport) Register(contact GroupContact, group AffinityGroup) {
+	trans.groupsMu.Lock()
 	trans.groups[contact.ID] = group
+	trans.groupsMu.Unlock()
+
 	// All registrations will be the local node
-	trans.host = contact.Host
+	if trans.host != contact.Host {
+		trans.host = contact.Host
+	}
+}
+
+// Unregister removes the affinity group from the transport
+func (trans *HTTPTransport) Unregister(contact GroupContact) {
+	trans.groupsMu.Lock()
+	delete(trans.groups, contact.ID)
+	trans.groupsMu.Unlock()
 }
 
 func (trans *HTTPTransport) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	trans.metrics.IncrCounter(metricTransportServed, 1, endpointLabel(r))
+
+	// Ping is not group specific
+	if r.URL.Path == endpointPing {
+		return
+	}
+
 	// Check group header or bail
 	group := trans.getGroup(w, r)
 	if group == nil {
@@ -160,6 +456,13 @@ func (trans *HTTPTransport) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	defer r.Body.Close()
 
 	switch {
+	case r.URL.Path == endpointInsertBatch || r.URL.Path == endpointLookupBatch || r.URL.Path == endpointMoveBatch:
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		trans.handleBatch(w, r, group)
+
 	case strings.HasPrefix(r.URL.Path, endpointKelips):
 		key := strings.TrimPrefix(r.URL.Path, endpointKelips)
 		key = strings.TrimPrefix(key, "/")
@@ -168,6 +471,11 @@ func (trans *HTTPTransport) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
+		if r.Method == http.MethodDelete {
+			trans.handleDelete(w, r, group, key)
+			return
+		}
+
 		req, err := parseRequest(r)
 		if err != nil {
 			w.WriteHeader(400)
@@ -181,7 +489,10 @@ func (trans *HTTPTransport) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			trans.handleLookup(w, r, group, req)
 
 		case http.MethodPost:
-			trans.handleInsert(w, r, group, key)
+			trans.handleInsert(w, r, group, req)
+
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
 
 		}
 
@@ -194,6 +505,15 @@ func (trans *HTTPTransport) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		}
 		trans.handlePeer(w, r, group, key)
 
+	case strings.HasPrefix(r.URL.Path, endpointLease):
+		key := strings.TrimPrefix(r.URL.Path, endpointLease)
+		key = strings.TrimPrefix(key, "/")
+		if key == "" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		trans.handleLease(w, r, group, key)
+
 	default:
 		w.WriteHeader(http.StatusNotFound)
 
@@ -201,26 +521,133 @@ func (trans *HTTPTransport) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 }
 
 func (trans *HTTPTransport) handleLookup(w http.ResponseWriter, r *http.Request, group AffinityGroup, req *Request) {
-	host, err := group.Lookup(req)
+	if r.Header.Get("Kelips-Trace") != "" {
+		req.Trace = &Trace{}
+	}
+
+	tuple, err := group.Lookup(r.Context(), req)
+	if req.Trace != nil {
+		setTraceHeader(w.Header(), req.Trace)
+	}
 	if err != nil {
 		w.WriteHeader(400)
 		w.Write([]byte(err.Error()))
 		return
 	}
 
-	w.Write([]byte(host))
+	setMetaHeader(w.Header(), tuple.Meta)
+	setTupleTTLHeader(w.Header(), tuple.TTL)
+	w.Write(joinHosts(tuple.Hosts))
 }
 
-func (trans *HTTPTransport) handleInsert(w http.ResponseWriter, r *http.Request, group AffinityGroup, key string) {
+func (trans *HTTPTransport) handleInsert(w http.ResponseWriter, r *http.Request, group AffinityGroup, req *Request) {
+	meta, err := parseMetaHeader(r.Header)
+	if err != nil {
+		w.WriteHeader(400)
+		w.Write([]byte(err.Error()))
+		return
+	}
 
-	host, err := group.Insert([]byte(key))
+	ttl, err := parseTupleTTLHeader(r.Header)
 	if err != nil {
 		w.WriteHeader(400)
 		w.Write([]byte(err.Error()))
 		return
 	}
 
-	w.Write([]byte(host))
+	hosts, err := group.Insert(r.Context(), &Tuple{Key: req.Key, Meta: meta, TTL: ttl})
+	if err != nil {
+		w.WriteHeader(400)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	w.Write(joinHosts(hosts))
+}
+
+func (trans *HTTPTransport) handleBatch(w http.ResponseWriter, r *http.Request, group AffinityGroup) {
+	var items []batchItem
+	err := json.NewDecoder(r.Body).Decode(&items)
+	if err != nil {
+		w.WriteHeader(400)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	var results []BatchResult
+	switch r.URL.Path {
+	case endpointInsertBatch:
+		tuples := make([]*Tuple, 0, len(items))
+		for _, item := range items {
+			tuples = append(tuples, &Tuple{Key: item.Key, Meta: item.Meta, TTL: item.TTL})
+		}
+		results = group.InsertBatch(r.Context(), tuples)
+
+	case endpointMoveBatch:
+		tuples := make([]*Tuple, 0, len(items))
+		for _, item := range items {
+			tuples = append(tuples, &Tuple{Key: item.Key, Hosts: item.Hosts, Meta: item.Meta, TTL: item.TTL})
+		}
+		results = group.MoveBatch(r.Context(), tuples)
+
+	default:
+		req, err := parseRequest(r)
+		if err != nil {
+			w.WriteHeader(400)
+			w.Write([]byte(err.Error()))
+			return
+		}
+
+		breq := &BatchRequest{TTL: req.TTL, Originator: req.Originator}
+		for _, item := range items {
+			breq.Keys = append(breq.Keys, item.Key)
+		}
+		results = group.LookupBatch(r.Context(), breq)
+	}
+
+	b, err := json.Marshal(batchItems(results))
+	if err != nil {
+		w.WriteHeader(500)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
+
+func (trans *HTTPTransport) handleDelete(w http.ResponseWriter, r *http.Request, group AffinityGroup, key string) {
+	err := group.Delete(r.Context(), []byte(key))
+	if err != nil {
+		w.WriteHeader(400)
+		w.Write([]byte(err.Error()))
+	}
+}
+
+// handleLease renews the lease of the key on PUT and releases the host set
+// in the Kelips-Lease-Host header on DELETE
+func (trans *HTTPTransport) handleLease(w http.ResponseWriter, r *http.Request, group AffinityGroup, key string) {
+	var err error
+	switch r.Method {
+	case http.MethodPut:
+		var ttl time.Duration
+		if ttl, err = parseTupleTTLHeader(r.Header); err == nil {
+			err = group.Renew(r.Context(), []byte(key), ttl)
+		}
+
+	case http.MethodDelete:
+		err = group.Release(r.Context(), []byte(key), r.Header.Get("Kelips-Lease-Host"))
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+
+	}
+
+	if err != nil {
+		w.WriteHeader(400)
+		w.Write([]byte(err.Error()))
+	}
 }
 
 func (trans *HTTPTransport) handlePeer(w http.ResponseWriter, r *http.Request, group AffinityGroup, key string) {
@@ -229,7 +656,7 @@ func (trans *HTTPTransport) handlePeer(w http.ResponseWriter, r *http.Request, g
 		return
 	}
 	log.Println("Transport AddPeer", key)
-	err := group.AddPeer(&Peer{Host: key})
+	err := group.AddPeer(r.Context(), &Peer{Host: key})
 	if err != nil {
 		w.WriteHeader(400)
 		w.Write([]byte(err.Error()))
@@ -245,7 +672,9 @@ func (trans *HTTPTransport) getGroup(w http.ResponseWriter, r *http.Request) Aff
 		return nil
 	}
 
+	trans.groupsMu.RLock()
 	group, ok := trans.groups[gid]
+	trans.groupsMu.RUnlock()
 	if !ok {
 		w.WriteHeader(404)
 		return nil
@@ -273,6 +702,129 @@ func parseRequest(r *http.Request) (*Request, error) {
 	return req, nil
 }
 
+// batchItem is the wire format of a single key in a batch request or
+// response.  Keys are base64 encoded by json so they may be binary
+type batchItem struct {
+	Key   []byte            `json:"key"`
+	Hosts []string          `json:"hosts,omitempty"`
+	Meta  map[string]string `json:"meta,omitempty"`
+	TTL   time.Duration     `json:"ttl,omitempty"`
+	Error string            `json:"error,omitempty"`
+}
+
+func batchItems(results []BatchResult) []batchItem {
+	items := make([]batchItem, 0, len(results))
+	for _, res := range results {
+		item := batchItem{Key: res.Key}
+		if res.Err != nil {
+			item.Error = res.Err.Error()
+		} else if res.Tuple != nil {
+			item.Hosts = res.Tuple.Hosts
+			item.Meta = res.Tuple.Meta
+			item.TTL = res.Tuple.TTL
+		}
+		items = append(items, item)
+	}
+	return items
+}
+
+// batchResults converts response items to results.  Errors are remote errors
+func batchResults(items []batchItem) []BatchResult {
+	results := make([]BatchResult, 0, len(items))
+	for _, item := range items {
+		res := BatchResult{Key: item.Key}
+		if item.Error != "" {
+			res.Err = &RemoteError{Message: item.Error}
+		} else {
+			res.Tuple = &Tuple{Key: item.Key, Hosts: item.Hosts, Meta: item.Meta, TTL: item.TTL}
+		}
+		results = append(results, res)
+	}
+	return results
+}
+
+// setMetaHeader adds a Kelips-Meta header with an escaped key=value pair for
+// each metadata entry
+func setMetaHeader(header http.Header, meta map[string]string) {
+	for k, v := range meta {
+		header.Add("Kelips-Meta", url.QueryEscape(k)+"="+url.QueryEscape(v))
+	}
+}
+
+// parseMetaHeader returns the metadata from all Kelips-Meta headers or nil if
+// there are none
+func parseMetaHeader(header http.Header) (map[string]string, error) {
+	values := header["Kelips-Meta"]
+	if len(values) == 0 {
+		return nil, nil
+	}
+
+	meta := make(map[string]string, len(values))
+	for _, kv := range values {
+		q, err := url.ParseQuery(kv)
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range q {
+			meta[k] = v[0]
+		}
+	}
+	return meta, nil
+}
+
+// setTupleTTLHeader sets the Kelips-Tuple-TTL header if the ttl is non-zero
+func setTupleTTLHeader(header http.Header, ttl time.Duration) {
+	if ttl > 0 {
+		header.Set("Kelips-Tuple-TTL", ttl.String())
+	}
+}
+
+// parseTupleTTLHeader returns the duration in the Kelips-Tuple-TTL header or
+// zero if it is not set
+func parseTupleTTLHeader(header http.Header) (time.Duration, error) {
+	val := header.Get("Kelips-Tuple-TTL")
+	if val == "" {
+		return 0, nil
+	}
+	return time.ParseDuration(val)
+}
+
+// setTraceHeader sets the json encoded trace hops as the Kelips-Trace header
+func setTraceHeader(header http.Header, trace *Trace) {
+	b, err := json.Marshal(trace.Hops)
+	if err == nil {
+		header.Set("Kelips-Trace", string(b))
+	}
+}
+
+// parseTraceHeader appends the hops in the Kelips-Trace header to the trace
+func parseTraceHeader(header http.Header, trace *Trace) error {
+	val := header.Get("Kelips-Trace")
+	if val == "" {
+		return nil
+	}
+
+	var hops []TraceHop
+	if err := json.Unmarshal([]byte(val), &hops); err != nil {
+		return err
+	}
+	trace.Hops = append(trace.Hops, hops...)
+	return nil
+}
+
+// joinHosts returns a comma separated list of hosts for a response body
+func joinHosts(hosts []string) []byte {
+	return []byte(strings.Join(hosts, ","))
+}
+
+// splitHosts parses a comma separated response body into hosts
+func splitHosts(b []byte) []string {
+	if len(b) == 0 {
+		return []string{}
+	}
+	return strings.Split(string(b), ",")
+}
+
 func readResponse(resp *http.Response) ([]byte, error) {
 	b, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
@@ -280,9 +832,9 @@ func readResponse(resp *http.Response) ([]byte, error) {
 	}
 	if resp.StatusCode != 200 {
 		if len(b) > 0 {
-			return nil, errors.New(string(b))
+			return nil, &RemoteError{Message: string(b)}
 		}
-		return nil, errors.New(resp.Status)
+		return nil, &RemoteError{Message: resp.Status}
 	}
 	return b, nil
 }
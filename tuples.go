@@ -1,16 +1,34 @@
 package kelips
 
 import (
+	"errors"
 	"sync"
 	"time"
 )
 
-// Tuple holds a key to host mapping along with the heartbeat count
+// MaxTupleMetaSize is the max total size in bytes of all metadata keys and
+// values of a tuple
+const MaxTupleMetaSize = 1024
+
+var (
+	errTupleMetaTooLarge = errors.New("tuple metadata too large")
+	errInvalidTupleTTL   = errors.New("invalid tuple ttl")
+	errTupleNotFound     = errors.New("tuple not found")
+	errTupleNoHosts      = errors.New("tuple has no hosts")
+)
+
+// Tuple holds a key to hosts mapping along with the heartbeat count
 type Tuple struct {
 	// Tuple key
 	Key []byte
-	// Host on which the data associated to the key lives
-	Host string
+	// Hosts on which the data associated to the key lives i.e. replicas
+	Hosts []string
+	// Arbitrary metadata e.g. size, content type, version or checksum
+	Meta map[string]string
+	// Lease duration.  A tuple with a TTL expires once it has not been
+	// renewed within it and is not kept alive by heartbeats.  Zero uses the
+	// store wide TTL refreshed by heartbeats
+	TTL time.Duration
 	// heartbeats associated with tuples
 	heartbeats int64
 	// last time heart beat was update
@@ -21,28 +39,117 @@ type Tuple struct {
 func (t *Tuple) Clone() *Tuple {
 	tuple := &Tuple{
 		Key:        make([]byte, len(t.Key)),
-		Host:       t.Host,
+		Hosts:      make([]string, len(t.Hosts)),
+		TTL:        t.TTL,
 		heartbeats: t.heartbeats,
 		lastseen:   t.lastseen,
 	}
 	copy(tuple.Key, t.Key)
-	return t
+	copy(tuple.Hosts, t.Hosts)
+
+	if t.Meta != nil {
+		tuple.Meta = make(map[string]string, len(t.Meta))
+		for k, v := range t.Meta {
+			tuple.Meta[k] = v
+		}
+	}
+
+	return tuple
+}
+
+// validate checks the tuple is within the allowed limits
+func (t *Tuple) validate() error {
+	var size int
+	for k, v := range t.Meta {
+		size += len(k) + len(v)
+	}
+	if size > MaxTupleMetaSize {
+		return errTupleMetaTooLarge
+	}
+	if t.TTL < 0 {
+		return errInvalidTupleTTL
+	}
+	for _, h := range t.Hosts {
+		if err := validateHost(h); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// HasHost returns true if the host is one of the tuple hosts
+func (t *Tuple) HasHost(host string) bool {
+	for _, h := range t.Hosts {
+		if h == host {
+			return true
+		}
+	}
+	return false
+}
+
+// removeHost removes the host from the tuple returning true if it was
+// removed
+func (t *Tuple) removeHost(host string) bool {
+	for i, h := range t.Hosts {
+		if h == host {
+			t.Hosts = append(t.Hosts[:i], t.Hosts[i+1:]...)
+			return true
+		}
+	}
+	return false
 }
 
-// ping increments the heartbeat count
+// ping increments the heartbeat count.  The last seen time of leased tuples
+// is only updated by renew
 func (t *Tuple) ping() {
 	t.heartbeats++
+	if t.TTL == 0 {
+		t.lastseen = time.Now().UnixNano()
+	}
+}
+
+// renew restarts the lease setting a new TTL if ttl is non-zero
+func (t *Tuple) renew(ttl time.Duration) {
+	if ttl > 0 {
+		t.TTL = ttl
+	}
 	t.lastseen = time.Now().UnixNano()
 }
 
+// expired returns true if the tuple was not seen within its TTL or d if it
+// has none as of now
+func (t *Tuple) expired(now int64, d time.Duration) bool {
+	if t.TTL > 0 {
+		d = t.TTL
+	}
+	return t.lastseen < now-d.Nanoseconds()
+}
+
+// Expires returns the time the tuple expires if it is leased.  It is zero
+// for tuples using the store wide TTL or not yet stored
+func (t *Tuple) Expires() time.Time {
+	if t.TTL == 0 || t.lastseen == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, t.lastseen).Add(t.TTL)
+}
+
 // TupleStorage implements a tuple storage interface
 type TupleStorage interface {
 	// Ping should increment the tuple counter and the last seen time
 	Ping(key ...[]byte) int
-	// Remove all tuples not seen in the last d time.Duration
+	// Remove all tuples not seen in the last d time.Duration or within
+	// their own TTL if set
 	Expire(d time.Duration) int
-	// Remove all tuples with the given host
+	// Remove the host from all tuples, removing tuples left without hosts.
+	// Returns the number of tuples affected
 	ExpireHost(host string) int
+	// Renew restarts the lease of the tuple setting its TTL if ttl is
+	// non-zero.  Returns false if the key is not found
+	Renew(key []byte, ttl time.Duration) bool
+	// Release removes the host from the tuple, removing the tuple if left
+	// without hosts.  Returns false if the host does not hold the key
+	Release(key []byte, host string) bool
 	// Insert the tuple
 	Insert(...*Tuple) int
 	// Delete all given keys returning the number of keys deleted
@@ -53,15 +160,74 @@ type TupleStorage interface {
 	List() []*Tuple
 }
 
+// tupleCounter is implemented by tuple stores that can count their tuples
+// without listing them
+type tupleCounter interface {
+	Count() int
+}
+
+// hostCounter is implemented by tuple stores that can count the tuples
+// homed on a host without listing them
+type hostCounter interface {
+	CountHost(host string) int
+}
+
+// hostCounts is the number of tuples homed on each host
+type hostCounts map[string]int
+
+// add adds n to the count of each host
+func (hc hostCounts) add(hosts []string, n int) {
+	for _, host := range hosts {
+		hc[host] += n
+		if hc[host] <= 0 {
+			delete(hc, host)
+		}
+	}
+}
+
 // InmemTuples implements an inmemory TupleStorage interface
 type InmemTuples struct {
 	mu sync.RWMutex
 	m  map[string]*Tuple
+	// tuples homed per host
+	homed hostCounts
+
+	metrics MetricsCollector
 }
 
 // NewInmemTuples returns a new instance of InmemTuples
 func NewInmemTuples() *InmemTuples {
-	return &InmemTuples{m: make(map[string]*Tuple)}
+	return &InmemTuples{m: make(map[string]*Tuple), homed: make(hostCounts), metrics: noopMetrics{}}
+}
+
+func (tuples *InmemTuples) setMetrics(m MetricsCollector) {
+	tuples.mu.Lock()
+	tuples.metrics = m
+	tuples.mu.Unlock()
+}
+
+// record records c tuples affected by op.  It must be called with the write
+// lock held
+func (tuples *InmemTuples) record(op string, c int) {
+	if c == 0 {
+		return
+	}
+	tuples.metrics.IncrCounter(metricStoreOps, float64(c), Label{Name: "op", Value: op})
+	tuples.metrics.SetGauge(metricStoreTuples, float64(len(tuples.m)))
+}
+
+// Count returns the number of tuples in the store
+func (tuples *InmemTuples) Count() int {
+	tuples.mu.RLock()
+	defer tuples.mu.RUnlock()
+	return len(tuples.m)
+}
+
+// CountHost returns the number of tuples homed on the host
+func (tuples *InmemTuples) CountHost(host string) int {
+	tuples.mu.RLock()
+	defer tuples.mu.RUnlock()
+	return tuples.homed[host]
 }
 
 // ExpireHost satisfies the TupleStorage interface
@@ -69,11 +235,21 @@ func (tuples *InmemTuples) ExpireHost(host string) int {
 	var c int
 	tuples.mu.Lock()
 	for k, v := range tuples.m {
-		if v.Host == host {
+		if !v.HasHost(host) {
+			continue
+		}
+		// Copy before modifying as the stored tuple may be shared
+		tuple := v.Clone()
+		tuple.removeHost(host)
+		if len(tuple.Hosts) == 0 {
 			delete(tuples.m, k)
-			c++
+		} else {
+			tuples.m[k] = tuple
 		}
+		tuples.homed.add([]string{host}, -1)
+		c++
 	}
+	tuples.record("expire-host", c)
 	tuples.mu.Unlock()
 	return c
 }
@@ -82,28 +258,70 @@ func (tuples *InmemTuples) ExpireHost(host string) int {
 func (tuples *InmemTuples) Expire(d time.Duration) int {
 	var c int
 	tuples.mu.Lock()
-	marker := time.Now().UnixNano() - d.Nanoseconds()
+	now := time.Now().UnixNano()
 	for k, v := range tuples.m {
-		if v.lastseen < marker {
+		if v.expired(now, d) {
 			delete(tuples.m, k)
+			tuples.homed.add(v.Hosts, -1)
 			c++
 		}
 	}
+	tuples.record("expire", c)
 	tuples.mu.Unlock()
 	return c
 }
 
+// Renew satisfies the TupleStorage interface
+func (tuples *InmemTuples) Renew(key []byte, ttl time.Duration) bool {
+	tuples.mu.Lock()
+	defer tuples.mu.Unlock()
+
+	val, ok := tuples.m[string(key)]
+	if !ok {
+		return false
+	}
+	val.renew(ttl)
+	tuples.record("renew", 1)
+	return true
+}
+
+// Release satisfies the TupleStorage interface
+func (tuples *InmemTuples) Release(key []byte, host string) bool {
+	tuples.mu.Lock()
+	defer tuples.mu.Unlock()
+
+	k := string(key)
+	val, ok := tuples.m[k]
+	if !ok || !val.HasHost(host) {
+		return false
+	}
+
+	// Copy before modifying as the stored tuple may be shared
+	tuple := val.Clone()
+	tuple.removeHost(host)
+	if len(tuple.Hosts) == 0 {
+		delete(tuples.m, k)
+	} else {
+		tuples.m[k] = tuple
+	}
+	tuples.homed.add([]string{host}, -1)
+	tuples.record("release", 1)
+	return true
+}
+
 // Delete satisfies the TupleStorage interface
 func (tuples *InmemTuples) Delete(keys ...[]byte) int {
 	var c int
 	tuples.mu.Lock()
 	for _, k := range keys {
 		key := string(k)
-		if _, ok := tuples.m[key]; ok {
+		if v, ok := tuples.m[key]; ok {
 			delete(tuples.m, key)
+			tuples.homed.add(v.Hosts, -1)
 			c++
 		}
 	}
+	tuples.record("delete", c)
 	tuples.mu.Unlock()
 	return c
 }
@@ -119,6 +337,7 @@ func (tuples *InmemTuples) Ping(keys ...[]byte) int {
 			c++
 		}
 	}
+	tuples.record("ping", c)
 	tuples.mu.Unlock()
 	return c
 }
@@ -134,10 +353,12 @@ func (tuples *InmemTuples) Insert(tpls ...*Tuple) int {
 		if _, ok := tuples.m[k]; !ok {
 			tpl.lastseen = time.Now().UnixNano()
 			tuples.m[k] = tpl
+			tuples.homed.add(tpl.Hosts, 1)
 			// log.Printf("Tuple added: %q", tpl.Key)
 			c++
 		}
 	}
+	tuples.record("insert", c)
 	tuples.mu.Unlock()
 	return c
 }
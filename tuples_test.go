@@ -1,51 +1,58 @@
 package kelips
 
 import (
+	"bytes"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
+	"github.com/hexablock/log"
 	"github.com/stretchr/testify/assert"
 )
 
 var testTuples = []*Tuple{
 	&Tuple{
-		Key:  []byte("foo"),
-		Host: "127.0.0.1:8902",
+		Key:   []byte("foo"),
+		Hosts: []string{"127.0.0.1:8902"},
 	},
 	&Tuple{
-		Key:  []byte("parent/child/grandchild"),
-		Host: "127.0.0.1:65432",
+		Key:   []byte("parent/child/grandchild"),
+		Hosts: []string{"127.0.0.1:65432"},
 	},
 	&Tuple{
-		Key:  []byte("parent/grandparent/greatgrandparent"),
-		Host: "127.0.0.1:3741",
+		Key:   []byte("parent/grandparent/greatgrandparent"),
+		Hosts: []string{"127.0.0.1:3741"},
 	},
 	&Tuple{
-		Key:  []byte("database/table/key"),
-		Host: "127.0.0.1:12345",
+		Key:   []byte("database/table/key"),
+		Hosts: []string{"127.0.0.1:12345"},
 	},
 	&Tuple{
-		Key:  []byte("cluster/group/node"),
-		Host: "127.0.0.1:23456",
+		Key:   []byte("cluster/group/node"),
+		Hosts: []string{"127.0.0.1:23456"},
 	},
 	&Tuple{
-		Key:  []byte("group.subgroup"),
-		Host: "127.0.0.1:34567",
+		Key:   []byte("group.subgroup"),
+		Hosts: []string{"127.0.0.1:34567"},
 	},
 	&Tuple{
-		Key:  []byte("key-subkey"),
-		Host: "127.0.0.1:3741",
+		Key:   []byte("key-subkey"),
+		Hosts: []string{"127.0.0.1:3741"},
 	},
 	&Tuple{
-		Key:  []byte("value-sub/value"),
-		Host: "127.0.0.1:8673",
+		Key:   []byte("value-sub/value"),
+		Hosts: []string{"127.0.0.1:8673"},
 	},
 	&Tuple{
-		Key:  []byte("sub/value-"),
-		Host: "127.0.0.1:3741",
+		Key:   []byte("sub/value-"),
+		Hosts: []string{"127.0.0.1:3741"},
 	},
 	&Tuple{
-		Key:  []byte("abcdefghijklmnopqrstuvwxyz"),
-		Host: "127.0.0.1:9107",
+		Key:   []byte("abcdefghijklmnopqrstuvwxyz"),
+		Hosts: []string{"127.0.0.1:9107"},
 	},
 }
 
@@ -65,7 +72,7 @@ func Test_inmemTuples(t *testing.T) {
 
 	// Ping
 	for _, tpl := range testTuples {
-		assert.Equal(t, 1, testTupleStore.Ping(tpl.Key), "ping", tpl.Host)
+		assert.Equal(t, 1, testTupleStore.Ping(tpl.Key), "ping", tpl.Hosts)
 	}
 	for _, tpl := range testTuples {
 		rt := testTupleStore.Lookup(tpl.Key)
@@ -81,13 +88,49 @@ func Test_inmemTuples(t *testing.T) {
 	// ExpireHost
 	assert.EqualValues(t, 3, testTupleStore.ExpireHost("127.0.0.1:3741"))
 	for _, tpl := range testTuples {
-		if tpl.Host != "127.0.0.1:3741" {
+		if !tpl.HasHost("127.0.0.1:3741") {
 			continue
 		}
 		assert.Nil(t, testTupleStore.Lookup(tpl.Key))
 	}
 }
 
+func Test_Tuple_replicas(t *testing.T) {
+	store := NewInmemTuples()
+	hosts := []string{"127.0.0.1:3741", "127.0.0.1:8902", "127.0.0.1:9107"}
+	store.Insert(&Tuple{Key: []byte("replicated"), Hosts: append([]string{}, hosts...)})
+
+	// Clone should not share host storage
+	rt := store.Lookup([]byte("replicated"))
+	assert.Equal(t, hosts, rt.Hosts)
+	rt.Hosts[0] = "changed"
+	assert.Equal(t, hosts, store.Lookup([]byte("replicated")).Hosts)
+
+	// Expiring a host only removes it from the replica set
+	assert.Equal(t, 1, store.ExpireHost(hosts[0]))
+	assert.Equal(t, hosts[1:], store.Lookup([]byte("replicated")).Hosts)
+	assert.Equal(t, 1, store.ExpireHost(hosts[1]))
+	assert.Equal(t, 1, store.ExpireHost(hosts[2]))
+	assert.Nil(t, store.Lookup([]byte("replicated")))
+
+	// Multi-host tuples survive the snapshot encoding
+	buf := bytes.NewBuffer(nil)
+	in := []*Tuple{
+		&Tuple{Key: []byte("replicated"), Hosts: hosts, Meta: map[string]string{"size": "10"}},
+		testTuples[1],
+	}
+	assert.Nil(t, writeTuples(buf, in))
+	out, err := readTuples(buf)
+	assert.Nil(t, err)
+	assert.Equal(t, len(in), len(out))
+	for i := range in {
+		assert.Equal(t, in[i].Key, out[i].Key)
+		assert.Equal(t, in[i].Hosts, out[i].Hosts)
+		assert.Equal(t, in[i].Meta, out[i].Meta)
+	}
+
+}
+
 // func Test_Tuple_Marshal_Unmarshal(t *testing.T) {
 // 	t1 := &Tuple{Key: []byte("foo")}
 // 	b1, err := t1.MarshalBinary()
@@ -119,3 +162,229 @@ func Test_inmemTuples(t *testing.T) {
 // 	assert.Equal(t, t1.heartbeats, tout.heartbeats)
 // 	assert.Equal(t, t1.lastseen, tout.lastseen)
 // }
+
+func Test_tuplesGossipDelegate_delete(t *testing.T) {
+	delegate := &tuplesGossipDelegate{
+		host:       "127.0.0.1:8902",
+		tuples:     NewInmemTuples(),
+		tombstones: newTombstones(),
+		metrics:    noopMetrics{},
+		log:        log.NewDefaultLogger(),
+	}
+
+	buf := bytes.NewBuffer(nil)
+	err := writeTuples(buf, testTuples)
+	assert.Nil(t, err)
+	state := buf.Bytes()
+
+	// Delete message from a remote
+	keys := [][]byte{testTuples[0].Key, testTuples[1].Key}
+	kbuf := bytes.NewBuffer(nil)
+	assert.Nil(t, writeMessageHeader(kbuf, tupleMsgDelete, "127.0.0.1:3741"))
+	assert.Nil(t, writeKeys(kbuf, keys))
+	delegate.NotifyMsg(kbuf.Bytes())
+
+	// Deleted keys should not be re-seeded on join
+	remote := &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 3741}
+	delegate.MergeRemoteState(remote, state, true)
+	for _, k := range keys {
+		assert.Nil(t, delegate.tuples.Lookup(k))
+	}
+	assert.Equal(t, len(testTuples)-len(keys), len(delegate.tuples.List()))
+
+	// An explicit insert clears the tombstone
+	ibuf := bytes.NewBuffer(nil)
+	assert.Nil(t, writeMessageHeader(ibuf, tupleMsgInsert, "127.0.0.1:3741"))
+	assert.Nil(t, writeTupleRecords(ibuf, testTuples[:1]))
+	delegate.NotifyMsg(ibuf.Bytes())
+	assert.NotNil(t, delegate.tuples.Lookup(keys[0]))
+	assert.Nil(t, delegate.tuples.Lookup(keys[1]))
+
+	// Tombstones expire
+	assert.Equal(t, 1, delegate.tombstones.expire(0))
+}
+
+func Test_FileTuples(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kelips-tuples")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "tuples.log")
+	store, err := NewFileTuples(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tpl := range testTuples {
+		store.Insert(&Tuple{Key: tpl.Key, Hosts: tpl.Hosts})
+	}
+	store.Insert(&Tuple{Key: []byte("meta"), Hosts: []string{"127.0.0.1:8902"}, Meta: map[string]string{"version": "3"}})
+	// Heartbeats are not logged until the next expiry
+	before, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 1, store.Ping(testTuples[1].Key))
+	after, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, before.Size(), after.Size())
+	assert.Equal(t, 0, store.Expire(time.Hour))
+	if after, err = os.Stat(path); err != nil {
+		t.Fatal(err)
+	}
+	assert.True(t, after.Size() > before.Size())
+
+	assert.Equal(t, 1, store.Ping(testTuples[1].Key))
+	assert.Equal(t, 1, store.Delete(testTuples[0].Key))
+	assert.Equal(t, 3, store.ExpireHost("127.0.0.1:3741"))
+	assert.Nil(t, store.Close())
+
+	// Reopen and check persisted state
+	store, err = NewFileTuples(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, len(testTuples)-3, len(store.List()))
+	assert.Nil(t, store.Lookup(testTuples[0].Key))
+
+	rt := store.Lookup(testTuples[1].Key)
+	assert.NotNil(t, rt)
+	assert.Nil(t, rt.Meta)
+	assert.Equal(t, testTuples[1].Hosts, rt.Hosts)
+	assert.EqualValues(t, 2, rt.heartbeats)
+	assert.NotEmpty(t, rt.lastseen)
+	assert.Equal(t, map[string]string{"version": "3"}, store.Lookup([]byte("meta")).Meta)
+
+	// Compaction keeps live tuples
+	assert.Nil(t, store.Compact())
+	assert.Nil(t, store.Close())
+
+	// Partially written trailing record is truncated
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte{recordPut, 100, 1, 2})
+	f.Close()
+
+	store, err = NewFileTuples(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, len(testTuples)-3, len(store.List()))
+	assert.Nil(t, store.Close())
+
+	// Records with a corrupt length are truncated
+	f, err = os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte{recordPut, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x7f, 1, 2})
+	f.Close()
+
+	store, err = NewFileTuples(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, len(testTuples)-3, len(store.List()))
+
+	// Expiry is driven by the persisted last seen time
+	assert.Equal(t, len(testTuples)-3, store.Expire(0))
+	assert.Nil(t, store.Close())
+
+	store, err = NewFileTuples(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 0, len(store.List()))
+	assert.Nil(t, store.Close())
+}
+
+func Test_InmemTuples_lease(t *testing.T) {
+	store := NewInmemTuples()
+	store.Insert(
+		&Tuple{Key: []byte("default"), Hosts: []string{"127.0.0.1:8902"}},
+		&Tuple{Key: []byte("leased"), Hosts: []string{"127.0.0.1:8902", "127.0.0.1:8903"}, TTL: time.Hour},
+	)
+
+	assert.Equal(t, 2, store.CountHost("127.0.0.1:8902"))
+	assert.Equal(t, 1, store.CountHost("127.0.0.1:8903"))
+
+	// Leases are kept past the store wide TTL
+	assert.Equal(t, 1, store.Expire(0))
+	assert.NotNil(t, store.Lookup([]byte("leased")))
+	assert.Equal(t, 1, store.CountHost("127.0.0.1:8902"))
+
+	// Heartbeats do not extend a lease
+	leased := store.Lookup([]byte("leased"))
+	assert.Equal(t, 1, store.Ping(leased.Key))
+	pinged := store.Lookup(leased.Key)
+	assert.EqualValues(t, 1, pinged.heartbeats)
+	assert.Equal(t, leased.lastseen, pinged.lastseen)
+	assert.Equal(t, time.Unix(0, leased.lastseen).Add(time.Hour), leased.Expires())
+
+	// Renewing without a ttl keeps the lease duration
+	assert.True(t, store.Renew(leased.Key, 0))
+	renewed := store.Lookup(leased.Key)
+	assert.Equal(t, time.Hour, renewed.TTL)
+	assert.True(t, renewed.lastseen > leased.lastseen)
+	assert.False(t, store.Renew([]byte("missing"), time.Minute))
+
+	// Short leases expire
+	assert.True(t, store.Renew(leased.Key, time.Nanosecond))
+	time.Sleep(time.Millisecond)
+	assert.Equal(t, 1, store.Expire(time.Hour))
+	assert.Nil(t, store.Lookup(leased.Key))
+	assert.Equal(t, 0, store.CountHost("127.0.0.1:8902"))
+
+	// Releasing the last host removes the tuple
+	store.Insert(&Tuple{Key: []byte("release"), Hosts: []string{"127.0.0.1:8902", "127.0.0.1:8903"}, TTL: time.Hour})
+	assert.False(t, store.Release([]byte("release"), "127.0.0.1:9999"))
+	assert.True(t, store.Release([]byte("release"), "127.0.0.1:8902"))
+	assert.Equal(t, []string{"127.0.0.1:8903"}, store.Lookup([]byte("release")).Hosts)
+	assert.Equal(t, 0, store.CountHost("127.0.0.1:8902"))
+	assert.Equal(t, 1, store.CountHost("127.0.0.1:8903"))
+	assert.True(t, store.Release([]byte("release"), "127.0.0.1:8903"))
+	assert.Nil(t, store.Lookup([]byte("release")))
+	assert.Equal(t, 0, store.CountHost("127.0.0.1:8903"))
+}
+
+func Test_FileTuples_lease(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kelips-tuples")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "tuples.log")
+	store, err := NewFileTuples(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store.Insert(
+		&Tuple{Key: []byte("leased"), Hosts: []string{"127.0.0.1:8902"}, TTL: time.Hour},
+		&Tuple{Key: []byte("released"), Hosts: []string{"127.0.0.1:8902"}, TTL: time.Hour},
+	)
+	assert.True(t, store.Renew([]byte("leased"), 2*time.Hour))
+	assert.True(t, store.Release([]byte("released"), "127.0.0.1:8902"))
+	assert.Equal(t, 1, store.CountHost("127.0.0.1:8902"))
+	assert.Nil(t, store.Close())
+
+	// Leases survive a reopen
+	store, err = NewFileTuples(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Nil(t, store.Lookup([]byte("released")))
+	assert.Equal(t, 2*time.Hour, store.Lookup([]byte("leased")).TTL)
+	assert.Equal(t, 1, store.CountHost("127.0.0.1:8902"))
+	assert.Equal(t, 0, store.Expire(0))
+	assert.Equal(t, 1, store.Delete([]byte("leased")))
+	assert.Equal(t, 0, store.CountHost("127.0.0.1:8902"))
+	assert.Nil(t, store.Close())
+}
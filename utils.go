@@ -1,12 +1,10 @@
 package kelips
 
 import (
-	"encoding/binary"
+	"context"
 	"hash"
-	"io"
 	"math/big"
 	"math/rand"
-	"net"
 	"strconv"
 	"strings"
 	"time"
@@ -14,9 +12,9 @@ import (
 	"github.com/euforia/gossip/peers"
 )
 
-// lookupGroup returns the affinity group id a peer belongs to. It is calculated by
-// hashing the host and applying modulo k (total affinity groups) to the int
-// value of the host hash
+// lookupGroup returns the affinity group id a key or host belongs to with
+// ModuloMapper. It is calculated by hashing the key and applying modulo k
+// (total affinity groups) to the int value of the hash
 func lookupGroup(key []byte, k int64, h hash.Hash) int64 {
 	h.Write(key)
 	sh := h.Sum(nil)
@@ -30,6 +28,20 @@ func randExpire(min, max time.Duration) time.Duration {
 	return time.Duration((r * float64(max-min)) + float64(min))
 }
 
+// sleepContext sleeps for d returning false if the context is done before
+// then
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
 func parseGroupContact(s string) (GroupContact, error) {
 	var g GroupContact
 	i := strings.LastIndex(s, "/")
@@ -58,60 +70,3 @@ func peersToPeerContacts(libPeers []*peers.Peer) []PeerContact {
 	}
 	return peers
 }
-
-func hostStringToBytes(host string) []byte {
-	addr, _ := net.ResolveTCPAddr("tcp", host)
-	pb := make([]byte, 2)
-	binary.BigEndian.PutUint16(pb, uint16(addr.Port))
-	return append(addr.IP, pb...)
-}
-
-func hostBytesToString(host []byte) string {
-	ip := net.IP(host[:16])
-	port := binary.BigEndian.Uint16(host[16:18])
-	return ip.String() + ":" + strconv.Itoa(int(port))
-}
-
-func readTuples(r io.Reader) ([]*Tuple, error) {
-	p := make([]byte, 1)
-	out := make([]*Tuple, 0)
-
-	for {
-		_, err := r.Read(p)
-		if err != nil {
-			if err == io.EOF {
-				return out, nil
-			}
-			return nil, err
-		}
-
-		line := make([]byte, p[0])
-		_, err = io.ReadFull(r, line)
-		if err != nil {
-			return out, err
-		}
-
-		out = append(out, &Tuple{
-			Key:  line[18:],
-			Host: hostBytesToString(line[:18]),
-		})
-	}
-}
-
-// Snapshot writes the keys and associated host to the writer.  The key size is limited
-// to 237 chars (255 - 18 byte host addr)
-func writeTuples(w io.Writer, tuples []*Tuple) error {
-	if len(tuples) == 0 {
-		return nil
-	}
-
-	for _, t := range tuples {
-		line := append(hostStringToBytes(t.Host), t.Key...)
-		_, err := w.Write(append([]byte{uint8(len(line))}, line...))
-		if err != nil {
-			return err
-		}
-	}
-
-	return nil
-}